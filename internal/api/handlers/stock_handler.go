@@ -19,7 +19,7 @@ type StockHandler struct {
 	db         *gorm.DB
 	cfg        *config.Config
 	logger     zerolog.Logger
-	apiService *services.ExternalAPIService
+	marketData *services.MarketDataService
 }
 
 // NewStockHandler creates a new stock handler
@@ -28,7 +28,7 @@ func NewStockHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *St
 		db:         db,
 		cfg:        cfg,
 		logger:     logger,
-		apiService: services.NewExternalAPIService(cfg),
+		marketData: services.NewMarketDataService(db, cfg, services.NewExternalAPIService(cfg)),
 	}
 }
 
@@ -68,7 +68,7 @@ type CreateStockRequest struct {
 	CompanyName         string  `json:"company_name" binding:"required"`
 	Sector              string  `json:"sector" binding:"required"`
 	Currency            string  `json:"currency"`
-	SharesOwned         int     `json:"shares_owned"`
+	SharesOwned         float64 `json:"shares_owned"`
 	AvgPriceLocal       float64 `json:"avg_price_local"`
 	UpdateFrequency     string  `json:"update_frequency"`
 	ProbabilityPositive float64 `json:"probability_positive"` // Optional manual input
@@ -106,13 +106,13 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 	if stock.UpdateFrequency == "" {
 		stock.UpdateFrequency = "daily"
 	}
-	if stock.ProbabilityPositive == 0 {
-		stock.ProbabilityPositive = 0.65 // Default conservative value
+	if stock.ProbabilityPositive > 0 {
+		stock.ProbabilitySource = services.InputSourceManual
 	}
 
 	// Fetch all stock data from Grok in one call (includes ALL calculations!)
 	// With automatic fallback to mock data that also includes calculations
-	if err := h.apiService.FetchAllStockData(&stock); err != nil {
+	if _, err := h.marketData.Update(&stock, h.marketData.Order(&stock)); err != nil {
 		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("⚠️ GROK FETCH FAILED during stock creation - Check API key and logs above")
 		// Return error to prevent saving stock with N/A data
 		c.JSON(http.StatusBadGateway, gin.H{
@@ -130,18 +130,11 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 	// - KellyFraction, HalfKellySuggested
 	// - BuyZoneMin, BuyZoneMax, Assessment
 
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := services.NewExchangeRateService(h.db, h.cfg, h.logger).UpdatePositionValues(&stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Calculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	stock.LastUpdated = time.Now()
 
 	// Save to database
@@ -197,7 +190,7 @@ func (h *StockHandler) UpdateStock(c *gin.Context) {
 	}
 
 	// Recalculate metrics
-	services.CalculateMetrics(&stock)
+	services.CalculateMetrics(&stock, h.strategy())
 	h.db.Save(&stock)
 
 	h.logger.Info().Str("ticker", stock.Ticker).Msg("Stock updated successfully")
@@ -228,20 +221,13 @@ func (h *StockHandler) UpdateStockPrice(c *gin.Context) {
 	stock.LastUpdated = time.Now()
 
 	// Recalculate all derived metrics based on new price
-	services.CalculateMetrics(&stock)
+	services.CalculateMetrics(&stock, h.strategy())
 
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := services.NewExchangeRateService(h.db, h.cfg, h.logger).UpdatePositionValues(&stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Recalculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	// Save to database
 	if err := h.db.Save(&stock).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to save stock")
@@ -280,7 +266,7 @@ func (h *StockHandler) UpdateStockField(c *gin.Context) {
 	case "fair_value":
 		stock.FairValue = req.Value
 	case "shares_owned":
-		stock.SharesOwned = int(req.Value)
+		stock.SharesOwned = req.Value
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field name"})
 		return
@@ -289,20 +275,13 @@ func (h *StockHandler) UpdateStockField(c *gin.Context) {
 	stock.LastUpdated = time.Now()
 
 	// Recalculate all derived metrics
-	services.CalculateMetrics(&stock)
+	services.CalculateMetrics(&stock, h.strategy())
 
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := services.NewExchangeRateService(h.db, h.cfg, h.logger).UpdatePositionValues(&stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Recalculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	// Save to database
 	if err := h.db.Save(&stock).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to save stock")
@@ -410,7 +389,7 @@ func (h *StockHandler) UpdateSingleStock(c *gin.Context) {
 		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to update stock data from API, using mock data")
 		// Don't return error - the updateStockData should have fallback to mock data
 		// Try to at least recalculate metrics with existing data
-		services.CalculateMetrics(&stock)
+		services.CalculateMetrics(&stock, h.strategy())
 		h.db.Save(&stock)
 	}
 
@@ -423,7 +402,7 @@ func (h *StockHandler) updateStockData(stock *models.Stock) error {
 	oldEV := stock.ExpectedValue
 
 	// Fetch all stock data from Grok in one call (includes ALL calculations!)
-	if err := h.apiService.FetchAllStockData(stock); err != nil {
+	if _, err := h.marketData.Update(stock, h.marketData.Order(stock)); err != nil {
 		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("⚠️ GROK FETCH FAILED - Check API key and logs above")
 		// Mock data is already set by the service including all calculations
 		return err
@@ -433,18 +412,11 @@ func (h *StockHandler) updateStockData(stock *models.Stock) error {
 
 	// NO NEED to call CalculateMetrics - Grok already calculated everything!
 
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate, using default")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := services.NewExchangeRateService(h.db, h.cfg, h.logger).UpdatePositionValues(stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Calculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	stock.LastUpdated = time.Now()
 
 	// Save to database
@@ -583,7 +555,7 @@ func (h *StockHandler) ExportJSON(c *gin.Context) {
 		BRatio              float64 `json:"b_ratio"`
 		KellyFraction       float64 `json:"kelly_fraction"`
 		HalfKellySuggested  float64 `json:"half_kelly_suggested"`
-		SharesOwned         int     `json:"shares_owned"`
+		SharesOwned         float64 `json:"shares_owned"`
 		AvgPriceLocal       float64 `json:"avg_price_local"`
 		BuyZoneMin          float64 `json:"buy_zone_min"`
 		BuyZoneMax          float64 `json:"buy_zone_max"`
@@ -634,3 +606,13 @@ func (h *StockHandler) ExportJSON(c *gin.Context) {
 	c.Header("Content-Disposition", "attachment;filename=portfolio_export.json")
 	c.JSON(http.StatusOK, exportData)
 }
+
+// strategy returns the active strategy profile, falling back to the defaults
+func (h *StockHandler) strategy() *models.StrategyProfile {
+	strategy, err := h.marketData.Strategy()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to load strategy profile, using defaults")
+		return services.DefaultStrategyProfile()
+	}
+	return strategy
+}
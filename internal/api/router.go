@@ -104,7 +104,7 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 		protected.POST("/cash", cashHandler.CreateCashHolding)
 		protected.PUT("/cash/:id", cashHandler.UpdateCashHolding)
 		protected.DELETE("/cash/:id", cashHandler.DeleteCashHolding)
-		protected.POST("/cash/refresh", cashHandler.RefreshUSDValues)
+		protected.POST("/cash/refresh", cashHandler.RefreshBaseValues)
 	}
 
 	return router
@@ -12,6 +12,7 @@ import (
 
 	"github.com/artpro/assessapp/pkg/config"
 	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
@@ -42,9 +43,7 @@ func NewAssessmentHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger
 		db:     db,
 		cfg:    cfg,
 		logger: logger,
-		client: &http.Client{
-			Timeout: 120 * time.Second, // Longer timeout for AI analysis
-		},
+		client: services.NewHTTPClient(cfg, 120*time.Second), // Longer timeout for AI analysis
 	}
 }
 
@@ -340,8 +339,9 @@ func (h *AssessmentHandler) fetchPortfolioContext() ([]models.Stock, []models.Ca
 }
 
 // buildPortfolioContext creates a formatted string describing the current portfolio
-func (h *AssessmentHandler) buildPortfolioContext(portfolio []models.Stock, cashHoldings []models.CashHolding) string {
+func (h *AssessmentHandler) buildPortfolioContext(portfolio []models.Stock, cashHoldings []models.CashHolding, strategy *models.StrategyProfile) string {
 	context := "\n\n## CURRENT PORTFOLIO CONTEXT\n\n"
+	currency := services.NewExchangeRateService(h.db, h.cfg, h.logger).ReportingCurrency()
 	
 	if len(portfolio) == 0 {
 		context += "**Current Portfolio:** Empty (no owned stocks)\n\n"
@@ -350,26 +350,29 @@ func (h *AssessmentHandler) buildPortfolioContext(portfolio []models.Stock, cash
 		context += "| Ticker | Company | Sector | Shares | Avg Price | Current Price | Position Value | Weight | EV | Assessment |\n"
 		context += "|--------|---------|--------|--------|-----------|---------------|----------------|--------|----|------------|\n"
 		
+		// Position values are in the reporting currency, prices in the stock's own currency
 		totalPortfolioValue := 0.0
 		for _, stock := range portfolio {
-			positionValue := float64(stock.SharesOwned) * stock.CurrentPrice
-			totalPortfolioValue += positionValue
+			totalPortfolioValue += stock.ValueBase
 		}
 		
 		sectorAllocations := make(map[string]float64)
 		
 		for _, stock := range portfolio {
-			positionValue := float64(stock.SharesOwned) * stock.CurrentPrice
-			weightPercent := (positionValue / totalPortfolioValue) * 100
+			positionValue := stock.ValueBase
+			weightPercent := 0.0
+			if totalPortfolioValue > 0 {
+				weightPercent = (positionValue / totalPortfolioValue) * 100
+			}
 			
-			context += fmt.Sprintf("| %s | %s | %s | %d | €%.2f | €%.2f | €%.0f | %.1f%% | %.1f%% | %s |\n",
+			context += fmt.Sprintf("| %s | %s | %s | %g | %s %.2f | %s %.2f | %s %.0f | %.1f%% | %.1f%% | %s |\n",
 				stock.Ticker,
 				stock.CompanyName,
 				stock.Sector,
 				stock.SharesOwned,
-				stock.AvgPriceLocal,
-				stock.CurrentPrice,
-				positionValue,
+				stock.Currency, stock.AvgPriceLocal,
+				stock.Currency, stock.CurrentPrice,
+				currency, positionValue,
 				weightPercent,
 				stock.ExpectedValue,
 				stock.Assessment)
@@ -382,7 +385,7 @@ func (h *AssessmentHandler) buildPortfolioContext(portfolio []models.Stock, cash
 		for sector, allocation := range sectorAllocations {
 			context += fmt.Sprintf("- %s: %.1f%%\n", sector, allocation)
 		}
-		context += fmt.Sprintf("\n**Total Portfolio Value:** €%.0f\n", totalPortfolioValue)
+		context += fmt.Sprintf("\n**Total Portfolio Value:** %s %.0f\n", currency, totalPortfolioValue)
 	}
 	
 	// Add cash holdings
@@ -392,22 +395,20 @@ func (h *AssessmentHandler) buildPortfolioContext(portfolio []models.Stock, cash
 		context += "\n**Available Cash:**\n"
 		totalCash := 0.0
 		for _, cash := range cashHoldings {
-			if cash.CurrencyCode == "EUR" {
-				// For EUR (base currency), use actual amount
-				context += fmt.Sprintf("- %s: %.0f (€%.0f)\n", cash.CurrencyCode, cash.Amount, cash.Amount)
-				totalCash += cash.Amount
+			if cash.CurrencyCode == currency {
+				context += fmt.Sprintf("- %s: %.0f\n", cash.CurrencyCode, cash.Amount)
 			} else {
-				// For other currencies, show both original and EUR value
-				context += fmt.Sprintf("- %s: %.0f (€%.0f)\n", cash.CurrencyCode, cash.Amount, cash.USDValue)
-				totalCash += cash.USDValue
+				// For other currencies, show both original and reporting currency value
+				context += fmt.Sprintf("- %s: %.0f (%s %.0f)\n", cash.CurrencyCode, cash.Amount, currency, cash.ValueBase)
 			}
+			totalCash += cash.ValueBase
 		}
-		context += fmt.Sprintf("\n**Total Available Cash:** €%.0f\n", totalCash)
+		context += fmt.Sprintf("\n**Total Available Cash:** %s %.0f\n", currency, totalCash)
 	}
 	
 	context += "\n**IMPORTANT:** Consider this portfolio context when making recommendations. Analyze:\n"
 	context += "- How this new position would affect sector diversification\n"
-	context += "- Whether current sector allocations exceed targets (Healthcare 30-35%, Tech 15%, etc.)\n"
+	context += "- Whether current sector allocations exceed targets (" + services.FormatSectorTargets(strategy) + ")\n"
 	context += "- If sufficient cash is available for the recommended position size\n"
 	context += "- How this fits with the overall portfolio risk and Kelly utilization\n"
 	
@@ -416,26 +417,33 @@ func (h *AssessmentHandler) buildPortfolioContext(portfolio []models.Stock, cash
 
 // buildAssessmentPrompt creates the comprehensive prompt for stock assessment
 func (h *AssessmentHandler) buildAssessmentPrompt(ticker string, portfolio []models.Stock, cashHoldings []models.CashHolding) string {
+	// Thresholds, bands and limits come from the active strategy profile
+	strategy, err := services.NewStrategyService(h.db).Active()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to load strategy profile, using defaults")
+		strategy = services.DefaultStrategyProfile()
+	}
+
 	// Build portfolio context string
-	portfolioContext := h.buildPortfolioContext(portfolio, cashHoldings)
+	portfolioContext := h.buildPortfolioContext(portfolio, cashHoldings, strategy)
 	// Get current date
 	currentDate := time.Now().Format("January 2, 2006")
 	
-	return fmt.Sprintf(`CURRENT DATE: %s
+	return fmt.Sprintf(`CURRENT DATE: %[1]s
 
 IMPORTANT: Please use the most recent available market data and financial information. Access current stock prices, latest quarterly earnings, recent analyst reports, and up-to-date fundamental metrics. If any data appears outdated, please indicate when the information was last updated.
 
-You are a financial advisor and investment consultant using a probabilistic strategy. For the stock %s, follow these steps:
+You are a financial advisor and investment consultant using a probabilistic strategy. For the stock %[2]s, follow these steps:
 
-1. Collect data: current price, fair value (median consensus target), upside %% = ((fair value - current price) / current price) * 100, downside %% (calibrate by beta: -15%% <0.5, -20%% 0.5–1, -25%% 1–1.5, -30%% >1.5), p (0.5–0.7 based on ratings), volatility, P/E, EPS growth, debt-to-EBITDA, dividend yield.
+1. Collect data: current price, fair value (median consensus target), upside %% = ((fair value - current price) / current price) * 100, downside %% (calibrate by beta: %[4]g%% <%[8]g, %[5]g%% %[8]g–%[9]g, %[6]g%% %[9]g–%[10]g, %[7]g%% >%[10]g), p (0.5–0.7 based on ratings), volatility, P/E, EPS growth, debt-to-EBITDA, dividend yield.
 
 2. Calculate EV = (p * upside %%) + ((1-p) * downside %%).
 
-3. Calculate b = upside %% / |downside %%|, Kelly f* = ((b * p) - (1-p)) / b, ½-Kelly = f*/2 capped at 15%%.
+3. Calculate b = upside %% / |downside %%|, Kelly f* = ((b * p) - (1-p)) / b, ½-Kelly = f*/2 capped at %[11]g%%.
 
-4. Assess: Add (EV >7%%), Hold (EV >0%%), Trim (EV <3%%), Sell (EV <0%%).
+4. Assess: Add (EV >%[12]g%%), Hold (EV >%[13]g%%), Trim (EV >%[14]g%%), Sell (otherwise).
 
-5. Recommend buy zone (prices for EV >7%%), laddered entries if Add. Align with sector targets (Healthcare 30–35%%, Tech 15%%, etc.).
+5. Recommend buy zone (prices for EV >%[12]g%%), laddered entries if Add. Align with sector targets (%[15]s).
 
 Output in structured format with EV, Kelly, assessment, and notes. Use conservative p; avoid hype.
 
@@ -460,13 +468,13 @@ Collect Fundamental and Market Data:
 
 Portfolio Construction Rules:
 • Diversification: include multiple sectors with positive EV to capture the "long tail" of outperformers.
-• Maximum single-position weight: 15%% (only for extremely high-conviction, low-volatility assets like Novo Nordisk).
+• Maximum single-position weight: %[11]g%% (only for extremely high-conviction, low-volatility assets like Novo Nordisk).
 • Typical range: 3–6%% per stock, depending on EV, volatility, and risk correlation.
 • Avoid overexposure to any one sector, region, or currency.
-• Cash buffer: always maintain 8–12%% of total portfolio in cash for high-EV opportunities during corrections.
+• Cash buffer: always maintain %[16]g–%[17]g%% of total portfolio in cash for high-EV opportunities during corrections.
 
 Execution and Risk Management Rules:
-1. Enter only within the defined "EV buy zone." Optimal buy zones correspond to the range where EV > 7%% and downside risk < 10%%. Avoid buying into EV < 3%% or after strong rallies.
+1. Enter only within the defined "EV buy zone." Optimal buy zones correspond to the range where EV > %[12]g%% and downside risk < 10%%. Avoid buying into EV < 3%% or after strong rallies.
 
 2. Add positions gradually ("laddered entries"). Divide entries into 2–3 limit orders across a price range to average in probabilistically.
 
@@ -474,7 +482,7 @@ Execution and Risk Management Rules:
 
 4. Position trimming: If EV drops below +3%% (e.g., due to overvaluation), trim or take profits.
 
-5. Portfolio rebalancing: Review weights quarterly. Maintain overall Kelly usage between 0.75–0.85 (not fully leveraged).
+5. Portfolio rebalancing: Review weights quarterly. Maintain overall Kelly usage between %[18]g–%[19]g (not fully leveraged).
 
 6. Hold cash strategically. Cash has optional value during corrections. Reinvest only when market-wide EV turns positive again.
 
@@ -488,12 +496,12 @@ Target Portfolio Metrics:
 Expected Value (EV): +10–11%% (Portfolio-wide mathematical expectation)
 Volatility (σ): 11–13%% (Moderate risk level)
 Sharpe Ratio (EV/σ): 0.8–0.9 (Efficient balance of risk/reward)
-Kelly Utilization: 0.75–0.85 (Safe use of probabilistic leverage)
+Kelly Utilization: %[18]g–%[19]g (Safe use of probabilistic leverage)
 Max drawdown tolerance: ≤15%% (Controlled downside risk)
 
 Summary Principle: "Every investment must be a probabilistic bet with a positive expected value, diversified across independent opportunities, and sized according to Kelly to maximize long-term growth without emotional interference."
 
-Please provide a detailed assessment for %s following the template format similar to the NVIDIA analysis example, including:
+Please provide a detailed assessment for %[2]s following the template format similar to the NVIDIA analysis example, including:
 
 - Step 1: Data Collection & Fundamental Analysis
 - Step 2: Conservative Parameter Estimation
@@ -507,7 +515,14 @@ Please provide a detailed assessment for %s following the template format simila
 
 Use real market data and provide specific numbers for all calculations. Be conservative with probability estimates and avoid hype.
 
-%s`, currentDate, ticker, ticker, portfolioContext)
+%[3]s`, currentDate, ticker, portfolioContext,
+		strategy.DownsideLow, strategy.DownsideMid, strategy.DownsideHigh, strategy.DownsideMax,
+		strategy.BetaLow, strategy.BetaMid, strategy.BetaHigh,
+		strategy.HalfKellyCap,
+		strategy.AddThresholdEV, strategy.HoldThresholdEV, strategy.TrimThresholdEV,
+		services.FormatSectorTargets(strategy),
+		strategy.CashMin, strategy.CashMax,
+		strategy.KellyMin, strategy.KellyMax)
 }
 
 // cleanupOldAssessments removes assessments beyond the most recent 20
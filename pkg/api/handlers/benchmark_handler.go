@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// BenchmarkHandler handles the benchmark price series
+type BenchmarkHandler struct {
+	db               *gorm.DB
+	cfg              *config.Config
+	logger           zerolog.Logger
+	benchmarkService *services.BenchmarkService
+}
+
+// NewBenchmarkHandler creates a new benchmark handler
+func NewBenchmarkHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *BenchmarkHandler {
+	return &BenchmarkHandler{
+		db:               db,
+		cfg:              cfg,
+		logger:           logger,
+		benchmarkService: services.NewBenchmarkService(db, services.NewMarketDataService(db, cfg, services.NewExternalAPIService(cfg))),
+	}
+}
+
+// GetBenchmarkPrices returns the stored series of the configured benchmark (?from=&to=, YYYY-MM-DD)
+func (h *BenchmarkHandler) GetBenchmarkPrices(c *gin.Context) {
+	settings, err := h.benchmarkService.Settings()
+	if err != nil {
+		h.respondBenchmarkError(c, err, "Failed to fetch benchmark settings")
+		return
+	}
+
+	var from, to time.Time
+	if param := c.Query("from"); param != "" {
+		if from, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	if param := c.Query("to"); param != "" {
+		if to, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+
+	prices, err := h.benchmarkService.GetPrices(settings.BenchmarkTicker, from, to)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch benchmark prices")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch benchmark prices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticker":   settings.BenchmarkTicker,
+		"name":     settings.BenchmarkName,
+		"currency": settings.BenchmarkCurrency,
+		"prices":   prices,
+	})
+}
+
+// RefreshBenchmarkPrices downloads the benchmark series from the market data API (?full=true for full history)
+func (h *BenchmarkHandler) RefreshBenchmarkPrices(c *gin.Context) {
+	stored, err := h.benchmarkService.RefreshPrices(c.Query("full") == "true")
+	if err != nil {
+		h.respondBenchmarkError(c, err, "Failed to refresh benchmark prices")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Benchmark prices refreshed", "stored": stored})
+}
+
+// ImportBenchmarkPrices stores a date/close CSV for the configured benchmark.
+// Multipart form fields: file, and optionally ticker and currency to import another series.
+func (h *BenchmarkHandler) ImportBenchmarkPrices(c *gin.Context) {
+	ticker := strings.TrimSpace(c.PostForm("ticker"))
+	currency := strings.ToUpper(strings.TrimSpace(c.PostForm("currency")))
+	if ticker == "" {
+		settings, err := h.benchmarkService.Settings()
+		if err != nil {
+			h.respondBenchmarkError(c, err, "Failed to fetch benchmark settings")
+			return
+		}
+		ticker = settings.BenchmarkTicker
+		if currency == "" {
+			currency = settings.BenchmarkCurrency
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large (max 10 MB)"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	closes, err := services.ParsePriceCSV(file)
+	if err != nil {
+		h.respondBenchmarkError(c, err, "Failed to parse benchmark prices")
+		return
+	}
+
+	stored, err := h.benchmarkService.StorePrices(ticker, currency, services.BenchmarkSourceCSV, closes)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to store benchmark prices")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store benchmark prices"})
+		return
+	}
+
+	h.logger.Info().Str("ticker", ticker).Int("stored", stored).Msg("Benchmark prices imported")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Benchmark prices imported",
+		"ticker":  ticker,
+		"stored":  stored,
+		"from":    closes[0].Date,
+		"to":      closes[len(closes)-1].Date,
+	})
+}
+
+// respondBenchmarkError maps configuration and input problems to 400 and everything else to 500
+func (h *BenchmarkHandler) respondBenchmarkError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrNoBenchmark) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No benchmark configured. Set benchmark_ticker in the portfolio settings."})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidImport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Error().Err(err).Msg(message)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message + ": " + err.Error()})
+}
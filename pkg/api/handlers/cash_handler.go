@@ -7,6 +7,7 @@ import (
 
 	"github.com/artpro/assessapp/pkg/config"
 	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
@@ -14,17 +15,19 @@ import (
 
 // CashHandler handles cash management requests
 type CashHandler struct {
-	db     *gorm.DB
-	cfg    *config.Config
-	logger zerolog.Logger
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *services.ExchangeRateService
 }
 
 // NewCashHandler creates a new cash handler
 func NewCashHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *CashHandler {
 	return &CashHandler{
-		db:     db,
-		cfg:    cfg,
-		logger: logger,
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
 	}
 }
 
@@ -41,7 +44,7 @@ type UpdateCashHoldingRequest struct {
 	Description string  `json:"description"`
 }
 
-// GetAllCashHoldings returns all cash holdings with USD values calculated
+// GetAllCashHoldings returns all cash holdings with values in the reporting currency
 func (h *CashHandler) GetAllCashHoldings(c *gin.Context) {
 	var cashHoldings []models.CashHolding
 	if err := h.db.Find(&cashHoldings).Error; err != nil {
@@ -50,14 +53,12 @@ func (h *CashHandler) GetAllCashHoldings(c *gin.Context) {
 		return
 	}
 
-	// Update USD values using current exchange rates
+	// Update base values using current exchange rates
 	for i := range cashHoldings {
-		usdValue, err := h.calculateUSDValue(cashHoldings[i].CurrencyCode, cashHoldings[i].Amount)
-		if err != nil {
-			h.logger.Warn().Err(err).Str("currency", cashHoldings[i].CurrencyCode).Msg("Failed to calculate USD value")
-			// Keep existing USD value if calculation fails
+		if err := h.setBaseValue(&cashHoldings[i]); err != nil {
+			h.logger.Warn().Err(err).Str("currency", cashHoldings[i].CurrencyCode).Msg("Failed to calculate base value")
+			// Keep existing base value if calculation fails
 		} else {
-			cashHoldings[i].USDValue = usdValue
 			cashHoldings[i].LastUpdated = time.Now()
 			h.db.Save(&cashHoldings[i])
 		}
@@ -88,22 +89,20 @@ func (h *CashHandler) CreateCashHolding(c *gin.Context) {
 		return
 	}
 
-	// Calculate USD value
-	usdValue, err := h.calculateUSDValue(req.CurrencyCode, req.Amount)
-	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to calculate USD value")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate USD value"})
-		return
-	}
-
 	cashHolding := models.CashHolding{
 		CurrencyCode: req.CurrencyCode,
 		Amount:       req.Amount,
-		USDValue:     usdValue,
 		Description:  req.Description,
 		LastUpdated:  time.Now(),
 	}
 
+	// Calculate value in the reporting currency
+	if err := h.setBaseValue(&cashHolding); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to calculate base value")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate base value"})
+		return
+	}
+
 	if err := h.db.Create(&cashHolding).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to create cash holding")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cash holding"})
@@ -139,17 +138,16 @@ func (h *CashHandler) UpdateCashHolding(c *gin.Context) {
 		return
 	}
 
-	// Calculate new USD value
-	usdValue, err := h.calculateUSDValue(cashHolding.CurrencyCode, req.Amount)
-	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to calculate USD value")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate USD value"})
+	cashHolding.Amount = req.Amount
+	cashHolding.Description = req.Description
+
+	// Calculate new value in the reporting currency
+	if err := h.setBaseValue(&cashHolding); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to calculate base value")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate base value"})
 		return
 	}
 
-	cashHolding.Amount = req.Amount
-	cashHolding.USDValue = usdValue
-	cashHolding.Description = req.Description
 	cashHolding.LastUpdated = time.Now()
 
 	if err := h.db.Save(&cashHolding).Error; err != nil {
@@ -191,8 +189,8 @@ func (h *CashHandler) DeleteCashHolding(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Cash holding deleted successfully"})
 }
 
-// RefreshUSDValues recalculates USD values for all cash holdings
-func (h *CashHandler) RefreshUSDValues(c *gin.Context) {
+// RefreshBaseValues recalculates the reporting-currency values of all cash holdings
+func (h *CashHandler) RefreshBaseValues(c *gin.Context) {
 	var cashHoldings []models.CashHolding
 	if err := h.db.Find(&cashHoldings).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to fetch cash holdings")
@@ -202,67 +200,35 @@ func (h *CashHandler) RefreshUSDValues(c *gin.Context) {
 
 	updatedCount := 0
 	for i := range cashHoldings {
-		usdValue, err := h.calculateUSDValue(cashHoldings[i].CurrencyCode, cashHoldings[i].Amount)
-		if err != nil {
-			h.logger.Warn().Err(err).Str("currency", cashHoldings[i].CurrencyCode).Msg("Failed to calculate USD value")
+		if err := h.setBaseValue(&cashHoldings[i]); err != nil {
+			h.logger.Warn().Err(err).Str("currency", cashHoldings[i].CurrencyCode).Msg("Failed to calculate base value")
 			continue
 		}
 
-		cashHoldings[i].USDValue = usdValue
 		cashHoldings[i].LastUpdated = time.Now()
 
 		if err := h.db.Save(&cashHoldings[i]).Error; err != nil {
-			h.logger.Warn().Err(err).Uint("id", cashHoldings[i].ID).Msg("Failed to update cash holding USD value")
+			h.logger.Warn().Err(err).Uint("id", cashHoldings[i].ID).Msg("Failed to update cash holding base value")
 			continue
 		}
 		updatedCount++
 	}
 
-	h.logger.Info().Int("updated_count", updatedCount).Msg("Cash holdings USD values refreshed")
+	h.logger.Info().Int("updated_count", updatedCount).Msg("Cash holdings base values refreshed")
 	c.JSON(http.StatusOK, gin.H{
-		"message": "USD values refreshed successfully",
+		"message": "Base values refreshed successfully",
 		"updated": updatedCount,
 		"total":   len(cashHoldings),
 	})
 }
 
-// calculateUSDValue converts amount from given currency to USD
-func (h *CashHandler) calculateUSDValue(currencyCode string, amount float64) (float64, error) {
-	// If EUR (base currency), convert to USD using USD rate
-	if currencyCode == "EUR" {
-		var usdRate models.ExchangeRate
-		if err := h.db.Where("currency_code = ?", "USD").First(&usdRate).Error; err != nil {
-			// If USD rate not found, assume 1:1 for development
-			h.logger.Warn().Msg("USD exchange rate not found, using 1:1")
-			return amount, nil
-		}
-		// EUR to USD: multiply by USD rate
-		return amount * usdRate.Rate, nil
-	}
-
-	// If USD, return as is
-	if currencyCode == "USD" {
-		return amount, nil
-	}
-
-	// For other currencies, convert via EUR base
-	var exchangeRate models.ExchangeRate
-	if err := h.db.Where("currency_code = ?", currencyCode).First(&exchangeRate).Error; err != nil {
-		h.logger.Warn().Str("currency", currencyCode).Msg("Exchange rate not found")
-		return amount, nil // Return original amount if no rate found
-	}
-
-	// Convert to EUR first (amount / rate), then to USD
-	var usdRate models.ExchangeRate
-	if err := h.db.Where("currency_code = ?", "USD").First(&usdRate).Error; err != nil {
-		// If USD rate not found, return EUR equivalent
-		h.logger.Warn().Msg("USD exchange rate not found, returning EUR equivalent")
-		return amount / exchangeRate.Rate, nil
+// setBaseValue converts the holding's amount to the reporting currency
+func (h *CashHandler) setBaseValue(holding *models.CashHolding) error {
+	value, currency, err := h.exchangeRateService.ConvertToReporting(holding.Amount, holding.CurrencyCode)
+	if err != nil {
+		return err
 	}
-
-	// Convert: amount in currency -> EUR -> USD
-	amountInEUR := amount / exchangeRate.Rate
-	usdValue := amountInEUR * usdRate.Rate
-
-	return usdValue, nil
-}
\ No newline at end of file
+	holding.ValueBase = value
+	holding.BaseCurrency = currency
+	return nil
+}
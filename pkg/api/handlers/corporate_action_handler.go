@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// CorporateActionHandler handles splits, renames and spin-offs
+type CorporateActionHandler struct {
+	db                 *gorm.DB
+	cfg                *config.Config
+	logger             zerolog.Logger
+	actionService      *services.CorporateActionService
+	transactionHandler *TransactionHandler
+}
+
+// NewCorporateActionHandler creates a new corporate action handler
+func NewCorporateActionHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *CorporateActionHandler {
+	return &CorporateActionHandler{
+		db:                 db,
+		cfg:                cfg,
+		logger:             logger,
+		actionService:      services.NewCorporateActionService(db),
+		transactionHandler: NewTransactionHandler(db, cfg, logger),
+	}
+}
+
+// CorporateActionRequest represents the request to apply a corporate action
+type CorporateActionRequest struct {
+	Type               string  `json:"type" binding:"required,oneof=split reverse_split ticker_change isin_change spin_off"`
+	EffectiveDate      string  `json:"effective_date" binding:"required"` // YYYY-MM-DD
+	RatioFrom          float64 `json:"ratio_from" binding:"gte=0"`
+	RatioTo            float64 `json:"ratio_to" binding:"gte=0"`
+	NewTicker          string  `json:"new_ticker"`
+	NewISIN            string  `json:"new_isin"`
+	SpinOffStockID     uint    `json:"spin_off_stock_id"`
+	SpinOffCompanyName string  `json:"spin_off_company_name"` // Used when the spin-off stock is created
+	CostAllocation     float64 `json:"cost_allocation" binding:"gte=0"`
+	Note               string  `json:"note"`
+}
+
+// GetCorporateActions returns all corporate actions, newest first
+func (h *CorporateActionHandler) GetCorporateActions(c *gin.Context) {
+	var actions []models.CorporateAction
+	if err := h.db.Order("effective_date DESC, id DESC").Find(&actions).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch corporate actions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch corporate actions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, actions)
+}
+
+// GetStockCorporateActions returns the corporate actions of a stock
+func (h *CorporateActionHandler) GetStockCorporateActions(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	var actions []models.CorporateAction
+	if err := h.db.Where("stock_id = ? OR spin_off_stock_id = ?", stock.ID, stock.ID).
+		Order("effective_date DESC, id DESC").Find(&actions).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch corporate actions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch corporate actions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, actions)
+}
+
+// CreateCorporateAction applies a corporate action to a stock
+func (h *CorporateActionHandler) CreateCorporateAction(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	var req CorporateActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	effectiveDate, err := time.Parse("2006-01-02", req.EffectiveDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "effective_date must be in YYYY-MM-DD format"})
+		return
+	}
+
+	action := models.CorporateAction{
+		StockID:        stock.ID,
+		Type:           req.Type,
+		EffectiveDate:  effectiveDate,
+		RatioFrom:      req.RatioFrom,
+		RatioTo:        req.RatioTo,
+		NewTicker:      req.NewTicker,
+		NewISIN:        req.NewISIN,
+		SpinOffStockID: req.SpinOffStockID,
+		CostAllocation: req.CostAllocation,
+		Note:           req.Note,
+	}
+	if username, exists := c.Get("username"); exists {
+		action.AppliedBy, _ = username.(string)
+	}
+
+	target := services.SpinOffTarget{
+		Ticker:      req.NewTicker,
+		CompanyName: req.SpinOffCompanyName,
+		ISIN:        req.NewISIN,
+	}
+	if err := h.actionService.Apply(&action, target); err != nil {
+		h.respondActionError(c, err, "Failed to apply corporate action")
+		return
+	}
+
+	h.refreshValues(action.StockID, action.SpinOffStockID)
+
+	h.logger.Info().Str("ticker", stock.Ticker).Str("type", action.Type).Uint("action_id", action.ID).Msg("Corporate action applied")
+	c.JSON(http.StatusCreated, action)
+}
+
+// RevertCorporateAction restores the state from before a corporate action
+func (h *CorporateActionHandler) RevertCorporateAction(c *gin.Context) {
+	var action models.CorporateAction
+	if err := h.db.First(&action, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Corporate action not found"})
+		return
+	}
+
+	if err := h.actionService.Revert(&action); err != nil {
+		h.respondActionError(c, err, "Failed to revert corporate action")
+		return
+	}
+
+	h.refreshValues(action.StockID, action.SpinOffStockID)
+
+	h.logger.Info().Uint("action_id", action.ID).Str("type", action.Type).Msg("Corporate action reverted")
+	c.JSON(http.StatusOK, action)
+}
+
+// refreshValues recalculates position value and P&L of the stocks touched by an action
+func (h *CorporateActionHandler) refreshValues(stockIDs ...uint) {
+	for _, id := range stockIDs {
+		if id == 0 {
+			continue
+		}
+		var stock models.Stock
+		if err := h.db.First(&stock, id).Error; err != nil {
+			continue
+		}
+		h.transactionHandler.updatePositionValues(&stock)
+		if err := h.db.Save(&stock).Error; err != nil {
+			h.logger.Warn().Err(err).Uint("stock_id", id).Msg("Failed to update position values")
+		}
+	}
+}
+
+// respondActionError maps validation failures to 400 and everything else to 500
+func (h *CorporateActionHandler) respondActionError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrInvalidCorporateAction) || errors.Is(err, services.ErrInvalidLedger) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Error().Err(err).Msg(message)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
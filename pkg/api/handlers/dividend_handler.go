@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// errCashShortfall is returned when reversing a cash credit would leave a negative balance
+var errCashShortfall = errors.New("insufficient cash")
+
+// DividendHandler handles dividend income requests
+type DividendHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *services.ExchangeRateService
+	cashHandler         *CashHandler
+}
+
+// NewDividendHandler creates a new dividend handler
+func NewDividendHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *DividendHandler {
+	return &DividendHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+		cashHandler:         NewCashHandler(db, cfg, logger),
+	}
+}
+
+// DividendRequest represents the request to record a dividend
+type DividendRequest struct {
+	ExDate             string  `json:"ex_date" binding:"required"` // YYYY-MM-DD
+	PayDate            string  `json:"pay_date"`                   // YYYY-MM-DD, defaults to ex_date
+	GrossPerShare      float64 `json:"gross_per_share" binding:"required,gt=0"`
+	Shares             float64 `json:"shares" binding:"gte=0"`               // Defaults to ledger holding on ex_date
+	WithholdingTax     float64 `json:"withholding_tax" binding:"gte=0"`      // Amount withheld
+	WithholdingTaxRate float64 `json:"withholding_tax_rate" binding:"gte=0"` // Percentage, used when amount is 0
+	Currency           string  `json:"currency"`                             // Defaults to the stock's currency
+	FXRate             float64 `json:"fx_rate"`                              // Defaults to the current rate relative to EUR
+	CreditCash         *bool   `json:"credit_cash"`                          // Overrides the portfolio setting
+	Note               string  `json:"note"`
+}
+
+// GetDividends returns all dividends, optionally filtered by ?year=, with yearly totals
+func (h *DividendHandler) GetDividends(c *gin.Context) {
+	query := h.db.Order("pay_date DESC")
+	if yearParam := c.Query("year"); yearParam != "" {
+		year, err := strconv.Atoi(yearParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+			return
+		}
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		query = query.Where("pay_date >= ? AND pay_date < ?", start, start.AddDate(1, 0, 0))
+	}
+
+	var dividends []models.Dividend
+	if err := query.Find(&dividends).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch dividends")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dividends"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dividends": dividends,
+		"by_year":   services.SummarizeDividendsByYear(dividends),
+	})
+}
+
+// GetStockDividends returns all dividends recorded for a stock
+func (h *DividendHandler) GetStockDividends(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	var dividends []models.Dividend
+	if err := h.db.Where("stock_id = ?", stock.ID).Order("pay_date DESC").Find(&dividends).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch dividends")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dividends"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dividends": dividends,
+		"by_year":   services.SummarizeDividendsByYear(dividends),
+	})
+}
+
+// CreateDividend records a dividend payment and optionally credits it to cash
+func (h *DividendHandler) CreateDividend(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	var req DividendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	exDate, err := time.Parse("2006-01-02", req.ExDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ex_date must be in YYYY-MM-DD format"})
+		return
+	}
+	payDate := exDate
+	if req.PayDate != "" {
+		payDate, err = time.Parse("2006-01-02", req.PayDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pay_date must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+
+	shares := req.Shares
+	if shares == 0 {
+		transactions, err := services.NewLedgerService(h.db).GetTransactions(stock.ID)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to fetch transactions")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+			return
+		}
+		shares, err = services.SharesHeldOn(transactions, exDate)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidLedger) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			h.logger.Error().Err(err).Msg("Failed to calculate shares held on the ex-date")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate shares held on the ex-date"})
+			return
+		}
+	}
+	if shares <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No shares held on the ex-date. Provide shares explicitly."})
+		return
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = stock.Currency
+	}
+
+	fxRate := req.FXRate
+	if fxRate <= 0 {
+		rate, err := h.exchangeRateService.GetRateAt(currency, payDate)
+		if err != nil || rate <= 0 {
+			h.logger.Warn().Err(err).Str("currency", currency).Msg("Failed to get FX rate for dividend, using 1.0")
+			rate = 1.0
+		}
+		fxRate = rate
+	}
+
+	gross := shares * req.GrossPerShare
+	withholding := req.WithholdingTax
+	if withholding == 0 && req.WithholdingTaxRate > 0 {
+		withholding = gross * req.WithholdingTaxRate / 100
+	}
+	if withholding > gross {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Withholding tax cannot exceed the gross amount"})
+		return
+	}
+
+	creditCash := false
+	if req.CreditCash != nil {
+		creditCash = *req.CreditCash
+	} else {
+		var settings models.PortfolioSettings
+		if err := h.db.First(&settings).Error; err == nil {
+			creditCash = settings.CreditDividendsToCash
+		}
+	}
+
+	dividend := models.Dividend{
+		StockID:        stock.ID,
+		Ticker:         stock.Ticker,
+		ExDate:         exDate,
+		PayDate:        payDate,
+		Shares:         shares,
+		GrossPerShare:  req.GrossPerShare,
+		GrossAmount:    gross,
+		WithholdingTax: withholding,
+		NetAmount:      gross - withholding,
+		Currency:       currency,
+		FXRate:         fxRate,
+		CreditedToCash: creditCash,
+		Note:           req.Note,
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dividend).Error; err != nil {
+			return err
+		}
+		if creditCash {
+			return h.adjustCash(tx, dividend.Currency, dividend.NetAmount)
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to record dividend")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record dividend"})
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Float64("net_amount", dividend.NetAmount).Str("currency", currency).Msg("Dividend recorded")
+	c.JSON(http.StatusCreated, dividend)
+}
+
+// DeleteDividend deletes a dividend and reverses its cash credit
+func (h *DividendHandler) DeleteDividend(c *gin.Context) {
+	var dividend models.Dividend
+	if err := h.db.First(&dividend, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dividend not found"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&dividend).Error; err != nil {
+			return err
+		}
+		if dividend.CreditedToCash {
+			return h.adjustCash(tx, dividend.Currency, -dividend.NetAmount)
+		}
+		return nil
+	})
+	if errors.Is(err, errCashShortfall) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to delete dividend")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete dividend"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dividend deleted successfully"})
+}
+
+// adjustCash adds amount to the cash holding of the given currency, creating it if needed.
+// A debit larger than the holding fails with errCashShortfall rather than being capped.
+func (h *DividendHandler) adjustCash(tx *gorm.DB, currency string, amount float64) error {
+	var holding models.CashHolding
+	err := tx.Where("currency_code = ?", currency).First(&holding).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		holding = models.CashHolding{
+			CurrencyCode: currency,
+			Description:  "Dividend income",
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if holding.Amount+amount < 0 {
+		return fmt.Errorf("%w: reversing the %.2f %s credit needs %.2f %s more than the cash holding of %.2f %s; record the missing cash first",
+			errCashShortfall, -amount, currency, -(holding.Amount + amount), currency, holding.Amount, currency)
+	}
+	holding.Amount += amount
+
+	if err := h.cashHandler.setBaseValue(&holding); err != nil {
+		h.logger.Warn().Err(err).Str("currency", currency).Msg("Failed to calculate base value of cash holding")
+	}
+	holding.LastUpdated = time.Now()
+
+	return tx.Save(&holding).Error
+}
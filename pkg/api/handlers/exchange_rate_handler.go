@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/artpro/assessapp/pkg/config"
 	"github.com/artpro/assessapp/pkg/services"
@@ -24,7 +27,7 @@ func NewExchangeRateHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logg
 		db:      db,
 		cfg:     cfg,
 		logger:  logger,
-		service: services.NewExchangeRateService(db, logger),
+		service: services.NewExchangeRateService(db, cfg, logger),
 	}
 }
 
@@ -40,7 +43,7 @@ func (h *ExchangeRateHandler) GetAllRates(c *gin.Context) {
 	c.JSON(http.StatusOK, rates)
 }
 
-// RefreshRates fetches latest rates from the API
+// RefreshRates refreshes the stored rates through the FX provider chain
 func (h *ExchangeRateHandler) RefreshRates(c *gin.Context) {
 	if err := h.service.FetchLatestRates(); err != nil {
 		h.logger.Error().Err(err).Msg("Failed to refresh exchange rates")
@@ -62,6 +65,114 @@ func (h *ExchangeRateHandler) RefreshRates(c *gin.Context) {
 	})
 }
 
+// ImportECBRates imports an ECB euro reference rate file (multipart field "file", CSV or XML)
+// and refreshes the stored rates so that the imported rates can take effect
+func (h *ExchangeRateHandler) ImportECBRates(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large (max 10 MB)"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	added, err := h.service.ImportECBRates(file)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidImport) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to import ECB rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import ECB rates"})
+		return
+	}
+
+	if err := h.service.FetchLatestRates(); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to refresh exchange rates after ECB import")
+	}
+	rates, err := h.service.GetAllRates()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates after ECB import")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ECB rates imported",
+		"added":   added,
+		"rates":   rates,
+	})
+}
+
+// RatePoint is one entry of a currency's rate series
+type RatePoint struct {
+	Date   time.Time `json:"date"`
+	Rate   float64   `json:"rate"`
+	Source string    `json:"source"`
+}
+
+// GetRateHistory returns the rate series per currency.
+// Query: ?currency=USD,DKK (default all), ?from=&to= (YYYY-MM-DD)
+func (h *ExchangeRateHandler) GetRateHistory(c *gin.Context) {
+	var currencies []string
+	for _, code := range strings.Split(c.Query("currency"), ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			currencies = append(currencies, code)
+		}
+	}
+
+	var from, to time.Time
+	var err error
+	if param := c.Query("from"); param != "" {
+		if from, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	if param := c.Query("to"); param != "" {
+		if to, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	history, err := h.service.GetRateHistory(currencies, from, to)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rate history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rate history"})
+		return
+	}
+
+	series := make(map[string][]RatePoint)
+	for _, code := range currencies {
+		series[code] = []RatePoint{}
+	}
+	for _, entry := range history {
+		series[entry.CurrencyCode] = append(series[entry.CurrencyCode], RatePoint{
+			Date:   entry.RecordedAt,
+			Rate:   entry.Rate,
+			Source: entry.Source,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"base_currency": "EUR",
+		"series":        series,
+	})
+}
+
 // AddCurrencyRequest represents a request to add a new currency
 type AddCurrencyRequest struct {
 	CurrencyCode string  `json:"currency_code" binding:"required"`
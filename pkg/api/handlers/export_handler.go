@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// Datasets available for CSV export
+const (
+	exportHoldings      = "holdings"
+	exportCash          = "cash"
+	exportExchangeRates = "exchange_rates"
+	exportHistory       = "history"
+	exportAlerts        = "alerts"
+)
+
+// ExportHandler handles CSV and XLSX exports
+type ExportHandler struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	logger zerolog.Logger
+}
+
+// NewExportHandler creates a new export handler
+func NewExportHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *ExportHandler {
+	return &ExportHandler{
+		db:     db,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// GetExportColumns lists the selectable holdings columns
+func (h *ExportHandler) GetExportColumns(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"columns":  services.HoldingColumns,
+		"datasets": []string{exportHoldings, exportCash, exportExchangeRates, exportHistory, exportAlerts},
+	})
+}
+
+// ExportCSV exports one dataset as CSV.
+// Query: ?dataset=holdings|cash|exchange_rates|history|alerts (default holdings),
+// ?columns=ticker,shares_owned,... for holdings, ?stock_id= to filter history.
+func (h *ExportHandler) ExportCSV(c *gin.Context) {
+	dataset := c.DefaultQuery("dataset", exportHoldings)
+
+	var table services.ExportTable
+	var err error
+	switch dataset {
+	case exportHoldings:
+		table, err = h.holdingsTable(c.Query("columns"))
+	case exportCash:
+		table, err = h.cashTable()
+	case exportExchangeRates:
+		table, err = h.exchangeRatesTable()
+	case exportHistory:
+		table, err = h.historyTable(c.Query("stock_id"))
+	case exportAlerts:
+		table, err = h.alertsTable()
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dataset"})
+		return
+	}
+	if err != nil {
+		h.respondExportError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=portfolio_%s.csv", dataset))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(table.Headers)
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = services.FormatExportValue(value)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to write CSV export")
+	}
+}
+
+// ExportXLSX exports a workbook with holdings, cash, exchange rates, history and alerts sheets.
+// Query: ?columns=ticker,shares_owned,... selects the holdings columns.
+func (h *ExportHandler) ExportXLSX(c *gin.Context) {
+	holdings, err := h.holdingsTable(c.Query("columns"))
+	if err != nil {
+		h.respondExportError(c, err)
+		return
+	}
+	cash, err := h.cashTable()
+	if err != nil {
+		h.respondExportError(c, err)
+		return
+	}
+	rates, err := h.exchangeRatesTable()
+	if err != nil {
+		h.respondExportError(c, err)
+		return
+	}
+	history, err := h.historyTable(c.Query("stock_id"))
+	if err != nil {
+		h.respondExportError(c, err)
+		return
+	}
+	alerts, err := h.alertsTable()
+	if err != nil {
+		h.respondExportError(c, err)
+		return
+	}
+
+	workbook, err := buildWorkbook([]services.ExportTable{holdings, cash, rates, history, alerts})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to build XLSX export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build workbook"})
+		return
+	}
+	defer workbook.Close()
+
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", "attachment;filename=portfolio_export.xlsx")
+	c.Status(http.StatusOK)
+	if err := workbook.Write(c.Writer); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to write XLSX export")
+	}
+}
+
+// buildWorkbook writes each table to its own sheet with a bold header row
+func buildWorkbook(tables []services.ExportTable) (*excelize.File, error) {
+	f := excelize.NewFile()
+
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22}) // m/d/yy h:mm
+	if err != nil {
+		return nil, err
+	}
+
+	for i, table := range tables {
+		if i == 0 {
+			if err := f.SetSheetName("Sheet1", table.Name); err != nil {
+				return nil, err
+			}
+		} else if _, err := f.NewSheet(table.Name); err != nil {
+			return nil, err
+		}
+
+		for col, header := range table.Headers {
+			cell, _ := excelize.CoordinatesToCellName(col+1, 1)
+			f.SetCellValue(table.Name, cell, header)
+			f.SetCellStyle(table.Name, cell, cell, headerStyle)
+		}
+
+		for r, row := range table.Rows {
+			for col, value := range row {
+				cell, _ := excelize.CoordinatesToCellName(col+1, r+2)
+				if t, ok := value.(time.Time); ok {
+					if t.IsZero() {
+						continue
+					}
+					f.SetCellValue(table.Name, cell, t.UTC())
+					f.SetCellStyle(table.Name, cell, cell, dateStyle)
+					continue
+				}
+				if err := f.SetCellValue(table.Name, cell, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if len(table.Headers) > 0 {
+			f.SetPanes(table.Name, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+		}
+	}
+
+	return f, nil
+}
+
+func (h *ExportHandler) holdingsTable(columns string) (services.ExportTable, error) {
+	selected, err := services.SelectHoldingColumns(columns)
+	if err != nil {
+		return services.ExportTable{}, err
+	}
+	var stocks []models.Stock
+	if err := h.db.Order("ticker ASC").Find(&stocks).Error; err != nil {
+		return services.ExportTable{}, err
+	}
+	return services.HoldingsTable(stocks, selected), nil
+}
+
+func (h *ExportHandler) cashTable() (services.ExportTable, error) {
+	var holdings []models.CashHolding
+	if err := h.db.Order("currency_code ASC").Find(&holdings).Error; err != nil {
+		return services.ExportTable{}, err
+	}
+	return services.CashTable(holdings), nil
+}
+
+func (h *ExportHandler) exchangeRatesTable() (services.ExportTable, error) {
+	var rates []models.ExchangeRate
+	if err := h.db.Order("currency_code ASC").Find(&rates).Error; err != nil {
+		return services.ExportTable{}, err
+	}
+	return services.ExchangeRatesTable(rates), nil
+}
+
+func (h *ExportHandler) historyTable(stockID string) (services.ExportTable, error) {
+	query := h.db.Order("recorded_at ASC, id ASC")
+	if stockID != "" {
+		query = query.Where("stock_id = ?", stockID)
+	}
+	var history []models.StockHistory
+	if err := query.Find(&history).Error; err != nil {
+		return services.ExportTable{}, err
+	}
+	return services.HistoryTable(history), nil
+}
+
+func (h *ExportHandler) alertsTable() (services.ExportTable, error) {
+	var alerts []models.Alert
+	if err := h.db.Order("created_at DESC").Find(&alerts).Error; err != nil {
+		return services.ExportTable{}, err
+	}
+	return services.AlertsTable(alerts), nil
+}
+
+// respondExportError maps unknown columns to 400 and everything else to 500
+func (h *ExportHandler) respondExportError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrUnknownExportColumn) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Error().Err(err).Msg("Failed to load export data")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load export data"})
+}
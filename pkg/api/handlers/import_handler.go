@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// maxImportFileSize limits uploaded broker statements
+const maxImportFileSize = 10 << 20
+
+// ImportHandler handles broker statement imports
+type ImportHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *services.ExchangeRateService
+	transactionHandler  *TransactionHandler
+}
+
+// NewImportHandler creates a new import handler
+func NewImportHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *ImportHandler {
+	return &ImportHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+		transactionHandler:  NewTransactionHandler(db, cfg, logger),
+	}
+}
+
+// ImportBroker parses a broker CSV export and previews or applies it as transactions.
+// Multipart form fields:
+//   - file: the CSV export
+//   - broker: ibkr, nordnet, saxo or generic
+//   - mapping: JSON column mapping for the generic parser
+//   - create_missing: "true" to create stocks that match no ISIN or ticker
+//   - apply: "true" to write the transactions, otherwise only a preview is returned
+func (h *ImportHandler) ImportBroker(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large (max 10 MB)"})
+		return
+	}
+
+	var mapping *services.ColumnMapping
+	if raw := c.PostForm("mapping"); raw != "" {
+		mapping = &services.ColumnMapping{}
+		if err := json.Unmarshal([]byte(raw), mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping must be valid JSON"})
+			return
+		}
+	}
+
+	broker := c.PostForm("broker")
+	parser, err := services.NewBrokerParser(broker, mapping)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	trades, err := parser.Parse(file)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidImport) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to parse broker file")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse broker file"})
+		return
+	}
+
+	var stocks []models.Stock
+	if err := h.db.Find(&stocks).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+
+	var transactions []models.Transaction
+	if err := h.db.Order("trade_date ASC, id ASC").Find(&transactions).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+	ledgers := make(map[uint][]models.Transaction)
+	for _, tx := range transactions {
+		ledgers[tx.StockID] = append(ledgers[tx.StockID], tx)
+	}
+
+	createMissing := c.PostForm("create_missing") == "true"
+	plan := services.PlanImport(parser.Name(), trades, stocks, ledgers, createMissing)
+
+	if c.PostForm("apply") != "true" {
+		c.JSON(http.StatusOK, gin.H{"applied": false, "plan": plan})
+		return
+	}
+
+	if !plan.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import would leave an invalid ledger", "plan": plan})
+		return
+	}
+
+	created, err := h.applyPlan(&plan)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidLedger) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to apply broker import")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply broker import"})
+		return
+	}
+
+	h.logger.Info().Str("broker", plan.Broker).Int("transactions", created).Int("duplicates", plan.Duplicates).Int("unmatched", plan.Unmatched).Msg("Broker import applied")
+	c.JSON(http.StatusOK, gin.H{"applied": true, "created": created, "plan": plan})
+}
+
+// applyPlan creates the planned stocks and transactions and re-derives every affected position
+func (h *ImportHandler) applyPlan(plan *services.ImportPlan) (int, error) {
+	created := 0
+	rates := make(map[string]float64)
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		stockIDs := make(map[string]uint)
+		for i, change := range plan.Stocks {
+			if change.StockID != 0 {
+				stockIDs[change.Key] = change.StockID
+				continue
+			}
+
+			stock := models.Stock{
+				Ticker:      change.Ticker,
+				ISIN:        change.ISIN,
+				CompanyName: change.Name,
+				Currency:    change.Currency,
+				LastUpdated: time.Now(),
+			}
+			if stock.CompanyName == "" {
+				stock.CompanyName = stock.Ticker
+			}
+			if stock.Currency == "" {
+				stock.Currency = "USD"
+			}
+			if err := tx.Create(&stock).Error; err != nil {
+				return err
+			}
+			stockIDs[change.Key] = stock.ID
+			plan.Stocks[i].StockID = stock.ID
+		}
+
+		for i, row := range plan.Rows {
+			if row.Status != services.ImportStatusNew && row.Status != services.ImportStatusNewStock {
+				continue
+			}
+
+			stockID := stockIDs[row.StockKey]
+			plan.Rows[i].StockID = stockID
+
+			transaction := row.Trade.Transaction(stockID, row.Trade.FXRate)
+			if transaction.Currency == "" {
+				var stock models.Stock
+				if err := tx.First(&stock, stockID).Error; err != nil {
+					return err
+				}
+				transaction.Currency = stock.Currency
+			}
+			if transaction.FXRate <= 0 {
+				transaction.FXRate = h.rateFor(transaction.Currency, transaction.TradeDate, rates)
+			}
+			transaction.Source = "import:" + plan.Broker
+			transaction.ExternalID = row.Trade.ExternalID(plan.Broker)
+			if transaction.Note == "" {
+				transaction.Note = fmt.Sprintf("Imported from %s (row %d)", plan.Broker, row.Trade.Row)
+			}
+			if err := tx.Create(&transaction).Error; err != nil {
+				return err
+			}
+			created++
+		}
+
+		for _, stockID := range stockIDs {
+			var stock models.Stock
+			if err := tx.First(&stock, stockID).Error; err != nil {
+				return err
+			}
+			if _, err := services.NewLedgerService(tx).SyncPosition(&stock); err != nil {
+				return err
+			}
+			h.transactionHandler.updatePositionValues(&stock)
+			stock.LastUpdated = time.Now()
+			if err := tx.Save(&stock).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return created, err
+}
+
+// rateFor returns the rate of a currency relative to EUR on the trade date, cached per import
+func (h *ImportHandler) rateFor(currency string, date time.Time, cache map[string]float64) float64 {
+	key := currency + "@" + date.Format("2006-01-02")
+	if rate, ok := cache[key]; ok {
+		return rate
+	}
+	rate, err := h.exchangeRateService.GetRateAt(currency, date)
+	if err != nil || rate <= 0 {
+		h.logger.Warn().Err(err).Str("currency", currency).Msg("Failed to get FX rate for import, using 1.0")
+		rate = 1.0
+	}
+	cache[key] = rate
+	return rate
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// PerformanceHandler handles portfolio and position return requests
+type PerformanceHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *services.ExchangeRateService
+	benchmarkService    *services.BenchmarkService
+}
+
+// NewPerformanceHandler creates a new performance handler
+func NewPerformanceHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *PerformanceHandler {
+	return &PerformanceHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+		benchmarkService:    services.NewBenchmarkService(db, services.NewMarketDataService(db, cfg, services.NewExternalAPIService(cfg))),
+	}
+}
+
+// PerformancePeriod is the portfolio and per-position performance for one period
+type PerformancePeriod struct {
+	Period    string                        `json:"period"`
+	Portfolio services.PeriodReturn         `json:"portfolio"`
+	Benchmark *services.BenchmarkComparison `json:"benchmark,omitempty"` // When a benchmark is configured
+	Positions []services.PositionReturn     `json:"positions,omitempty"`
+}
+
+// GetPerformance returns time-weighted (TWR) and money-weighted (XIRR) returns.
+// Query: ?period=mtd|ytd|1y|inception (default all four), or ?from=&to= (YYYY-MM-DD)
+// for a custom range; ?stock_id= limits to one position; ?positions=false omits positions.
+func (h *PerformanceHandler) GetPerformance(c *gin.Context) {
+	input, err := h.loadInput(c.Query("stock_id"))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to load performance data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load performance data"})
+		return
+	}
+	if c.Query("stock_id") != "" && len(input.Stocks) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	calc := services.NewPerformanceCalculator(input)
+	includePositions := c.DefaultQuery("positions", "true") != "false"
+
+	type periodRange struct {
+		name     string
+		from, to time.Time
+	}
+	var ranges []periodRange
+
+	if c.Query("from") != "" || c.Query("to") != "" {
+		from, to := calc.PeriodRange(services.PeriodInception)
+		if param := c.Query("from"); param != "" {
+			if from, err = time.Parse("2006-01-02", param); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "from must be in YYYY-MM-DD format"})
+				return
+			}
+		}
+		if param := c.Query("to"); param != "" {
+			if to, err = time.Parse("2006-01-02", param); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "to must be in YYYY-MM-DD format"})
+				return
+			}
+		}
+		if to.Before(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+			return
+		}
+		ranges = append(ranges, periodRange{services.PeriodCustom, from, to})
+	} else {
+		periods := []string{services.PeriodMTD, services.PeriodYTD, services.PeriodOneYear, services.PeriodInception}
+		if period := c.Query("period"); period != "" {
+			switch period {
+			case services.PeriodMTD, services.PeriodYTD, services.PeriodOneYear, services.PeriodInception:
+				periods = []string{period}
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "period must be one of mtd, ytd, 1y, inception"})
+				return
+			}
+		}
+		for _, period := range periods {
+			from, to := calc.PeriodRange(period)
+			ranges = append(ranges, periodRange{period, from, to})
+		}
+	}
+
+	// Benchmark comparison is only meaningful for the whole portfolio
+	var benchmarkSettings models.PortfolioSettings
+	var benchmarkPrices []models.BenchmarkPrice
+	if c.Query("stock_id") == "" {
+		settings, err := h.benchmarkService.Settings()
+		switch {
+		case err == nil:
+			benchmarkSettings = settings
+			if benchmarkPrices, err = h.benchmarkService.GetPrices(settings.BenchmarkTicker, time.Time{}, time.Time{}); err != nil {
+				h.logger.Warn().Err(err).Msg("Failed to fetch benchmark prices")
+			}
+		case !errors.Is(err, services.ErrNoBenchmark):
+			h.logger.Warn().Err(err).Msg("Failed to fetch benchmark settings")
+		}
+	}
+
+	results := make([]PerformancePeriod, 0, len(ranges))
+	for _, r := range ranges {
+		result := PerformancePeriod{
+			Period:    r.name,
+			Portfolio: calc.Portfolio(r.name, r.from, r.to),
+		}
+		if benchmarkSettings.BenchmarkTicker != "" {
+			comparison := services.CompareWithBenchmark(calc, result.Portfolio.TWR, benchmarkSettings, benchmarkPrices, r.from, r.to)
+			result.Benchmark = &comparison
+		}
+		if includePositions {
+			result.Positions = calc.Positions(r.name, r.from, r.to)
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"periods":   results,
+		"inception": calc.Inception(),
+		"currency":  "EUR",
+		"note":      "Returns cover invested positions; cash balances have no flow history and are excluded.",
+	})
+}
+
+// loadInput reads stocks, ledgers, dividends and price history, optionally for one stock
+func (h *PerformanceHandler) loadInput(stockID string) (services.PerformanceInput, error) {
+	input := services.PerformanceInput{
+		Transactions: make(map[uint][]models.Transaction),
+		Dividends:    make(map[uint][]models.Dividend),
+		History:      make(map[uint][]models.StockHistory),
+		Now:          time.Now(),
+	}
+
+	scope := func(query *gorm.DB, column string) *gorm.DB {
+		if stockID == "" {
+			return query
+		}
+		return query.Where(column+" = ?", stockID)
+	}
+
+	if stockID != "" {
+		if _, err := strconv.ParseUint(stockID, 10, 64); err != nil {
+			return input, nil
+		}
+	}
+
+	if err := scope(h.db.Model(&models.Stock{}), "id").Find(&input.Stocks).Error; err != nil {
+		return input, err
+	}
+
+	var transactions []models.Transaction
+	if err := scope(h.db.Order("trade_date ASC, id ASC"), "stock_id").Find(&transactions).Error; err != nil {
+		return input, err
+	}
+	for _, tx := range transactions {
+		input.Transactions[tx.StockID] = append(input.Transactions[tx.StockID], tx)
+	}
+
+	var dividends []models.Dividend
+	if err := scope(h.db.Order("pay_date ASC"), "stock_id").Find(&dividends).Error; err != nil {
+		return input, err
+	}
+	for _, d := range dividends {
+		input.Dividends[d.StockID] = append(input.Dividends[d.StockID], d)
+	}
+
+	var history []models.StockHistory
+	if err := scope(h.db.Order("recorded_at ASC"), "stock_id").Find(&history).Error; err != nil {
+		return input, err
+	}
+	for _, entry := range history {
+		input.History[entry.StockID] = append(input.History[entry.StockID], entry)
+	}
+
+	rates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to fetch exchange rates for performance")
+		rates = map[string]float64{"EUR": 1.0}
+	}
+	input.CurrentRates = rates
+
+	if input.RateHistory, err = h.exchangeRateService.GetRateHistory(nil, time.Time{}, time.Time{}); err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to fetch exchange rate history for performance")
+	}
+
+	return input, nil
+}
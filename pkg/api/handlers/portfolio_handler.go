@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/artpro/assessapp/pkg/config"
@@ -18,17 +21,22 @@ type PortfolioHandler struct {
 	cfg                 *config.Config
 	logger              zerolog.Logger
 	apiService          *services.ExternalAPIService
+	marketData          *services.MarketDataService
 	exchangeRateService *services.ExchangeRateService
+	snapshotService     *services.SnapshotService
 }
 
 // NewPortfolioHandler creates a new portfolio handler
 func NewPortfolioHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *PortfolioHandler {
+	apiService := services.NewExternalAPIService(cfg)
 	return &PortfolioHandler{
 		db:                  db,
 		cfg:                 cfg,
 		logger:              logger,
-		apiService:          services.NewExternalAPIService(cfg),
-		exchangeRateService: services.NewExchangeRateService(db, logger),
+		apiService:          apiService,
+		marketData:          services.NewMarketDataService(db, cfg, apiService),
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+		snapshotService:     services.NewSnapshotService(db, cfg, logger),
 	}
 }
 
@@ -45,19 +53,23 @@ func (h *PortfolioHandler) GetPortfolioSummary(c *gin.Context) {
 	fxRates, err := h.exchangeRateService.GetRatesMap()
 	if err != nil {
 		h.logger.Warn().Err(err).Msg("Failed to fetch exchange rates from database")
-		// Use fallback rates
-		fxRates = make(map[string]float64)
-		fxRates["EUR"] = 1.0
-		fxRates["USD"] = 1.154
-		fxRates["DKK"] = 7.4604
-		fxRates["GBP"] = 0.8796
-		fxRates["RUB"] = 93.7594
+		// Use the built-in defaults
+		fxRates = make(map[string]float64, len(services.StaticFXRates))
+		for code, rate := range services.StaticFXRates {
+			fxRates[code] = rate
+		}
 	}
 
-	// Calculate portfolio metrics
-	metrics := services.CalculatePortfolioMetrics(stocks, fxRates)
+	// Calculate portfolio metrics (in EUR), reported in the reporting currency
+	currency := h.exchangeRateService.ReportingCurrency()
+	correlations, err := services.NewStatisticsService(h.db).Correlations(stocks)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to compute correlations; assuming fully correlated positions")
+		correlations = nil
+	}
+	metrics := services.CalculatePortfolioMetrics(stocks, fxRates, correlations)
 
-	// Update weights for each stock
+	// Update weights and reporting-currency values for each stock
 	for i := range stocks {
 		if stocks[i].SharesOwned <= 0 {
 			continue
@@ -67,21 +79,170 @@ func (h *PortfolioHandler) GetPortfolioSummary(c *gin.Context) {
 		if fxRate == 0 {
 			fxRate = 1.0
 		}
-		// Convert to EUR (base currency)
-		valueEUR := float64(stocks[i].SharesOwned) * stocks[i].CurrentPrice / fxRate
+		// Convert to EUR (base of the rates) for the weight
+		valueEUR := stocks[i].SharesOwned * stocks[i].CurrentPrice / fxRate
 		if metrics.TotalValue > 0 {
 			stocks[i].Weight = (valueEUR / metrics.TotalValue) * 100
-			stocks[i].CurrentValueUSD = valueEUR * fxRates["USD"] // Store in USD for backward compatibility
+			services.PositionValues(&stocks[i], fxRates, currency)
 			h.db.Save(&stocks[i])
 		}
 	}
+	metrics = metrics.InCurrency(currency, fxRates)
+
+	// Dividend income for the current calendar year
+	yearStart := time.Date(time.Now().Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	var dividends []models.Dividend
+	if err := h.db.Where("pay_date >= ? AND pay_date < ?", yearStart, yearStart.AddDate(1, 0, 0)).Find(&dividends).Error; err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to fetch dividends for summary")
+	}
+	dividendIncome := services.DividendYear{Year: yearStart.Year(), ByCurrency: map[string]services.DividendTotals{}}
+	if years := services.SummarizeDividendsByYear(dividends); len(years) > 0 {
+		dividendIncome = years[len(years)-1]
+	}
+
+	// Without the scheduler (serverless) the first summary of the day records the snapshot
+	if !h.cfg.EnableScheduler {
+		if err := h.snapshotService.EnsureDailySnapshot(); err != nil {
+			h.logger.Warn().Err(err).Msg("Failed to record daily portfolio snapshot")
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"summary": metrics,
-		"stocks":  stocks,
+		"summary":         metrics,
+		"stocks":          stocks,
+		"dividend_income": dividendIncome,
+		"currency":        currency,
 	})
 }
 
+// GetAllocation returns joint fractional-Kelly target weights for all stocks with a
+// positive edge (?held=true to consider only current positions)
+func (h *PortfolioHandler) GetAllocation(c *gin.Context) {
+	query := h.db.Preload("Scenarios").Order("ticker ASC")
+	if c.Query("held") == "true" {
+		query = query.Where("shares_owned > 0")
+	}
+	var stocks []models.Stock
+	if err := query.Find(&stocks).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+
+	var cash []models.CashHolding
+	if err := h.db.Find(&cash).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch cash holdings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash holdings"})
+		return
+	}
+
+	fxRates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	correlations, err := services.NewStatisticsService(h.db).Correlations(stocks)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to compute correlations; using the default correlation")
+		correlations = nil
+	}
+
+	strategy, err := h.marketData.Strategy()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to load strategy profile")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load strategy profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.AllocateKelly(stocks, cash, fxRates, correlations, services.AllocationConstraintsFor(strategy)))
+}
+
+// Simulate runs a seeded Monte Carlo of the portfolio over a horizon for the current
+// weights and for the half-Kelly weights (body: paths, horizon_days, seed, ruin_level,
+// drawdown_limit; all optional, drawdown_limit defaulting to the drawdown alert level)
+func (h *PortfolioHandler) Simulate(c *gin.Context) {
+	var opts services.SimulationOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	if opts.DrawdownLimit == 0 {
+		var settings models.PortfolioSettings
+		if err := h.db.First(&settings).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			h.logger.Error().Err(err).Msg("Failed to fetch settings")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+			return
+		}
+		opts.DrawdownLimit = settings.DrawdownAlertLevel
+	}
+	if err := opts.Normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var stocks []models.Stock
+	if err := h.db.Preload("Scenarios").Order("ticker ASC").Find(&stocks).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+	var cash []models.CashHolding
+	if err := h.db.Find(&cash).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch cash holdings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash holdings"})
+		return
+	}
+	fxRates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+	strategy, err := h.marketData.Strategy()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to load strategy profile")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load strategy profile"})
+		return
+	}
+	correlations, err := services.NewStatisticsService(h.db).Correlations(stocks)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to compute correlations; using the default correlation")
+		correlations = nil
+	}
+
+	halfKelly, warnings := services.HalfKellyTargets(stocks, services.AllocationConstraintsFor(strategy))
+	simulation, err := services.SimulatePortfolio(stocks, cash, fxRates, correlations, halfKelly, opts)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to simulate portfolio")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to simulate portfolio"})
+		return
+	}
+	simulation.Warnings = append(warnings, simulation.Warnings...)
+
+	c.JSON(http.StatusOK, simulation)
+}
+
+// GetDrawdown returns the running peak, current and maximum drawdown and recovery of
+// the portfolio and of each held position (?source=snapshots|prices)
+func (h *PortfolioHandler) GetDrawdown(c *gin.Context) {
+	report, err := services.NewDrawdownService(h.db, h.cfg, h.logger).Report(c.Query("source"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDrawdownSource) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to compute drawdowns")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute drawdowns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 // GetSettings returns portfolio settings
 func (h *PortfolioHandler) GetSettings(c *gin.Context) {
 	var settings models.PortfolioSettings
@@ -92,6 +253,7 @@ func (h *PortfolioHandler) GetSettings(c *gin.Context) {
 				UpdateFrequency:  "daily",
 				AlertsEnabled:    true,
 				AlertThresholdEV: 10.0,
+				CostBasisMethod:  "fifo",
 			}
 			h.db.Create(&settings)
 		} else {
@@ -115,7 +277,12 @@ func (h *PortfolioHandler) GetAPIStatus(c *gin.Context) {
 			"configured": h.cfg.AlphaVantageAPIKey != "",
 			"status":     "unknown",
 		},
-		"timestamp": time.Now(),
+		"file": gin.H{
+			"configured": h.cfg.MarketDataDir != "",
+			"directory":  h.cfg.MarketDataDir,
+		},
+		"market_data_order": h.marketData.PortfolioOrder(),
+		"timestamp":         time.Now(),
 	}
 
 	// Test Alpha Vantage connection if configured
@@ -144,7 +311,8 @@ func (h *PortfolioHandler) GetAPIStatus(c *gin.Context) {
 		}
 
 		// Try to fetch data
-		err := h.apiService.FetchAllStockData(&testStock)
+		grok, _ := h.marketData.Provider(services.MarketDataGrok)
+		_, err := grok.Quote(&testStock)
 		if err != nil {
 			status["grok"].(gin.H)["status"] = "error"
 			status["grok"].(gin.H)["error"] = err.Error()
@@ -181,12 +349,68 @@ func (h *PortfolioHandler) UpdateSettings(c *gin.Context) {
 		}
 	}
 
+	// A new reporting currency must be a tracked currency
+	reportingChanged := false
+	if value, ok := req["reporting_currency"]; ok {
+		code, _ := value.(string)
+		code = strings.ToUpper(strings.TrimSpace(code))
+		var rate models.ExchangeRate
+		if err := h.db.Where("currency_code = ? AND is_active = ?", code, true).First(&rate).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reporting_currency must be an active exchange rate currency"})
+			return
+		}
+		req["reporting_currency"] = code
+		reportingChanged = code != settings.ReportingCurrency
+	}
+
+	// The provider priority may only name registered market data providers
+	if value, ok := req["data_providers"]; ok {
+		list, _ := value.(string)
+		order, err := h.marketData.ValidateOrder(list)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req["data_providers"] = order
+	}
+
+	// Statistics windows count daily returns
+	for _, key := range []string{"volatility_window", "beta_window"} {
+		if value, ok := req[key]; ok {
+			window, _ := value.(float64)
+			if window < services.MinRiskObservations || window != float64(int(window)) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a whole number of at least %d trading days", key, services.MinRiskObservations)})
+				return
+			}
+		}
+	}
+
+	if value, ok := req["min_trade_value"]; ok {
+		if amount, _ := value.(float64); amount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_trade_value must not be negative"})
+			return
+		}
+	}
+
+	if value, ok := req["drawdown_alert_level"]; ok {
+		if level, _ := value.(float64); level < 0 || level >= 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "drawdown_alert_level must be between 0 and 100"})
+			return
+		}
+	}
+
 	if err := h.db.Model(&settings).Updates(req).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to update settings")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
 		return
 	}
 
+	if reportingChanged {
+		if err := h.exchangeRateService.RefreshBaseValues(); err != nil {
+			h.logger.Error().Err(err).Msg("Failed to recalculate values in the new reporting currency")
+		}
+	}
+
 	c.JSON(http.StatusOK, settings)
 }
 
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// PriceHandler handles the daily price bars of stocks
+type PriceHandler struct {
+	db           *gorm.DB
+	cfg          *config.Config
+	logger       zerolog.Logger
+	priceService *services.PriceService
+}
+
+// NewPriceHandler creates a new price handler
+func NewPriceHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *PriceHandler {
+	return &PriceHandler{
+		db:           db,
+		cfg:          cfg,
+		logger:       logger,
+		priceService: services.NewPriceService(db, services.NewMarketDataService(db, cfg, services.NewExternalAPIService(cfg))),
+	}
+}
+
+// GetPrices returns the stored bars of a stock (?from=&to=, YYYY-MM-DD; ?interval=daily|weekly|monthly)
+func (h *PriceHandler) GetPrices(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if param := c.Query("from"); param != "" {
+		if from, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	if param := c.Query("to"); param != "" {
+		if to, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+
+	interval := c.DefaultQuery("interval", services.PriceIntervalDaily)
+	bars, err := h.priceService.GetBars(stock.ID, from, to)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch price bars")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+		return
+	}
+	bars, err = services.ResampleBars(bars, interval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stock_id": stock.ID,
+		"ticker":   stock.Ticker,
+		"currency": stock.Currency,
+		"interval": interval,
+		"prices":   bars,
+	})
+}
+
+// BackfillPrices downloads the stock's daily bars through its market data providers (?full=true for full history)
+func (h *PriceHandler) BackfillPrices(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	stored, provider, err := h.priceService.Backfill(&stock, c.Query("full") == "true")
+	if err != nil {
+		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("Failed to backfill prices")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to backfill prices: " + err.Error()})
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Str("provider", provider).Int("stored", stored).Msg("Prices backfilled")
+	c.JSON(http.StatusOK, gin.H{"message": "Prices backfilled", "provider": provider, "stored": stored})
+}
+
+// ImportPrices stores a daily price CSV (date, close and optional open/high/low/volume)
+// for the stock. Multipart form field: file.
+func (h *PriceHandler) ImportPrices(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large (max 10 MB)"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	bars, err := services.ParsePriceCSV(file)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidImport) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to parse prices")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse prices"})
+		return
+	}
+
+	stored, err := h.priceService.StoreBars(stock.ID, services.PriceSourceCSV, bars)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to store prices")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store prices"})
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Int("stored", stored).Msg("Prices imported")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Prices imported",
+		"ticker":  stock.Ticker,
+		"stored":  stored,
+		"from":    bars[0].Date,
+		"to":      bars[len(bars)-1].Date,
+	})
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// RebalanceHandler handles rebalance proposals and their orders
+type RebalanceHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *services.ExchangeRateService
+}
+
+// NewRebalanceHandler creates a new rebalance handler
+func NewRebalanceHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *RebalanceHandler {
+	return &RebalanceHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+	}
+}
+
+// RebalanceRequest configures a new proposal
+type RebalanceRequest struct {
+	Method        string   `json:"method"`          // half_kelly (default) or allocator
+	MinTradeValue *float64 `json:"min_trade_value"` // In the reporting currency; defaults to the portfolio setting
+}
+
+// ExecuteOrderRequest marks a rebalance order as executed or skipped
+type ExecuteOrderRequest struct {
+	Status            string  `json:"status" binding:"required"` // executed or skipped
+	Shares            float64 `json:"shares"`                    // Executed quantity; defaults to the proposed shares
+	Price             float64 `json:"price"`                     // Execution price in local currency; defaults to the proposed price
+	Fees              float64 `json:"fees"`
+	TradeDate         string  `json:"trade_date"` // YYYY-MM-DD, defaults to today
+	RecordTransaction bool    `json:"record_transaction"`
+}
+
+// CreateRebalance computes the orders that move the portfolio to its target weights and saves them
+func (h *RebalanceHandler) CreateRebalance(c *gin.Context) {
+	var req RebalanceRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	method, err := services.ParseRebalanceMethod(req.Method)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var stocks []models.Stock
+	if err := h.db.Preload("Scenarios").Order("ticker ASC").Find(&stocks).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+	var cash []models.CashHolding
+	if err := h.db.Find(&cash).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch cash holdings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash holdings"})
+		return
+	}
+	fxRates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	var settings models.PortfolioSettings
+	if err := h.db.First(&settings).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		h.logger.Error().Err(err).Msg("Failed to fetch settings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+	minTrade := settings.MinTradeValue
+	if req.MinTradeValue != nil {
+		if *req.MinTradeValue < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_trade_value must not be negative"})
+			return
+		}
+		minTrade = *req.MinTradeValue
+	}
+	// The minimum is set in the reporting currency; orders are compared in EUR
+	if rate := fxRates[h.exchangeRateService.ReportingCurrency()]; rate > 0 {
+		minTrade /= rate
+	}
+
+	strategy, err := services.NewStrategyService(h.db).Active()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to load strategy profile")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load strategy profile"})
+		return
+	}
+	constraints := services.AllocationConstraintsFor(strategy)
+	var targets map[uint]float64
+	var warnings []string
+	if method == services.RebalanceMethodAllocator {
+		correlations, err := services.NewStatisticsService(h.db).Correlations(stocks)
+		if err != nil {
+			h.logger.Warn().Err(err).Msg("Failed to compute correlations; using the default correlation")
+			correlations = nil
+		}
+		allocation := services.AllocateKelly(stocks, cash, fxRates, correlations, constraints)
+		targets = make(map[uint]float64, len(allocation.Positions))
+		for _, position := range allocation.Positions {
+			targets[position.StockID] = position.TargetWeight
+		}
+		warnings = allocation.Warnings
+	} else {
+		targets, warnings = services.HalfKellyTargets(stocks, constraints)
+	}
+
+	proposal := services.BuildRebalance(stocks, cash, fxRates, targets, services.RebalanceOptions{
+		Method:        method,
+		MinTradeValue: minTrade,
+		Constraints:   constraints,
+	})
+	proposal.Warnings = append(warnings, proposal.Warnings...)
+	if len(proposal.Orders) == 0 {
+		proposal.Status = models.RebalanceStatusCompleted
+	}
+
+	if err := h.db.Create(&proposal).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to save rebalance proposal")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rebalance proposal"})
+		return
+	}
+
+	h.logger.Info().Uint("proposal_id", proposal.ID).Str("method", method).Int("orders", len(proposal.Orders)).Msg("Rebalance proposal created")
+	c.JSON(http.StatusCreated, proposal)
+}
+
+// GetRebalances returns the saved proposals, newest first
+func (h *RebalanceHandler) GetRebalances(c *gin.Context) {
+	var proposals []models.RebalanceProposal
+	if err := h.db.Preload("Orders").Order("created_at DESC").Limit(20).Find(&proposals).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch rebalance proposals")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rebalance proposals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, proposals)
+}
+
+// GetRebalance returns one proposal with its orders
+func (h *RebalanceHandler) GetRebalance(c *gin.Context) {
+	var proposal models.RebalanceProposal
+	if err := h.db.Preload("Orders").First(&proposal, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rebalance proposal not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, proposal)
+}
+
+// UpdateOrder marks an order as executed or skipped. Executed orders can record the
+// trade in the stock's ledger (record_transaction), which updates the position.
+func (h *RebalanceHandler) UpdateOrder(c *gin.Context) {
+	var proposal models.RebalanceProposal
+	if err := h.db.Preload("Orders").First(&proposal, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rebalance proposal not found"})
+		return
+	}
+	var order *models.RebalanceOrder
+	for i := range proposal.Orders {
+		if c.Param("orderId") == fmt.Sprint(proposal.Orders[i].ID) {
+			order = &proposal.Orders[i]
+		}
+	}
+	if order == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var req ExecuteOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if order.Status != models.RebalanceOrderPending {
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrRebalanceOrderClosed.Error()})
+		return
+	}
+
+	tradeDate := time.Now()
+	if req.TradeDate != "" {
+		parsed, err := time.Parse("2006-01-02", req.TradeDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "trade_date must be in YYYY-MM-DD format"})
+			return
+		}
+		tradeDate = parsed
+	}
+
+	switch req.Status {
+	case models.RebalanceOrderSkipped:
+		order.Status = models.RebalanceOrderSkipped
+	case models.RebalanceOrderExecuted:
+		if req.Shares < 0 || req.Price < 0 || req.Fees < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "shares, price and fees must not be negative"})
+			return
+		}
+		order.Status = models.RebalanceOrderExecuted
+		order.ExecutedShares = order.Shares
+		if req.Shares > 0 {
+			order.ExecutedShares = req.Shares
+		}
+		order.ExecutedPrice = order.Price
+		if req.Price > 0 {
+			order.ExecutedPrice = req.Price
+		}
+		order.ExecutedAt = &tradeDate
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be executed or skipped"})
+		return
+	}
+	proposal.Status = services.CompleteRebalanceStatus(proposal.Orders)
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if req.RecordTransaction && order.Status == models.RebalanceOrderExecuted {
+			if err := h.recordTransaction(tx, order, req.Fees, tradeDate); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(order).Error; err != nil {
+			return err
+		}
+		return tx.Model(&proposal).Update("status", proposal.Status).Error
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidLedger) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to update rebalance order")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rebalance order"})
+		return
+	}
+
+	h.logger.Info().Uint("proposal_id", proposal.ID).Str("ticker", order.Ticker).Str("status", order.Status).Msg("Rebalance order updated")
+	c.JSON(http.StatusOK, proposal)
+}
+
+// recordTransaction writes the executed order to the stock's ledger and re-derives the position
+func (h *RebalanceHandler) recordTransaction(tx *gorm.DB, order *models.RebalanceOrder, fees float64, tradeDate time.Time) error {
+	var stock models.Stock
+	if err := tx.First(&stock, order.StockID).Error; err != nil {
+		return err
+	}
+
+	fxRate, err := h.exchangeRateService.GetRateAt(order.Currency, tradeDate)
+	if err != nil || fxRate <= 0 {
+		h.logger.Warn().Err(err).Str("currency", order.Currency).Msg("Failed to get FX rate for transaction, using 1.0")
+		fxRate = 1.0
+	}
+	transaction := models.Transaction{
+		StockID:   stock.ID,
+		Type:      order.Side,
+		TradeDate: tradeDate,
+		Quantity:  order.ExecutedShares,
+		Price:     order.ExecutedPrice,
+		Fees:      fees,
+		Currency:  order.Currency,
+		FXRate:    fxRate,
+		Source:    "rebalance",
+		Note:      fmt.Sprintf("Rebalance proposal %d", order.ProposalID),
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return err
+	}
+	order.TransactionID = &transaction.ID
+
+	if _, err := services.NewLedgerService(tx).SyncPosition(&stock); err != nil {
+		return err
+	}
+	if err := h.exchangeRateService.UpdatePositionValues(&stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
+	}
+	stock.LastUpdated = time.Now()
+	return tx.Save(&stock).Error
+}
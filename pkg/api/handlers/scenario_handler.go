@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// ScenarioHandler handles the bull/base/bear scenarios of stocks
+type ScenarioHandler struct {
+	db              *gorm.DB
+	cfg             *config.Config
+	logger          zerolog.Logger
+	scenarioService *services.ScenarioService
+	strategyService *services.StrategyService
+}
+
+// NewScenarioHandler creates a new scenario handler
+func NewScenarioHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *ScenarioHandler {
+	return &ScenarioHandler{
+		db:              db,
+		cfg:             cfg,
+		logger:          logger,
+		scenarioService: services.NewScenarioService(db),
+		strategyService: services.NewStrategyService(db),
+	}
+}
+
+// ScenarioInput is one scenario of a SetScenariosRequest
+type ScenarioInput struct {
+	Name        string  `json:"name" binding:"required"`
+	Probability float64 `json:"probability"` // 0-1
+	TargetPrice float64 `json:"target_price"`
+	Note        string  `json:"note"`
+}
+
+// SetScenariosRequest replaces a stock's scenarios; an empty list returns it to the binary model
+type SetScenariosRequest struct {
+	Scenarios []ScenarioInput `json:"scenarios"`
+}
+
+// GetScenarios returns the stock's scenarios and the metrics of their distribution
+func (h *ScenarioHandler) GetScenarios(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.Preload("Scenarios").First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.scenarioResponse(&stock))
+}
+
+// SetScenarios replaces the stock's scenarios and recalculates its EV and Kelly from them
+func (h *ScenarioHandler) SetScenarios(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	var req SetScenariosRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	scenarios := make([]models.StockScenario, 0, len(req.Scenarios))
+	for _, input := range req.Scenarios {
+		scenarios = append(scenarios, models.StockScenario{
+			Name:        input.Name,
+			Probability: input.Probability,
+			TargetPrice: input.TargetPrice,
+			Note:        input.Note,
+		})
+	}
+
+	if err := h.scenarioService.Replace(&stock, scenarios); err != nil {
+		if errors.Is(err, services.ErrInvalidScenarios) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("Failed to save scenarios")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save scenarios"})
+		return
+	}
+
+	strategy, err := h.strategyService.Active()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to load strategy profile, using defaults")
+		strategy = services.DefaultStrategyProfile()
+	}
+	services.CalculateMetrics(&stock, strategy)
+	stock.LastUpdated = time.Now()
+	if err := h.db.Save(&stock).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to save stock")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save stock"})
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Int("scenarios", len(stock.Scenarios)).Str("ev_model", stock.EVModel).Msg("Stock scenarios updated")
+	c.JSON(http.StatusOK, h.scenarioResponse(&stock))
+}
+
+func (h *ScenarioHandler) scenarioResponse(stock *models.Stock) gin.H {
+	response := gin.H{
+		"stock_id":       stock.ID,
+		"ticker":         stock.Ticker,
+		"current_price":  stock.CurrentPrice,
+		"currency":       stock.Currency,
+		"ev_model":       stock.EVModel,
+		"expected_value": stock.ExpectedValue,
+		"b_ratio":        stock.BRatio,
+		"kelly_fraction": stock.KellyFraction,
+		"assessment":     stock.Assessment,
+		"scenarios":      stock.Scenarios,
+	}
+	if stock.Scenarios == nil {
+		response["scenarios"] = []models.StockScenario{}
+	}
+	if len(stock.Scenarios) > 0 {
+		response["metrics"] = services.EvaluateScenarios(stock.CurrentPrice, stock.Scenarios)
+	}
+	return response
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// SnapshotHandler handles daily portfolio snapshots
+type SnapshotHandler struct {
+	db              *gorm.DB
+	cfg             *config.Config
+	logger          zerolog.Logger
+	snapshotService *services.SnapshotService
+}
+
+// NewSnapshotHandler creates a new snapshot handler
+func NewSnapshotHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *SnapshotHandler {
+	return &SnapshotHandler{
+		db:              db,
+		cfg:             cfg,
+		logger:          logger,
+		snapshotService: services.NewSnapshotService(db, cfg, logger),
+	}
+}
+
+// GetSnapshots returns the snapshot series, optionally limited by ?from= and ?to= (YYYY-MM-DD)
+func (h *SnapshotHandler) GetSnapshots(c *gin.Context) {
+	var from, to time.Time
+	var err error
+	if param := c.Query("from"); param != "" {
+		if from, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	if param := c.Query("to"); param != "" {
+		if to, err = time.Parse("2006-01-02", param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	snapshots, err := h.snapshotService.GetSnapshots(from, to)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch portfolio snapshots")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch portfolio snapshots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snapshots": snapshots,
+		"currency":  "EUR",
+	})
+}
+
+// CreateSnapshot records today's snapshot now, replacing an earlier one from today
+func (h *SnapshotHandler) CreateSnapshot(c *gin.Context) {
+	snapshot, err := h.snapshotService.TakeSnapshot(services.SnapshotSourceManual)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to take portfolio snapshot")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to take portfolio snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+// CronSnapshot records today's snapshot for external schedulers such as Vercel Cron.
+// It requires "Authorization: Bearer <CRON_SECRET>" and is disabled when no secret is set.
+func (h *SnapshotHandler) CronSnapshot(c *gin.Context) {
+	if h.cfg.CronSecret == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cron endpoints are not configured"})
+		return
+	}
+	expected := "Bearer " + h.cfg.CronSecret
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	snapshot, err := h.snapshotService.TakeSnapshot(services.SnapshotSourceCron)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to take portfolio snapshot")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to take portfolio snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snapshot_date": snapshot.SnapshotDate,
+		"total_value":   snapshot.TotalValue,
+	})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// StatisticsHandler handles beta, volatility and correlations computed from price history
+type StatisticsHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	statisticsService   *services.StatisticsService
+	exchangeRateService *services.ExchangeRateService
+}
+
+// NewStatisticsHandler creates a new statistics handler
+func NewStatisticsHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *StatisticsHandler {
+	return &StatisticsHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		statisticsService:   services.NewStatisticsService(db),
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+	}
+}
+
+// GetStockStatistics returns the stock's beta and volatility, where they came from,
+// and the latest computed statistics
+func (h *StatisticsHandler) GetStockStatistics(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	history, err := h.statisticsService.History(stock.ID, 30)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch risk statistics")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch risk statistics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stock_id":            stock.ID,
+		"ticker":              stock.Ticker,
+		"risk_source":         stock.RiskSource,
+		"beta":                stock.Beta,
+		"beta_method":         stock.BetaMethod,
+		"volatility":          stock.Volatility,
+		"volatility_method":   stock.VolatilityMethod,
+		"provider_beta":       stock.ProviderBeta,
+		"provider_volatility": stock.ProviderVolatility,
+		"history":             history,
+	})
+}
+
+// RefreshStockStatistics computes the stock's beta and volatility from its stored price bars
+func (h *StatisticsHandler) RefreshStockStatistics(c *gin.Context) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return
+	}
+
+	stat, err := h.statisticsService.Refresh(&stock)
+	if err != nil {
+		if errors.Is(err, services.ErrInsufficientPriceHistory) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error() + ". Backfill or import prices first."})
+			return
+		}
+		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("Failed to compute risk statistics")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute risk statistics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"statistics": stat, "stock": stock})
+}
+
+// RefreshAllStatistics computes beta and volatility for every stock with price bars
+func (h *StatisticsHandler) RefreshAllStatistics(c *gin.Context) {
+	refreshed, err := h.statisticsService.RefreshAll()
+	response := gin.H{"message": "Risk statistics refreshed", "refreshed": refreshed}
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Some risk statistics could not be computed")
+		response["errors"] = err.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// heldStocks returns the stocks with shares, or every stock with ?all=true
+func (h *StatisticsHandler) heldStocks(c *gin.Context) ([]models.Stock, error) {
+	query := h.db.Order("ticker ASC")
+	if c.Query("all") != "true" {
+		query = query.Where("shares_owned > 0")
+	}
+	var stocks []models.Stock
+	err := query.Find(&stocks).Error
+	return stocks, err
+}
+
+// GetCorrelations returns the correlation matrix of the held positions (?all=true for every stock)
+func (h *StatisticsHandler) GetCorrelations(c *gin.Context) {
+	stocks, err := h.heldStocks(c)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+
+	correlations, err := h.statisticsService.Correlations(stocks)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to compute correlations")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute correlations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, correlations)
+}
+
+// GetPortfolioRisk returns the portfolio's covariance-based volatility and each position's contribution
+func (h *StatisticsHandler) GetPortfolioRisk(c *gin.Context) {
+	var stocks []models.Stock
+	if err := h.db.Where("shares_owned > 0").Find(&stocks).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+
+	fxRates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	correlations, err := h.statisticsService.Correlations(stocks)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to compute correlations")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute correlations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.CalculatePortfolioRisk(stocks, fxRates, correlations))
+}
@@ -16,19 +16,26 @@ import (
 
 // StockHandler handles stock-related requests
 type StockHandler struct {
-	db         *gorm.DB
-	cfg        *config.Config
-	logger     zerolog.Logger
-	apiService *services.ExternalAPIService
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	marketData          *services.MarketDataService
+	priceService        *services.PriceService
+	statisticsService   *services.StatisticsService
+	exchangeRateService *services.ExchangeRateService
 }
 
 // NewStockHandler creates a new stock handler
 func NewStockHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *StockHandler {
+	marketData := services.NewMarketDataService(db, cfg, services.NewExternalAPIService(cfg))
 	return &StockHandler{
-		db:         db,
-		cfg:        cfg,
-		logger:     logger,
-		apiService: services.NewExternalAPIService(cfg),
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		marketData:          marketData,
+		priceService:        services.NewPriceService(db, marketData),
+		statisticsService:   services.NewStatisticsService(db),
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
 	}
 }
 
@@ -49,7 +56,7 @@ func (h *StockHandler) GetStock(c *gin.Context) {
 	id := c.Param("id")
 
 	var stock models.Stock
-	if err := h.db.First(&stock, id).Error; err != nil {
+	if err := h.db.Preload("Scenarios").First(&stock, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
 		} else {
@@ -69,10 +76,11 @@ type CreateStockRequest struct {
 	CompanyName         string  `json:"company_name" binding:"required"`
 	Sector              string  `json:"sector" binding:"required"`
 	Currency            string  `json:"currency"`
-	SharesOwned         int     `json:"shares_owned"`
+	SharesOwned         float64 `json:"shares_owned"`
 	AvgPriceLocal       float64 `json:"avg_price_local"`
 	UpdateFrequency     string  `json:"update_frequency"`
 	ProbabilityPositive float64 `json:"probability_positive"` // Optional manual input
+	DataProviders       string  `json:"data_providers"`       // Optional market data provider priority
 }
 
 // CreateStock creates a new stock and triggers initial calculations
@@ -90,6 +98,12 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 		return
 	}
 
+	dataProviders, err := h.marketData.ValidateOrder(req.DataProviders)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	stock := models.Stock{
 		Ticker:              req.Ticker,
 		ISIN:                req.ISIN,
@@ -100,6 +114,7 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 		AvgPriceLocal:       req.AvgPriceLocal,
 		UpdateFrequency:     req.UpdateFrequency,
 		ProbabilityPositive: req.ProbabilityPositive,
+		DataProviders:       dataProviders,
 	}
 
 	if stock.Currency == "" {
@@ -108,42 +123,31 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 	if stock.UpdateFrequency == "" {
 		stock.UpdateFrequency = "daily"
 	}
-	if stock.ProbabilityPositive == 0 {
-		stock.ProbabilityPositive = 0.65 // Default conservative value
+	if stock.ProbabilityPositive > 0 {
+		stock.ProbabilitySource = services.InputSourceManual
 	}
 
-	// Fetch all stock data from Grok in one call (includes ALL calculations!)
-	// With automatic fallback to mock data that also includes calculations
-	if err := h.apiService.FetchAllStockData(&stock); err != nil {
-		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("⚠️ GROK FETCH FAILED during stock creation - Check API key and logs above")
+	// Fetch quote, fundamentals and fair value through the stock's provider priority;
+	// derived metrics are recalculated by the market data service
+	update, err := h.marketData.Update(&stock, h.marketData.Order(&stock))
+	if err != nil {
+		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Msg("⚠️ MARKET DATA FETCH FAILED during stock creation - Check API keys and logs above")
 		// Return error to prevent saving stock with N/A data
 		c.JSON(http.StatusBadGateway, gin.H{
-			"error":  "Failed to fetch stock data from Grok API. Please check your XAI_API_KEY configuration.",
-			"ticker": stock.Ticker,
+			"error":   "Failed to fetch stock data from any market data provider. Please check your API keys or MARKET_DATA_DIR.",
+			"ticker":  stock.Ticker,
+			"details": update.Errors,
 		})
 		return
 	}
 
-	h.logger.Info().Str("ticker", stock.Ticker).Msg("✓ Successfully fetched data from Grok")
+	h.logger.Info().Str("ticker", stock.Ticker).Str("providers", stock.DataSource).Msg("✓ Successfully fetched market data")
 
-	// NO NEED to call CalculateMetrics - Grok already calculated everything!
-	// The following fields are now provided by Grok:
-	// - UpsidePotential, BRatio, ExpectedValue
-	// - KellyFraction, HalfKellySuggested
-	// - BuyZoneMin, BuyZoneMax, Assessment
-
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := h.exchangeRateService.UpdatePositionValues(&stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Calculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	stock.LastUpdated = time.Now()
 
 	// Save to database
@@ -153,6 +157,14 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 		return
 	}
 
+	// Record the initial holding in the transaction ledger
+	h.createOpeningBalance(&stock)
+
+	// Keep the quote's daily bar in the price history
+	if err := h.priceService.RecordQuote(&stock, update); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to store price bar")
+	}
+
 	// Create initial history entry
 	history := models.StockHistory{
 		StockID:             stock.ID,
@@ -166,6 +178,7 @@ func (h *StockHandler) CreateStock(c *gin.Context) {
 		KellyFraction:       stock.KellyFraction,
 		Weight:              stock.Weight,
 		Assessment:          stock.Assessment,
+		DataSource:          stock.DataSource,
 		RecordedAt:          time.Now(),
 	}
 	h.db.Create(&history)
@@ -180,7 +193,7 @@ func (h *StockHandler) UpdateStock(c *gin.Context) {
 	id := c.Param("id")
 
 	var stock models.Stock
-	if err := h.db.First(&stock, id).Error; err != nil {
+	if err := h.db.Preload("Scenarios").First(&stock, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
 		return
 	}
@@ -191,6 +204,19 @@ func (h *StockHandler) UpdateStock(c *gin.Context) {
 		return
 	}
 
+	// Position fields are derived from the transaction ledger
+	delete(req, "shares_owned")
+	delete(req, "avg_price_local")
+
+	// A downside or probability sent without its source was entered by the user
+	for field, sourceField := range map[string]string{"downside_risk": "downside_source", "probability_positive": "probability_source"} {
+		if _, ok := req[field]; ok {
+			if _, ok := req[sourceField]; !ok {
+				req[sourceField] = services.InputSourceManual
+			}
+		}
+	}
+
 	// Update allowed fields
 	if err := h.db.Model(&stock).Updates(req).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to update stock")
@@ -199,7 +225,7 @@ func (h *StockHandler) UpdateStock(c *gin.Context) {
 	}
 
 	// Recalculate metrics
-	services.CalculateMetrics(&stock)
+	services.CalculateMetrics(&stock, h.strategy())
 	h.db.Save(&stock)
 
 	h.logger.Info().Str("ticker", stock.Ticker).Msg("Stock updated successfully")
@@ -212,7 +238,7 @@ func (h *StockHandler) UpdateStockPrice(c *gin.Context) {
 	id := c.Param("id")
 
 	var stock models.Stock
-	if err := h.db.First(&stock, id).Error; err != nil {
+	if err := h.db.Preload("Scenarios").First(&stock, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
 		return
 	}
@@ -230,20 +256,13 @@ func (h *StockHandler) UpdateStockPrice(c *gin.Context) {
 	stock.LastUpdated = time.Now()
 
 	// Recalculate all derived metrics based on new price
-	services.CalculateMetrics(&stock)
+	services.CalculateMetrics(&stock, h.strategy())
 
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := h.exchangeRateService.UpdatePositionValues(&stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Recalculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	// Save to database
 	if err := h.db.Save(&stock).Error; err != nil {
 		h.logger.Error().Err(err).Msg("Failed to save stock")
@@ -256,12 +275,13 @@ func (h *StockHandler) UpdateStockPrice(c *gin.Context) {
 	c.JSON(http.StatusOK, stock)
 }
 
-// UpdateStockField updates a single field (avg_price_local, fair_value, shares_owned) and recalculates metrics
+// UpdateStockField updates a single field (e.g. fair_value, current_price) and recalculates metrics.
+// shares_owned and avg_price_local are derived from the transaction ledger and cannot be edited here.
 func (h *StockHandler) UpdateStockField(c *gin.Context) {
 	id := c.Param("id")
 
 	var stock models.Stock
-	if err := h.db.First(&stock, id).Error; err != nil {
+	if err := h.db.Preload("Scenarios").First(&stock, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
 		return
 	}
@@ -285,39 +305,55 @@ func (h *StockHandler) UpdateStockField(c *gin.Context) {
 			stock.CurrentPrice = floatVal
 			fieldUpdated = true
 		}
-	case "avg_price_local":
-		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 {
-			stock.AvgPriceLocal = floatVal
-			fieldUpdated = true
-		}
+	case "avg_price_local", "shares_owned":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Position is derived from transactions. Use /api/stocks/" + id + "/transactions instead."})
+		return
 	case "fair_value":
 		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 {
 			stock.FairValue = floatVal
 			fieldUpdated = true
 		}
-	case "shares_owned":
-		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 {
-			stock.SharesOwned = int(floatVal)
-			fieldUpdated = true
-		}
 	case "beta":
 		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 {
 			stock.Beta = floatVal
+			stock.BetaMethod = services.RiskMethodManual
 			fieldUpdated = true
 		}
 	case "volatility":
 		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 {
 			stock.Volatility = floatVal
+			stock.VolatilityMethod = services.RiskMethodManual
 			fieldUpdated = true
 		}
+	case "risk_source":
+		strVal := req.StringValue
+		if strVal == "" {
+			strVal, _ = req.Value.(string)
+		}
+		source, err := services.ParseRiskSource(strVal)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		latest, err := h.statisticsService.Latest(stock.ID)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to fetch risk statistics")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch risk statistics"})
+			return
+		}
+		stock.RiskSource = source
+		services.ApplyRiskSource(&stock, latest)
+		fieldUpdated = true
 	case "probability_positive":
 		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 && floatVal <= 1 {
 			stock.ProbabilityPositive = floatVal
+			stock.ProbabilitySource = services.InputSourceManual // 0 returns it to the profile's default
 			fieldUpdated = true
 		}
 	case "downside_risk":
 		if floatVal, ok := req.Value.(float64); ok && floatVal <= 0 {
 			stock.DownsideRisk = floatVal
+			stock.DownsideSource = services.InputSourceManual // 0 returns it to the beta band
 			fieldUpdated = true
 		}
 	case "pe_ratio":
@@ -340,6 +376,16 @@ func (h *StockHandler) UpdateStockField(c *gin.Context) {
 			stock.DividendYield = floatVal
 			fieldUpdated = true
 		}
+	case "target_weight":
+		if floatVal, ok := req.Value.(float64); ok && floatVal >= 0 && floatVal <= 100 {
+			stock.TargetWeight = floatVal
+			fieldUpdated = true
+		}
+	case "lot_size":
+		if floatVal, ok := req.Value.(float64); ok && floatVal > 0 {
+			stock.LotSize = floatVal
+			fieldUpdated = true
+		}
 	// String fields
 	case "comment":
 		if req.StringValue != "" {
@@ -381,6 +427,18 @@ func (h *StockHandler) UpdateStockField(c *gin.Context) {
 			stock.ISIN = strVal
 			fieldUpdated = true
 		}
+	case "data_providers":
+		strVal := req.StringValue
+		if strVal == "" {
+			strVal, _ = req.Value.(string)
+		}
+		order, err := h.marketData.ValidateOrder(strVal)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stock.DataProviders = order
+		fieldUpdated = true
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field name"})
 		return
@@ -394,20 +452,13 @@ func (h *StockHandler) UpdateStockField(c *gin.Context) {
 	stock.LastUpdated = time.Now()
 
 	// Recalculate all derived metrics (only if numeric fields changed)
-	if req.Field != "comment" && req.Field != "company_name" && req.Field != "sector" && req.Field != "update_frequency" && req.Field != "isin" {
-		services.CalculateMetrics(&stock)
+	if req.Field != "comment" && req.Field != "company_name" && req.Field != "sector" && req.Field != "update_frequency" && req.Field != "isin" && req.Field != "data_providers" {
+		services.CalculateMetrics(&stock, h.strategy())
 
-		// Get FX rate for USD conversion
-		fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-		if err != nil {
-			h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate")
-			fxRate = 1.0
+		// Calculate values in the reporting currency
+		if err := h.exchangeRateService.UpdatePositionValues(&stock); err != nil {
+			h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 		}
-
-		// Recalculate USD values
-		stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-		costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-		stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
 	}
 
 	// Save to database
@@ -503,85 +554,107 @@ func (h *StockHandler) UpdateAllStocks(c *gin.Context) {
 	})
 }
 
+// RecalculateStocksRequest selects the stocks to recalculate; empty means all
+type RecalculateStocksRequest struct {
+	StockIDs []uint `json:"stock_ids"`
+}
+
+// RecalculateStocks reruns the derived metrics, reporting-currency values and weights from
+// stored data without calling any market data provider, and returns each stock's
+// assessment and EV before and after
+func (h *StockHandler) RecalculateStocks(c *gin.Context) {
+	var req RecalculateStocksRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	if len(req.StockIDs) > 0 {
+		var count int64
+		if err := h.db.Model(&models.Stock{}).Where("id IN ?", req.StockIDs).Count(&count).Error; err != nil {
+			h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+			return
+		}
+		if int(count) != len(uniqueIDs(req.StockIDs)) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+			return
+		}
+	}
+
+	fxRates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	result, err := services.NewStrategyService(h.db).Recalculate(h.strategy(), req.StockIDs, fxRates, h.exchangeRateService.ReportingCurrency())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to recalculate stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate stocks"})
+		return
+	}
+
+	h.logger.Info().Int("recalculated", result.Recalculated).Int("changed", result.Changed).Msg("Stocks recalculated from stored data")
+	c.JSON(http.StatusOK, result)
+}
+
 // UpdateSingleStock updates a single stock's data
 func (h *StockHandler) UpdateSingleStock(c *gin.Context) {
 	id := c.Param("id")
-	source := c.Query("source") // Optional: "grok", "alphavantage", or "" for auto
+	source := c.Query("source") // Optional provider priority (e.g. "grok" or "file,alphavantage"); empty uses the stock's
 
 	var stock models.Stock
-	if err := h.db.First(&stock, id).Error; err != nil {
+	if err := h.db.Preload("Scenarios").First(&stock, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
 		return
 	}
 
-	if err := h.updateStockDataWithSource(&stock, source); err != nil {
-		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to update stock data from API, using mock data")
-		// Don't return error - the updateStockData should have fallback to mock data
-		// Try to at least recalculate metrics with existing data
-		services.CalculateMetrics(&stock)
+	order := h.marketData.Order(&stock)
+	if source != "" {
+		normalized, err := h.marketData.ValidateOrder(source)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		order = services.ParseProviderList(normalized)
+	}
+
+	if err := h.updateStockDataWithProviders(&stock, order); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to update stock data from market data providers, keeping stored data")
+		// Don't return error - recalculate metrics with the existing data
+		services.CalculateMetrics(&stock, h.strategy())
 		h.db.Save(&stock)
 	}
 
 	c.JSON(http.StatusOK, stock)
 }
 
-// updateStockData is a helper function to update stock data from external APIs (auto-mode)
+// updateStockData is a helper function to update stock data through the stock's provider priority
 func (h *StockHandler) updateStockData(stock *models.Stock) error {
-	return h.updateStockDataWithSource(stock, "")
+	return h.updateStockDataWithProviders(stock, h.marketData.Order(stock))
 }
 
-// updateStockDataWithSource updates stock data from specified source
-func (h *StockHandler) updateStockDataWithSource(stock *models.Stock, source string) error {
+// updateStockDataWithProviders updates stock data from the given market data providers, in order
+func (h *StockHandler) updateStockDataWithProviders(stock *models.Stock, order []string) error {
 	// Store old EV for alert comparison
 	oldEV := stock.ExpectedValue
 
-	var err error
-	now := time.Now()
-	switch source {
-	case "grok":
-		// Fetch only from Grok (interpretive/analytical data)
-		err = h.apiService.FetchFromGrok(stock)
-		if err == nil {
-			stock.GrokFetchedAt = &now
-		}
-	case "alphavantage":
-		// Fetch only from Alpha Vantage (raw financial data)
-		err = h.apiService.FetchFromAlphaVantage(stock)
-		if err == nil {
-			stock.AlphaVantageFetchedAt = &now
-		}
-	default:
-		// Auto mode: try Alpha Vantage first, then Grok
-		err = h.apiService.FetchAllStockData(stock)
-		if err == nil {
-			// In auto mode, both sources might have been updated
-			stock.AlphaVantageFetchedAt = &now
-			stock.GrokFetchedAt = &now
-		}
-	}
-
+	update, err := h.marketData.Update(stock, order)
 	if err != nil {
-		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Str("source", source).Msg("⚠️ API FETCH FAILED - Check API key and logs above")
-		// Mock data is already set by the service including all calculations
+		h.logger.Error().Err(err).Str("ticker", stock.Ticker).Strs("providers", order).Msg("⚠️ MARKET DATA FETCH FAILED - Check API keys and logs above")
 		return err
 	}
 
-	h.logger.Info().Str("ticker", stock.Ticker).Msg("✓ Successfully fetched data from Grok")
+	h.logger.Info().Str("ticker", stock.Ticker).Str("providers", stock.DataSource).Msg("✓ Successfully fetched market data")
 
-	// NO NEED to call CalculateMetrics - Grok already calculated everything!
-
-	// Get FX rate for USD conversion
-	fxRate, err := h.apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("currency", stock.Currency).Msg("Failed to fetch FX rate, using default")
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := h.exchangeRateService.UpdatePositionValues(stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Calculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	stock.LastUpdated = time.Now()
 
 	// Save to database
@@ -590,6 +663,10 @@ func (h *StockHandler) updateStockDataWithSource(stock *models.Stock, source str
 		return err
 	}
 
+	if err := h.priceService.RecordQuote(stock, update); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to store price bar")
+	}
+
 	// Create history entry
 	history := models.StockHistory{
 		StockID:             stock.ID,
@@ -603,6 +680,7 @@ func (h *StockHandler) updateStockDataWithSource(stock *models.Stock, source str
 		KellyFraction:       stock.KellyFraction,
 		Weight:              stock.Weight,
 		Assessment:          stock.Assessment,
+		DataSource:          stock.DataSource,
 		RecordedAt:          time.Now(),
 	}
 	h.db.Create(&history)
@@ -624,6 +702,15 @@ func (h *StockHandler) updateStockDataWithSource(stock *models.Stock, source str
 	return nil
 }
 
+// GetMarketDataProviders lists the registered market data providers and the portfolio's priority
+func (h *StockHandler) GetMarketDataProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"providers":       []string{services.MarketDataAlphaVantage, services.MarketDataGrok, services.MarketDataFile},
+		"default_order":   h.marketData.DefaultOrder(),
+		"portfolio_order": h.marketData.PortfolioOrder(),
+	})
+}
+
 // GetStockHistory returns historical data for a stock
 func (h *StockHandler) GetStockHistory(c *gin.Context) {
 	id := c.Param("id")
@@ -669,6 +756,7 @@ func (h *StockHandler) RestoreStock(c *gin.Context) {
 	}
 
 	// Reset ID to create a new record
+	oldID := stock.ID
 	stock.ID = 0
 	stock.CreatedAt = time.Time{}
 	stock.UpdatedAt = time.Time{}
@@ -680,6 +768,14 @@ func (h *StockHandler) RestoreStock(c *gin.Context) {
 		return
 	}
 
+	// Re-attach the ledger of the deleted stock to the restored record
+	if err := h.db.Model(&models.Transaction{}).Where("stock_id = ?", oldID).Update("stock_id", stock.ID).Error; err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to re-attach transactions to restored stock")
+	}
+	if err := h.db.Model(&models.StockScenario{}).Where("stock_id = ?", oldID).Update("stock_id", stock.ID).Error; err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to re-attach scenarios to restored stock")
+	}
+
 	// Mark as restored
 	now := time.Now()
 	deletedStock.RestoredAt = &now
@@ -709,7 +805,9 @@ func (h *StockHandler) ExportJSON(c *gin.Context) {
 		FairValue           float64 `json:"fair_value"`
 		UpsidePotential     float64 `json:"upside_potential"`
 		DownsideRisk        float64 `json:"downside_risk"`
+		DownsideSource      string  `json:"downside_source"`
 		ProbabilityPositive float64 `json:"probability_positive"`
+		ProbabilitySource   string  `json:"probability_source"`
 		ExpectedValue       float64 `json:"expected_value"`
 		Beta                float64 `json:"beta"`
 		Volatility          float64 `json:"volatility"`
@@ -720,7 +818,7 @@ func (h *StockHandler) ExportJSON(c *gin.Context) {
 		BRatio              float64 `json:"b_ratio"`
 		KellyFraction       float64 `json:"kelly_fraction"`
 		HalfKellySuggested  float64 `json:"half_kelly_suggested"`
-		SharesOwned         int     `json:"shares_owned"`
+		SharesOwned         float64 `json:"shares_owned"`
 		AvgPriceLocal       float64 `json:"avg_price_local"`
 		BuyZoneMin          float64 `json:"buy_zone_min"`
 		BuyZoneMax          float64 `json:"buy_zone_max"`
@@ -744,7 +842,9 @@ func (h *StockHandler) ExportJSON(c *gin.Context) {
 			FairValue:           stock.FairValue,
 			UpsidePotential:     stock.UpsidePotential,
 			DownsideRisk:        stock.DownsideRisk,
+			DownsideSource:      stock.DownsideSource,
 			ProbabilityPositive: stock.ProbabilityPositive,
+			ProbabilitySource:   stock.ProbabilitySource,
 			ExpectedValue:       stock.ExpectedValue,
 			Beta:                stock.Beta,
 			Volatility:          stock.Volatility,
@@ -761,8 +861,8 @@ func (h *StockHandler) ExportJSON(c *gin.Context) {
 			BuyZoneMax:          stock.BuyZoneMax,
 			Assessment:          stock.Assessment,
 			UpdateFrequency:     stock.UpdateFrequency,
-			DataSource:          "Manual", // Default as per template
-			FairValueSource:     "",       // Not currently stored
+			DataSource:          stock.DataSource,
+			FairValueSource:     stock.FairValueSource,
 			Comment:             stock.Comment,
 		})
 	}
@@ -788,7 +888,9 @@ type BulkStockData struct {
 	FairValue           float64 `json:"fair_value"`
 	UpsidePotential     float64 `json:"upside_potential"`
 	DownsideRisk        float64 `json:"downside_risk"`
+	DownsideSource      string  `json:"downside_source"` // derived, manual or provider; manual if omitted
 	ProbabilityPositive float64 `json:"probability_positive"`
+	ProbabilitySource   string  `json:"probability_source"` // derived, manual or provider; manual if omitted
 	ExpectedValue       float64 `json:"expected_value"`
 	Beta                float64 `json:"beta"`
 	Volatility          float64 `json:"volatility"`
@@ -799,7 +901,7 @@ type BulkStockData struct {
 	BRatio              float64 `json:"b_ratio"`
 	KellyFraction       float64 `json:"kelly_fraction"`
 	HalfKellySuggested  float64 `json:"half_kelly_suggested"`
-	SharesOwned         int     `json:"shares_owned"`
+	SharesOwned         float64 `json:"shares_owned"`
 	AvgPriceLocal       float64 `json:"avg_price_local"`
 	BuyZoneMin          float64 `json:"buy_zone_min"`
 	BuyZoneMax          float64 `json:"buy_zone_max"`
@@ -810,6 +912,16 @@ type BulkStockData struct {
 	Comment             string  `json:"comment"`
 }
 
+// importedSource is the source of an imported downside risk or probability: the one
+// exported with it, else manual
+func importedSource(source string) string {
+	switch source {
+	case services.InputSourceDerived, services.InputSourceProvider:
+		return source
+	}
+	return services.InputSourceManual
+}
+
 // BulkUpdateStocks handles bulk stock updates from JSON
 func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 	var req BulkUpdateRequest
@@ -872,18 +984,23 @@ func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 				stock.UpdateFrequency = "daily"
 			}
 
-			// Calculate additional fields if possible
-			if stock.SharesOwned > 0 && stock.CurrentPrice > 0 {
-				stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice
-				if stock.AvgPriceLocal > 0 {
-					stock.UnrealizedPnL = stock.CurrentValueUSD - (float64(stock.SharesOwned) * stock.AvgPriceLocal)
-				}
+			if stock.DownsideRisk != 0 {
+				stock.DownsideSource = importedSource(stockData.DownsideSource)
+			}
+			if stock.ProbabilityPositive > 0 {
+				stock.ProbabilitySource = importedSource(stockData.ProbabilitySource)
+			}
+
+			// Calculate values in the reporting currency
+			if err := h.exchangeRateService.UpdatePositionValues(&stock); err != nil {
+				h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 			}
 
 			if err := h.db.Create(&stock).Error; err != nil {
 				errors = append(errors, "Failed to create "+stockData.Ticker+": "+err.Error())
 				continue
 			}
+			h.createOpeningBalance(&stock)
 			created++
 		} else if err == nil {
 			// Update existing stock
@@ -908,9 +1025,11 @@ func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 			}
 			if stockData.DownsideRisk != 0 {
 				existing.DownsideRisk = stockData.DownsideRisk
+				existing.DownsideSource = importedSource(stockData.DownsideSource)
 			}
 			if stockData.ProbabilityPositive > 0 {
 				existing.ProbabilityPositive = stockData.ProbabilityPositive
+				existing.ProbabilitySource = importedSource(stockData.ProbabilitySource)
 			}
 			if stockData.ExpectedValue != 0 {
 				existing.ExpectedValue = stockData.ExpectedValue
@@ -942,10 +1061,13 @@ func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 			if stockData.HalfKellySuggested != 0 {
 				existing.HalfKellySuggested = stockData.HalfKellySuggested
 			}
-			if stockData.SharesOwned >= 0 {
+			// SharesOwned and AvgPriceLocal are derived from the transaction ledger;
+			// only seed an opening balance for stocks that have no ledger yet
+			var txCount int64
+			h.db.Model(&models.Transaction{}).Where("stock_id = ?", existing.ID).Count(&txCount)
+			seedLedger := txCount == 0 && stockData.SharesOwned > 0
+			if seedLedger {
 				existing.SharesOwned = stockData.SharesOwned
-			}
-			if stockData.AvgPriceLocal > 0 {
 				existing.AvgPriceLocal = stockData.AvgPriceLocal
 			}
 			if stockData.BuyZoneMin > 0 {
@@ -970,12 +1092,9 @@ func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 				existing.Comment = stockData.Comment
 			}
 
-			// Recalculate value fields
-			if existing.SharesOwned > 0 && existing.CurrentPrice > 0 {
-				existing.CurrentValueUSD = float64(existing.SharesOwned) * existing.CurrentPrice
-				if existing.AvgPriceLocal > 0 {
-					existing.UnrealizedPnL = existing.CurrentValueUSD - (float64(existing.SharesOwned) * existing.AvgPriceLocal)
-				}
+			// Calculate values in the reporting currency
+			if err := h.exchangeRateService.UpdatePositionValues(&existing); err != nil {
+				h.logger.Warn().Err(err).Str("ticker", existing.Ticker).Msg("Failed to calculate position values")
 			}
 
 			existing.LastUpdated = time.Now()
@@ -984,6 +1103,9 @@ func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 				errors = append(errors, "Failed to update "+stockData.Ticker+": "+err.Error())
 				continue
 			}
+			if seedLedger {
+				h.createOpeningBalance(&existing)
+			}
 			updated++
 		} else {
 			errors = append(errors, "Error checking "+stockData.Ticker+": "+err.Error())
@@ -1009,3 +1131,38 @@ func (h *StockHandler) BulkUpdateStocks(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// createOpeningBalance records the stock's initial holding as an opening-balance transaction
+func (h *StockHandler) createOpeningBalance(stock *models.Stock) {
+	if stock.SharesOwned <= 0 {
+		return
+	}
+
+	fxRate, err := h.exchangeRateService.GetRate(stock.Currency)
+	if err != nil || fxRate <= 0 {
+		fxRate = 1.0
+	}
+
+	opening := services.OpeningBalance(stock, fxRate)
+	if err := h.db.Create(&opening).Error; err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to create opening balance transaction")
+	}
+}
+
+// strategy returns the active strategy profile, falling back to the defaults
+func (h *StockHandler) strategy() *models.StrategyProfile {
+	strategy, err := h.marketData.Strategy()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to load strategy profile, using defaults")
+		return services.DefaultStrategyProfile()
+	}
+	return strategy
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// StrategyHandler handles the versioned strategy profiles
+type StrategyHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	strategyService     *services.StrategyService
+	exchangeRateService *services.ExchangeRateService
+}
+
+// NewStrategyHandler creates a new strategy handler
+func NewStrategyHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *StrategyHandler {
+	return &StrategyHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		strategyService:     services.NewStrategyService(db),
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+	}
+}
+
+// GetActiveStrategy returns the profile used for metrics, allocation and prompts
+func (h *StrategyHandler) GetActiveStrategy(c *gin.Context) {
+	profile, err := h.strategyService.Active()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to load strategy profile")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load strategy profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// GetProfiles returns every version of every profile
+func (h *StrategyHandler) GetProfiles(c *gin.Context) {
+	profiles, err := h.strategyService.List()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch strategy profiles")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch strategy profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+// GetProfile returns one profile version
+func (h *StrategyHandler) GetProfile(c *gin.Context) {
+	id, ok := h.profileID(c)
+	if !ok {
+		return
+	}
+	profile, err := h.strategyService.Get(id)
+	if err != nil {
+		h.respondProfileError(c, err, "Failed to fetch strategy profile")
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// CreateProfile stores a new, inactive profile. Omitted parameters take the default profile's values.
+func (h *StrategyHandler) CreateProfile(c *gin.Context) {
+	profile := services.DefaultStrategyProfile()
+	profile.Description = ""
+	if err := c.ShouldBindJSON(profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.strategyService.Create(profile, currentUsername(c)); err != nil {
+		h.respondProfileError(c, err, "Failed to create strategy profile")
+		return
+	}
+
+	h.logger.Info().Str("name", profile.Name).Msg("Strategy profile created")
+	c.JSON(http.StatusCreated, profile)
+}
+
+// UpdateProfile stores the changed parameters as the next version of the profile.
+// Editing the active version activates the new one; ?recalculate=true then recomputes every stock.
+func (h *StrategyHandler) UpdateProfile(c *gin.Context) {
+	id, ok := h.profileID(c)
+	if !ok {
+		return
+	}
+	var req map[string]interface{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	profile, err := h.strategyService.Update(id, req, currentUsername(c))
+	if err != nil {
+		h.respondProfileError(c, err, "Failed to update strategy profile")
+		return
+	}
+	h.logger.Info().Str("name", profile.Name).Int("version", profile.Version).Msg("Strategy profile version created")
+
+	response := gin.H{"profile": profile}
+	if profile.Active && c.Query("recalculate") == "true" {
+		if !h.recalculate(c, profile, response) {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ActivateProfile makes a profile version the active one; ?recalculate=true recomputes every stock with it
+func (h *StrategyHandler) ActivateProfile(c *gin.Context) {
+	id, ok := h.profileID(c)
+	if !ok {
+		return
+	}
+
+	profile, err := h.strategyService.Activate(id)
+	if err != nil {
+		h.respondProfileError(c, err, "Failed to activate strategy profile")
+		return
+	}
+	h.logger.Info().Str("name", profile.Name).Int("version", profile.Version).Msg("Strategy profile activated")
+
+	response := gin.H{"profile": profile}
+	if c.Query("recalculate") == "true" {
+		if !h.recalculate(c, profile, response) {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// recalculate recomputes every stock with the profile and adds the result to the response
+func (h *StrategyHandler) recalculate(c *gin.Context, profile *models.StrategyProfile, response gin.H) bool {
+	fxRates, err := h.exchangeRateService.GetRatesMap()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch exchange rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return false
+	}
+	result, err := h.strategyService.Recalculate(profile, nil, fxRates, h.exchangeRateService.ReportingCurrency())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to recalculate stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate stocks"})
+		return false
+	}
+	h.logger.Info().Int("stocks", result.Recalculated).Int("changed", result.Changed).Str("name", profile.Name).Int("version", profile.Version).Msg("Stocks recalculated with strategy profile")
+	response["recalculation"] = result
+	return true
+}
+
+func (h *StrategyHandler) profileID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (h *StrategyHandler) respondProfileError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy profile not found"})
+	case errors.Is(err, services.ErrInvalidStrategy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStrategyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error().Err(err).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// currentUsername returns the authenticated user's name, if any
+func currentUsername(c *gin.Context) string {
+	username, _ := c.Get("username")
+	name, _ := username.(string)
+	return name
+}
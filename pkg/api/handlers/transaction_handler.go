@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// TransactionHandler handles the per-stock transaction ledger
+type TransactionHandler struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *services.ExchangeRateService
+}
+
+// NewTransactionHandler creates a new transaction handler
+func NewTransactionHandler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *TransactionHandler {
+	return &TransactionHandler{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: services.NewExchangeRateService(db, cfg, logger),
+	}
+}
+
+// TransactionRequest represents the request to create or update a transaction
+type TransactionRequest struct {
+	Type      string  `json:"type" binding:"required,oneof=buy sell fee"`
+	TradeDate string  `json:"trade_date"` // YYYY-MM-DD, defaults to today
+	Quantity  float64 `json:"quantity" binding:"gte=0"`
+	Price     float64 `json:"price" binding:"gte=0"`
+	Fees      float64 `json:"fees" binding:"gte=0"`
+	Currency  string  `json:"currency"` // Defaults to the stock's currency
+	FXRate    float64 `json:"fx_rate"`  // Defaults to the current rate relative to EUR
+	Note      string  `json:"note"`
+}
+
+// GetTransactions returns the ledger and derived position for a stock
+func (h *TransactionHandler) GetTransactions(c *gin.Context) {
+	stock, ok := h.findStock(c)
+	if !ok {
+		return
+	}
+
+	transactions, err := services.NewLedgerService(h.db).GetTransactions(stock.ID)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+
+	position, err := services.CalculatePosition(transactions)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Ledger cannot be replayed")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"position":     position,
+	})
+}
+
+// CreateTransaction records a new ledger entry and re-derives the position
+func (h *TransactionHandler) CreateTransaction(c *gin.Context) {
+	stock, ok := h.findStock(c)
+	if !ok {
+		return
+	}
+
+	var req TransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	transaction := models.Transaction{StockID: stock.ID, Source: "manual"}
+	if err := h.applyRequest(&transaction, &req, &stock); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	position, err := h.saveWithLedger(&stock, func(tx *gorm.DB) error {
+		return tx.Create(&transaction).Error
+	})
+	if err != nil {
+		h.respondLedgerError(c, err, "Failed to create transaction")
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Str("type", transaction.Type).Float64("quantity", transaction.Quantity).Msg("Transaction recorded")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"transaction": transaction,
+		"position":    position,
+		"stock":       stock,
+	})
+}
+
+// UpdateTransaction updates an existing ledger entry and re-derives the position
+func (h *TransactionHandler) UpdateTransaction(c *gin.Context) {
+	stock, ok := h.findStock(c)
+	if !ok {
+		return
+	}
+
+	var transaction models.Transaction
+	if err := h.db.Where("stock_id = ?", stock.ID).First(&transaction, c.Param("txId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	var req TransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.applyRequest(&transaction, &req, &stock); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	position, err := h.saveWithLedger(&stock, func(tx *gorm.DB) error {
+		return tx.Save(&transaction).Error
+	})
+	if err != nil {
+		h.respondLedgerError(c, err, "Failed to update transaction")
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Uint("transaction_id", transaction.ID).Msg("Transaction updated")
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction": transaction,
+		"position":    position,
+		"stock":       stock,
+	})
+}
+
+// DeleteTransaction removes a ledger entry and re-derives the position
+func (h *TransactionHandler) DeleteTransaction(c *gin.Context) {
+	stock, ok := h.findStock(c)
+	if !ok {
+		return
+	}
+
+	var transaction models.Transaction
+	if err := h.db.Where("stock_id = ?", stock.ID).First(&transaction, c.Param("txId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	position, err := h.saveWithLedger(&stock, func(tx *gorm.DB) error {
+		return tx.Delete(&transaction).Error
+	})
+	if err != nil {
+		h.respondLedgerError(c, err, "Failed to delete transaction")
+		return
+	}
+
+	h.logger.Info().Str("ticker", stock.Ticker).Uint("transaction_id", transaction.ID).Msg("Transaction deleted")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Transaction deleted successfully",
+		"position": position,
+		"stock":    stock,
+	})
+}
+
+// GetRealizedPnL returns realized gains per sale for a single stock
+func (h *TransactionHandler) GetRealizedPnL(c *gin.Context) {
+	stock, ok := h.findStock(c)
+	if !ok {
+		return
+	}
+
+	method, ok := h.costBasisMethod(c)
+	if !ok {
+		return
+	}
+
+	transactions, err := services.NewLedgerService(h.db).GetTransactions(stock.ID)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+
+	sales, openLots, err := services.MatchLots(transactions, method)
+	if err != nil {
+		h.respondLedgerError(c, err, "Failed to calculate realized P&L")
+		return
+	}
+
+	var totalLocal, totalEUR float64
+	for i := range sales {
+		sales[i].Ticker = stock.Ticker
+		totalLocal += sales[i].GainLocal
+		totalEUR += sales[i].GainEUR
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticker":           stock.Ticker,
+		"method":           method,
+		"currency":         stock.Currency,
+		"sales":            sales,
+		"open_lots":        openLots,
+		"total_gain_local": totalLocal,
+		"total_gain_eur":   totalEUR,
+		"by_year":          services.SummarizeRealizedByYear(sales),
+	})
+}
+
+// GetPortfolioRealizedPnL returns realized gains for all stocks, grouped by year.
+// An optional ?year= filter limits the returned sales to one calendar year.
+func (h *TransactionHandler) GetPortfolioRealizedPnL(c *gin.Context) {
+	method, ok := h.costBasisMethod(c)
+	if !ok {
+		return
+	}
+
+	year := 0
+	if yearParam := c.Query("year"); yearParam != "" {
+		parsed, err := strconv.Atoi(yearParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+			return
+		}
+		year = parsed
+	}
+
+	var stocks []models.Stock
+	if err := h.db.Find(&stocks).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to fetch stocks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocks"})
+		return
+	}
+
+	ledger := services.NewLedgerService(h.db)
+	allSales := []services.RealizedSale{}
+	for _, stock := range stocks {
+		transactions, err := ledger.GetTransactions(stock.ID)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to fetch transactions")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+			return
+		}
+
+		sales, _, err := services.MatchLots(transactions, method)
+		if err != nil {
+			h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Skipping stock with invalid ledger")
+			continue
+		}
+
+		for _, sale := range sales {
+			if year != 0 && sale.SaleDate.Year() != year {
+				continue
+			}
+			sale.Ticker = stock.Ticker
+			allSales = append(allSales, sale)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"method": method,
+		"years":  services.SummarizeRealizedByYear(allSales),
+		"sales":  allSales,
+	})
+}
+
+// costBasisMethod resolves the lot matching method from ?method= or portfolio settings
+func (h *TransactionHandler) costBasisMethod(c *gin.Context) (string, bool) {
+	method := strings.ToLower(c.Query("method"))
+	if method == "" {
+		var settings models.PortfolioSettings
+		if err := h.db.First(&settings).Error; err == nil {
+			method = settings.CostBasisMethod
+		}
+	}
+	if method == "" {
+		method = services.CostBasisFIFO
+	}
+
+	if !services.ValidCostBasisMethod(method) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid method. Must be 'fifo', 'lifo' or 'average'"})
+		return "", false
+	}
+	return method, true
+}
+
+// findStock loads the stock from the :id route parameter, writing a 404 if missing
+func (h *TransactionHandler) findStock(c *gin.Context) (models.Stock, bool) {
+	var stock models.Stock
+	if err := h.db.First(&stock, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock not found"})
+		return stock, false
+	}
+	return stock, true
+}
+
+// applyRequest validates the request and copies it onto the transaction
+func (h *TransactionHandler) applyRequest(transaction *models.Transaction, req *TransactionRequest, stock *models.Stock) error {
+	switch req.Type {
+	case models.TransactionTypeBuy, models.TransactionTypeSell:
+		if req.Quantity <= 0 {
+			return errors.New("quantity must be greater than 0 for buy and sell transactions")
+		}
+	case models.TransactionTypeFee:
+		if req.Fees <= 0 {
+			return errors.New("fees must be greater than 0 for fee transactions")
+		}
+		req.Quantity = 0
+		req.Price = 0
+	}
+
+	tradeDate := time.Now()
+	if req.TradeDate != "" {
+		parsed, err := time.Parse("2006-01-02", req.TradeDate)
+		if err != nil {
+			return errors.New("trade_date must be in YYYY-MM-DD format")
+		}
+		tradeDate = parsed
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = stock.Currency
+	}
+
+	fxRate := req.FXRate
+	if fxRate <= 0 {
+		rate, err := h.exchangeRateService.GetRateAt(currency, tradeDate)
+		if err != nil || rate <= 0 {
+			h.logger.Warn().Err(err).Str("currency", currency).Msg("Failed to get FX rate for transaction, using 1.0")
+			rate = 1.0
+		}
+		fxRate = rate
+	}
+
+	transaction.Type = req.Type
+	transaction.TradeDate = tradeDate
+	transaction.Quantity = req.Quantity
+	transaction.Price = req.Price
+	transaction.Fees = req.Fees
+	transaction.Currency = currency
+	transaction.FXRate = fxRate
+	transaction.Note = req.Note
+	return nil
+}
+
+// saveWithLedger runs the ledger mutation, re-derives the position and saves the stock atomically
+func (h *TransactionHandler) saveWithLedger(stock *models.Stock, mutate func(tx *gorm.DB) error) (services.Position, error) {
+	var position services.Position
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := mutate(tx); err != nil {
+			return err
+		}
+
+		pos, err := services.NewLedgerService(tx).SyncPosition(stock)
+		if err != nil {
+			return err
+		}
+		position = pos
+
+		h.updatePositionValues(stock)
+		stock.LastUpdated = time.Now()
+		return tx.Save(stock).Error
+	})
+	return position, err
+}
+
+// updatePositionValues recalculates position value and P&L in the reporting currency from the derived holding
+func (h *TransactionHandler) updatePositionValues(stock *models.Stock) {
+	if err := h.exchangeRateService.UpdatePositionValues(stock); err != nil {
+		h.logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
+	}
+}
+
+// respondLedgerError maps ledger validation failures to 400 and everything else to 500
+func (h *TransactionHandler) respondLedgerError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrInvalidLedger) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Error().Err(err).Msg(message)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -58,6 +58,19 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 	exchangeRateHandler := handlers.NewExchangeRateHandler(db, cfg, logger)
 	cashHandler := handlers.NewCashHandler(db, cfg, logger)
 	assessmentHandler := handlers.NewAssessmentHandler(db, cfg, logger)
+	transactionHandler := handlers.NewTransactionHandler(db, cfg, logger)
+	dividendHandler := handlers.NewDividendHandler(db, cfg, logger)
+	corporateActionHandler := handlers.NewCorporateActionHandler(db, cfg, logger)
+	importHandler := handlers.NewImportHandler(db, cfg, logger)
+	exportHandler := handlers.NewExportHandler(db, cfg, logger)
+	snapshotHandler := handlers.NewSnapshotHandler(db, cfg, logger)
+	performanceHandler := handlers.NewPerformanceHandler(db, cfg, logger)
+	benchmarkHandler := handlers.NewBenchmarkHandler(db, cfg, logger)
+	priceHandler := handlers.NewPriceHandler(db, cfg, logger)
+	statisticsHandler := handlers.NewStatisticsHandler(db, cfg, logger)
+	rebalanceHandler := handlers.NewRebalanceHandler(db, cfg, logger)
+	strategyHandler := handlers.NewStrategyHandler(db, cfg, logger)
+	scenarioHandler := handlers.NewScenarioHandler(db, cfg, logger)
 
 	// Public routes
 	public := router.Group("/api")
@@ -72,6 +85,9 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 				"build_date": config.BuildDate,
 			})
 		})
+
+		// Cron routes (authenticated with CRON_SECRET instead of a user token)
+		public.GET("/cron/snapshot", snapshotHandler.CronSnapshot)
 	}
 
 	// Protected routes
@@ -95,10 +111,46 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 		protected.POST("/stocks/update-all", stockHandler.UpdateAllStocks)
 		protected.POST("/stocks/:id/update", stockHandler.UpdateSingleStock)
 		protected.POST("/stocks/bulk-update", stockHandler.BulkUpdateStocks)
+		protected.POST("/stocks/recalculate", stockHandler.RecalculateStocks)
+
+		protected.GET("/market-data/providers", stockHandler.GetMarketDataProviders)
 
 		// Stock history routes
 		protected.GET("/stocks/:id/history", stockHandler.GetStockHistory)
 
+		// Daily price bar routes
+		protected.GET("/stocks/:id/prices", priceHandler.GetPrices)
+		protected.POST("/stocks/:id/prices/backfill", priceHandler.BackfillPrices)
+		protected.POST("/stocks/:id/prices/import", priceHandler.ImportPrices)
+
+		// Risk statistics routes (beta and volatility from price history)
+		protected.GET("/stocks/:id/statistics", statisticsHandler.GetStockStatistics)
+		protected.POST("/stocks/:id/statistics/refresh", statisticsHandler.RefreshStockStatistics)
+		protected.POST("/statistics/refresh", statisticsHandler.RefreshAllStatistics)
+
+		// Scenario routes (bull/base/bear distribution behind EV and Kelly)
+		protected.GET("/stocks/:id/scenarios", scenarioHandler.GetScenarios)
+		protected.PUT("/stocks/:id/scenarios", scenarioHandler.SetScenarios)
+
+		// Stock transaction ledger routes
+		protected.GET("/stocks/:id/transactions", transactionHandler.GetTransactions)
+		protected.POST("/stocks/:id/transactions", transactionHandler.CreateTransaction)
+		protected.PUT("/stocks/:id/transactions/:txId", transactionHandler.UpdateTransaction)
+		protected.DELETE("/stocks/:id/transactions/:txId", transactionHandler.DeleteTransaction)
+		protected.GET("/stocks/:id/realized-pnl", transactionHandler.GetRealizedPnL)
+
+		// Dividend routes
+		protected.GET("/stocks/:id/dividends", dividendHandler.GetStockDividends)
+		protected.POST("/stocks/:id/dividends", dividendHandler.CreateDividend)
+		protected.GET("/dividends", dividendHandler.GetDividends)
+		protected.DELETE("/dividends/:id", dividendHandler.DeleteDividend)
+
+		// Corporate action routes
+		protected.GET("/stocks/:id/corporate-actions", corporateActionHandler.GetStockCorporateActions)
+		protected.POST("/stocks/:id/corporate-actions", corporateActionHandler.CreateCorporateAction)
+		protected.GET("/corporate-actions", corporateActionHandler.GetCorporateActions)
+		protected.POST("/corporate-actions/:id/revert", corporateActionHandler.RevertCorporateAction)
+
 		// Deleted stocks (log) routes
 		protected.GET("/deleted-stocks", stockHandler.GetDeletedStocks)
 		protected.POST("/deleted-stocks/:id/restore", stockHandler.RestoreStock)
@@ -107,12 +159,44 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 		protected.GET("/portfolio/summary", portfolioHandler.GetPortfolioSummary)
 		protected.GET("/portfolio/settings", portfolioHandler.GetSettings)
 		protected.PUT("/portfolio/settings", portfolioHandler.UpdateSettings)
+		protected.GET("/portfolio/realized-pnl", transactionHandler.GetPortfolioRealizedPnL)
+		protected.GET("/portfolio/snapshots", snapshotHandler.GetSnapshots)
+		protected.POST("/portfolio/snapshots", snapshotHandler.CreateSnapshot)
+		protected.GET("/portfolio/performance", performanceHandler.GetPerformance)
+		protected.GET("/portfolio/risk", statisticsHandler.GetPortfolioRisk)
+		protected.GET("/portfolio/correlations", statisticsHandler.GetCorrelations)
+		protected.GET("/portfolio/allocation", portfolioHandler.GetAllocation)
+		protected.GET("/portfolio/drawdown", portfolioHandler.GetDrawdown)
+		protected.POST("/portfolio/simulate", portfolioHandler.Simulate)
+		protected.POST("/portfolio/rebalance", rebalanceHandler.CreateRebalance)
+		protected.GET("/portfolio/rebalance", rebalanceHandler.GetRebalances)
+		protected.GET("/portfolio/rebalance/:id", rebalanceHandler.GetRebalance)
+		protected.PUT("/portfolio/rebalance/:id/orders/:orderId", rebalanceHandler.UpdateOrder)
+
+		// Strategy profile routes
+		protected.GET("/strategy", strategyHandler.GetActiveStrategy)
+		protected.GET("/strategy/profiles", strategyHandler.GetProfiles)
+		protected.POST("/strategy/profiles", strategyHandler.CreateProfile)
+		protected.GET("/strategy/profiles/:id", strategyHandler.GetProfile)
+		protected.PUT("/strategy/profiles/:id", strategyHandler.UpdateProfile)
+		protected.POST("/strategy/profiles/:id/activate", strategyHandler.ActivateProfile)
+
+		// Benchmark routes
+		protected.GET("/benchmark/prices", benchmarkHandler.GetBenchmarkPrices)
+		protected.POST("/benchmark/prices/refresh", benchmarkHandler.RefreshBenchmarkPrices)
+		protected.POST("/benchmark/prices/import", benchmarkHandler.ImportBenchmarkPrices)
 
 		// API Status routes
 		protected.GET("/api-status", portfolioHandler.GetAPIStatus)
 
+		// Import routes
+		protected.POST("/import/broker", importHandler.ImportBroker)
+
 		// Export routes
 		protected.GET("/export/json", stockHandler.ExportJSON)
+		protected.GET("/export/csv", exportHandler.ExportCSV)
+		protected.GET("/export/xlsx", exportHandler.ExportXLSX)
+		protected.GET("/export/columns", exportHandler.GetExportColumns)
 
 		// Alerts routes
 		protected.GET("/alerts", portfolioHandler.GetAlerts)
@@ -121,6 +205,8 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 		// Exchange rates routes
 		protected.GET("/exchange-rates", exchangeRateHandler.GetAllRates)
 		protected.POST("/exchange-rates/refresh", exchangeRateHandler.RefreshRates)
+		protected.GET("/exchange-rates/history", exchangeRateHandler.GetRateHistory)
+		protected.POST("/exchange-rates/import/ecb", exchangeRateHandler.ImportECBRates)
 		protected.POST("/exchange-rates", exchangeRateHandler.AddCurrency)
 		protected.PUT("/exchange-rates/:code", exchangeRateHandler.UpdateRate)
 		protected.DELETE("/exchange-rates/:code", exchangeRateHandler.DeleteCurrency)
@@ -130,7 +216,7 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *gin.En
 		protected.POST("/cash", cashHandler.CreateCashHolding)
 		protected.PUT("/cash/:id", cashHandler.UpdateCashHolding)
 		protected.DELETE("/cash/:id", cashHandler.DeleteCashHolding)
-		protected.POST("/cash/refresh", cashHandler.RefreshUSDValues)
+		protected.POST("/cash/refresh", cashHandler.RefreshBaseValues)
 
 		// Assessment routes
 		protected.POST("/assessment/request", assessmentHandler.RequestAssessment)
@@ -2,6 +2,10 @@ package config
 
 import "os"
 
+// FixtureSecret replaces API keys in recorded HTTP fixtures. In replay mode it also stands
+// in for missing keys, so every provider is treated as configured and served from fixtures.
+const FixtureSecret = "REDACTED"
+
 // Config holds all application configuration
 type Config struct {
 	AppEnv                string
@@ -19,14 +23,19 @@ type Config struct {
 	AlertEmailFrom        string
 	AlertEmailTo          string
 	EnableScheduler       bool
+	CronSecret            string // Bearer token expected by /api/cron/* (Vercel Cron sends CRON_SECRET)
 	DefaultUpdateFrequency string
+	MarketDataProviders   string // Default market data provider priority, comma-separated
+	MarketDataDir         string // Directory read by the file market data provider
+	HTTPFixturesMode      string // live, record or replay for all external API calls
+	HTTPFixturesDir       string // Where recorded HTTP fixtures are stored
 }
 
 // Load reads configuration from environment variables
 func Load() *Config {
 	enableScheduler := os.Getenv("ENABLE_SCHEDULER") == "true"
 	
-	return &Config{
+	cfg := &Config{
 		AppEnv:                getEnv("APP_ENV", "development"),
 		Port:                  getEnv("PORT", "8080"),
 		FrontendURL:           getEnv("FRONTEND_URL", "http://localhost:3000"),
@@ -37,13 +46,28 @@ func Load() *Config {
 		AlphaVantageAPIKey:    os.Getenv("ALPHA_VANTAGE_API_KEY"),
 		XAIAPIKey:             os.Getenv("XAI_API_KEY"),
 		DeepseekAPIKey:        os.Getenv("DEEPSEEK_API_KEY"),
-		ExchangeRatesAPIKey:   os.Getenv("EXCHANGE_RATES_API_KEY"),
+		ExchangeRatesAPIKey:   getEnv("EXCHANGE_RATE_API_KEY", os.Getenv("EXCHANGE_RATES_API_KEY")), // EXCHANGE_RATES_API_KEY is accepted as an alias
 		SendGridAPIKey:        os.Getenv("SENDGRID_API_KEY"),
 		AlertEmailFrom:        os.Getenv("ALERT_EMAIL_FROM"),
 		AlertEmailTo:          os.Getenv("ALERT_EMAIL_TO"),
 		EnableScheduler:       enableScheduler,
+		CronSecret:            os.Getenv("CRON_SECRET"),
 		DefaultUpdateFrequency: getEnv("DEFAULT_UPDATE_FREQUENCY", "daily"),
+		MarketDataProviders:   getEnv("MARKET_DATA_PROVIDERS", "alphavantage,grok"),
+		MarketDataDir:         os.Getenv("MARKET_DATA_DIR"),
+		HTTPFixturesMode:      getEnv("HTTP_FIXTURES_MODE", "live"),
+		HTTPFixturesDir:       getEnv("HTTP_FIXTURES_DIR", "./fixtures/http"),
+	}
+
+	if cfg.HTTPFixturesMode == "replay" {
+		for _, key := range []*string{&cfg.AlphaVantageAPIKey, &cfg.XAIAPIKey, &cfg.DeepseekAPIKey, &cfg.ExchangeRatesAPIKey, &cfg.SendGridAPIKey} {
+			if *key == "" {
+				*key = FixtureSecret
+			}
+		}
 	}
+
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
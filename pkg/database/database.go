@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/artpro/assessapp/pkg/models"
+	"github.com/artpro/assessapp/pkg/services"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -57,38 +58,303 @@ func InitDB(dbPath string) (*gorm.DB, error) {
 		}
 	}
 
+	// Rename value columns before AutoMigrate would add the new ones next to them
+	if err := RenameLegacyColumns(db); err != nil {
+		return nil, fmt.Errorf("failed to rename legacy columns: %w", err)
+	}
+
 	// Run auto migrations
+	// Note: stocks.shares_owned changed from integer to real/decimal for fractional
+	// quantities; AutoMigrate widens the column in place (SQLite rebuilds the table,
+	// PostgreSQL casts with USING), so existing integer values are preserved.
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Stock{},
 		&models.StockHistory{},
+		&models.StockScenario{},
 		&models.DeletedStock{},
 		&models.PortfolioSettings{},
 		&models.Alert{},
 		&models.ExchangeRate{},
+		&models.ExchangeRateHistory{},
 		&models.CashHolding{},
 		&models.Assessment{},
+		&models.Transaction{},
+		&models.Dividend{},
+		&models.CorporateAction{},
+		&models.PortfolioSnapshot{},
+		&models.BenchmarkPrice{},
+		&models.PriceBar{},
+		&models.RiskStatistic{},
+		&models.RebalanceProposal{},
+		&models.RebalanceOrder{},
+		&models.StrategyProfile{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	// Initialize default exchange rates
 	InitializeExchangeRates(db)
+
+	// Store the default strategy profile as the first active version
+	if err := InitializeStrategyProfile(db); err != nil {
+		return nil, fmt.Errorf("failed to initialize strategy profile: %w", err)
+	}
+
+	// Give every rate a starting point in the rate history
+	if err := MigrateExchangeRateHistory(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate exchange rate history: %w", err)
+	}
+
+	// Renamed value columns held mixed currencies; recompute them in the reporting currency
+	if err := MigrateBaseValues(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate base values: %w", err)
+	}
+
+	// Beta and volatility stored so far were reported by providers
+	if err := MigrateRiskSources(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate risk sources: %w", err)
+	}
+
+	// Tell derived downside and probability values from entered or reported ones
+	if err := MigrateInputSources(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate input sources: %w", err)
+	}
+
+	// Move existing positions into the transaction ledger
+	if err := MigrateOpeningBalances(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate opening balances: %w", err)
+	}
 	
 	return db, nil
 }
 
+// MigrateOpeningBalances creates an opening-balance transaction for every stock
+// that holds shares but has no ledger entries yet
+func MigrateOpeningBalances(db *gorm.DB) error {
+	var stocks []models.Stock
+	if err := db.Where("shares_owned > 0").Find(&stocks).Error; err != nil {
+		return err
+	}
+
+	for i := range stocks {
+		var count int64
+		if err := db.Model(&models.Transaction{}).Where("stock_id = ?", stocks[i].ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		fxRate := 1.0
+		var rate models.ExchangeRate
+		if err := db.Where("currency_code = ?", stocks[i].Currency).First(&rate).Error; err == nil && rate.Rate > 0 {
+			fxRate = rate.Rate
+		}
+
+		opening := services.OpeningBalance(&stocks[i], fxRate)
+		if err := db.Create(&opening).Error; err != nil {
+			return fmt.Errorf("failed to create opening balance for %s: %w", stocks[i].Ticker, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateRiskSources records the beta and volatility of stocks that predate risk
+// sources as provider figures, credited to the stock's fundamentals provider
+func MigrateRiskSources(db *gorm.DB) error {
+	var stocks []models.Stock
+	if err := db.Where("(beta_method IS NULL OR beta_method = '') AND (volatility_method IS NULL OR volatility_method = '')").
+		Where("beta <> 0 OR volatility <> 0").Find(&stocks).Error; err != nil {
+		return err
+	}
+
+	for _, stock := range stocks {
+		method := stock.FundamentalsProvider
+		if method == "" {
+			method = services.RiskSourceProvider
+		}
+		updates := map[string]interface{}{"risk_source": services.RiskSourceProvider}
+		if stock.Beta != 0 {
+			updates["provider_beta"] = stock.Beta
+			updates["beta_method"] = method
+		}
+		if stock.Volatility != 0 {
+			updates["provider_volatility"] = stock.Volatility
+			updates["volatility_method"] = method
+		}
+		if err := db.Model(&stock).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateInputSources records where the downside risk and probability of stocks that
+// predate input sources came from. Values matching the active profile's beta band or
+// default p (or the former hard-coded 0.65) were derived; other values are credited to
+// the analyst target provider if the stock has one, else to the user.
+func MigrateInputSources(db *gorm.DB) error {
+	strategy, err := services.NewStrategyService(db).Active()
+	if err != nil {
+		return err
+	}
+
+	var stocks []models.Stock
+	if err := db.Where("downside_source IS NULL OR downside_source = '' OR probability_source IS NULL OR probability_source = ''").
+		Find(&stocks).Error; err != nil {
+		return err
+	}
+
+	for _, stock := range stocks {
+		entered := services.InputSourceManual
+		if stock.FairValueProvider != "" {
+			entered = services.InputSourceProvider
+		}
+		updates := map[string]interface{}{}
+		if stock.DownsideSource == "" {
+			source := entered
+			if stock.DownsideRisk == 0 || (stock.Beta > 0 && stock.DownsideRisk == services.DownsideForBeta(strategy, stock.Beta)) {
+				source = services.InputSourceDerived
+			}
+			updates["downside_source"] = source
+		}
+		if stock.ProbabilitySource == "" {
+			source := entered
+			if stock.ProbabilityPositive == 0 || stock.ProbabilityPositive == strategy.DefaultProbability || stock.ProbabilityPositive == 0.65 {
+				source = services.InputSourceDerived
+			}
+			updates["probability_source"] = source
+		}
+		if err := db.Model(&stock).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RenameLegacyColumns renames value columns whose names did not match their currency:
+// stocks.current_value_usd and stocks.unrealized_pn_l (GORM's name for UnrealizedPnL)
+// held EUR or local values, and cash_holdings.usd_value was shown as EUR
+func RenameLegacyColumns(db *gorm.DB) error {
+	renames := []struct {
+		model    interface{}
+		from, to string
+	}{
+		{&models.Stock{}, "current_value_usd", "value_base"},
+		{&models.Stock{}, "unrealized_pn_l", "pnl_base"},
+		{&models.CashHolding{}, "usd_value", "value_base"},
+	}
+
+	migrator := db.Migrator()
+	for _, r := range renames {
+		if !migrator.HasTable(r.model) || !migrator.HasColumn(r.model, r.from) || migrator.HasColumn(r.model, r.to) {
+			continue
+		}
+		if err := migrator.RenameColumn(r.model, r.from, r.to); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", r.from, r.to, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateBaseValues computes value_base and pnl_base in the reporting currency for
+// positions and cash holdings that do not state their currency yet
+func MigrateBaseValues(db *gorm.DB) error {
+	var settings models.PortfolioSettings
+	currency := "EUR"
+	if err := db.First(&settings).Error; err == nil && settings.ReportingCurrency != "" {
+		currency = settings.ReportingCurrency
+	}
+
+	var rates []models.ExchangeRate
+	if err := db.Where("is_active = ?", true).Find(&rates).Error; err != nil {
+		return err
+	}
+	fxRates := make(map[string]float64, len(rates))
+	for _, rate := range rates {
+		fxRates[rate.CurrencyCode] = rate.Rate
+	}
+
+	var stocks []models.Stock
+	if err := db.Where("base_currency IS NULL OR base_currency = ''").Find(&stocks).Error; err != nil {
+		return err
+	}
+	for i := range stocks {
+		services.PositionValues(&stocks[i], fxRates, currency)
+		if err := db.Model(&stocks[i]).UpdateColumns(map[string]interface{}{
+			"value_base":    stocks[i].ValueBase,
+			"pnl_base":      stocks[i].PnLBase,
+			"base_currency": stocks[i].BaseCurrency,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	var holdings []models.CashHolding
+	if err := db.Where("base_currency IS NULL OR base_currency = ''").Find(&holdings).Error; err != nil {
+		return err
+	}
+	for _, holding := range holdings {
+		if err := db.Model(&holding).UpdateColumns(map[string]interface{}{
+			"value_base":    holding.Amount * services.CrossRate(holding.CurrencyCode, currency, fxRates),
+			"base_currency": currency,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateExchangeRateHistory records the current rate of every currency that
+// has no history yet, dated at its last update
+func MigrateExchangeRateHistory(db *gorm.DB) error {
+	var rates []models.ExchangeRate
+	if err := db.Find(&rates).Error; err != nil {
+		return err
+	}
+
+	for _, rate := range rates {
+		var count int64
+		if err := db.Model(&models.ExchangeRateHistory{}).Where("currency_code = ?", rate.CurrencyCode).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		// Rates stored before providers were recorded keep an empty source
+		source := rate.Source
+		if source == "" && rate.IsManual {
+			source = services.RateSourceManual
+		}
+		recordedAt := rate.LastUpdated
+		if recordedAt.IsZero() {
+			recordedAt = rate.CreatedAt
+		}
+		entry := models.ExchangeRateHistory{
+			CurrencyCode: rate.CurrencyCode,
+			Rate:         rate.Rate,
+			RecordedAt:   recordedAt,
+			Source:       source,
+		}
+		if err := db.Create(&entry).Error; err != nil {
+			return fmt.Errorf("failed to create rate history for %s: %w", rate.CurrencyCode, err)
+		}
+	}
+
+	return nil
+}
+
 // InitializeExchangeRates creates default exchange rates if they don't exist
 func InitializeExchangeRates(db *gorm.DB) error {
-	defaultRates := []models.ExchangeRate{
-		{CurrencyCode: "EUR", Rate: 1.0, IsActive: true},       // Base currency
-		{CurrencyCode: "USD", Rate: 1.154, IsActive: true},     // Default rate
-		{CurrencyCode: "DKK", Rate: 7.4604, IsActive: true},    // Default rate
-		{CurrencyCode: "GBP", Rate: 0.8796, IsActive: true},    // Default rate
-		{CurrencyCode: "RUB", Rate: 93.7594, IsActive: true},   // Default rate
-	}
-	
-	for _, rate := range defaultRates {
+	for code, value := range services.StaticFXRates {
+		rate := models.ExchangeRate{CurrencyCode: code, Rate: value, Source: services.RateSourceStatic, IsActive: true}
 		var existing models.ExchangeRate
 		result := db.Where("currency_code = ?", rate.CurrencyCode).First(&existing)
 		if result.Error == gorm.ErrRecordNotFound {
@@ -102,6 +368,20 @@ func InitializeExchangeRates(db *gorm.DB) error {
 	return nil
 }
 
+// InitializeStrategyProfile stores the default strategy profile if no profile exists
+func InitializeStrategyProfile(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.StrategyProfile{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	profile := services.DefaultStrategyProfile()
+	profile.CreatedBy = "system"
+	return db.Create(profile).Error
+}
+
 // InitializeAdminUser creates the admin user if it doesn't exist
 func InitializeAdminUser(db *gorm.DB, username, password string) error {
 	var user models.User
@@ -140,6 +420,7 @@ func InitializePortfolioSettings(db *gorm.DB) error {
 			UpdateFrequency:     "daily",
 			AlertsEnabled:       true,
 			AlertThresholdEV:    10.0, // Alert on 10% EV change
+			CostBasisMethod:     "fifo",
 		}
 
 		if err := db.Create(&settings).Error; err != nil {
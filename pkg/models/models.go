@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -27,10 +28,18 @@ type Stock struct {
 	FairValue              float64   `json:"fair_value"`               // Consensus target in local currency
 	UpsidePotential        float64   `json:"upside_potential"`         // Percentage
 	DownsideRisk           float64   `json:"downside_risk"`            // Percentage (negative)
+	DownsideSource         string    `json:"downside_source"`          // derived (beta band of the strategy profile), manual or provider
 	ProbabilityPositive    float64   `json:"probability_positive"`     // p value (0-1)
+	ProbabilitySource      string    `json:"probability_source"`       // derived (default p of the strategy profile), manual or provider
 	ExpectedValue          float64   `json:"expected_value"`           // EV percentage
+	EVModel                string    `json:"ev_model"`                 // What produced EV and Kelly: binary (p, upside, downside) or scenarios
 	Beta                   float64   `json:"beta"`
 	Volatility             float64   `json:"volatility"`               // Sigma percentage
+	RiskSource             string    `gorm:"default:provider" json:"risk_source"` // Where beta and volatility come from: provider or computed
+	BetaMethod             string    `json:"beta_method"`              // What produced Beta, e.g. "alphavantage", "manual", "computed (252d daily vs SPY)"
+	VolatilityMethod       string    `json:"volatility_method"`        // What produced Volatility, same form as BetaMethod
+	ProviderBeta           float64   `json:"provider_beta"`            // Last beta reported by a market data provider
+	ProviderVolatility     float64   `json:"provider_volatility"`      // Last volatility reported by a market data provider
 	PERatio                float64   `json:"pe_ratio"`
 	EPSGrowthRate          float64   `json:"eps_growth_rate"`          // Percentage
 	DebtToEBITDA           float64   `json:"debt_to_ebitda"`
@@ -38,22 +47,31 @@ type Stock struct {
 	BRatio                 float64   `json:"b_ratio"`                  // Upside/Downside ratio
 	KellyFraction          float64   `json:"kelly_fraction"`           // f* percentage
 	HalfKellySuggested     float64   `json:"half_kelly_suggested"`     // ½-Kelly percentage (capped at 15%)
-	SharesOwned            int       `json:"shares_owned"`
+	SharesOwned            float64   `json:"shares_owned"`            // Fractional quantities allowed (ETFs, savings plans)
 	AvgPriceLocal          float64   `json:"avg_price_local"`          // Entry cost in local currency
-	CurrentValueUSD        float64   `json:"current_value_usd"`        // Position value in USD
+	ValueBase              float64   `json:"value_base"`               // Position value in BaseCurrency
 	Weight                 float64   `json:"weight"`                   // Portfolio allocation percentage
-	UnrealizedPnL          float64   `json:"unrealized_pnl"`           // In USD
+	TargetWeight           float64   `json:"target_weight"`            // Configured target percentage of the portfolio; 0 uses half-Kelly
+	LotSize                float64   `gorm:"default:1" json:"lot_size"` // Smallest tradable quantity for rebalancing orders
+	PnLBase                float64   `gorm:"column:pnl_base" json:"pnl_base"` // Unrealized P&L in BaseCurrency
+	BaseCurrency           string    `json:"base_currency"`            // Reporting currency of ValueBase and PnLBase
 	BuyZoneMin             float64   `json:"buy_zone_min"`             // Minimum price for buy zone
 	BuyZoneMax             float64   `json:"buy_zone_max"`             // Maximum price for buy zone
 	Assessment             string    `json:"assessment"`               // Hold/Add/Trim/Sell
 	UpdateFrequency        string    `json:"update_frequency"`         // daily/weekly/monthly/manually
 	DataSource             string     `json:"data_source"`              // Source of data (e.g., "Grok", "Alpha Vantage", "Manual")
 	FairValueSource        string     `json:"fair_value_source"`        // Source of fair value (e.g., "TipRanks, Nov 5, 2025")
+	DataProviders          string     `json:"data_providers"`           // Market data provider priority, e.g. "file,alphavantage"; empty uses the portfolio's
+	PriceProvider          string     `json:"price_provider"`           // Market data provider of the last price update
+	PriceUpdatedAt         *time.Time `json:"price_updated_at"`         // When a market data update last set the price; edits only touch LastUpdated
+	FundamentalsProvider   string     `json:"fundamentals_provider"`    // Market data provider of the last fundamentals update
+	FairValueProvider      string     `json:"fair_value_provider"`      // Market data provider of the last fair value update
 	AlphaVantageFetchedAt  *time.Time `json:"alpha_vantage_fetched_at"` // When data was last fetched from Alpha Vantage
 	GrokFetchedAt          *time.Time `json:"grok_fetched_at"`          // When data was last fetched from Grok
 	AlphaVantageRawJSON    string     `gorm:"type:text" json:"alpha_vantage_raw_json"` // Raw JSON response from Alpha Vantage
 	GrokRawJSON            string     `gorm:"type:text" json:"grok_raw_json"`          // Raw JSON response from Grok
 	Comment                string     `gorm:"type:text" json:"comment"` // User notes and memos for this stock
+	Scenarios              []StockScenario `gorm:"foreignKey:StockID" json:"scenarios,omitempty"` // Outcome distribution; empty uses the binary model
 	LastUpdated            time.Time  `json:"last_updated"`
 	CreatedAt              time.Time `json:"created_at"`
 	UpdatedAt              time.Time `json:"updated_at"`
@@ -73,9 +91,29 @@ type StockHistory struct {
 	KellyFraction       float64   `json:"kelly_fraction"`
 	Weight              float64   `json:"weight"`
 	Assessment          string    `json:"assessment"`
+	DataSource          string    `json:"data_source"` // Providers that supplied the update, or manual
 	RecordedAt          time.Time `gorm:"index" json:"recorded_at"`
 }
 
+// EV models of a stock
+const (
+	EVModelBinary    = "binary"    // Probability p of reaching fair value, else the downside
+	EVModelScenarios = "scenarios" // Probability-weighted target prices of the stock's scenarios
+)
+
+// StockScenario is one outcome of a stock's price distribution, e.g. bull, base or bear.
+// The probabilities of a stock's scenarios sum to 1.
+type StockScenario struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	StockID     uint      `gorm:"not null;index" json:"stock_id"`
+	Name        string    `gorm:"not null" json:"name"` // bull, base, bear or a custom label
+	Probability float64   `json:"probability"`          // 0-1
+	TargetPrice float64   `json:"target_price"`         // In local currency
+	Note        string    `gorm:"type:text" json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // DeletedStock stores soft-deleted stocks in a log
 type DeletedStock struct {
 	ID           uint           `gorm:"primarykey" json:"id"`
@@ -91,11 +129,23 @@ type DeletedStock struct {
 // PortfolioSettings stores portfolio-level configuration
 type PortfolioSettings struct {
 	ID                  uint      `gorm:"primarykey" json:"id"`
-	TotalPortfolioValue float64   `json:"total_portfolio_value"` // In USD
+	TotalPortfolioValue float64   `json:"total_portfolio_value"` // In ReportingCurrency
 	UpdateFrequency     string    `json:"update_frequency"`      // daily/weekly/monthly
 	LastUpdateRun       time.Time `json:"last_update_run"`
 	AlertsEnabled       bool      `json:"alerts_enabled"`
 	AlertThresholdEV    float64   `json:"alert_threshold_ev"`    // Alert when EV changes by this %
+	CostBasisMethod     string    `json:"cost_basis_method" gorm:"default:fifo"` // fifo/lifo/average for realized P&L
+	CreditDividendsToCash bool    `json:"credit_dividends_to_cash"` // Add net dividends to the matching cash holding
+	BenchmarkTicker     string    `json:"benchmark_ticker"`      // Index or ETF symbol for comparison (e.g. URTH, SPY)
+	BenchmarkName       string    `json:"benchmark_name"`        // Display name (e.g. MSCI World)
+	BenchmarkCurrency   string    `json:"benchmark_currency"`    // Currency of the benchmark prices
+	ReportingCurrency   string    `json:"reporting_currency" gorm:"default:EUR"` // Currency of value_base/pnl_base and the summary
+	DataProviders       string    `json:"data_providers"`        // Default market data provider priority; empty uses MARKET_DATA_PROVIDERS
+	RiskBenchmarkTicker string    `json:"risk_benchmark_ticker"` // Benchmark for computed beta; empty uses BenchmarkTicker
+	VolatilityWindow    int       `json:"volatility_window" gorm:"default:252"` // Daily returns used for computed volatility
+	BetaWindow          int       `json:"beta_window" gorm:"default:252"`       // Daily returns used for computed beta
+	MinTradeValue       float64   `json:"min_trade_value" gorm:"default:250"`   // Smallest rebalancing order, in ReportingCurrency
+	DrawdownAlertLevel  float64   `json:"drawdown_alert_level" gorm:"default:15"` // Drawdown percentage that raises an alert; 0 disables
 	CreatedAt           time.Time `json:"created_at"`
 	UpdatedAt           time.Time `json:"updated_at"`
 }
@@ -105,7 +155,7 @@ type Alert struct {
 	ID          uint      `gorm:"primarykey" json:"id"`
 	StockID     uint      `json:"stock_id"`
 	Ticker      string    `json:"ticker"`
-	AlertType   string    `json:"alert_type"`   // ev_change, buy_zone, etc.
+	AlertType   string    `json:"alert_type"`   // ev_change, buy_zone, drawdown, etc.
 	Message     string    `json:"message"`
 	EmailSent   bool      `json:"email_sent"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -116,6 +166,8 @@ type ExchangeRate struct {
 	ID           uint      `gorm:"primarykey" json:"id"`
 	CurrencyCode string    `gorm:"unique;not null" json:"currency_code"` // EUR, USD, DKK, GBP, RUB, etc.
 	Rate         float64   `json:"rate"`                                  // Rate relative to EUR (base currency)
+	Source       string    `json:"source"`                                // FX provider that supplied the rate: api, ecb, manual, static
+	RateDate     time.Time `json:"rate_date"`                             // When the provider quoted the rate
 	LastUpdated  time.Time `json:"last_updated"`
 	IsActive     bool      `json:"is_active" gorm:"default:true"`        // Whether this currency is actively used
 	IsManual     bool      `json:"is_manual" gorm:"default:false"`       // Whether rate is manually set
@@ -123,12 +175,23 @@ type ExchangeRate struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// ExchangeRateHistory records every rate a currency has had, relative to EUR
+type ExchangeRateHistory struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CurrencyCode string    `gorm:"not null;index:idx_rate_history_currency_date,priority:1" json:"currency_code"`
+	Rate         float64   `json:"rate"` // Units per EUR
+	RecordedAt   time.Time `gorm:"index:idx_rate_history_currency_date,priority:2" json:"recorded_at"`
+	Source       string    `json:"source"` // FX provider: api, ecb, manual, static
+	CreatedAt    time.Time `json:"created_at"`
+}
+
 // CashHolding represents available cash in different currencies
 type CashHolding struct {
 	ID           uint      `gorm:"primarykey" json:"id"`
 	CurrencyCode string    `gorm:"not null;index" json:"currency_code"` // EUR, USD, DKK, GBP, etc.
 	Amount       float64   `json:"amount"`                               // Amount available in this currency
-	USDValue     float64   `json:"usd_value"`                           // Current value in USD (calculated)
+	ValueBase    float64   `json:"value_base"`                          // Current value in BaseCurrency (calculated)
+	BaseCurrency string    `json:"base_currency"`                       // Reporting currency of ValueBase
 	Description  string    `json:"description"`                         // Optional description/note
 	LastUpdated  time.Time `json:"last_updated"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -146,6 +209,343 @@ type Assessment struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// Transaction types recorded in the ledger
+const (
+	TransactionTypeBuy            = "buy"
+	TransactionTypeSell           = "sell"
+	TransactionTypeFee            = "fee"
+	TransactionTypeCostAdjustment = "cost_adjustment" // Scales the cost of the lots open on its date (spin-offs)
+)
+
+// Transaction represents a single buy, sell, fee or cost adjustment entry in a stock's ledger.
+// Position quantity and average cost are derived from these rows.
+type Transaction struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	StockID    uint      `gorm:"not null;index" json:"stock_id"`
+	Type       string    `gorm:"not null" json:"type"`               // buy, sell, fee or cost_adjustment
+	TradeDate  time.Time `gorm:"index" json:"trade_date"`
+	Quantity   float64   `json:"quantity"`                           // Shares bought or sold (0 for fees)
+	Price      float64   `json:"price"`                              // Price per share in local currency
+	Fees       float64   `json:"fees"`                               // Commission/fee amount in local currency
+	Currency   string    `json:"currency"`                           // Local currency of the trade
+	FXRate     float64   `json:"fx_rate"`                            // Rate relative to EUR at trade time
+	CostFactor float64   `json:"cost_factor,omitempty"`              // Multiplier of the open lots' cost (cost_adjustment only)
+	ExternalID string    `gorm:"index" json:"external_id,omitempty"` // Broker trade ID or imported row fingerprint, set on import
+	Source     string    `json:"source"`                             // manual, opening_balance, etc.
+	Note       string    `gorm:"type:text" json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Dividend represents a dividend payment received for a stock
+type Dividend struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	StockID        uint      `gorm:"not null;index" json:"stock_id"`
+	Ticker         string    `gorm:"index" json:"ticker"`
+	ExDate         time.Time `json:"ex_date"`
+	PayDate        time.Time `gorm:"index" json:"pay_date"`
+	Shares         float64   `json:"shares"`          // Shares held on the ex-date
+	GrossPerShare  float64   `json:"gross_per_share"` // In payment currency
+	GrossAmount    float64   `json:"gross_amount"`
+	WithholdingTax float64   `json:"withholding_tax"` // Amount withheld at source
+	NetAmount      float64   `json:"net_amount"`
+	Currency       string    `json:"currency"`
+	FXRate         float64   `json:"fx_rate"`          // Rate relative to EUR on the pay date
+	CreditedToCash bool      `json:"credited_to_cash"` // Net amount was added to the cash holding
+	Note           string    `gorm:"type:text" json:"note"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Corporate action types
+const (
+	CorporateActionSplit        = "split"
+	CorporateActionReverseSplit = "reverse_split"
+	CorporateActionTickerChange = "ticker_change"
+	CorporateActionISINChange   = "isin_change"
+	CorporateActionSpinOff      = "spin_off"
+)
+
+// CorporateAction is the audit record of a split, rename or spin-off applied to a stock
+type CorporateAction struct {
+	ID                   uint       `gorm:"primarykey" json:"id"`
+	StockID              uint       `gorm:"not null;index" json:"stock_id"`
+	Type                 string     `gorm:"not null" json:"type"` // split, reverse_split, ticker_change, isin_change, spin_off
+	EffectiveDate        time.Time  `json:"effective_date"`
+	RatioFrom            float64    `json:"ratio_from"` // e.g. 1 in a 1-for-4 split (old shares)
+	RatioTo              float64    `json:"ratio_to"`   // e.g. 4 in a 1-for-4 split (new shares)
+	OldTicker            string     `json:"old_ticker,omitempty"`
+	NewTicker            string     `json:"new_ticker,omitempty"`
+	OldISIN              string     `json:"old_isin,omitempty"`
+	NewISIN              string     `json:"new_isin,omitempty"`
+	SpinOffStockID       uint       `json:"spin_off_stock_id,omitempty"`
+	CostAllocation       float64    `json:"cost_allocation,omitempty"` // % of cost basis moved to the spin-off
+	Status               string     `gorm:"default:'applied'" json:"status"` // applied or reverted
+	AffectedTransactions int        `json:"affected_transactions"`
+	AffectedHistory      int        `json:"affected_history"`
+	Snapshot             string     `gorm:"type:text" json:"-"` // JSON state before the action, used to revert
+	AppliedBy            string     `json:"applied_by"`
+	AppliedAt            *time.Time `json:"applied_at,omitempty"`
+	RevertedAt           *time.Time `json:"reverted_at,omitempty"`
+	Note                 string     `gorm:"type:text" json:"note"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// BenchmarkPrice is one daily close of a benchmark index or ETF
+type BenchmarkPrice struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Ticker    string    `gorm:"not null;uniqueIndex:idx_benchmark_ticker_date" json:"ticker"`
+	Date      time.Time `gorm:"not null;uniqueIndex:idx_benchmark_ticker_date" json:"date"`
+	Close     float64   `json:"close"`
+	Currency  string    `json:"currency"`
+	Source    string    `json:"source"` // Market data provider (alphavantage, file) or csv
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PriceBar is one trading day of a stock's price history, in the stock's currency.
+// Bars before an applied split are stored split-adjusted.
+type PriceBar struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	StockID   uint      `gorm:"not null;uniqueIndex:idx_price_bar_stock_date" json:"stock_id"`
+	Date      time.Time `gorm:"not null;uniqueIndex:idx_price_bar_stock_date" json:"date"` // UTC midnight of the trading day
+	Open      float64   `json:"open"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Close     float64   `json:"close"`
+	Volume    float64   `json:"volume"`
+	Source    string    `json:"source"` // Market data provider (alphavantage, file) or csv
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RiskStatistic is one computation of a stock's beta and volatility from its stored price bars.
+// Nil values could not be computed (too little history or no benchmark series).
+type RiskStatistic struct {
+	ID                     uint      `gorm:"primarykey" json:"id"`
+	StockID                uint      `gorm:"not null;index" json:"stock_id"`
+	Volatility             *float64  `json:"volatility"` // Annualized sigma percentage
+	VolatilityWindow       int       `json:"volatility_window"`
+	VolatilityObservations int       `json:"volatility_observations"` // Daily returns actually used
+	Beta                   *float64  `json:"beta"`
+	BetaWindow             int       `json:"beta_window"`
+	BetaObservations       int       `json:"beta_observations"` // Daily returns matched with the benchmark
+	BenchmarkTicker        string    `json:"benchmark_ticker"`
+	From                   time.Time `json:"from"` // First price bar used
+	To                     time.Time `json:"to"`   // Last price bar used
+	ComputedAt             time.Time `gorm:"index" json:"computed_at"`
+}
+
+// PortfolioSnapshot records the portfolio state at the end of a day. Values are in EUR.
+type PortfolioSnapshot struct {
+	ID                 uint               `gorm:"primarykey" json:"id"`
+	SnapshotDate       time.Time          `gorm:"uniqueIndex;not null" json:"snapshot_date"` // UTC midnight of the day
+	TotalValue         float64            `json:"total_value"`                               // Positions plus cash
+	PositionsValue     float64            `json:"positions_value"`
+	CashValue          float64            `json:"cash_value"`
+	CostBasis          float64            `json:"cost_basis"`
+	UnrealizedPnL      float64            `json:"unrealized_pnl"`
+	OverallEV          float64            `json:"overall_ev"`
+	WeightedVolatility float64            `json:"weighted_volatility"`
+	SharpeRatio        float64            `json:"sharpe_ratio"`
+	KellyUtilization   float64            `json:"kelly_utilization"`
+	PositionCount      int                `json:"position_count"`
+	Source             string             `json:"source"` // scheduler, manual, auto or cron
+	SectorWeightsJSON  string             `gorm:"type:text" json:"-"`
+	PositionsJSON      string             `gorm:"type:text" json:"-"`
+	CashJSON           string             `gorm:"type:text" json:"-"`
+	SectorWeights      map[string]float64 `gorm:"-" json:"sector_weights"`
+	Positions          []SnapshotPosition `gorm:"-" json:"positions"`
+	Cash               map[string]float64 `gorm:"-" json:"cash"` // Amount per currency
+	CreatedAt          time.Time          `json:"created_at"`
+	UpdatedAt          time.Time          `json:"updated_at"`
+}
+
+// SnapshotPosition is the value of one position within a portfolio snapshot
+type SnapshotPosition struct {
+	StockID       uint    `json:"stock_id"`
+	Ticker        string  `json:"ticker"`
+	Sector        string  `json:"sector"`
+	Currency      string  `json:"currency"`
+	Shares        float64 `json:"shares"`
+	Price         float64 `json:"price"`      // Local currency
+	Value         float64 `json:"value"`      // EUR
+	CostBasis     float64 `json:"cost_basis"` // EUR at the current rate
+	Weight        float64 `json:"weight"`     // Percentage of positions value
+	ExpectedValue float64 `json:"expected_value"`
+}
+
+// BeforeSave encodes the snapshot's sector weights, positions and cash
+func (p *PortfolioSnapshot) BeforeSave(tx *gorm.DB) error {
+	for _, field := range []struct {
+		value  interface{}
+		target *string
+	}{
+		{p.SectorWeights, &p.SectorWeightsJSON},
+		{p.Positions, &p.PositionsJSON},
+		{p.Cash, &p.CashJSON},
+	} {
+		raw, err := json.Marshal(field.value)
+		if err != nil {
+			return err
+		}
+		*field.target = string(raw)
+	}
+	return nil
+}
+
+// AfterFind decodes the snapshot's sector weights, positions and cash
+func (p *PortfolioSnapshot) AfterFind(tx *gorm.DB) error {
+	if p.SectorWeightsJSON != "" {
+		if err := json.Unmarshal([]byte(p.SectorWeightsJSON), &p.SectorWeights); err != nil {
+			return err
+		}
+	}
+	if p.PositionsJSON != "" {
+		if err := json.Unmarshal([]byte(p.PositionsJSON), &p.Positions); err != nil {
+			return err
+		}
+	}
+	if p.CashJSON != "" {
+		if err := json.Unmarshal([]byte(p.CashJSON), &p.Cash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SectorRange bounds a sector's share of the portfolio, in percent
+type SectorRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// StrategyProfile holds the parameters of the investment strategy used by the metric
+// calculations, the allocator and the AI prompts. Profiles are versioned: an edit stores
+// a new version and earlier versions stay unchanged. One version is active at a time.
+type StrategyProfile struct {
+	ID                 uint                   `gorm:"primarykey" json:"id"`
+	Name               string                 `gorm:"not null;uniqueIndex:idx_strategy_name_version" json:"name"`
+	Version            int                    `gorm:"not null;uniqueIndex:idx_strategy_name_version" json:"version"`
+	Active             bool                   `gorm:"index" json:"active"`
+	Description        string                 `gorm:"type:text" json:"description"`
+	AddThresholdEV     float64                `json:"add_threshold_ev"`    // EV % above which the assessment is Add
+	HoldThresholdEV    float64                `json:"hold_threshold_ev"`   // EV % above which it is Hold
+	TrimThresholdEV    float64                `json:"trim_threshold_ev"`   // EV % above which it is Trim, else Sell
+	BetaLow            float64                `json:"beta_low"`            // Upper beta of the lowest downside band
+	BetaMid            float64                `json:"beta_mid"`            // Upper beta of the second band
+	BetaHigh           float64                `json:"beta_high"`           // Upper beta of the third band
+	DownsideLow        float64                `json:"downside_low"`        // Downside % for beta below BetaLow
+	DownsideMid        float64                `json:"downside_mid"`        // Downside % for beta below BetaMid
+	DownsideHigh       float64                `json:"downside_high"`       // Downside % for beta below BetaHigh
+	DownsideMax        float64                `json:"downside_max"`        // Downside % for higher betas
+	DefaultProbability float64                `json:"default_probability"` // p of stocks without one
+	HalfKellyCap       float64                `json:"half_kelly_cap"`      // Cap of the half-Kelly suggestion and of each position, %
+	BuyZoneTargetEV    float64                `json:"buy_zone_target_ev"`  // EV % at the top of the buy zone
+	CashMin            float64                `json:"cash_min"`            // Cash buffer, % of the portfolio
+	CashMax            float64                `json:"cash_max"`
+	KellyMin           float64                `json:"kelly_min"` // Share of full Kelly deployed by the allocator
+	KellyMax           float64                `json:"kelly_max"`
+	SectorRangesJSON   string                 `gorm:"type:text" json:"-"`
+	SectorRanges       map[string]SectorRange `gorm:"-" json:"sector_ranges"`
+	CreatedBy          string                 `json:"created_by"`
+	CreatedAt          time.Time              `json:"created_at"`
+	UpdatedAt          time.Time              `json:"updated_at"`
+}
+
+// BeforeSave encodes the profile's sector ranges
+func (p *StrategyProfile) BeforeSave(tx *gorm.DB) error {
+	raw, err := json.Marshal(p.SectorRanges)
+	if err != nil {
+		return err
+	}
+	p.SectorRangesJSON = string(raw)
+	return nil
+}
+
+// AfterFind decodes the profile's sector ranges
+func (p *StrategyProfile) AfterFind(tx *gorm.DB) error {
+	if p.SectorRangesJSON != "" {
+		return json.Unmarshal([]byte(p.SectorRangesJSON), &p.SectorRanges)
+	}
+	return nil
+}
+
+// Rebalance proposal statuses
+const (
+	RebalanceStatusOpen      = "open"      // Orders are waiting to be executed
+	RebalanceStatusCompleted = "completed" // Every order was executed or skipped
+)
+
+// Rebalance order statuses
+const (
+	RebalanceOrderPending  = "pending"
+	RebalanceOrderExecuted = "executed"
+	RebalanceOrderSkipped  = "skipped"
+)
+
+// RebalanceProposal is a saved trade list that moves the portfolio towards its target weights.
+// Values are in EUR.
+type RebalanceProposal struct {
+	ID            uint             `gorm:"primarykey" json:"id"`
+	Method        string           `json:"method"` // half_kelly or allocator
+	Status        string           `gorm:"default:'open'" json:"status"`
+	Currency      string           `json:"currency"`
+	TotalValue    float64          `json:"total_value"` // Positions plus cash before trading
+	CashBefore    float64          `json:"cash_before"`
+	CashAfter     float64          `json:"cash_after"` // Once every order is executed
+	BuyValue      float64          `json:"buy_value"`
+	SellValue     float64          `json:"sell_value"`
+	MinTradeValue float64          `json:"min_trade_value"`
+	WarningsJSON  string           `gorm:"type:text" json:"-"`
+	Warnings      []string         `gorm:"-" json:"warnings"`
+	Orders        []RebalanceOrder `gorm:"foreignKey:ProposalID" json:"orders"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// RebalanceOrder is one buy or sell of a rebalance proposal
+type RebalanceOrder struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	ProposalID     uint       `gorm:"not null;index" json:"proposal_id"`
+	StockID        uint       `gorm:"index" json:"stock_id"`
+	Ticker         string     `json:"ticker"`
+	Side           string     `json:"side"` // buy or sell
+	Shares         float64    `json:"shares"`
+	Price          float64    `json:"price"` // Local currency at proposal time
+	Currency       string     `json:"currency"`
+	ValueLocal     float64    `json:"value_local"`
+	Value          float64    `json:"value"` // EUR
+	CurrentWeight  float64    `json:"current_weight"`
+	TargetWeight   float64    `json:"target_weight"`
+	Status         string     `gorm:"default:'pending'" json:"status"` // pending, executed or skipped
+	ExecutedShares float64    `json:"executed_shares"`
+	ExecutedPrice  float64    `json:"executed_price"`
+	ExecutedAt     *time.Time `json:"executed_at,omitempty"`
+	TransactionID  *uint      `json:"transaction_id,omitempty"` // Ledger entry recorded on execution
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// BeforeSave encodes the proposal's warnings
+func (p *RebalanceProposal) BeforeSave(tx *gorm.DB) error {
+	raw, err := json.Marshal(p.Warnings)
+	if err != nil {
+		return err
+	}
+	p.WarningsJSON = string(raw)
+	return nil
+}
+
+// AfterFind decodes the proposal's warnings
+func (p *RebalanceProposal) AfterFind(tx *gorm.DB) error {
+	if p.WarningsJSON != "" {
+		return json.Unmarshal([]byte(p.WarningsJSON), &p.Warnings)
+	}
+	return nil
+}
+
 // BeforeCreate hook for Stock to set defaults
 func (s *Stock) BeforeCreate(tx *gorm.DB) error {
 	if s.UpdateFrequency == "" {
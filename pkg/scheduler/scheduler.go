@@ -14,24 +14,48 @@ import (
 // InitScheduler initializes the cron scheduler for automatic updates
 func InitScheduler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) {
 	s := gocron.NewScheduler(time.UTC)
-	apiService := services.NewExternalAPIService(cfg)
+	marketData := services.NewMarketDataService(db, cfg, services.NewExternalAPIService(cfg))
+	exchangeRateService := services.NewExchangeRateService(db, cfg, logger)
 
-	// Daily update job
+	// Daily update job, starting with fresh FX rates
 	s.Every(1).Day().At("00:00").Do(func() {
+		logger.Info().Msg("Refreshing exchange rates")
+		if err := exchangeRateService.FetchLatestRates(); err != nil {
+			logger.Error().Err(err).Msg("Failed to refresh exchange rates")
+		}
+
 		logger.Info().Msg("Running daily stock update")
-		updateStocksWithFrequency(db, apiService, logger, "daily")
+		updateStocksWithFrequency(db, marketData, exchangeRateService, logger, "daily")
 	})
 
 	// Weekly update job (Mondays)
 	s.Every(1).Monday().At("00:00").Do(func() {
 		logger.Info().Msg("Running weekly stock update")
-		updateStocksWithFrequency(db, apiService, logger, "weekly")
+		updateStocksWithFrequency(db, marketData, exchangeRateService, logger, "weekly")
 	})
 
 	// Monthly update job (1st of month)
 	s.Every(1).Month(1).At("00:00").Do(func() {
 		logger.Info().Msg("Running monthly stock update")
-		updateStocksWithFrequency(db, apiService, logger, "monthly")
+		updateStocksWithFrequency(db, marketData, exchangeRateService, logger, "monthly")
+	})
+
+	// Daily beta and volatility from the stored price bars
+	s.Every(1).Day().At("23:30").Do(func() {
+		logger.Info().Msg("Refreshing risk statistics")
+		refreshed, err := services.NewStatisticsService(db).RefreshAll()
+		if err != nil {
+			logger.Warn().Err(err).Msg("Some risk statistics could not be computed")
+		}
+		logger.Info().Int("count", refreshed).Msg("Risk statistics refreshed")
+	})
+
+	// Daily portfolio snapshot, after the day's updates
+	s.Every(1).Day().At("23:55").Do(func() {
+		logger.Info().Msg("Recording daily portfolio snapshot")
+		if _, err := services.NewSnapshotService(db, cfg, logger).TakeSnapshot(services.SnapshotSourceScheduler); err != nil {
+			logger.Error().Err(err).Msg("Failed to record portfolio snapshot")
+		}
 	})
 
 	// Alert check job (every hour)
@@ -44,7 +68,7 @@ func InitScheduler(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) {
 }
 
 // updateStocksWithFrequency updates all stocks with the specified frequency
-func updateStocksWithFrequency(db *gorm.DB, apiService *services.ExternalAPIService, logger zerolog.Logger, frequency string) {
+func updateStocksWithFrequency(db *gorm.DB, marketData *services.MarketDataService, exchangeRateService *services.ExchangeRateService, logger zerolog.Logger, frequency string) {
 	// Skip if frequency is "manually" - these stocks are only updated by user action
 	if frequency == "manually" {
 		return
@@ -56,10 +80,15 @@ func updateStocksWithFrequency(db *gorm.DB, apiService *services.ExternalAPIServ
 		return
 	}
 
-	logger.Info().Int("count", len(stocks)).Str("frequency", frequency).Msg("Updating stocks")
+	// Metrics are recomputed with the active strategy profile
+	event := logger.Info().Int("count", len(stocks)).Str("frequency", frequency)
+	if strategy, err := marketData.Strategy(); err == nil {
+		event = event.Str("strategy", strategy.Name).Int("strategy_version", strategy.Version)
+	}
+	event.Msg("Updating stocks")
 
 	for i := range stocks {
-		if err := updateStock(db, apiService, &stocks[i], logger); err != nil {
+		if err := updateStock(db, marketData, exchangeRateService, &stocks[i], logger); err != nil {
 			logger.Warn().Err(err).Str("ticker", stocks[i].Ticker).Msg("Failed to update stock")
 		} else {
 			logger.Debug().Str("ticker", stocks[i].Ticker).Msg("Stock updated successfully")
@@ -71,35 +100,20 @@ func updateStocksWithFrequency(db *gorm.DB, apiService *services.ExternalAPIServ
 }
 
 // updateStock updates a single stock's data
-func updateStock(db *gorm.DB, apiService *services.ExternalAPIService, stock *models.Stock, logger zerolog.Logger) error {
+func updateStock(db *gorm.DB, marketData *services.MarketDataService, exchangeRateService *services.ExchangeRateService, stock *models.Stock, logger zerolog.Logger) error {
 	oldEV := stock.ExpectedValue
 
-	// Fetch current price
-	price, err := apiService.FetchStockPrice(stock.Ticker)
+	// Fetch market data through the stock's provider priority (recalculates derived metrics)
+	update, err := marketData.Update(stock, marketData.Order(stock))
 	if err != nil {
 		return err
 	}
-	stock.CurrentPrice = price
 
-	// Fetch Grok calculations
-	if err := apiService.FetchGrokCalculations(stock); err != nil {
-		return err
-	}
-
-	// Calculate derived metrics
-	services.CalculateMetrics(stock)
-
-	// Get FX rate for USD conversion
-	fxRate, err := apiService.FetchExchangeRate(stock.Currency)
-	if err != nil {
-		fxRate = 1.0
+	// Calculate values in the reporting currency
+	if err := exchangeRateService.UpdatePositionValues(stock); err != nil {
+		logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to calculate position values")
 	}
 
-	// Calculate USD values
-	stock.CurrentValueUSD = float64(stock.SharesOwned) * stock.CurrentPrice * fxRate
-	costBasis := float64(stock.SharesOwned) * stock.AvgPriceLocal * fxRate
-	stock.UnrealizedPnL = stock.CurrentValueUSD - costBasis
-
 	stock.LastUpdated = time.Now()
 
 	// Save to database
@@ -107,6 +121,11 @@ func updateStock(db *gorm.DB, apiService *services.ExternalAPIService, stock *mo
 		return err
 	}
 
+	// Keep the quote's daily bar in the price history
+	if err := services.NewPriceService(db, marketData).RecordQuote(stock, update); err != nil {
+		logger.Warn().Err(err).Str("ticker", stock.Ticker).Msg("Failed to store price bar")
+	}
+
 	// Create history entry
 	history := models.StockHistory{
 		StockID:             stock.ID,
@@ -120,6 +139,7 @@ func updateStock(db *gorm.DB, apiService *services.ExternalAPIService, stock *mo
 		KellyFraction:       stock.KellyFraction,
 		Weight:              stock.Weight,
 		Assessment:          stock.Assessment,
+		DataSource:          stock.DataSource,
 		RecordedAt:          time.Now(),
 	}
 	db.Create(&history)
@@ -2,10 +2,12 @@ package services
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/artpro/assessapp/pkg/config"
 	"github.com/artpro/assessapp/pkg/models"
 	"github.com/rs/zerolog"
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
@@ -14,6 +16,7 @@ import (
 type AlertService struct {
 	cfg    *config.Config
 	logger zerolog.Logger
+	client *rest.Client
 }
 
 // NewAlertService creates a new alert service
@@ -21,6 +24,7 @@ func NewAlertService(cfg *config.Config, logger zerolog.Logger) *AlertService {
 	return &AlertService{
 		cfg:    cfg,
 		logger: logger,
+		client: &rest.Client{HTTPClient: NewHTTPClient(cfg, 30*time.Second)},
 	}
 }
 
@@ -56,9 +60,11 @@ func (s *AlertService) SendAlert(alert models.Alert) error {
 	`, alert.Ticker, alert.AlertType, alert.Message, alert.CreatedAt.Format("2006-01-02 15:04:05"))
 
 	message := mail.NewSingleEmail(from, subject, to, plainTextContent, htmlContent)
-	client := sendgrid.NewSendClient(s.cfg.SendGridAPIKey)
+	request := sendgrid.GetRequest(s.cfg.SendGridAPIKey, "/v3/mail/send", "")
+	request.Method = rest.Post
+	request.Body = mail.GetRequestBody(message)
 	
-	response, err := client.Send(message)
+	response, err := s.client.Send(request)
 	if err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// AllocationConstraints are the strategy's limits for the Kelly allocator. Weights are
+// percentages of the whole portfolio including cash.
+type AllocationConstraints struct {
+	MaxPosition  float64                       `json:"max_position"`  // Cap per position
+	SectorRanges map[string]models.SectorRange `json:"sector_ranges"` // Sectors without a range are only capped per position
+	CashMin      float64                       `json:"cash_min"`      // Cash buffer kept at all times
+	CashMax      float64                       `json:"cash_max"`      // Cash above this is reported as undeployed
+	KellyMin     float64                       `json:"kelly_min"`     // Lowest share of the full-Kelly bet (e.g. 0.75)
+	KellyMax     float64                       `json:"kelly_max"`     // Highest share of the full-Kelly bet (e.g. 0.85)
+}
+
+// sectorAliases maps provider sector names onto the strategy's sector names
+var sectorAliases = map[string]string{
+	"health care": "healthcare",
+	"real estate": "reits",
+	"financial":   "financials",
+}
+
+// sectorRange returns the range configured for a sector, matched case-insensitively
+func (c AllocationConstraints) sectorRange(sector string) (string, models.SectorRange, bool) {
+	key := strings.ToLower(strings.TrimSpace(sector))
+	if alias, ok := sectorAliases[key]; ok {
+		key = alias
+	}
+	for name, r := range c.SectorRanges {
+		if strings.ToLower(name) == key {
+			return name, r, true
+		}
+	}
+	return "", models.SectorRange{}, false
+}
+
+// defaultAllocationCorrelation is assumed between stocks without a computed correlation,
+// a typical pairwise correlation of equities
+const defaultAllocationCorrelation = 0.3
+
+// AllocationTarget is the allocator's suggestion for one stock
+type AllocationTarget struct {
+	StockID            uint    `json:"stock_id"`
+	Ticker             string  `json:"ticker"`
+	Sector             string  `json:"sector"`
+	ExpectedValue      float64 `json:"expected_value"`       // EV %
+	HalfKellySuggested float64 `json:"half_kelly_suggested"` // Stand-alone sizing from CalculateMetrics
+	CurrentWeight      float64 `json:"current_weight"`
+	TargetWeight       float64 `json:"target_weight"`
+}
+
+// SectorAllocation compares a sector's current and target weight with its range
+type SectorAllocation struct {
+	Sector        string   `json:"sector"`
+	Min           *float64 `json:"min,omitempty"`
+	Max           *float64 `json:"max,omitempty"`
+	CurrentWeight float64  `json:"current_weight"`
+	TargetWeight  float64  `json:"target_weight"`
+}
+
+// Allocation is the result of the multi-asset Kelly allocator. Weights, returns and growth
+// are percentages; returns and growth are per assessment horizon (the horizon of the fair
+// values behind upside and downside).
+type Allocation struct {
+	KellyFraction         float64               `json:"kelly_fraction"` // Share of the full-Kelly bet deployed
+	Invested              float64               `json:"invested"`
+	Cash                  float64               `json:"cash"`
+	CurrentCash           float64               `json:"current_cash"`
+	ExpectedReturn        float64               `json:"expected_return"`
+	Volatility            float64               `json:"volatility"`
+	ExpectedGrowth        float64               `json:"expected_growth"`         // Expected log growth of the target weights
+	CurrentExpectedGrowth float64               `json:"current_expected_growth"` // Expected log growth of the current weights
+	CorrelationCoverage   float64               `json:"correlation_coverage"`    // Share of candidate pairs with a computed correlation, percentage
+	Positions             []AllocationTarget    `json:"positions"`
+	Sectors               []SectorAllocation    `json:"sectors"`
+	Constraints           AllocationConstraints `json:"constraints"`
+	Warnings              []string              `json:"warnings"`
+}
+
+// allocationGroup is a set of weights whose sum is bounded (a sector)
+type allocationGroup struct {
+	members  []int
+	min, max float64
+}
+
+// AllocateKelly solves for joint fractional-Kelly target weights. Each stock is a binary bet
+// (probability p of its upside, else its downside), or the distribution of its scenarios
+// when they are loaded; with the correlations of daily returns
+// the bets form a covariance matrix, and the weights maximize the growth approximation
+// w'μ - w'Σw/(2k) within the constraints, which gives k times the full-Kelly weights when
+// no constraint binds. k starts at KellyMin and rises towards KellyMax until the cash falls
+// to CashMax. Stocks without a positive edge get no weight. fxRates are units per EUR.
+func AllocateKelly(stocks []models.Stock, cash []models.CashHolding, fxRates map[string]float64, correlations *CorrelationMatrix, constraints AllocationConstraints) Allocation {
+	allocation := Allocation{
+		Positions:   []AllocationTarget{},
+		Sectors:     []SectorAllocation{},
+		Constraints: constraints,
+		Warnings:    []string{},
+	}
+
+	// Current weights of the whole portfolio including cash
+	var total, cashValue float64
+	values := make([]float64, len(stocks))
+	for i, stock := range stocks {
+		if stock.SharesOwned <= 0 {
+			continue
+		}
+		fxRate := fxRates[stock.Currency]
+		if fxRate == 0 {
+			fxRate = 1.0
+		}
+		values[i] = stock.SharesOwned * stock.CurrentPrice / fxRate
+		total += values[i]
+	}
+	for _, holding := range cash {
+		fxRate := fxRates[holding.CurrencyCode]
+		if fxRate == 0 {
+			fxRate = 1.0
+		}
+		cashValue += holding.Amount / fxRate
+	}
+	total += cashValue
+	if total > 0 {
+		allocation.CurrentCash = cashValue / total * 100
+	} else {
+		allocation.CurrentCash = 100
+	}
+
+	// Expected return and outcome spread of each bet, as fractions
+	mu := make([]float64, len(stocks))
+	sigma := make([]float64, len(stocks))
+	eligible := make([]bool, len(stocks))
+	for i, stock := range stocks {
+		if len(stock.Scenarios) > 0 && stock.CurrentPrice > 0 {
+			outcome := EvaluateScenarios(stock.CurrentPrice, stock.Scenarios)
+			mu[i], sigma[i] = outcome.ExpectedValue/100, outcome.StdDev/100
+			eligible[i] = mu[i] > 0 && sigma[i] > 0
+			continue
+		}
+		p := stock.ProbabilityPositive
+		up, down := stock.UpsidePotential/100, stock.DownsideRisk/100
+		if p <= 0 || p >= 1 || up <= 0 || down >= 0 {
+			continue
+		}
+		mu[i] = p*up + (1-p)*down
+		sigma[i] = math.Sqrt(p*(1-p)) * (up - down)
+		eligible[i] = mu[i] > 0 && stock.CurrentPrice > 0
+	}
+
+	var candidates []int
+	for i := range stocks {
+		if eligible[i] {
+			candidates = append(candidates, i)
+		}
+	}
+	n := len(candidates)
+
+	// Covariance of the candidate bets
+	cov := make([][]float64, n)
+	pairs, covered := 0, 0
+	for a, i := range candidates {
+		cov[a] = make([]float64, n)
+		for b, j := range candidates {
+			rho, ok := correlations.Correlation(stocks[i].ID, stocks[j].ID)
+			if a < b {
+				pairs++
+				if ok {
+					covered++
+				}
+			}
+			if !ok {
+				rho = defaultAllocationCorrelation
+			}
+			cov[a][b] = rho * sigma[i] * sigma[j]
+		}
+	}
+	if pairs > 0 {
+		allocation.CorrelationCoverage = float64(covered) / float64(pairs) * 100
+	} else {
+		allocation.CorrelationCoverage = 100
+	}
+
+	// Sector groups of the candidates; minimums are limited to what the sector can hold
+	budget := 1 - constraints.CashMin/100
+	maxPosition := constraints.MaxPosition / 100
+	if maxPosition <= 0 || maxPosition > budget {
+		maxPosition = budget
+	}
+	groupsBySector := make(map[string]*allocationGroup)
+	var groups []*allocationGroup
+	for a, i := range candidates {
+		name, r, ok := constraints.sectorRange(stocks[i].Sector)
+		if !ok {
+			groups = append(groups, &allocationGroup{members: []int{a}, min: 0, max: math.Inf(1)})
+			continue
+		}
+		group := groupsBySector[name]
+		if group == nil {
+			group = &allocationGroup{min: r.Min / 100, max: r.Max / 100}
+			groupsBySector[name] = group
+			groups = append(groups, group)
+		}
+		group.members = append(group.members, a)
+	}
+	names := make([]string, 0, len(constraints.SectorRanges))
+	for name := range constraints.SectorRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := constraints.SectorRanges[name]
+		group := groupsBySector[name]
+		if r.Min > 0 && (group == nil || float64(len(group.members))*maxPosition < r.Min/100) {
+			allocation.Warnings = append(allocation.Warnings, fmt.Sprintf("%s cannot reach its %.0f%% minimum: not enough stocks with a positive edge", name, r.Min))
+		}
+	}
+	var minimums float64
+	for _, group := range groups {
+		group.min = math.Min(group.min, math.Min(group.max, float64(len(group.members))*maxPosition))
+		minimums += group.min
+	}
+	if minimums > budget {
+		allocation.Warnings = append(allocation.Warnings, "sector minimums exceed the investable budget; scaled down")
+		for _, group := range groups {
+			group.min *= budget / minimums
+		}
+	}
+
+	// Solve for the lowest Kelly share that deploys the cash down to CashMax
+	kellyMin, kellyMax := constraints.KellyMin, constraints.KellyMax
+	if kellyMin <= 0 {
+		kellyMin = 0.5
+	}
+	if kellyMax < kellyMin {
+		kellyMax = kellyMin
+	}
+	target := 1 - constraints.CashMax/100
+	k := kellyMin
+	weights := solveKelly(mu, cov, candidates, groups, maxPosition, budget, k, nil)
+	if sumWeights(weights) < target && kellyMax > kellyMin {
+		upper := solveKelly(mu, cov, candidates, groups, maxPosition, budget, kellyMax, weights)
+		if sumWeights(upper) < target {
+			k, weights = kellyMax, upper
+		} else {
+			lo, hi := kellyMin, kellyMax
+			weights = upper
+			for iter := 0; iter < 12; iter++ {
+				mid := (lo + hi) / 2
+				w := solveKelly(mu, cov, candidates, groups, maxPosition, budget, mid, weights)
+				if sumWeights(w) >= target {
+					hi, weights = mid, w
+				} else {
+					lo = mid
+				}
+			}
+			k = hi
+		}
+	}
+	allocation.KellyFraction = k
+
+	// Results
+	targets := make([]float64, len(stocks))
+	for a, i := range candidates {
+		targets[i] = weights[a]
+	}
+	allocation.Invested = sumWeights(weights) * 100
+	allocation.Cash = 100 - allocation.Invested
+	if allocation.Cash > constraints.CashMax+1e-6 {
+		allocation.Warnings = append(allocation.Warnings, fmt.Sprintf("cash of %.1f%% exceeds the %.0f%% buffer at %.2f Kelly: not enough positive-edge capacity", allocation.Cash, constraints.CashMax, k))
+	}
+
+	currentWeights := make([]float64, len(stocks))
+	for i := range stocks {
+		if total > 0 {
+			currentWeights[i] = values[i] / total
+		}
+	}
+	expected, variance := kellyMoments(mu, cov, candidates, targets)
+	allocation.ExpectedReturn = expected * 100
+	allocation.Volatility = math.Sqrt(math.Max(variance, 0)) * 100
+	allocation.ExpectedGrowth = (expected - variance/2) * 100
+	currentExpected, currentVariance := kellyMoments(mu, cov, candidates, currentWeights)
+	for i := range stocks {
+		// Held stocks without a modeled edge still count with their EV; their spread is unknown
+		if !eligible[i] {
+			currentExpected += currentWeights[i] * mu[i]
+		}
+	}
+	allocation.CurrentExpectedGrowth = (currentExpected - currentVariance/2) * 100
+
+	sectors := make(map[string]*SectorAllocation)
+	sectorFor := func(sector string) *SectorAllocation {
+		name := sector
+		if configured, _, ok := constraints.sectorRange(sector); ok {
+			name = configured
+		}
+		if sectors[name] == nil {
+			sectors[name] = &SectorAllocation{Sector: name}
+		}
+		return sectors[name]
+	}
+	for name, r := range constraints.SectorRanges {
+		minimum, maximum := r.Min, r.Max
+		sector := sectorFor(name)
+		sector.Min, sector.Max = &minimum, &maximum
+	}
+	for i, stock := range stocks {
+		if currentWeights[i] == 0 && targets[i] == 0 {
+			continue
+		}
+		allocation.Positions = append(allocation.Positions, AllocationTarget{
+			StockID:            stock.ID,
+			Ticker:             stock.Ticker,
+			Sector:             stock.Sector,
+			ExpectedValue:      stock.ExpectedValue,
+			HalfKellySuggested: stock.HalfKellySuggested,
+			CurrentWeight:      currentWeights[i] * 100,
+			TargetWeight:       targets[i] * 100,
+		})
+		sector := sectorFor(stock.Sector)
+		sector.CurrentWeight += currentWeights[i] * 100
+		sector.TargetWeight += targets[i] * 100
+	}
+	sort.Slice(allocation.Positions, func(a, b int) bool {
+		return allocation.Positions[a].TargetWeight > allocation.Positions[b].TargetWeight
+	})
+	for _, sector := range sectors {
+		allocation.Sectors = append(allocation.Sectors, *sector)
+	}
+	sort.Slice(allocation.Sectors, func(a, b int) bool { return allocation.Sectors[a].Sector < allocation.Sectors[b].Sector })
+
+	return allocation
+}
+
+// solveKelly maximizes w'μ - w'Σw/(2k) over the candidates by projected gradient ascent,
+// starting from start when given
+func solveKelly(mu []float64, cov [][]float64, candidates []int, groups []*allocationGroup, maxPosition, budget, k float64, start []float64) []float64 {
+	n := len(candidates)
+	w := make([]float64, n)
+	if n == 0 {
+		return w
+	}
+	copy(w, start)
+
+	// Step size from a bound on the largest eigenvalue of Σ/k
+	var lipschitz float64
+	for a := range cov {
+		var row float64
+		for b := range cov[a] {
+			row += math.Abs(cov[a][b])
+		}
+		lipschitz = math.Max(lipschitz, row/k)
+	}
+	if lipschitz <= 0 {
+		lipschitz = 1
+	}
+
+	next := make([]float64, n)
+	for iter := 0; iter < 2000; iter++ {
+		for a, i := range candidates {
+			gradient := mu[i]
+			for b := range w {
+				gradient -= cov[a][b] * w[b] / k
+			}
+			next[a] = w[a] + gradient/lipschitz
+		}
+		projected := projectAllocation(next, groups, maxPosition, budget)
+		var change float64
+		for a := range w {
+			change = math.Max(change, math.Abs(projected[a]-w[a]))
+		}
+		w = projected
+		if change < 1e-10 {
+			break
+		}
+	}
+	return w
+}
+
+// projectAllocation returns the nearest weights to y with 0 ≤ w ≤ maxPosition, every
+// group sum within its range and the total at most budget
+func projectAllocation(y []float64, groups []*allocationGroup, maxPosition, budget float64) []float64 {
+	w := make([]float64, len(y))
+	project := func(shift float64) float64 {
+		var total float64
+		for _, group := range groups {
+			total += projectGroup(y, w, group, shift, maxPosition)
+		}
+		return total
+	}
+	if project(0) <= budget {
+		return w
+	}
+
+	// The total falls as the shift grows; find the shift that meets the budget
+	lo, hi := 0.0, maxPosition
+	for _, v := range y {
+		hi = math.Max(hi, v)
+	}
+	for iter := 0; iter < 60; iter++ {
+		mid := (lo + hi) / 2
+		if project(mid) > budget {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	project(hi)
+	return w
+}
+
+// projectGroup writes the projection of y - shift onto the group's range into w and
+// returns the group's sum
+func projectGroup(y, w []float64, group *allocationGroup, shift, maxPosition float64) float64 {
+	sum := func(lambda float64) float64 {
+		var total float64
+		for _, a := range group.members {
+			w[a] = math.Max(0, math.Min(maxPosition, y[a]-shift-lambda))
+			total += w[a]
+		}
+		return total
+	}
+
+	total := sum(0)
+	if total >= group.min-1e-12 && total <= group.max+1e-12 {
+		return total
+	}
+	bound := group.max
+	if total < group.min {
+		bound = group.min
+	}
+
+	// The sum falls as lambda grows; weights span the full range within these limits
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, a := range group.members {
+		lo = math.Min(lo, y[a]-shift-maxPosition)
+		hi = math.Max(hi, y[a]-shift)
+	}
+	for iter := 0; iter < 60; iter++ {
+		mid := (lo + hi) / 2
+		if sum(mid) > bound {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return sum((lo + hi) / 2)
+}
+
+// kellyMoments returns the expected return and variance of the candidates' bets at the
+// given weights (indexed like the stocks)
+func kellyMoments(mu []float64, cov [][]float64, candidates []int, weights []float64) (float64, float64) {
+	var expected, variance float64
+	for a, i := range candidates {
+		expected += weights[i] * mu[i]
+		for b, j := range candidates {
+			variance += weights[i] * cov[a][b] * weights[j]
+		}
+	}
+	return expected, variance
+}
+
+func sumWeights(weights []float64) float64 {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	return total
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func TestAllocateKelly(t *testing.T) {
+	// Each bet returns +20% with p=0.55, else -20%: μ=0.02, σ²=0.0396
+	bet := func(id uint, ticker, sector string) models.Stock {
+		return models.Stock{ID: id, Ticker: ticker, Sector: sector, CurrentPrice: 100,
+			ProbabilityPositive: 0.55, UpsidePotential: 20, DownsideRisk: -20}
+	}
+	stocks := []models.Stock{bet(1, "AAA", "Technology"), bet(2, "BBB", "Technology")}
+	// Full-Kelly weight of each of two such bets with the default correlation of 0.3
+	fullKelly := 0.02 / (0.0396 * (1 + defaultAllocationCorrelation)) * 100
+	unconstrained := AllocationConstraints{CashMax: 100, KellyMin: 0.5, KellyMax: 0.5}
+
+	tests := []struct {
+		name        string
+		constraints func(c AllocationConstraints) AllocationConstraints
+		wantEach    float64 // Target weight of each stock
+		wantKelly   float64
+	}{
+		{"no binding constraint gives k times full Kelly", func(c AllocationConstraints) AllocationConstraints { return c }, 0.5 * fullKelly, 0.5},
+		{"position cap", func(c AllocationConstraints) AllocationConstraints {
+			c.MaxPosition = 5
+			return c
+		}, 5, 0.5},
+		{"sector maximum", func(c AllocationConstraints) AllocationConstraints {
+			c.SectorRanges = map[string]models.SectorRange{"Technology": {Min: 0, Max: 12}}
+			return c
+		}, 6, 0.5},
+		{"cash minimum", func(c AllocationConstraints) AllocationConstraints {
+			c.CashMin = 92
+			return c
+		}, 4, 0.5},
+		{"cash maximum raises k until the cash is deployed", func(c AllocationConstraints) AllocationConstraints {
+			c.CashMax = 50
+			c.KellyMax = 1
+			return c
+		}, 25, 25 / fullKelly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocation := AllocateKelly(stocks, nil, nil, nil, tt.constraints(unconstrained))
+			if len(allocation.Positions) != 2 {
+				t.Fatalf("%d positions, want 2", len(allocation.Positions))
+			}
+			for _, position := range allocation.Positions {
+				if math.Abs(position.TargetWeight-tt.wantEach) > 0.05 {
+					t.Errorf("%s target %.3f%%, want %.3f%%", position.Ticker, position.TargetWeight, tt.wantEach)
+				}
+			}
+			if math.Abs(allocation.KellyFraction-tt.wantKelly) > 0.01 {
+				t.Errorf("Kelly fraction %.3f, want %.3f", allocation.KellyFraction, tt.wantKelly)
+			}
+			if math.Abs(allocation.Cash-(100-2*tt.wantEach)) > 0.1 {
+				t.Errorf("cash %.2f%%, want %.2f%%", allocation.Cash, 100-2*tt.wantEach)
+			}
+		})
+	}
+}
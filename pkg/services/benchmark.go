@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoBenchmark is returned when no benchmark is configured in the portfolio settings
+var ErrNoBenchmark = errors.New("no benchmark configured")
+
+// BenchmarkSourceCSV marks benchmark prices imported from a file; downloaded
+// prices record the market data provider that supplied them
+const BenchmarkSourceCSV = "csv"
+
+// benchmarkMatchWindow is how far after a period start the first close may lie
+// (weekends, holidays) and still count as the starting level
+const benchmarkMatchWindow = 7 * 24 * time.Hour
+
+// BenchmarkComparison compares the portfolio's TWR with the benchmark over one period.
+// Returns are percentages; pointers are nil when the price series does not cover the period.
+type BenchmarkComparison struct {
+	Ticker               string   `json:"ticker"`
+	Name                 string   `json:"name,omitempty"`
+	Currency             string   `json:"currency"`
+	BenchmarkReturn      *float64 `json:"benchmark_return"`       // In EUR, like the portfolio
+	BenchmarkReturnLocal *float64 `json:"benchmark_return_local"` // In the benchmark's currency
+	ActiveReturn         *float64 `json:"active_return"`          // Portfolio TWR minus benchmark return
+	RelativeReturn       *float64 `json:"relative_return"`        // (1 + portfolio) / (1 + benchmark) - 1
+	TrackingError        *float64 `json:"tracking_error"`         // Annualized stdev of weekly active returns
+	Observations         int      `json:"observations"`           // Weekly returns used for the tracking error
+}
+
+// BenchmarkService stores benchmark price series and compares them with the portfolio
+type BenchmarkService struct {
+	db         *gorm.DB
+	marketData *MarketDataService
+}
+
+// NewBenchmarkService creates a new benchmark service
+func NewBenchmarkService(db *gorm.DB, marketData *MarketDataService) *BenchmarkService {
+	return &BenchmarkService{db: db, marketData: marketData}
+}
+
+// Settings returns the portfolio settings, failing with ErrNoBenchmark if no benchmark is set
+func (s *BenchmarkService) Settings() (models.PortfolioSettings, error) {
+	var settings models.PortfolioSettings
+	if err := s.db.First(&settings).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return settings, ErrNoBenchmark
+		}
+		return settings, err
+	}
+	if settings.BenchmarkTicker == "" {
+		return settings, ErrNoBenchmark
+	}
+	return settings, nil
+}
+
+// StorePrices inserts or updates daily closes of a benchmark
+func (s *BenchmarkService) StorePrices(ticker, currency, source string, closes []DailyClose) (int, error) {
+	if len(closes) == 0 {
+		return 0, nil
+	}
+
+	prices := make([]models.BenchmarkPrice, 0, len(closes))
+	for _, c := range closes {
+		prices = append(prices, models.BenchmarkPrice{
+			Ticker:   ticker,
+			Date:     SnapshotDay(c.Date),
+			Close:    c.Close,
+			Currency: currency,
+			Source:   source,
+		})
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ticker"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"close", "currency", "source", "updated_at"}),
+	}).CreateInBatches(&prices, 500).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(prices), nil
+}
+
+// RefreshPrices downloads the configured benchmark's daily series through the portfolio's market data providers
+func (s *BenchmarkService) RefreshPrices(full bool) (int, error) {
+	settings, err := s.Settings()
+	if err != nil {
+		return 0, err
+	}
+
+	benchmark := &models.Stock{Ticker: settings.BenchmarkTicker, Currency: settings.BenchmarkCurrency}
+	closes, provider, err := s.marketData.History(benchmark, s.marketData.PortfolioOrder(), full)
+	if err != nil {
+		return 0, err
+	}
+	return s.StorePrices(settings.BenchmarkTicker, settings.BenchmarkCurrency, provider, closes)
+}
+
+// GetPrices returns the stored closes of a benchmark, oldest first. Zero times leave the range open.
+func (s *BenchmarkService) GetPrices(ticker string, from, to time.Time) ([]models.BenchmarkPrice, error) {
+	query := s.db.Where("ticker = ?", ticker).Order("date ASC")
+	if !from.IsZero() {
+		query = query.Where("date >= ?", SnapshotDay(from))
+	}
+	if !to.IsZero() {
+		query = query.Where("date <= ?", SnapshotDay(to))
+	}
+
+	var prices []models.BenchmarkPrice
+	if err := query.Find(&prices).Error; err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// CompareWithBenchmark measures the portfolio's TWR against the benchmark series over [from, to]
+func CompareWithBenchmark(calc *PerformanceCalculator, portfolioTWR float64, settings models.PortfolioSettings, prices []models.BenchmarkPrice, from, to time.Time) BenchmarkComparison {
+	comparison := BenchmarkComparison{
+		Ticker:   settings.BenchmarkTicker,
+		Name:     settings.BenchmarkName,
+		Currency: settings.BenchmarkCurrency,
+	}
+	from, to = SnapshotDay(from), SnapshotDay(to)
+
+	// Benchmark level converted to EUR on the close date
+	toEUR := func(p models.BenchmarkPrice) float64 {
+		if settings.BenchmarkCurrency == "" {
+			return p.Close
+		}
+		return p.Close / calc.RateAt(settings.BenchmarkCurrency, p.Date)
+	}
+
+	start, okStart := benchmarkStart(prices, from)
+	end, okEnd := benchmarkEnd(prices, to)
+	if !okStart || !okEnd || !end.Date.After(start.Date) {
+		return comparison
+	}
+
+	local := (end.Close/start.Close - 1) * 100
+	base := (toEUR(end)/toEUR(start) - 1) * 100
+	active := portfolioTWR - base
+	relative := ((1+portfolioTWR/100)/(1+base/100) - 1) * 100
+	comparison.BenchmarkReturnLocal = &local
+	comparison.BenchmarkReturn = &base
+	comparison.ActiveReturn = &active
+	comparison.RelativeReturn = &relative
+
+	// Tracking error from weekly active returns (last close of each ISO week)
+	var weekly []models.BenchmarkPrice
+	for _, p := range prices {
+		if p.Date.Before(start.Date) || p.Date.After(end.Date) {
+			continue
+		}
+		if n := len(weekly); n > 0 && sameWeek(weekly[n-1].Date, p.Date) {
+			weekly[n-1] = p
+			continue
+		}
+		weekly = append(weekly, p)
+	}
+
+	var actives []float64
+	for i := 1; i < len(weekly); i++ {
+		period := calc.Portfolio(PeriodCustom, weekly[i-1].Date, weekly[i].Date)
+		if period.StartValue <= 0 {
+			continue // No exposure during the week
+		}
+		benchmark := toEUR(weekly[i])/toEUR(weekly[i-1]) - 1
+		actives = append(actives, period.TWR/100-benchmark)
+	}
+	comparison.Observations = len(actives)
+
+	if len(actives) >= 3 {
+		var mean float64
+		for _, a := range actives {
+			mean += a
+		}
+		mean /= float64(len(actives))
+		var variance float64
+		for _, a := range actives {
+			variance += (a - mean) * (a - mean)
+		}
+		variance /= float64(len(actives) - 1)
+		trackingError := math.Sqrt(variance) * math.Sqrt(52) * 100
+		comparison.TrackingError = &trackingError
+	}
+
+	return comparison
+}
+
+// benchmarkStart returns the last close on or before the date, or the first close shortly after it
+func benchmarkStart(prices []models.BenchmarkPrice, date time.Time) (models.BenchmarkPrice, bool) {
+	idx := sort.Search(len(prices), func(i int) bool { return prices[i].Date.After(date) })
+	if idx > 0 {
+		return prices[idx-1], true
+	}
+	if len(prices) > 0 && prices[0].Date.Sub(date) <= benchmarkMatchWindow {
+		return prices[0], true
+	}
+	return models.BenchmarkPrice{}, false
+}
+
+// benchmarkEnd returns the last close on or before the date
+func benchmarkEnd(prices []models.BenchmarkPrice, date time.Time) (models.BenchmarkPrice, bool) {
+	idx := sort.Search(len(prices), func(i int) bool { return prices[i].Date.After(date) })
+	if idx > 0 && date.Sub(prices[idx-1].Date) <= benchmarkMatchWindow {
+		return prices[idx-1], true
+	}
+	return models.BenchmarkPrice{}, false
+}
+
+func sameWeek(a, b time.Time) bool {
+	ay, aw := a.ISOWeek()
+	by, bw := b.ISOWeek()
+	return ay == by && aw == bw
+}
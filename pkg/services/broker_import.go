@@ -0,0 +1,751 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// ErrInvalidImport is returned when a broker file cannot be parsed
+var ErrInvalidImport = errors.New("invalid import file")
+
+// ImportedTrade is a single trade read from a broker statement
+type ImportedTrade struct {
+	Row       int       `json:"row"` // 1-based line in the source file
+	TradeDate time.Time `json:"trade_date"`
+	Type      string    `json:"type"` // buy or sell
+	Ticker    string    `json:"ticker"`
+	ISIN      string    `json:"isin"`
+	Name      string    `json:"name"`
+	Quantity  float64   `json:"quantity"`
+	Price     float64   `json:"price"`
+	Fees      float64   `json:"fees"`
+	Currency  string    `json:"currency"`
+	FXRate    float64   `json:"fx_rate,omitempty"` // Units of currency per EUR, 0 when the file has none
+	Reference string    `json:"reference,omitempty"`
+}
+
+// BrokerParser turns a broker export into trades
+type BrokerParser interface {
+	Name() string
+	Parse(r io.Reader) ([]ImportedTrade, error)
+}
+
+// ColumnMapping configures the generic parser. Each field is the header of the
+// column holding that value; Date, Quantity and Price plus Ticker or ISIN are required.
+type ColumnMapping struct {
+	Date         string   `json:"date"`
+	Type         string   `json:"type"` // Optional, a negative quantity means sell
+	Ticker       string   `json:"ticker"`
+	ISIN         string   `json:"isin"`
+	Name         string   `json:"name"`
+	Quantity     string   `json:"quantity"`
+	Price        string   `json:"price"`
+	Fees         string   `json:"fees"`
+	Currency     string   `json:"currency"`
+	FXRate       string   `json:"fx_rate"`
+	Reference    string   `json:"reference"`
+	DateFormat   string   `json:"date_format"`   // Go layout, defaults to common formats
+	Delimiter    string   `json:"delimiter"`     // Detected from the header when empty
+	DecimalComma bool     `json:"decimal_comma"` // 1.234,56 instead of 1,234.56
+	BuyValues    []string `json:"buy_values"`    // Values of the type column meaning buy
+	SellValues   []string `json:"sell_values"`   // Values of the type column meaning sell
+}
+
+// Supported broker parser names
+const (
+	BrokerIBKR    = "ibkr"
+	BrokerNordnet = "nordnet"
+	BrokerSaxo    = "saxo"
+	BrokerGeneric = "generic"
+)
+
+// NewBrokerParser returns the parser for a broker. The mapping is only used by the generic parser.
+func NewBrokerParser(broker string, mapping *ColumnMapping) (BrokerParser, error) {
+	switch strings.ToLower(broker) {
+	case BrokerIBKR:
+		return &ibkrParser{}, nil
+	case BrokerNordnet:
+		return nordnetParser(), nil
+	case BrokerSaxo:
+		return saxoParser(), nil
+	case BrokerGeneric:
+		if mapping == nil {
+			return nil, fmt.Errorf("%w: the generic parser needs a column mapping", ErrInvalidImport)
+		}
+		return genericParser(*mapping)
+	}
+	return nil, fmt.Errorf("%w: unknown broker %q", ErrInvalidImport, broker)
+}
+
+// ibkrParser reads the Trades section of an Interactive Brokers activity statement
+type ibkrParser struct{}
+
+func (p *ibkrParser) Name() string { return BrokerIBKR }
+
+func (p *ibkrParser) Parse(r io.Reader) ([]ImportedTrade, error) {
+	records, err := readRecords(r, ',')
+	if err != nil {
+		return nil, err
+	}
+
+	// Activity statements hold many sections, each with its own header row
+	headers := make(map[string]map[string]int)
+	isins := make(map[string]string)
+	names := make(map[string]string)
+	var trades []ImportedTrade
+
+	for i, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		section, kind := record[0], record[1]
+		if kind == "Header" {
+			headers[section] = headerIndex(record[2:])
+			continue
+		}
+		if kind != "Data" {
+			continue
+		}
+		cols, ok := headers[section]
+		if !ok {
+			continue
+		}
+		fields := record[2:]
+
+		switch section {
+		case "Financial Instrument Information":
+			symbol := field(fields, cols, "symbol")
+			if isin := field(fields, cols, "security id"); isin != "" {
+				isins[symbol] = isin
+			}
+			names[symbol] = field(fields, cols, "description")
+
+		case "Trades":
+			if field(fields, cols, "datadiscriminator") != "Order" ||
+				field(fields, cols, "asset category") != "Stocks" {
+				continue
+			}
+			quantity, err := parseNumber(field(fields, cols, "quantity"), false)
+			if err != nil {
+				return nil, rowError(i, "quantity", err)
+			}
+			price, err := parseNumber(field(fields, cols, "t. price"), false)
+			if err != nil {
+				return nil, rowError(i, "price", err)
+			}
+			fees, _ := parseNumber(field(fields, cols, "comm/fee"), false)
+			// Date/Time is "2024-01-10, 10:30:00"
+			dateText := strings.TrimSpace(strings.SplitN(field(fields, cols, "date/time"), ",", 2)[0])
+			tradeDate, err := parseDate(dateText, "")
+			if err != nil {
+				return nil, rowError(i, "date", err)
+			}
+
+			trade := ImportedTrade{
+				Row:       i + 1,
+				TradeDate: tradeDate,
+				Ticker:    field(fields, cols, "symbol"),
+				Quantity:  abs(quantity),
+				Price:     price,
+				Fees:      abs(fees),
+				Currency:  field(fields, cols, "currency"),
+			}
+			trade.Type = models.TransactionTypeBuy
+			if quantity < 0 {
+				trade.Type = models.TransactionTypeSell
+			}
+			trades = append(trades, trade)
+		}
+	}
+
+	// The instrument section comes after the trades, so resolve ISINs last
+	for i := range trades {
+		trades[i].ISIN = isins[trades[i].Ticker]
+		trades[i].Name = names[trades[i].Ticker]
+	}
+
+	if len(trades) == 0 {
+		return nil, fmt.Errorf("%w: no stock trades found in the activity statement", ErrInvalidImport)
+	}
+	return trades, nil
+}
+
+// columnParser reads a flat CSV where each field is found by one of several header aliases
+type columnParser struct {
+	name         string
+	columns      map[string][]string // field -> header aliases, lower case
+	dateFormat   string
+	delimiter    rune
+	decimalComma bool
+	buyValues    []string
+	sellValues   []string
+}
+
+// Field keys used by columnParser
+const (
+	colDate      = "date"
+	colType      = "type"
+	colTicker    = "ticker"
+	colISIN      = "isin"
+	colName      = "name"
+	colQuantity  = "quantity"
+	colPrice     = "price"
+	colFees      = "fees"
+	colCurrency  = "currency"
+	colFXRate    = "fx_rate"
+	colReference = "reference"
+)
+
+// nordnetParser reads Nordnet transaction exports (Swedish, Norwegian, Danish, Finnish or English headers)
+func nordnetParser() *columnParser {
+	return &columnParser{
+		name: BrokerNordnet,
+		columns: map[string][]string{
+			colDate:      {"affärsdag", "handelsdag", "kauppapäivä", "trade day", "trade date"},
+			colType:      {"transaktionstyp", "transaksjonstype", "transaktionstype", "tapahtumatyyppi", "transaction type"},
+			colTicker:    {"värdepapper", "verdipapir", "værdipapir", "arvopaperi", "security"},
+			colISIN:      {"isin"},
+			colQuantity:  {"antal", "antall", "määrä", "quantity"},
+			colPrice:     {"kurs", "hinta", "price"},
+			colFees:      {"total avgift", "totalt avgifter", "samlede afgifter", "kokonaiskulut", "total fees", "courtage", "kurtasje", "kurtage", "brokerage"},
+			colCurrency:  {"valuta", "valuutta", "currency"},
+			colReference: {"id", "verifikationsnummer", "verification number"},
+		},
+		decimalComma: true,
+		buyValues:    []string{"köpt", "kjøpt", "købt", "osto", "buy", "bought"},
+		sellValues:   []string{"sålt", "solgt", "myynti", "sell", "sold"},
+	}
+}
+
+// saxoParser reads Saxo Bank trade exports
+func saxoParser() *columnParser {
+	return &columnParser{
+		name: BrokerSaxo,
+		columns: map[string][]string{
+			colDate:      {"trade date", "tradetime", "trade time"},
+			colType:      {"buy/sell", "direction", "b/s"},
+			colTicker:    {"instrument symbol", "symbol"},
+			colISIN:      {"instrument isin", "isin"},
+			colName:      {"instrument", "instrument description"},
+			colQuantity:  {"amount", "quantity", "traded amount"},
+			colPrice:     {"price", "traded price", "open price"},
+			colFees:      {"commission", "total cost", "costs"},
+			colCurrency:  {"instrument currency", "currency"},
+			colReference: {"trade id", "tradeid", "order id"},
+		},
+		buyValues:  []string{"buy", "bought", "køb", "köp", "kjøp"},
+		sellValues: []string{"sell", "sold", "salg", "sälj"},
+	}
+}
+
+// genericParser builds a column parser from a user supplied mapping
+func genericParser(m ColumnMapping) (*columnParser, error) {
+	if m.Date == "" || m.Quantity == "" || m.Price == "" || (m.Ticker == "" && m.ISIN == "") {
+		return nil, fmt.Errorf("%w: mapping needs date, quantity, price and ticker or isin columns", ErrInvalidImport)
+	}
+
+	p := &columnParser{
+		name:         BrokerGeneric,
+		columns:      make(map[string][]string),
+		dateFormat:   m.DateFormat,
+		decimalComma: m.DecimalComma,
+		buyValues:    lowerAll(m.BuyValues),
+		sellValues:   lowerAll(m.SellValues),
+	}
+	if len(p.buyValues) == 0 {
+		p.buyValues = []string{"buy", "b", "bought"}
+	}
+	if len(p.sellValues) == 0 {
+		p.sellValues = []string{"sell", "s", "sold"}
+	}
+	if m.Delimiter != "" {
+		if m.Delimiter == `\t` {
+			m.Delimiter = "\t"
+		}
+		p.delimiter = []rune(m.Delimiter)[0]
+	}
+
+	for key, header := range map[string]string{
+		colDate: m.Date, colType: m.Type, colTicker: m.Ticker, colISIN: m.ISIN, colName: m.Name,
+		colQuantity: m.Quantity, colPrice: m.Price, colFees: m.Fees, colCurrency: m.Currency,
+		colFXRate: m.FXRate, colReference: m.Reference,
+	} {
+		if header != "" {
+			p.columns[key] = []string{strings.ToLower(strings.TrimSpace(header))}
+		}
+	}
+	return p, nil
+}
+
+func (p *columnParser) Name() string { return p.name }
+
+func (p *columnParser) Parse(r io.Reader) ([]ImportedTrade, error) {
+	records, err := readRecords(r, p.delimiter)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%w: file has no data rows", ErrInvalidImport)
+	}
+
+	header := headerIndex(records[0])
+	cols := make(map[string]int)
+	for key, aliases := range p.columns {
+		for _, alias := range aliases {
+			if idx, ok := header[alias]; ok {
+				cols[key] = idx
+				break
+			}
+		}
+	}
+	for _, required := range []string{colDate, colQuantity, colPrice} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("%w: missing %s column", ErrInvalidImport, required)
+		}
+	}
+	_, hasTicker := cols[colTicker]
+	_, hasISIN := cols[colISIN]
+	if !hasTicker && !hasISIN {
+		return nil, fmt.Errorf("%w: missing ticker or isin column", ErrInvalidImport)
+	}
+
+	var trades []ImportedTrade
+	for i, record := range records[1:] {
+		row := i + 2
+		get := func(key string) string {
+			idx, ok := cols[key]
+			if !ok || idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+
+		quantityText := get(colQuantity)
+		if quantityText == "" {
+			continue
+		}
+
+		// Rows that are neither buys nor sells (dividends, deposits, ...) are skipped
+		tradeType := ""
+		if typeText := strings.ToLower(get(colType)); typeText != "" {
+			if containsValue(p.buyValues, typeText) {
+				tradeType = models.TransactionTypeBuy
+			} else if containsValue(p.sellValues, typeText) {
+				tradeType = models.TransactionTypeSell
+			} else {
+				continue
+			}
+		}
+
+		quantity, err := parseNumber(quantityText, p.decimalComma)
+		if err != nil {
+			return nil, rowError(row-1, "quantity", err)
+		}
+		if quantity == 0 {
+			continue
+		}
+		if tradeType == "" {
+			tradeType = models.TransactionTypeBuy
+			if quantity < 0 {
+				tradeType = models.TransactionTypeSell
+			}
+		}
+		price, err := parseNumber(get(colPrice), p.decimalComma)
+		if err != nil {
+			return nil, rowError(row-1, "price", err)
+		}
+		fees, err := parseNumber(get(colFees), p.decimalComma)
+		if err != nil {
+			return nil, rowError(row-1, "fees", err)
+		}
+		fxRate, err := parseNumber(get(colFXRate), p.decimalComma)
+		if err != nil {
+			return nil, rowError(row-1, "fx_rate", err)
+		}
+		tradeDate, err := parseDate(get(colDate), p.dateFormat)
+		if err != nil {
+			return nil, rowError(row-1, "date", err)
+		}
+
+		trade := ImportedTrade{
+			Row:       row,
+			TradeDate: tradeDate,
+			Type:      tradeType,
+			Ticker:    get(colTicker),
+			ISIN:      strings.ToUpper(get(colISIN)),
+			Name:      get(colName),
+			Quantity:  abs(quantity),
+			Price:     abs(price),
+			Fees:      abs(fees),
+			Currency:  strings.ToUpper(get(colCurrency)),
+			FXRate:    fxRate,
+			Reference: get(colReference),
+		}
+		if trade.Ticker == "" && trade.ISIN == "" {
+			return nil, rowError(row-1, "ticker", errors.New("ticker and isin are both empty"))
+		}
+		trades = append(trades, trade)
+	}
+
+	if len(trades) == 0 {
+		return nil, fmt.Errorf("%w: no buy or sell rows found", ErrInvalidImport)
+	}
+	return trades, nil
+}
+
+// readRecords decodes the file (UTF-8 or UTF-16 with BOM) and reads all CSV records.
+// A zero delimiter is detected from the first line.
+func readRecords(r io.Reader, delimiter rune) ([][]string, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := decodeText(raw)
+
+	if delimiter == 0 {
+		firstLine, _, _ := strings.Cut(text, "\n")
+		delimiter = ','
+		best := strings.Count(firstLine, ",")
+		for _, candidate := range []rune{';', '\t'} {
+			if n := strings.Count(firstLine, string(candidate)); n > best {
+				delimiter, best = candidate, n
+			}
+		}
+	}
+
+	reader := csv.NewReader(bufio.NewReader(strings.NewReader(text)))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return records, nil
+}
+
+// decodeText strips a UTF-8 BOM or converts UTF-16 (as exported by Nordnet) to a string
+func decodeText(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xEF, 0xBB, 0xBF}):
+		return string(raw[3:])
+	case bytes.HasPrefix(raw, []byte{0xFF, 0xFE}), bytes.HasPrefix(raw, []byte{0xFE, 0xFF}):
+		bigEndian := raw[0] == 0xFE
+		raw = raw[2:]
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 0; i+1 < len(raw); i += 2 {
+			if bigEndian {
+				units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+			} else {
+				units = append(units, uint16(raw[i+1])<<8|uint16(raw[i]))
+			}
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(raw)
+}
+
+// headerIndex maps lower-cased, trimmed header names to their column index (first wins)
+func headerIndex(header []string) map[string]int {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if _, exists := index[key]; !exists {
+			index[key] = i
+		}
+	}
+	return index
+}
+
+// field returns the trimmed value of a named column, or "" if missing
+func field(record []string, cols map[string]int, name string) string {
+	idx, ok := cols[name]
+	if !ok || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
+
+// parseNumber parses broker formatted numbers such as "1,234.56", "1 234,56" or "-12"
+func parseNumber(text string, decimalComma bool) (float64, error) {
+	text = strings.NewReplacer(" ", "", " ", "", " ", "", "'", "").Replace(strings.TrimSpace(text))
+	if text == "" || text == "-" || text == "--" {
+		return 0, nil
+	}
+	if decimalComma {
+		text = strings.ReplaceAll(text, ".", "")
+		text = strings.ReplaceAll(text, ",", ".")
+	} else {
+		text = strings.ReplaceAll(text, ",", "")
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// importDateFormats are tried in order when no explicit layout is configured
+var importDateFormats = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"02-01-2006",
+	"02.01.2006",
+	"02/01/2006",
+	"02-Jan-2006",
+	"2 January 2006",
+	"20060102",
+}
+
+// parseDate parses a trade date using the layout, or the common formats if it is empty
+func parseDate(text, layout string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if layout != "" {
+		t, err := time.Parse(layout, text)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return truncateDay(t), nil
+	}
+	for _, format := range importDateFormats {
+		if t, err := time.Parse(format, text); err == nil {
+			return truncateDay(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", text)
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func rowError(index int, column string, err error) error {
+	return fmt.Errorf("%w: row %d: invalid %s: %v", ErrInvalidImport, index+1, column, err)
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return out
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Import row statuses
+const (
+	ImportStatusNew       = "new"       // Matched an existing stock, transaction will be created
+	ImportStatusNewStock  = "new_stock" // Stock will be created together with the transaction
+	ImportStatusDuplicate = "duplicate" // Same trade is already in the ledger, skipped
+	ImportStatusUnmatched = "unmatched" // No stock found for the ISIN or ticker, skipped
+)
+
+// ImportRow is the preview of what happens to one imported trade
+type ImportRow struct {
+	Trade     ImportedTrade `json:"trade"`
+	Status    string        `json:"status"`
+	StockID   uint          `json:"stock_id,omitempty"`
+	MatchedBy string        `json:"matched_by,omitempty"` // isin or ticker
+	StockKey  string        `json:"-"`
+}
+
+// ImportStockChange summarizes how a stock's position changes by the import
+type ImportStockChange struct {
+	StockID           uint    `json:"stock_id,omitempty"` // 0 for stocks that will be created
+	Ticker            string  `json:"ticker"`
+	ISIN              string  `json:"isin,omitempty"`
+	Name              string  `json:"name,omitempty"`
+	Currency          string  `json:"currency"`
+	Transactions      int     `json:"transactions"`
+	CurrentQuantity   float64 `json:"current_quantity"`
+	ResultingQuantity float64 `json:"resulting_quantity"`
+	Error             string  `json:"error,omitempty"`
+	Key               string  `json:"-"`
+}
+
+// ImportPlan is the full preview of a broker import
+type ImportPlan struct {
+	Broker     string              `json:"broker"`
+	Rows       []ImportRow         `json:"rows"`
+	Stocks     []ImportStockChange `json:"stocks"`
+	New        int                 `json:"new"`
+	Duplicates int                 `json:"duplicates"`
+	Unmatched  int                 `json:"unmatched"`
+	Valid      bool                `json:"valid"` // False when a resulting ledger would be invalid
+}
+
+// PlanImport matches trades to stocks by ISIN, then ticker, flags trades already in the
+// ledger and replays each affected ledger to check the import keeps it valid.
+// Unmatched trades become new stocks when createMissing is set.
+func PlanImport(broker string, trades []ImportedTrade, stocks []models.Stock, ledgers map[uint][]models.Transaction, createMissing bool) ImportPlan {
+	byISIN := make(map[string]*models.Stock)
+	byTicker := make(map[string]*models.Stock)
+	for i := range stocks {
+		if stocks[i].ISIN != "" {
+			byISIN[strings.ToUpper(stocks[i].ISIN)] = &stocks[i]
+		}
+		byTicker[strings.ToUpper(stocks[i].Ticker)] = &stocks[i]
+	}
+
+	plan := ImportPlan{Broker: broker, Rows: []ImportRow{}, Stocks: []ImportStockChange{}, Valid: true}
+	changes := make(map[string]*ImportStockChange)
+	var order []string
+	pending := make(map[string][]models.Transaction)
+
+	for _, trade := range trades {
+		row := ImportRow{Trade: trade}
+
+		var stock *models.Stock
+		if trade.ISIN != "" {
+			if stock = byISIN[strings.ToUpper(trade.ISIN)]; stock != nil {
+				row.MatchedBy = "isin"
+			}
+		}
+		if stock == nil && trade.Ticker != "" {
+			if stock = byTicker[strings.ToUpper(trade.Ticker)]; stock != nil {
+				row.MatchedBy = "ticker"
+			}
+		}
+
+		switch {
+		case stock != nil:
+			row.StockID = stock.ID
+			row.StockKey = fmt.Sprintf("id:%d", stock.ID)
+			row.Status = ImportStatusNew
+			if isDuplicateTrade(broker, trade, ledgers[stock.ID]) {
+				row.Status = ImportStatusDuplicate
+			}
+		case createMissing:
+			row.StockKey = "new:" + strings.ToUpper(NewStockTicker(trade))
+			row.Status = ImportStatusNewStock
+		default:
+			row.Status = ImportStatusUnmatched
+		}
+
+		switch row.Status {
+		case ImportStatusDuplicate:
+			plan.Duplicates++
+		case ImportStatusUnmatched:
+			plan.Unmatched++
+		default:
+			plan.New++
+			change, ok := changes[row.StockKey]
+			if !ok {
+				change = &ImportStockChange{Key: row.StockKey, Currency: trade.Currency, ISIN: trade.ISIN, Name: trade.Name, Ticker: NewStockTicker(trade)}
+				if stock != nil {
+					change.StockID = stock.ID
+					change.Ticker = stock.Ticker
+					change.ISIN = stock.ISIN
+					change.Name = stock.CompanyName
+					change.Currency = stock.Currency
+				}
+				changes[row.StockKey] = change
+				order = append(order, row.StockKey)
+			}
+			change.Transactions++
+			pending[row.StockKey] = append(pending[row.StockKey], trade.Transaction(0, 0))
+		}
+
+		plan.Rows = append(plan.Rows, row)
+	}
+
+	for _, key := range order {
+		change := changes[key]
+		existing := ledgers[change.StockID]
+		if current, err := CalculatePosition(existing); err == nil {
+			change.CurrentQuantity = current.Quantity
+		}
+
+		combined := append(append([]models.Transaction{}, existing...), pending[key]...)
+		resulting, err := CalculatePosition(combined)
+		if err != nil {
+			change.Error = err.Error()
+			plan.Valid = false
+		} else {
+			change.ResultingQuantity = resulting.Quantity
+		}
+		plan.Stocks = append(plan.Stocks, *change)
+	}
+
+	return plan
+}
+
+// Transaction converts the trade to a ledger transaction for the given stock
+func (t ImportedTrade) Transaction(stockID uint, fxRate float64) models.Transaction {
+	return models.Transaction{
+		StockID:   stockID,
+		Type:      t.Type,
+		TradeDate: t.TradeDate,
+		Quantity:  t.Quantity,
+		Price:     t.Price,
+		Fees:      t.Fees,
+		Currency:  t.Currency,
+		FXRate:    fxRate,
+		Note:      t.Reference,
+	}
+}
+
+// NewStockTicker returns the ticker used when an unmatched trade creates a stock
+func NewStockTicker(t ImportedTrade) string {
+	if t.Ticker != "" {
+		return t.Ticker
+	}
+	return t.ISIN
+}
+
+// ExternalID identifies the trade across imports: the broker's reference when the file
+// has one, otherwise a fingerprint of the row's values as imported
+func (t ImportedTrade) ExternalID(broker string) string {
+	if t.Reference != "" {
+		return broker + ":" + t.Reference
+	}
+	instrument := strings.ToUpper(t.ISIN)
+	if instrument == "" {
+		instrument = strings.ToUpper(t.Ticker)
+	}
+	return fmt.Sprintf("%s:%s:%s:%s:%g:%g:%g", broker, t.TradeDate.Format("2006-01-02"), t.Type, instrument, t.Quantity, t.Price, t.Fees)
+}
+
+// isDuplicateTrade reports whether the ledger already holds the same trade. Imported
+// transactions match on their stored external ID, which splits and spin-offs leave alone;
+// manual entries fall back to comparing the trade's values.
+func isDuplicateTrade(broker string, trade ImportedTrade, ledger []models.Transaction) bool {
+	externalID := trade.ExternalID(broker)
+	for _, tx := range ledger {
+		if tx.ExternalID != "" {
+			if tx.ExternalID == externalID {
+				return true
+			}
+			continue
+		}
+		if tx.Type == trade.Type &&
+			truncateDay(tx.TradeDate).Equal(trade.TradeDate) &&
+			abs(tx.Quantity-trade.Quantity) < quantityEpsilon &&
+			abs(tx.Price-trade.Price) < 1e-6 {
+			return true
+		}
+	}
+	return false
+}
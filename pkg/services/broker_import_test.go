@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func TestPlanImportFlagsDuplicatesAfterSplit(t *testing.T) {
+	day := time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC)
+	stocks := []models.Stock{{ID: 1, Ticker: "ACME", ISIN: "US0000000001", Currency: "USD"}}
+	withRef := ImportedTrade{Row: 2, TradeDate: day, Type: models.TransactionTypeBuy, ISIN: "US0000000001", Quantity: 10, Price: 100, Currency: "USD", Reference: "T-1"}
+	withoutRef := ImportedTrade{Row: 3, TradeDate: day, Type: models.TransactionTypeBuy, ISIN: "US0000000001", Quantity: 4, Price: 90, Currency: "USD"}
+
+	// Both trades were imported before a 2:1 split rewrote their quantity and price
+	imported := func(trade ImportedTrade, id uint) models.Transaction {
+		tx := trade.Transaction(1, 1)
+		tx.ID = id
+		tx.ExternalID = trade.ExternalID("degiro")
+		tx.Quantity *= 2
+		tx.Price /= 2
+		return tx
+	}
+	ledger := []models.Transaction{imported(withRef, 1), imported(withoutRef, 2)}
+	// A manual entry with the same values as the new trade is still recognized
+	manual := models.Transaction{ID: 3, StockID: 1, Type: models.TransactionTypeBuy, TradeDate: day, Quantity: 1, Price: 50, FXRate: 1}
+
+	tests := []struct {
+		name   string
+		trade  ImportedTrade
+		ledger []models.Transaction
+		want   string
+	}{
+		{"same reference", withRef, ledger, ImportStatusDuplicate},
+		{"same raw values", withoutRef, ledger, ImportStatusDuplicate},
+		{"other reference with split values", ImportedTrade{TradeDate: day, Type: models.TransactionTypeBuy, ISIN: "US0000000001", Quantity: 20, Price: 50, Reference: "T-2"}, ledger, ImportStatusNew},
+		{"manual entry", ImportedTrade{TradeDate: day, Type: models.TransactionTypeBuy, ISIN: "US0000000001", Quantity: 1, Price: 50}, []models.Transaction{manual}, ImportStatusDuplicate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanImport("degiro", []ImportedTrade{tt.trade}, stocks, map[uint][]models.Transaction{1: tt.ledger}, false)
+			if got := plan.Rows[0].Status; got != tt.want {
+				t.Errorf("status %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
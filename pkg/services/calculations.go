@@ -8,19 +8,20 @@ import (
 
 // CalculateMetrics calculates all derived metrics for a stock
 // These formulas implement the investment strategy's Kelly criterion and EV approach
-func CalculateMetrics(stock *models.Stock) {
-	// 1. Calibrate Downside Risk based on Beta (if not manually set)
-	// Beta < 0.5: -15%, Beta 0.5-1: -20%, Beta 1-1.5: -25%, Beta > 1.5: -30%
-	if stock.DownsideRisk == 0 && stock.Beta > 0 {
-		if stock.Beta < 0.5 {
-			stock.DownsideRisk = -15.0
-		} else if stock.Beta < 1.0 {
-			stock.DownsideRisk = -20.0
-		} else if stock.Beta < 1.5 {
-			stock.DownsideRisk = -25.0
-		} else {
-			stock.DownsideRisk = -30.0
-		}
+// with the thresholds of the given strategy profile (nil uses the default profile).
+// A stock with loaded scenarios takes its EV, b ratio and Kelly from their distribution.
+func CalculateMetrics(stock *models.Stock, strategy *models.StrategyProfile) {
+	if strategy == nil {
+		strategy = DefaultStrategyProfile()
+	}
+
+	// 1. Calibrate Downside Risk based on Beta (unless entered or reported)
+	// Default bands: Beta < 0.5: -15%, Beta 0.5-1: -20%, Beta 1-1.5: -25%, Beta > 1.5: -30%
+	if stock.DownsideRisk == 0 {
+		stock.DownsideSource = InputSourceDerived
+	}
+	if stock.DownsideSource == InputSourceDerived && stock.Beta > 0 {
+		stock.DownsideRisk = DownsideForBeta(strategy, stock.Beta)
 	}
 
 	// 2. Calculate Upside Potential (%)
@@ -29,9 +30,12 @@ func CalculateMetrics(stock *models.Stock) {
 		stock.UpsidePotential = ((stock.FairValue - stock.CurrentPrice) / stock.CurrentPrice) * 100
 	}
 
-	// 3. Set default probability if not set (0.65 for typical "Buy" rating)
+	// 3. Use the profile's default probability unless p was entered or reported (0.65 by default)
 	if stock.ProbabilityPositive == 0 {
-		stock.ProbabilityPositive = 0.65
+		stock.ProbabilitySource = InputSourceDerived
+	}
+	if stock.ProbabilitySource == InputSourceDerived {
+		stock.ProbabilityPositive = strategy.DefaultProbability
 	}
 
 	// 4. Calculate b ratio (Upside/Downside ratio)
@@ -59,34 +63,41 @@ func CalculateMetrics(stock *models.Stock) {
 			stock.KellyFraction = 0
 		}
 	}
+	stock.EVModel = models.EVModelBinary
+
+	// 6b. Scenarios replace the binary model's EV, b ratio and Kelly (p and downside stay as inputs)
+	var scenarios ScenarioMetrics
+	if len(stock.Scenarios) > 0 && stock.CurrentPrice > 0 {
+		scenarios = EvaluateScenarios(stock.CurrentPrice, stock.Scenarios)
+		stock.ExpectedValue = scenarios.ExpectedValue
+		stock.BRatio = scenarios.BRatio
+		stock.KellyFraction = scenarios.KellyFraction
+		stock.EVModel = models.EVModelScenarios
+	}
 
 	// 7. Calculate Half-Kelly Suggested Weight (%)
-	// Formula: f* / 2, capped at 15%
+	// Formula: f* / 2, capped at the max position size (15% by default)
 	// Using half-Kelly for more conservative sizing
 	stock.HalfKellySuggested = stock.KellyFraction / 2
-	if stock.HalfKellySuggested > 15 {
-		stock.HalfKellySuggested = 15 // Cap at 15% max position size
+	if stock.HalfKellySuggested > strategy.HalfKellyCap {
+		stock.HalfKellySuggested = strategy.HalfKellyCap
 	}
 
 	// 8. Determine Assessment based on EV
-	// Strategy rules: EV > 7% = Add, EV > 0% = Hold, EV < -3% = Sell, else Trim
-	if stock.ExpectedValue > 7 {
-		stock.Assessment = "Add"
-	} else if stock.ExpectedValue > 0 {
-		stock.Assessment = "Hold"
-	} else if stock.ExpectedValue > -3 {
-		stock.Assessment = "Trim"
-	} else {
-		stock.Assessment = "Sell"
-	}
+	// Default rules: EV > 7% = Add, EV > 0% = Hold, EV < -3% = Sell, else Trim
+	stock.Assessment = AssessmentForEV(strategy, stock.ExpectedValue)
 
 	// Calculate Buy Zone (approximate range where EV > 7%)
 	// This is a simplified calculation - could be refined with more complex modeling
-	if stock.FairValue > 0 && stock.ProbabilityPositive > 0 {
-		// Find price where EV would be ~15% (attractive entry)
+	if stock.EVModel == models.EVModelScenarios {
+		// EV = (expected target / P - 1) * 100 reaches the target at P = expected target / (1 + target/100)
+		stock.BuyZoneMax = scenarios.ExpectedPrice / (1 + strategy.BuyZoneTargetEV/100)
+		stock.BuyZoneMin = stock.BuyZoneMax * 0.90
+	} else if stock.FairValue > 0 && stock.ProbabilityPositive > 0 {
+		// Find price where EV would reach the profile's target (~15%, attractive entry)
 		// Working backwards from EV formula: EV = p * ((FV - P)/P * 100) + (1-p) * downside
-		// For attractive entry, we want EV >= 15%
-		targetEV := 15.0
+		// For attractive entry, we want EV >= target
+		targetEV := strategy.BuyZoneTargetEV
 
 		// Calculate the price where upside potential gives us target EV
 		// Assuming downside risk stays proportional to current estimate
@@ -107,8 +118,10 @@ func CalculateMetrics(stock *models.Stock) {
 	}
 }
 
-// CalculatePortfolioMetrics calculates portfolio-level metrics
-func CalculatePortfolioMetrics(stocks []models.Stock, fxRates map[string]float64) PortfolioMetrics {
+// CalculatePortfolioMetrics calculates portfolio-level metrics in EUR (fxRates are units per EUR).
+// Volatility comes from the covariance of the positions; a nil correlation matrix
+// treats all positions as perfectly correlated.
+func CalculatePortfolioMetrics(stocks []models.Stock, fxRates map[string]float64, correlations *CorrelationMatrix) PortfolioMetrics {
 	var totalValue float64
 	stockValues := make([]float64, len(stocks))
 	
@@ -119,20 +132,19 @@ func CalculatePortfolioMetrics(stocks []models.Stock, fxRates map[string]float64
 			continue
 		}
 		
-		// Calculate position value in USD
+		// Calculate position value in EUR
 		fxRate := fxRates[stock.Currency]
 		if fxRate == 0 {
-			fxRate = 1.0 // Default to 1 if no rate available (assume USD)
+			fxRate = 1.0 // Default to 1 if no rate available (assume EUR)
 		}
 
-		valueUSD := float64(stock.SharesOwned) * stock.CurrentPrice / fxRate
-		stockValues[i] = valueUSD
-		totalValue += valueUSD
+		valueEUR := stock.SharesOwned * stock.CurrentPrice / fxRate
+		stockValues[i] = valueEUR
+		totalValue += valueEUR
 	}
 
 	// Second pass: Calculate weighted metrics with correct total
 	var weightedEV float64
-	var weightedVolatility float64
 	sectorWeights := make(map[string]float64)
 	kellyUtilization := 0.0
 	
@@ -145,7 +157,6 @@ func CalculatePortfolioMetrics(stocks []models.Stock, fxRates map[string]float64
 		if totalValue > 0 && stockValues[i] > 0 {
 			weight := stockValues[i] / totalValue
 			weightedEV += stock.ExpectedValue * weight
-			weightedVolatility += stock.Volatility * weight
 			
 			// Accumulate sector weights
 			sectorWeights[stock.Sector] += weight * 100
@@ -155,28 +166,81 @@ func CalculatePortfolioMetrics(stocks []models.Stock, fxRates map[string]float64
 		}
 	}
 
-	// Calculate Sharpe Ratio (simplified: EV / Volatility)
+	// Portfolio sigma from the covariance matrix rather than the average of the sigmas
+	risk := CalculatePortfolioRisk(stocks, fxRates, correlations)
+
+	// Calculate Sharpe Ratio (simplified: EV / portfolio volatility)
 	sharpeRatio := 0.0
-	if weightedVolatility > 0 {
-		sharpeRatio = weightedEV / weightedVolatility
+	if risk.Volatility > 0 {
+		sharpeRatio = weightedEV / risk.Volatility
 	}
 
 	return PortfolioMetrics{
-		TotalValue:         totalValue,
-		OverallEV:          weightedEV,
-		WeightedVolatility: weightedVolatility,
-		SharpeRatio:        sharpeRatio,
-		KellyUtilization:   kellyUtilization,
-		SectorWeights:      sectorWeights,
+		Currency:                "EUR",
+		TotalValue:              totalValue,
+		OverallEV:               weightedEV,
+		WeightedVolatility:      risk.Volatility,
+		UndiversifiedVolatility: risk.UndiversifiedVolatility,
+		SharpeRatio:             sharpeRatio,
+		KellyUtilization:        kellyUtilization,
+		SectorWeights:           sectorWeights,
+		Warnings:                risk.Warnings,
 	}
 }
 
 // PortfolioMetrics holds portfolio-level aggregated metrics
 type PortfolioMetrics struct {
-	TotalValue         float64            `json:"total_value"`
-	OverallEV          float64            `json:"overall_ev"`
-	WeightedVolatility float64            `json:"weighted_volatility"`
-	SharpeRatio        float64            `json:"sharpe_ratio"`
-	KellyUtilization   float64            `json:"kelly_utilization"`
-	SectorWeights      map[string]float64 `json:"sector_weights"`
+	Currency                string             `json:"currency"` // Currency of TotalValue
+	TotalValue              float64            `json:"total_value"`
+	OverallEV               float64            `json:"overall_ev"`
+	WeightedVolatility      float64            `json:"weighted_volatility"`      // Portfolio sigma from the covariance matrix
+	UndiversifiedVolatility float64            `json:"undiversified_volatility"` // Weighted average of the positions' sigmas
+	SharpeRatio             float64            `json:"sharpe_ratio"`
+	KellyUtilization        float64            `json:"kelly_utilization"`
+	SectorWeights           map[string]float64 `json:"sector_weights"`
+	Warnings                []string           `json:"warnings,omitempty"` // E.g. when the correlations had to be shrunk
+}
+
+// InCurrency returns the metrics with TotalValue converted from EUR to the given currency
+func (m PortfolioMetrics) InCurrency(currency string, fxRates map[string]float64) PortfolioMetrics {
+	if currency == "" || currency == m.Currency {
+		return m
+	}
+	rate := fxRates[currency]
+	if rate == 0 {
+		return m
+	}
+	m.TotalValue *= rate
+	m.Currency = currency
+	return m
+}
+
+// PositionValues fills the stock's value and unrealized P&L in the reporting currency.
+// Both use today's rate; fxRates are units per EUR.
+func PositionValues(stock *models.Stock, fxRates map[string]float64, reportingCurrency string) {
+	if reportingCurrency == "" {
+		reportingCurrency = "EUR"
+	}
+	rate := CrossRate(stock.Currency, reportingCurrency, fxRates)
+
+	stock.ValueBase = stock.SharesOwned * stock.CurrentPrice * rate
+	costBasis := stock.SharesOwned * stock.AvgPriceLocal * rate
+	stock.PnLBase = stock.ValueBase - costBasis
+	stock.BaseCurrency = reportingCurrency
+}
+
+// CrossRate returns how many units of the target currency one unit of the source currency
+// is worth. Missing rates count as 1.0 (EUR).
+func CrossRate(from, to string, fxRates map[string]float64) float64 {
+	if from == to {
+		return 1.0
+	}
+	fromRate, toRate := fxRates[from], fxRates[to]
+	if from == "EUR" || fromRate == 0 {
+		fromRate = 1.0
+	}
+	if to == "EUR" || toRate == 0 {
+		toRate = 1.0
+	}
+	return toRate / fromRate
 }
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func TestCalculateMetricsRederivesDerivedInputs(t *testing.T) {
+	cautious := DefaultStrategyProfile()
+	cautious.DownsideMid = -22
+	cautious.DefaultProbability = 0.6
+
+	tests := []struct {
+		name                string
+		downside, p         float64
+		downsideSource      string
+		probabilitySource   string
+		wantDownside, wantP float64
+	}{
+		{"empty inputs are derived", 0, 0, "", "", -22, 0.6},
+		{"derived inputs follow the profile", -20, 0.65, InputSourceDerived, InputSourceDerived, -22, 0.6},
+		{"manual inputs are kept", -18, 0.7, InputSourceManual, InputSourceManual, -18, 0.7},
+		{"provider inputs are kept", -35, 0.55, InputSourceProvider, InputSourceProvider, -35, 0.55},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stock := models.Stock{
+				CurrentPrice:        100,
+				FairValue:           120,
+				Beta:                0.8,
+				DownsideRisk:        tt.downside,
+				DownsideSource:      tt.downsideSource,
+				ProbabilityPositive: tt.p,
+				ProbabilitySource:   tt.probabilitySource,
+			}
+
+			CalculateMetrics(&stock, DefaultStrategyProfile())
+			CalculateMetrics(&stock, cautious)
+			if stock.DownsideRisk != tt.wantDownside || stock.ProbabilityPositive != tt.wantP {
+				t.Errorf("downside %v, p %v; want %v, %v", stock.DownsideRisk, stock.ProbabilityPositive, tt.wantDownside, tt.wantP)
+			}
+		})
+	}
+}
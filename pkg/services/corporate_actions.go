@@ -0,0 +1,500 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCorporateAction is returned when an action cannot be applied or reverted
+var ErrInvalidCorporateAction = errors.New("invalid corporate action")
+
+// corporateActionSnapshot is the state captured before an action so it can be reverted
+type corporateActionSnapshot struct {
+	Stock                 models.Stock          `json:"stock"`
+	Transactions          []models.Transaction  `json:"transactions"`
+	History               []models.StockHistory `json:"history"`
+	Dividends             []models.Dividend     `json:"dividends"`
+	CreatedTransactionIDs []uint                `json:"created_transaction_ids"`
+	CreatedStockID        uint                  `json:"created_stock_id"`
+	PriceAlreadyAdjusted  bool                  `json:"price_already_adjusted"` // The price was refreshed after the split, so apply left it as is
+	AdjustedPrice         float64               `json:"adjusted_price"`         // The price apply left; a different price at revert was replaced since
+}
+
+// SpinOffTarget describes the stock receiving shares in a spin-off
+type SpinOffTarget struct {
+	Ticker      string
+	CompanyName string
+	ISIN        string
+}
+
+// CorporateActionService applies and reverts corporate actions
+type CorporateActionService struct {
+	db *gorm.DB
+}
+
+// NewCorporateActionService creates a new corporate action service
+func NewCorporateActionService(db *gorm.DB) *CorporateActionService {
+	return &CorporateActionService{db: db}
+}
+
+// Apply validates the action and adjusts the position, ledger lots and price history
+// in a single database transaction, storing the action with a revert snapshot. Ledger
+// rows are changed without touching their UpdatedAt, so Revert can tell later edits apart.
+func (s *CorporateActionService) Apply(action *models.CorporateAction, target SpinOffTarget) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var stock models.Stock
+		if err := tx.First(&stock, action.StockID).Error; err != nil {
+			return err
+		}
+
+		snapshot := corporateActionSnapshot{Stock: stock}
+
+		var err error
+		switch action.Type {
+		case models.CorporateActionSplit, models.CorporateActionReverseSplit:
+			err = s.applySplit(tx, action, &stock, &snapshot)
+		case models.CorporateActionTickerChange:
+			err = s.applyTickerChange(tx, action, &stock, &snapshot)
+		case models.CorporateActionISINChange:
+			if action.NewISIN == "" {
+				return fmt.Errorf("%w: new_isin is required", ErrInvalidCorporateAction)
+			}
+			action.OldISIN = stock.ISIN
+			stock.ISIN = action.NewISIN
+		case models.CorporateActionSpinOff:
+			err = s.applySpinOff(tx, action, &stock, target, &snapshot)
+		default:
+			return fmt.Errorf("%w: unknown type %q", ErrInvalidCorporateAction, action.Type)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := NewLedgerService(tx).SyncPosition(&stock); err != nil {
+			return err
+		}
+		if err := tx.Save(&stock).Error; err != nil {
+			return err
+		}
+
+		raw, err := json.Marshal(snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to serialize snapshot: %w", err)
+		}
+		now := time.Now()
+		action.Snapshot = string(raw)
+		action.Status = "applied"
+		action.AppliedAt = &now
+		action.AffectedTransactions = len(snapshot.Transactions) + len(snapshot.CreatedTransactionIDs)
+		action.AffectedHistory = len(snapshot.History)
+		return tx.Create(action).Error
+	})
+}
+
+// splitFactor returns the share multiplier of a split (new shares per old share)
+func splitFactor(action *models.CorporateAction) (float64, error) {
+	if action.RatioFrom <= 0 || action.RatioTo <= 0 {
+		return 0, fmt.Errorf("%w: ratio_from and ratio_to must be greater than 0", ErrInvalidCorporateAction)
+	}
+	factor := action.RatioTo / action.RatioFrom
+	if action.Type == models.CorporateActionSplit && factor <= 1 {
+		return 0, fmt.Errorf("%w: a split must increase the share count", ErrInvalidCorporateAction)
+	}
+	if action.Type == models.CorporateActionReverseSplit && factor >= 1 {
+		return 0, fmt.Errorf("%w: a reverse split must decrease the share count", ErrInvalidCorporateAction)
+	}
+	return factor, nil
+}
+
+// applySplit scales quantities up and prices down for everything before the effective date
+func (s *CorporateActionService) applySplit(tx *gorm.DB, action *models.CorporateAction, stock *models.Stock, snapshot *corporateActionSnapshot) error {
+	factor, err := splitFactor(action)
+	if err != nil {
+		return err
+	}
+
+	var transactions []models.Transaction
+	if err := tx.Where("stock_id = ? AND trade_date < ?", stock.ID, action.EffectiveDate).Find(&transactions).Error; err != nil {
+		return err
+	}
+	snapshot.Transactions = append(snapshot.Transactions, transactions...)
+	for i := range transactions {
+		if err := tx.Model(&transactions[i]).UpdateColumns(map[string]interface{}{
+			"quantity": transactions[i].Quantity * factor,
+			"price":    transactions[i].Price / factor,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	var history []models.StockHistory
+	if err := tx.Where("stock_id = ? AND recorded_at < ?", stock.ID, action.EffectiveDate).Find(&history).Error; err != nil {
+		return err
+	}
+	snapshot.History = append(snapshot.History, history...)
+	for i := range history {
+		history[i].CurrentPrice /= factor
+		history[i].FairValue /= factor
+		if err := tx.Save(&history[i]).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := scaleStoredBars(tx, stock.ID, action.EffectiveDate, factor); err != nil {
+		return err
+	}
+
+	// A price refreshed by market data on or after the effective date is already post-split
+	if stock.PriceUpdatedAt != nil && !stock.PriceUpdatedAt.Before(action.EffectiveDate) {
+		snapshot.PriceAlreadyAdjusted = true
+		return nil
+	}
+	stock.CurrentPrice /= factor
+	stock.FairValue /= factor
+	stock.BuyZoneMin /= factor
+	stock.BuyZoneMax /= factor
+	snapshot.AdjustedPrice = stock.CurrentPrice
+	return nil
+}
+
+// scaleStoredBars adjusts the price bars before a split date by the split factor.
+// Reverting scales them back with the inverse factor, which also covers bars
+// downloaded after the split was applied.
+func scaleStoredBars(tx *gorm.DB, stockID uint, before time.Time, factor float64) error {
+	return tx.Model(&models.PriceBar{}).
+		Where("stock_id = ? AND date < ?", stockID, before).
+		Updates(map[string]interface{}{
+			"open":   gorm.Expr("open / ?", factor),
+			"high":   gorm.Expr("high / ?", factor),
+			"low":    gorm.Expr("low / ?", factor),
+			"close":  gorm.Expr("close / ?", factor),
+			"volume": gorm.Expr("volume * ?", factor),
+		}).Error
+}
+
+// applyTickerChange renames the stock and every row that denormalizes its ticker
+func (s *CorporateActionService) applyTickerChange(tx *gorm.DB, action *models.CorporateAction, stock *models.Stock, snapshot *corporateActionSnapshot) error {
+	if action.NewTicker == "" {
+		return fmt.Errorf("%w: new_ticker is required", ErrInvalidCorporateAction)
+	}
+
+	var count int64
+	tx.Model(&models.Stock{}).Where("ticker = ? AND id <> ?", action.NewTicker, stock.ID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("%w: ticker %s is already used by another stock", ErrInvalidCorporateAction, action.NewTicker)
+	}
+
+	action.OldTicker = stock.Ticker
+
+	var history []models.StockHistory
+	if err := tx.Where("stock_id = ?", stock.ID).Find(&history).Error; err != nil {
+		return err
+	}
+	snapshot.History = history
+	if err := tx.Model(&models.StockHistory{}).Where("stock_id = ?", stock.ID).Update("ticker", action.NewTicker).Error; err != nil {
+		return err
+	}
+
+	var dividends []models.Dividend
+	if err := tx.Where("stock_id = ?", stock.ID).Find(&dividends).Error; err != nil {
+		return err
+	}
+	snapshot.Dividends = dividends
+	if err := tx.Model(&models.Dividend{}).Where("stock_id = ?", stock.ID).UpdateColumn("ticker", action.NewTicker).Error; err != nil {
+		return err
+	}
+
+	stock.Ticker = action.NewTicker
+	return nil
+}
+
+// applySpinOff moves part of the cost basis into a new position in the spun-off stock. The
+// parent's open lots are reduced by a cost adjustment dated on the effective date, so rows
+// of lots sold before the spin-off, and the realized P&L of those sales, stay untouched.
+func (s *CorporateActionService) applySpinOff(tx *gorm.DB, action *models.CorporateAction, stock *models.Stock, target SpinOffTarget, snapshot *corporateActionSnapshot) error {
+	if action.RatioFrom <= 0 || action.RatioTo <= 0 {
+		return fmt.Errorf("%w: ratio_from and ratio_to must be greater than 0", ErrInvalidCorporateAction)
+	}
+	if action.CostAllocation < 0 || action.CostAllocation >= 100 {
+		return fmt.Errorf("%w: cost_allocation must be between 0 and 100", ErrInvalidCorporateAction)
+	}
+
+	var transactions []models.Transaction
+	if err := tx.Where("stock_id = ? AND trade_date < ?", stock.ID, action.EffectiveDate).Find(&transactions).Error; err != nil {
+		return err
+	}
+	// The open lots under the realized P&L method carry the cost that is split off
+	var settings models.PortfolioSettings
+	if err := tx.First(&settings).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	method := settings.CostBasisMethod
+	if method == "" {
+		method = CostBasisFIFO
+	}
+	_, lots, err := MatchLots(transactions, method)
+	if err != nil {
+		return err
+	}
+	var quantity, openCost float64
+	for _, lot := range lots {
+		quantity += lot.Quantity
+		openCost += lot.CostLocal
+	}
+	if quantity <= quantityEpsilon {
+		return fmt.Errorf("%w: no shares held before the effective date", ErrInvalidCorporateAction)
+	}
+
+	child, created, err := s.findOrCreateSpinOffStock(tx, action, stock, target)
+	if err != nil {
+		return err
+	}
+	if created {
+		snapshot.CreatedStockID = child.ID
+	}
+	action.SpinOffStockID = child.ID
+	action.NewTicker = child.Ticker
+
+	// Reduce the parent's open lots by the allocated share of cost
+	if action.CostAllocation > 0 {
+		adjustment := models.Transaction{
+			StockID:    stock.ID,
+			Type:       models.TransactionTypeCostAdjustment,
+			TradeDate:  action.EffectiveDate,
+			CostFactor: 1 - action.CostAllocation/100,
+			Currency:   stock.Currency,
+			FXRate:     latestFXRate(transactions),
+			Source:     "corporate_action",
+			Note:       fmt.Sprintf("%g%% of the cost allocated to the %s spin-off", action.CostAllocation, child.Ticker),
+		}
+		if err := tx.Create(&adjustment).Error; err != nil {
+			return err
+		}
+		snapshot.CreatedTransactionIDs = append(snapshot.CreatedTransactionIDs, adjustment.ID)
+	}
+
+	// Open the spin-off position carrying the allocated cost
+	childQuantity := quantity * action.RatioTo / action.RatioFrom
+	allocatedCost := openCost * action.CostAllocation / 100
+	opening := models.Transaction{
+		StockID:   child.ID,
+		Type:      models.TransactionTypeBuy,
+		TradeDate: action.EffectiveDate,
+		Quantity:  childQuantity,
+		Price:     allocatedCost / childQuantity,
+		Currency:  stock.Currency,
+		FXRate:    latestFXRate(transactions),
+		Source:    "corporate_action",
+		Note:      fmt.Sprintf("Spin-off from %s", stock.Ticker),
+	}
+	if err := tx.Create(&opening).Error; err != nil {
+		return err
+	}
+	snapshot.CreatedTransactionIDs = append(snapshot.CreatedTransactionIDs, opening.ID)
+
+	if _, err := NewLedgerService(tx).SyncPosition(&child); err != nil {
+		return err
+	}
+	return tx.Save(&child).Error
+}
+
+// findOrCreateSpinOffStock returns the stock receiving the spin-off shares
+func (s *CorporateActionService) findOrCreateSpinOffStock(tx *gorm.DB, action *models.CorporateAction, parent *models.Stock, target SpinOffTarget) (models.Stock, bool, error) {
+	var child models.Stock
+	if action.SpinOffStockID != 0 {
+		if err := tx.First(&child, action.SpinOffStockID).Error; err != nil {
+			return child, false, fmt.Errorf("%w: spin-off stock not found", ErrInvalidCorporateAction)
+		}
+		return child, false, nil
+	}
+
+	if target.Ticker == "" {
+		return child, false, fmt.Errorf("%w: spin_off_stock_id or new_ticker is required", ErrInvalidCorporateAction)
+	}
+	if err := tx.Where("ticker = ?", target.Ticker).First(&child).Error; err == nil {
+		return child, false, nil
+	}
+
+	child = models.Stock{
+		Ticker:          target.Ticker,
+		ISIN:            target.ISIN,
+		CompanyName:     target.CompanyName,
+		Sector:          parent.Sector,
+		Currency:        parent.Currency,
+		UpdateFrequency: parent.UpdateFrequency,
+		LastUpdated:     time.Now(),
+	}
+	if child.CompanyName == "" {
+		child.CompanyName = target.Ticker
+	}
+	if err := tx.Create(&child).Error; err != nil {
+		return child, false, err
+	}
+	return child, true, nil
+}
+
+// latestFXRate returns the FX rate of the most recent transaction, or 1.0 if none is set
+func latestFXRate(transactions []models.Transaction) float64 {
+	sorted := make([]models.Transaction, len(transactions))
+	copy(sorted, transactions)
+	sortTransactions(sorted)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if sorted[i].FXRate > 0 {
+			return sorted[i].FXRate
+		}
+	}
+	return 1.0
+}
+
+// Revert restores the state captured when the action was applied. Only the most
+// recent applied action of a stock can be reverted, so snapshots never conflict. It
+// refuses when a transaction or dividend was edited since; rows deleted since stay deleted.
+func (s *CorporateActionService) Revert(action *models.CorporateAction) error {
+	if action.Status != "applied" {
+		return fmt.Errorf("%w: action is already reverted", ErrInvalidCorporateAction)
+	}
+
+	var latest models.CorporateAction
+	if err := s.db.Where("stock_id = ? AND status = ?", action.StockID, "applied").Order("id DESC").First(&latest).Error; err != nil {
+		return err
+	}
+	if latest.ID != action.ID {
+		return fmt.Errorf("%w: revert later actions on this stock first", ErrInvalidCorporateAction)
+	}
+
+	var snapshot corporateActionSnapshot
+	if err := json.Unmarshal([]byte(action.Snapshot), &snapshot); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	appliedAt := action.CreatedAt
+	if action.AppliedAt != nil {
+		appliedAt = *action.AppliedAt
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range snapshot.Transactions {
+			original := &snapshot.Transactions[i]
+			var current models.Transaction
+			if err := tx.First(&current, original.ID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				return err
+			}
+			if editedSince(current.UpdatedAt, original.UpdatedAt, appliedAt) {
+				return fmt.Errorf("%w: transaction %d was edited after the action was applied", ErrInvalidCorporateAction, original.ID)
+			}
+			if err := tx.Save(original).Error; err != nil {
+				return err
+			}
+		}
+		for i := range snapshot.Dividends {
+			original := &snapshot.Dividends[i]
+			var current models.Dividend
+			if err := tx.First(&current, original.ID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				return err
+			}
+			if editedSince(current.UpdatedAt, original.UpdatedAt, appliedAt) {
+				return fmt.Errorf("%w: dividend %d was edited after the action was applied", ErrInvalidCorporateAction, original.ID)
+			}
+			if err := tx.Save(original).Error; err != nil {
+				return err
+			}
+		}
+		// Updates never inserts, so deleted history entries are not brought back
+		for i := range snapshot.History {
+			if err := tx.Select("*").Updates(&snapshot.History[i]).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.CreatedTransactionIDs) > 0 {
+			if err := tx.Delete(&models.Transaction{}, snapshot.CreatedTransactionIDs).Error; err != nil {
+				return err
+			}
+		}
+
+		if action.SpinOffStockID != 0 {
+			if err := s.revertSpinOffStock(tx, action.SpinOffStockID, snapshot.CreatedStockID); err != nil {
+				return err
+			}
+		}
+
+		// Restore the stock's own fields but keep live market data from later updates
+		var stock models.Stock
+		if err := tx.First(&stock, action.StockID).Error; err != nil {
+			return err
+		}
+		stock.Ticker = snapshot.Stock.Ticker
+		stock.ISIN = snapshot.Stock.ISIN
+		if action.Type == models.CorporateActionSplit || action.Type == models.CorporateActionReverseSplit {
+			factor, err := splitFactor(action)
+			if err != nil {
+				return err
+			}
+			if err := scaleStoredBars(tx, stock.ID, action.EffectiveDate, 1/factor); err != nil {
+				return err
+			}
+			// Scale the price back only if apply divided it and neither a market data
+			// refresh nor an edit has replaced it since
+			refreshed := stock.PriceUpdatedAt != nil && !stock.PriceUpdatedAt.Before(appliedAt)
+			edited := snapshot.AdjustedPrice != 0 && math.Abs(stock.CurrentPrice-snapshot.AdjustedPrice) > 1e-9*snapshot.AdjustedPrice
+			if !snapshot.PriceAlreadyAdjusted && !refreshed && !edited {
+				stock.CurrentPrice *= factor
+				stock.FairValue *= factor
+				stock.BuyZoneMin *= factor
+				stock.BuyZoneMax *= factor
+			}
+		}
+		if _, err := NewLedgerService(tx).SyncPosition(&stock); err != nil {
+			return err
+		}
+		if err := tx.Save(&stock).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		action.Status = "reverted"
+		action.RevertedAt = &now
+		return tx.Save(action).Error
+	})
+}
+
+// editedSince reports whether a ledger row was edited after the action was applied. Apply
+// keeps UpdatedAt as it was; actions applied before it did so set it just before appliedAt.
+func editedSince(current, snapshot, appliedAt time.Time) bool {
+	return !current.Equal(snapshot) && current.After(appliedAt)
+}
+
+// revertSpinOffStock re-derives the spin-off position, deleting the stock if the action created it
+func (s *CorporateActionService) revertSpinOffStock(tx *gorm.DB, childID, createdStockID uint) error {
+	var child models.Stock
+	if err := tx.First(&child, childID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if child.ID == createdStockID {
+		var remaining int64
+		tx.Model(&models.Transaction{}).Where("stock_id = ?", child.ID).Count(&remaining)
+		if remaining == 0 {
+			return tx.Delete(&child).Error
+		}
+	}
+
+	if _, err := NewLedgerService(tx).SyncPosition(&child); err != nil {
+		return err
+	}
+	return tx.Save(&child).Error
+}
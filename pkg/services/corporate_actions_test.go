@@ -0,0 +1,224 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// splitFixture holds 10 shares bought at 100 before a 1-for-4 split, priced by market data
+// at priceUpdated; the stock itself was edited just now
+func splitFixture(t *testing.T, db *gorm.DB, priceUpdated, effective time.Time) (models.Stock, models.Transaction) {
+	t.Helper()
+	stock := models.Stock{Ticker: "SPLT", Currency: "USD", CurrentPrice: 120, FairValue: 160, PriceUpdatedAt: &priceUpdated, LastUpdated: time.Now()}
+	if err := db.Create(&stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	buy := models.Transaction{StockID: stock.ID, Type: models.TransactionTypeBuy, TradeDate: effective.AddDate(0, -1, 0), Quantity: 10, Price: 100, Currency: "USD", FXRate: 1}
+	if err := db.Create(&buy).Error; err != nil {
+		t.Fatal(err)
+	}
+	return stock, buy
+}
+
+func applySplit(t *testing.T, service *CorporateActionService, stockID uint, effective time.Time) *models.CorporateAction {
+	t.Helper()
+	action := &models.CorporateAction{StockID: stockID, Type: models.CorporateActionSplit, EffectiveDate: effective, RatioFrom: 1, RatioTo: 4}
+	if err := service.Apply(action, SpinOffTarget{}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	return action
+}
+
+func TestCorporateActionSplitApplyRevert(t *testing.T) {
+	db := openTestDB(t)
+	service := NewCorporateActionService(db)
+	effective := time.Now().AddDate(0, 0, -1)
+	stock, buy := splitFixture(t, db, effective.AddDate(0, 0, -2), effective)
+
+	action := applySplit(t, service, stock.ID, effective)
+	db.First(&stock, stock.ID)
+	if stock.CurrentPrice != 30 || stock.SharesOwned != 40 {
+		t.Fatalf("after split: price %v shares %v, want 30 and 40", stock.CurrentPrice, stock.SharesOwned)
+	}
+
+	// Ledger and stock edits touch LastUpdated but leave the price as apply set it
+	db.Model(&stock).Update("last_updated", time.Now().Add(time.Minute))
+	if err := service.Revert(action); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	db.First(&stock, stock.ID)
+	var restored models.Transaction
+	db.First(&restored, buy.ID)
+	if stock.CurrentPrice != 120 || stock.SharesOwned != 10 || restored.Quantity != 10 || restored.Price != 100 {
+		t.Errorf("after revert: price %v shares %v lot %v@%v, want 120, 10 and 10@100",
+			stock.CurrentPrice, stock.SharesOwned, restored.Quantity, restored.Price)
+	}
+}
+
+func TestCorporateActionSplitKeepsRefreshedPrice(t *testing.T) {
+	db := openTestDB(t)
+	service := NewCorporateActionService(db)
+	effective := time.Now().AddDate(0, 0, -3)
+
+	// The provider already delivered the post-split price
+	stock, _ := splitFixture(t, db, effective.AddDate(0, 0, 1), effective)
+	action := applySplit(t, service, stock.ID, effective)
+	db.First(&stock, stock.ID)
+	if stock.CurrentPrice != 120 {
+		t.Errorf("apply changed a refreshed price to %v", stock.CurrentPrice)
+	}
+	if err := service.Revert(action); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	db.First(&stock, stock.ID)
+	if stock.CurrentPrice != 120 {
+		t.Errorf("revert changed a price apply left alone to %v", stock.CurrentPrice)
+	}
+
+	// A refresh or a price edit after apply is kept on revert
+	for _, change := range []map[string]interface{}{
+		{"current_price": 31.5, "price_updated_at": time.Now().Add(time.Minute)},
+		{"current_price": 31.5},
+	} {
+		db.Model(&stock).Updates(map[string]interface{}{"current_price": 120, "price_updated_at": effective.AddDate(0, 0, -5)})
+		action = applySplit(t, service, stock.ID, effective)
+		db.Model(&stock).Updates(change)
+		if err := service.Revert(action); err != nil {
+			t.Fatalf("Revert: %v", err)
+		}
+		db.First(&stock, stock.ID)
+		if math.Abs(stock.CurrentPrice-31.5) > 1e-9 {
+			t.Errorf("revert after %v replaced the price with %v", change, stock.CurrentPrice)
+		}
+	}
+}
+
+func TestCorporateActionRevertRefusesEditedLedger(t *testing.T) {
+	db := openTestDB(t)
+	service := NewCorporateActionService(db)
+	effective := time.Now().AddDate(0, 0, -1)
+	stock, buy := splitFixture(t, db, effective.AddDate(0, 0, -2), effective)
+	action := applySplit(t, service, stock.ID, effective)
+
+	var edited models.Transaction
+	db.First(&edited, buy.ID)
+	edited.Fees = 2
+	edited.UpdatedAt = time.Time{}
+	if err := db.Save(&edited).Error; err != nil {
+		t.Fatal(err)
+	}
+	err := service.Revert(action)
+	if !errors.Is(err, ErrInvalidCorporateAction) {
+		t.Fatalf("Revert after an edit: got %v, want ErrInvalidCorporateAction", err)
+	}
+	db.First(&edited, buy.ID)
+	if edited.Quantity != 40 || edited.Fees != 2 {
+		t.Errorf("refused revert changed the lot to %v shares, fees %v", edited.Quantity, edited.Fees)
+	}
+}
+
+func TestCorporateActionRevertKeepsDeletedRowsDeleted(t *testing.T) {
+	db := openTestDB(t)
+	service := NewCorporateActionService(db)
+	effective := time.Now().AddDate(0, 0, -1)
+	stock, buy := splitFixture(t, db, effective.AddDate(0, 0, -2), effective)
+	history := models.StockHistory{StockID: stock.ID, Ticker: stock.Ticker, CurrentPrice: 100, RecordedAt: effective.AddDate(0, 0, -7)}
+	if err := db.Create(&history).Error; err != nil {
+		t.Fatal(err)
+	}
+	action := applySplit(t, service, stock.ID, effective)
+
+	db.Delete(&models.Transaction{}, buy.ID)
+	db.Delete(&models.StockHistory{}, history.ID)
+	if err := service.Revert(action); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	var transactions, entries int64
+	db.Model(&models.Transaction{}).Where("id = ?", buy.ID).Count(&transactions)
+	db.Model(&models.StockHistory{}).Where("id = ?", history.ID).Count(&entries)
+	if transactions != 0 || entries != 0 {
+		t.Errorf("revert re-created %d transactions and %d history entries", transactions, entries)
+	}
+}
+
+func TestCorporateActionSpinOffKeepsRealizedPnL(t *testing.T) {
+	effective := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, method := range []string{CostBasisFIFO, CostBasisLIFO, CostBasisAverage} {
+		t.Run(method, func(t *testing.T) {
+			db := openTestDB(t)
+			if err := db.Create(&models.PortfolioSettings{CostBasisMethod: method}).Error; err != nil {
+				t.Fatal(err)
+			}
+			stock := models.Stock{Ticker: "PRNT", Currency: "USD", CurrentPrice: 250}
+			if err := db.Create(&stock).Error; err != nil {
+				t.Fatal(err)
+			}
+			ledger := []models.Transaction{
+				{StockID: stock.ID, Type: models.TransactionTypeBuy, TradeDate: effective.AddDate(0, -3, 0), Quantity: 10, Price: 100, Currency: "USD", FXRate: 1},
+				{StockID: stock.ID, Type: models.TransactionTypeBuy, TradeDate: effective.AddDate(0, -2, 0), Quantity: 10, Price: 200, Currency: "USD", FXRate: 1},
+				{StockID: stock.ID, Type: models.TransactionTypeSell, TradeDate: effective.AddDate(0, -1, 0), Quantity: 5, Price: 300, Currency: "USD", FXRate: 1},
+			}
+			if err := db.Create(&ledger).Error; err != nil {
+				t.Fatal(err)
+			}
+			before, err := NewLedgerService(db).GetTransactions(stock.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			salesBefore, lotsBefore, err := MatchLots(before, method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			action := &models.CorporateAction{StockID: stock.ID, Type: models.CorporateActionSpinOff, EffectiveDate: effective, RatioFrom: 1, RatioTo: 1, CostAllocation: 20}
+			if err := NewCorporateActionService(db).Apply(action, SpinOffTarget{Ticker: "CHLD"}); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			after, err := NewLedgerService(db).GetTransactions(stock.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range before {
+				if after[i].Quantity != before[i].Quantity || after[i].Price != before[i].Price || after[i].Fees != before[i].Fees {
+					t.Errorf("ledger row %d changed to %v@%v", after[i].ID, after[i].Quantity, after[i].Price)
+				}
+			}
+			salesAfter, lotsAfter, err := MatchLots(after, method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(salesAfter) != 1 || salesAfter[0].GainLocal != salesBefore[0].GainLocal {
+				t.Errorf("realized gain %v after the spin-off, want %v", salesAfter[0].GainLocal, salesBefore[0].GainLocal)
+			}
+
+			var openBefore, openAfter float64
+			for _, lot := range lotsBefore {
+				openBefore += lot.CostLocal
+			}
+			for _, lot := range lotsAfter {
+				openAfter += lot.CostLocal
+			}
+			var child models.Stock
+			db.First(&child, action.SpinOffStockID)
+			childTransactions, _ := NewLedgerService(db).GetTransactions(child.ID)
+			childCost := childTransactions[0].Quantity * childTransactions[0].Price
+			if math.Abs(openAfter-0.8*openBefore) > 1e-9 || math.Abs(childCost-0.2*openBefore) > 1e-9 {
+				t.Errorf("open cost %v and spin-off cost %v, want %v and %v", openAfter, childCost, 0.8*openBefore, 0.2*openBefore)
+			}
+
+			if err := NewCorporateActionService(db).Revert(action); err != nil {
+				t.Fatalf("Revert: %v", err)
+			}
+			reverted, _ := NewLedgerService(db).GetTransactions(stock.ID)
+			if len(reverted) != len(before) {
+				t.Errorf("%d transactions after revert, want %d", len(reverted), len(before))
+			}
+		})
+	}
+}
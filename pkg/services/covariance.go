@@ -0,0 +1,249 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// CorrelationMatrix holds the pairwise correlations of daily returns between stocks,
+// computed from the stored price bars in each stock's own currency (FX risk is not
+// modeled). Values are nil where two stocks share too little history.
+type CorrelationMatrix struct {
+	StockIDs     []uint       `json:"stock_ids"`
+	Tickers      []string     `json:"tickers"`
+	Values       [][]*float64 `json:"values"`
+	Observations [][]int      `json:"observations"` // Daily returns matched for each pair
+	Window       int          `json:"window"`       // Daily returns considered per pair
+	ComputedAt   time.Time    `json:"computed_at"`
+
+	index map[uint]int
+}
+
+// Correlation returns the correlation of two stocks, if it could be computed
+func (m *CorrelationMatrix) Correlation(a, b uint) (float64, bool) {
+	if a == b {
+		return 1, true
+	}
+	if m == nil {
+		return 0, false
+	}
+	i, okA := m.index[a]
+	j, okB := m.index[b]
+	if !okA || !okB || m.Values[i][j] == nil {
+		return 0, false
+	}
+	return *m.Values[i][j], true
+}
+
+// RiskContribution is one position's share of the portfolio's volatility. The
+// contributions of all positions add up to the portfolio sigma.
+type RiskContribution struct {
+	StockID         uint    `json:"stock_id"`
+	Ticker          string  `json:"ticker"`
+	Weight          float64 `json:"weight"`           // Percentage of the positions' value
+	Volatility      float64 `json:"volatility"`       // Annualized sigma percentage
+	MarginalRisk    float64 `json:"marginal_risk"`    // Sigma percentage points per unit of weight, at the margin
+	Contribution    float64 `json:"contribution"`     // Sigma percentage points attributable to the position
+	ContributionPct float64 `json:"contribution_pct"` // Share of the portfolio sigma, percentage
+}
+
+// PortfolioRisk is the covariance-based volatility of the current positions
+type PortfolioRisk struct {
+	Volatility              float64            `json:"volatility"`               // Annualized portfolio sigma percentage
+	UndiversifiedVolatility float64            `json:"undiversified_volatility"` // Weighted average of the positions' sigmas
+	DiversificationRatio    float64            `json:"diversification_ratio"`    // Undiversified / portfolio sigma
+	CorrelationCoverage     float64            `json:"correlation_coverage"`     // Share of position pairs with a computed correlation, percentage
+	CorrelationShrink       float64            `json:"correlation_shrink"`       // Factor applied to the correlations to keep the matrix valid; 1 when none was needed
+	Contributions           []RiskContribution `json:"contributions"`
+	Warnings                []string           `json:"warnings,omitempty"`
+}
+
+// Correlations computes the correlation matrix of the given stocks over the
+// volatility window of the portfolio settings
+func (s *StatisticsService) Correlations(stocks []models.Stock) (*CorrelationMatrix, error) {
+	window, _, _, err := s.riskSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(stocks))
+	for _, stock := range stocks {
+		ids = append(ids, stock.ID)
+	}
+	var bars []models.PriceBar
+	if err := s.db.Where("stock_id IN ?", ids).Order("date ASC").Find(&bars).Error; err != nil {
+		return nil, err
+	}
+	byStock := make(map[uint][]models.PriceBar)
+	for _, bar := range bars {
+		byStock[bar.StockID] = append(byStock[bar.StockID], bar)
+	}
+	series := make([][]closePoint, len(stocks))
+	for i, stock := range stocks {
+		series[i] = barSeries(byStock[stock.ID])
+	}
+
+	return buildCorrelationMatrix(stocks, series, window), nil
+}
+
+// buildCorrelationMatrix correlates the close series of the stocks (oldest first,
+// same order as stocks) pairwise over their last window common daily returns
+func buildCorrelationMatrix(stocks []models.Stock, series [][]closePoint, window int) *CorrelationMatrix {
+	n := len(stocks)
+	m := &CorrelationMatrix{
+		StockIDs:     make([]uint, n),
+		Tickers:      make([]string, n),
+		Values:       make([][]*float64, n),
+		Observations: make([][]int, n),
+		Window:       window,
+		ComputedAt:   time.Now(),
+		index:        make(map[uint]int, n),
+	}
+	for i, stock := range stocks {
+		m.StockIDs[i] = stock.ID
+		m.Tickers[i] = stock.Ticker
+		m.Values[i] = make([]*float64, n)
+		m.Observations[i] = make([]int, n)
+		m.index[stock.ID] = i
+	}
+
+	for i := 0; i < n; i++ {
+		one := 1.0
+		m.Values[i][i] = &one
+		m.Observations[i][i] = len(lastPoints(series[i], window+1)) - 1
+		if m.Observations[i][i] < 0 {
+			m.Observations[i][i] = 0
+		}
+		for j := i + 1; j < n; j++ {
+			aligned := alignSeries(series[i], series[j])
+			x := simpleReturns(lastPoints(aligned[0], window+1))
+			y := simpleReturns(lastPoints(aligned[1], window+1))
+			m.Observations[i][j], m.Observations[j][i] = len(x), len(x)
+			if len(x) < MinRiskObservations {
+				continue
+			}
+			varX, varY := sampleCovariance(x, x), sampleCovariance(y, y)
+			if varX <= 0 || varY <= 0 {
+				continue
+			}
+			rho := sampleCovariance(x, y) / math.Sqrt(varX*varY)
+			rho = math.Max(-1, math.Min(1, rho))
+			m.Values[i][j], m.Values[j][i] = &rho, &rho
+		}
+	}
+	return m
+}
+
+// CalculatePortfolioRisk computes sigma = sqrt(w'Σw) of the held positions, with
+// Σ built from each stock's volatility and the correlations. Pairs without a
+// correlation are treated as perfectly correlated, so missing history never
+// understates risk; without any correlations sigma equals the weighted average.
+// Pairwise estimates mixed with those fallbacks need not form a valid matrix; the
+// correlations are then shrunk towards zero as for the simulation, with a warning.
+func CalculatePortfolioRisk(stocks []models.Stock, fxRates map[string]float64, correlations *CorrelationMatrix) PortfolioRisk {
+	var held []models.Stock
+	var values []float64
+	var total float64
+	for _, stock := range stocks {
+		if stock.SharesOwned <= 0 {
+			continue
+		}
+		fxRate := fxRates[stock.Currency]
+		if fxRate == 0 {
+			fxRate = 1.0
+		}
+		value := stock.SharesOwned * stock.CurrentPrice / fxRate
+		if value <= 0 {
+			continue
+		}
+		held = append(held, stock)
+		values = append(values, value)
+		total += value
+	}
+
+	risk := PortfolioRisk{Contributions: []RiskContribution{}}
+	if total <= 0 {
+		return risk
+	}
+
+	n := len(held)
+	weights := make([]float64, n)
+	for i := range held {
+		weights[i] = values[i] / total
+		risk.UndiversifiedVolatility += weights[i] * held[i].Volatility
+	}
+
+	corr := make([][]float64, n)
+	pairs, covered := 0, 0
+	for i := 0; i < n; i++ {
+		corr[i] = make([]float64, n)
+		for j := 0; j < n; j++ {
+			rho, ok := correlations.Correlation(held[i].ID, held[j].ID)
+			if i < j {
+				pairs++
+				if ok {
+					covered++
+				}
+			}
+			if !ok {
+				rho = 1
+			}
+			corr[i][j] = rho
+		}
+	}
+	_, shrink := choleskyShrunk(corr)
+	risk.CorrelationShrink = shrink
+	if shrink < 1 {
+		risk.Warnings = append(risk.Warnings, fmt.Sprintf("correlations shrunk to %.0f%% to form a valid correlation matrix", shrink*100))
+	}
+
+	// (Σw)_i, with volatilities as fractions
+	sigmaW := make([]float64, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			rho := corr[i][j]
+			if i != j {
+				rho *= shrink
+			}
+			sigmaW[i] += rho * held[i].Volatility / 100 * held[j].Volatility / 100 * weights[j]
+		}
+	}
+
+	var variance float64
+	for i := range held {
+		variance += weights[i] * sigmaW[i]
+	}
+	sigma := math.Sqrt(math.Max(variance, 0))
+	risk.Volatility = sigma * 100
+	if risk.Volatility > 0 {
+		risk.DiversificationRatio = risk.UndiversifiedVolatility / risk.Volatility
+	}
+	if pairs > 0 {
+		risk.CorrelationCoverage = float64(covered) / float64(pairs) * 100
+	} else {
+		risk.CorrelationCoverage = 100
+	}
+
+	for i, stock := range held {
+		contribution := RiskContribution{
+			StockID:    stock.ID,
+			Ticker:     stock.Ticker,
+			Weight:     weights[i] * 100,
+			Volatility: stock.Volatility,
+		}
+		if sigma > 0 {
+			contribution.MarginalRisk = sigmaW[i] / sigma * 100
+			contribution.Contribution = weights[i] * sigmaW[i] / sigma * 100
+			contribution.ContributionPct = weights[i] * sigmaW[i] / variance * 100
+		}
+		risk.Contributions = append(risk.Contributions, contribution)
+	}
+	sort.Slice(risk.Contributions, func(a, b int) bool {
+		return risk.Contributions[a].Contribution > risk.Contributions[b].Contribution
+	})
+	return risk
+}
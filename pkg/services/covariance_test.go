@@ -0,0 +1,51 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func TestCalculatePortfolioRiskRepairsCorrelations(t *testing.T) {
+	stocks := []models.Stock{
+		{ID: 1, Ticker: "A", SharesOwned: 10, CurrentPrice: 100, Volatility: 30},
+		{ID: 2, Ticker: "B", SharesOwned: 10, CurrentPrice: 100, Volatility: 30},
+		{ID: 3, Ticker: "C", SharesOwned: 10, CurrentPrice: 100, Volatility: 30},
+	}
+	correlations := func(ab, ac, bc *float64) *CorrelationMatrix {
+		return &CorrelationMatrix{
+			StockIDs: []uint{1, 2, 3},
+			Values:   [][]*float64{{nil, ab, ac}, {ab, nil, bc}, {ac, bc, nil}},
+			index:    map[uint]int{1: 0, 2: 1, 3: 2},
+		}
+	}
+	rho := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name         string
+		correlations *CorrelationMatrix
+		wantShrunk   bool
+		wantSigma    float64 // 0 when only a positive sigma is expected
+	}{
+		{"no correlations", nil, false, 30},
+		{"consistent correlations", correlations(rho(0.5), rho(0.5), rho(0.5)), false, 30 * math.Sqrt(2.0/3)},
+		{"inconsistent correlations", correlations(rho(0.9), rho(0.9), rho(-0.9)), true, 0},
+		// B-C falls back to 1, yet A moves with B and against C
+		{"fallback mixed with correlations", correlations(rho(0.9), rho(-0.9), nil), true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			risk := CalculatePortfolioRisk(stocks, nil, tt.correlations)
+			if risk.Volatility <= 0 {
+				t.Fatalf("Volatility = %v, want a positive sigma", risk.Volatility)
+			}
+			if tt.wantSigma > 0 && math.Abs(risk.Volatility-tt.wantSigma) > 1e-6 {
+				t.Errorf("Volatility = %v, want %v", risk.Volatility, tt.wantSigma)
+			}
+			if shrunk := risk.CorrelationShrink < 1; shrunk != tt.wantShrunk || shrunk != (len(risk.Warnings) > 0) {
+				t.Errorf("shrink %v, warnings %v; want shrunk %v", risk.CorrelationShrink, risk.Warnings, tt.wantShrunk)
+			}
+		})
+	}
+}
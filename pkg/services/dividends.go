@@ -0,0 +1,73 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// DividendTotals holds gross, withholding and net dividend amounts
+type DividendTotals struct {
+	Gross          float64 `json:"gross"`
+	WithholdingTax float64 `json:"withholding_tax"`
+	Net            float64 `json:"net"`
+}
+
+// DividendYear aggregates dividend income for one calendar year (by pay date)
+type DividendYear struct {
+	Year       int                       `json:"year"`
+	Payments   int                       `json:"payments"`
+	ByCurrency map[string]DividendTotals `json:"by_currency"`
+	EUR        DividendTotals            `json:"eur"` // Converted at each payment's FX rate
+}
+
+// SharesHeldOn returns the ledger quantity held at the start of the given date,
+// i.e. only trades strictly before the date count (ex-date entitlement). An invalid
+// ledger up to that date returns ErrInvalidLedger.
+func SharesHeldOn(transactions []models.Transaction, date time.Time) (float64, error) {
+	var eligible []models.Transaction
+	for _, tx := range transactions {
+		if tx.TradeDate.Before(date) {
+			eligible = append(eligible, tx)
+		}
+	}
+
+	pos, err := CalculatePosition(eligible)
+	if err != nil {
+		return 0, err
+	}
+	return pos.Quantity, nil
+}
+
+// SummarizeDividendsByYear groups dividends by the calendar year they were paid
+func SummarizeDividendsByYear(dividends []models.Dividend) []DividendYear {
+	byYear := make(map[int]*DividendYear)
+	for _, d := range dividends {
+		year := d.PayDate.Year()
+		summary, ok := byYear[year]
+		if !ok {
+			summary = &DividendYear{Year: year, ByCurrency: make(map[string]DividendTotals)}
+			byYear[year] = summary
+		}
+
+		summary.Payments++
+
+		totals := summary.ByCurrency[d.Currency]
+		totals.Gross += d.GrossAmount
+		totals.WithholdingTax += d.WithholdingTax
+		totals.Net += d.NetAmount
+		summary.ByCurrency[d.Currency] = totals
+
+		summary.EUR.Gross += toEUR(d.GrossAmount, d.FXRate)
+		summary.EUR.WithholdingTax += toEUR(d.WithholdingTax, d.FXRate)
+		summary.EUR.Net += toEUR(d.NetAmount, d.FXRate)
+	}
+
+	years := make([]DividendYear, 0, len(byYear))
+	for _, summary := range byYear {
+		years = append(years, *summary)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].Year < years[j].Year })
+	return years
+}
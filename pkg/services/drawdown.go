@@ -0,0 +1,410 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Sources of the portfolio's drawdown series
+const (
+	DrawdownSourceSnapshots = "snapshots" // Daily portfolio snapshots, including cash
+	DrawdownSourcePrices    = "prices"    // Reconstructed from the ledger and stored price bars, positions only
+)
+
+// AlertTypeDrawdown marks alerts raised when a drawdown crosses the configured level
+const AlertTypeDrawdown = "drawdown"
+
+// ErrInvalidDrawdownSource is returned for series sources other than snapshots or prices
+var ErrInvalidDrawdownSource = errors.New("source must be snapshots or prices")
+
+// ValuePoint is one day of a valuation series
+type ValuePoint struct {
+	Date     time.Time `json:"date"`
+	Value    float64   `json:"value"`
+	Drawdown float64   `json:"drawdown"` // Percentage below the running peak
+}
+
+// DrawdownStats describes the drawdowns of a valuation series. Percentages are
+// positive; days are calendar days.
+type DrawdownStats struct {
+	StockID           uint       `json:"stock_id,omitempty"`
+	Ticker            string     `json:"ticker,omitempty"`
+	Currency          string     `json:"currency,omitempty"` // Of a position's prices
+	From              time.Time  `json:"from"`
+	To                time.Time  `json:"to"`
+	Observations      int        `json:"observations"`
+	Peak              float64    `json:"peak"` // Running peak of the series
+	PeakDate          time.Time  `json:"peak_date"`
+	Current           float64    `json:"current"`
+	CurrentDrawdown   float64    `json:"current_drawdown"`
+	DaysUnderwater    int        `json:"days_underwater"` // Since the running peak
+	MaxDrawdown       float64    `json:"max_drawdown"`
+	MaxDrawdownPeak   *time.Time `json:"max_drawdown_peak"`
+	MaxDrawdownTrough *time.Time `json:"max_drawdown_trough"`
+	RecoveredAt       *time.Time `json:"recovered_at"`  // When the series regained the peak before the max drawdown
+	RecoveryDays      *int       `json:"recovery_days"` // From the trough to RecoveredAt; nil while not recovered
+}
+
+// DrawdownReport holds the drawdowns of the portfolio and of each held position
+type DrawdownReport struct {
+	Source     string          `json:"source"`
+	AlertLevel float64         `json:"alert_level"`
+	Portfolio  DrawdownStats   `json:"portfolio"`
+	Series     []ValuePoint    `json:"series"` // Portfolio index, 100 at the start
+	Positions  []DrawdownStats `json:"positions"`
+}
+
+// MeasureDrawdown computes running peak, current and maximum drawdown and the recovery
+// of the maximum drawdown of a series ordered oldest first. It fills in the points'
+// drawdowns.
+func MeasureDrawdown(points []ValuePoint) DrawdownStats {
+	var stats DrawdownStats
+	if len(points) == 0 {
+		return stats
+	}
+	stats.From = points[0].Date
+	stats.To = points[len(points)-1].Date
+	stats.Observations = len(points)
+
+	var maxPeakIdx, maxTroughIdx, recoveredIdx = -1, -1, -1
+	peakIdx := 0
+	for i := range points {
+		if points[i].Value >= points[peakIdx].Value {
+			peakIdx = i
+			if maxPeakIdx >= 0 && recoveredIdx < 0 && points[i].Value >= points[maxPeakIdx].Value {
+				recoveredIdx = i
+			}
+		}
+		peak := points[peakIdx].Value
+		if peak > 0 {
+			points[i].Drawdown = (peak - points[i].Value) / peak * 100
+		}
+		if points[i].Drawdown > stats.MaxDrawdown {
+			stats.MaxDrawdown = points[i].Drawdown
+			maxPeakIdx, maxTroughIdx, recoveredIdx = peakIdx, i, -1
+		}
+	}
+
+	last := points[len(points)-1]
+	stats.Peak = points[peakIdx].Value
+	stats.PeakDate = points[peakIdx].Date
+	stats.Current = last.Value
+	stats.CurrentDrawdown = last.Drawdown
+	stats.DaysUnderwater = int(last.Date.Sub(stats.PeakDate).Hours() / 24)
+	if maxTroughIdx >= 0 {
+		peakDate, troughDate := points[maxPeakIdx].Date, points[maxTroughIdx].Date
+		stats.MaxDrawdownPeak, stats.MaxDrawdownTrough = &peakDate, &troughDate
+		if recoveredIdx >= 0 {
+			recovered := points[recoveredIdx].Date
+			days := int(recovered.Sub(troughDate).Hours() / 24)
+			stats.RecoveredAt, stats.RecoveryDays = &recovered, &days
+		}
+	}
+	return stats
+}
+
+// DrawdownService measures drawdowns and raises drawdown alerts
+type DrawdownService struct {
+	db                  *gorm.DB
+	logger              zerolog.Logger
+	exchangeRateService *ExchangeRateService
+}
+
+// NewDrawdownService creates a new drawdown service
+func NewDrawdownService(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *DrawdownService {
+	return &DrawdownService{
+		db:                  db,
+		logger:              logger,
+		exchangeRateService: NewExchangeRateService(db, cfg, logger),
+	}
+}
+
+// Report measures the drawdowns of the portfolio and of every held position. Positions
+// use their daily closes in their own currency since they were first bought. The
+// portfolio series chains daily returns of the previous day's holdings, so purchases,
+// sales and deposits do not count as gains or losses; cash is held flat. An empty
+// source uses the snapshots once there are two, else the price history.
+func (s *DrawdownService) Report(source string) (DrawdownReport, error) {
+	report := DrawdownReport{Series: []ValuePoint{}, Positions: []DrawdownStats{}}
+
+	var settings models.PortfolioSettings
+	if err := s.db.First(&settings).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return report, err
+	}
+	report.AlertLevel = settings.DrawdownAlertLevel
+
+	var snapshots []models.PortfolioSnapshot
+	if source == "" || source == DrawdownSourceSnapshots {
+		if err := s.db.Order("snapshot_date ASC").Find(&snapshots).Error; err != nil {
+			return report, err
+		}
+	}
+	switch source {
+	case "":
+		source = DrawdownSourcePrices
+		if len(snapshots) >= 2 {
+			source = DrawdownSourceSnapshots
+		}
+	case DrawdownSourceSnapshots, DrawdownSourcePrices:
+	default:
+		return report, ErrInvalidDrawdownSource
+	}
+	report.Source = source
+
+	var stocks []models.Stock
+	if err := s.db.Order("ticker ASC").Find(&stocks).Error; err != nil {
+		return report, err
+	}
+	var transactions []models.Transaction
+	if err := s.db.Order("trade_date ASC, id ASC").Find(&transactions).Error; err != nil {
+		return report, err
+	}
+	byStock := make(map[uint][]models.Transaction)
+	for _, tx := range transactions {
+		byStock[tx.StockID] = append(byStock[tx.StockID], tx)
+	}
+	var bars []models.PriceBar
+	if err := s.db.Order("date ASC").Find(&bars).Error; err != nil {
+		return report, err
+	}
+	barsByStock := make(map[uint][]models.PriceBar)
+	for _, bar := range bars {
+		barsByStock[bar.StockID] = append(barsByStock[bar.StockID], bar)
+	}
+
+	today := SnapshotDay(time.Now())
+	for _, stock := range stocks {
+		if stock.SharesOwned <= 0 {
+			continue
+		}
+		series := positionSeries(stock, byStock[stock.ID], barsByStock[stock.ID], today)
+		stats := MeasureDrawdown(series)
+		stats.StockID, stats.Ticker, stats.Currency = stock.ID, stock.Ticker, stock.Currency
+		report.Positions = append(report.Positions, stats)
+	}
+
+	if source == DrawdownSourceSnapshots {
+		report.Series = snapshotIndex(snapshots)
+	} else {
+		var rateHistory []models.ExchangeRateHistory
+		if err := s.db.Order("recorded_at ASC").Find(&rateHistory).Error; err != nil {
+			return report, err
+		}
+		fxRates, err := s.exchangeRateService.GetRatesMap()
+		if err != nil {
+			return report, err
+		}
+		calc := NewPerformanceCalculator(PerformanceInput{
+			Stocks:       stocks,
+			Transactions: byStock,
+			CurrentRates: fxRates,
+			RateHistory:  rateHistory,
+		})
+		report.Series = priceIndex(stocks, byStock, barsByStock, calc.RateAt, today)
+	}
+	report.Portfolio = MeasureDrawdown(report.Series)
+	return report, nil
+}
+
+// CheckAlerts raises a drawdown alert for the portfolio and for each position whose
+// current drawdown has reached the configured level, once per drawdown: a new alert
+// needs a new peak first. It returns the number of alerts created.
+func (s *DrawdownService) CheckAlerts() (int, error) {
+	var settings models.PortfolioSettings
+	if err := s.db.First(&settings).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !settings.AlertsEnabled || settings.DrawdownAlertLevel <= 0 {
+		return 0, nil
+	}
+
+	report, err := s.Report("")
+	if err != nil {
+		return 0, err
+	}
+
+	candidates := append([]DrawdownStats{report.Portfolio}, report.Positions...)
+	created := 0
+	for _, stats := range candidates {
+		if stats.Observations < 2 || stats.CurrentDrawdown < settings.DrawdownAlertLevel {
+			continue
+		}
+		var count int64
+		if err := s.db.Model(&models.Alert{}).
+			Where("alert_type = ? AND stock_id = ? AND created_at >= ?", AlertTypeDrawdown, stats.StockID, stats.PeakDate).
+			Count(&count).Error; err != nil {
+			return created, err
+		}
+		if count > 0 {
+			continue
+		}
+
+		ticker, subject := stats.Ticker, stats.Ticker
+		if stats.StockID == 0 {
+			ticker, subject = "Portfolio", "The portfolio"
+		}
+		alert := models.Alert{
+			StockID:   stats.StockID,
+			Ticker:    ticker,
+			AlertType: AlertTypeDrawdown,
+			Message: fmt.Sprintf("%s is %.1f%% below its peak of %s (alert level %.0f%%)",
+				subject, stats.CurrentDrawdown, stats.PeakDate.Format("2006-01-02"), settings.DrawdownAlertLevel),
+			EmailSent: false,
+			CreatedAt: time.Now(),
+		}
+		if err := s.db.Create(&alert).Error; err != nil {
+			return created, err
+		}
+		s.logger.Info().Str("ticker", ticker).Float64("drawdown", stats.CurrentDrawdown).Msg("Drawdown alert raised")
+		created++
+	}
+	return created, nil
+}
+
+// positionSeries returns the stock's daily closes since its first trade, ending with
+// today's price
+func positionSeries(stock models.Stock, transactions []models.Transaction, bars []models.PriceBar, today time.Time) []ValuePoint {
+	var since time.Time
+	if len(transactions) > 0 {
+		since = SnapshotDay(transactions[0].TradeDate)
+	}
+	var series []ValuePoint
+	for _, bar := range bars {
+		if bar.Close > 0 && !bar.Date.Before(since) && bar.Date.Before(today) {
+			series = append(series, ValuePoint{Date: SnapshotDay(bar.Date), Value: bar.Close})
+		}
+	}
+	if stock.CurrentPrice > 0 {
+		series = append(series, ValuePoint{Date: today, Value: stock.CurrentPrice})
+	}
+	return series
+}
+
+// snapshotIndex chains the snapshots into an index of 100 at the first snapshot. Each
+// day values the previous day's shares and cash at the day's unit values (a sold-out
+// position keeps its last unit value).
+func snapshotIndex(snapshots []models.PortfolioSnapshot) []ValuePoint {
+	series := make([]ValuePoint, 0, len(snapshots))
+	index := 100.0
+	var prevShares, prevUnits map[uint]float64
+	var prevCash float64
+	for k, snapshot := range snapshots {
+		shares := make(map[uint]float64, len(snapshot.Positions))
+		units := make(map[uint]float64, len(snapshot.Positions))
+		for _, position := range snapshot.Positions {
+			if position.Shares > 0 {
+				shares[position.StockID] = position.Shares
+				units[position.StockID] = position.Value / position.Shares
+			}
+		}
+		if k > 0 {
+			index *= chainedReturn(prevShares, prevUnits, units, prevCash)
+		}
+		series = append(series, ValuePoint{Date: SnapshotDay(snapshot.SnapshotDate), Value: index})
+		prevShares, prevUnits, prevCash = shares, units, snapshot.CashValue
+	}
+	return series
+}
+
+// priceIndex reconstructs the positions' index (100 at the first trade) from the ledger,
+// the price bars and the FX rates, for every day with a bar and today
+func priceIndex(stocks []models.Stock, transactions map[uint][]models.Transaction, bars map[uint][]models.PriceBar, rate RateFunc, today time.Time) []ValuePoint {
+	var inception time.Time
+	for _, list := range transactions {
+		if len(list) > 0 && (inception.IsZero() || list[0].TradeDate.Before(inception)) {
+			inception = SnapshotDay(list[0].TradeDate)
+		}
+	}
+	if inception.IsZero() {
+		return []ValuePoint{}
+	}
+
+	seen := map[time.Time]bool{today: true}
+	days := []time.Time{today}
+	for _, list := range bars {
+		for _, bar := range list {
+			day := SnapshotDay(bar.Date)
+			if !day.Before(inception) && day.Before(today) && !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	series := make([]ValuePoint, 0, len(days))
+	index := 100.0
+	var prevShares, prevUnits map[uint]float64
+	for k, day := range days {
+		shares := make(map[uint]float64)
+		units := make(map[uint]float64)
+		for _, stock := range stocks {
+			price := stock.CurrentPrice
+			if day.Before(today) {
+				price = closeOnOrBefore(bars[stock.ID], day)
+			}
+			if price > 0 {
+				units[stock.ID] = price / rate(stock.Currency, day)
+			} else if prevUnits[stock.ID] > 0 {
+				units[stock.ID] = prevUnits[stock.ID]
+			}
+
+			var held []models.Transaction
+			for _, tx := range transactions[stock.ID] {
+				if !SnapshotDay(tx.TradeDate).After(day) {
+					held = append(held, tx)
+				}
+			}
+			if pos, err := CalculatePosition(held); err == nil && pos.Quantity > 0 {
+				shares[stock.ID] = pos.Quantity
+			}
+		}
+		if k > 0 {
+			index *= chainedReturn(prevShares, prevUnits, units, 0)
+		}
+		series = append(series, ValuePoint{Date: day, Value: index})
+		prevShares, prevUnits = shares, units
+	}
+	return series
+}
+
+// chainedReturn is the growth factor of holding the shares and cash from one day's unit
+// values to the next's
+func chainedReturn(shares, before, after map[uint]float64, cash float64) float64 {
+	start, end := cash, cash
+	for id, quantity := range shares {
+		unit := before[id]
+		if unit <= 0 {
+			continue
+		}
+		next, ok := after[id]
+		if !ok || next <= 0 {
+			next = unit
+		}
+		start += quantity * unit
+		end += quantity * next
+	}
+	if start <= 0 {
+		return 1
+	}
+	return end / start
+}
+
+// closeOnOrBefore returns the last close on or before the day, or 0 before the first bar
+func closeOnOrBefore(bars []models.PriceBar, day time.Time) float64 {
+	idx := sort.Search(len(bars), func(i int) bool { return SnapshotDay(bars[i].Date).After(day) })
+	if idx == 0 {
+		return 0
+	}
+	return bars[idx-1].Close
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeasureDrawdown(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	series := func(values ...float64) []ValuePoint {
+		points := make([]ValuePoint, len(values))
+		for i, value := range values {
+			points[i] = ValuePoint{Date: start.AddDate(0, 0, i), Value: value}
+		}
+		return points
+	}
+	// day returns the day index of a date, -1 for nil
+	day := func(date *time.Time) int {
+		if date == nil {
+			return -1
+		}
+		return int(date.Sub(start).Hours() / 24)
+	}
+
+	tests := []struct {
+		name                        string
+		points                      []ValuePoint
+		wantMax, wantCurrent        float64
+		wantPeak, wantTrough        int // Days of the max drawdown; -1 for none
+		wantRecovered, wantRecovery int // Day recovered and days from the trough; -1 while not recovered
+		wantUnderwater              int
+	}{
+		{"recovered drawdown", series(100, 120, 90, 110, 125, 115), 25, 8, 1, 2, 4, 2, 1},
+		{"open drawdown", series(100, 80, 60, 70), 40, 30, 0, 2, -1, -1, 3},
+		{"deeper drawdown after a recovery", series(100, 90, 100, 110, 55, 60), 50, 100 * 50.0 / 110, 3, 4, -1, -1, 2},
+		{"rising series", series(100, 110, 120), 0, 0, -1, -1, -1, -1, 0},
+		{"single point", series(100), 0, 0, -1, -1, -1, -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := MeasureDrawdown(tt.points)
+			if math.Abs(stats.MaxDrawdown-tt.wantMax) > 1e-9 || math.Abs(stats.CurrentDrawdown-tt.wantCurrent) > 1e-9 {
+				t.Errorf("max %v, current %v; want %v, %v", stats.MaxDrawdown, stats.CurrentDrawdown, tt.wantMax, tt.wantCurrent)
+			}
+			if day(stats.MaxDrawdownPeak) != tt.wantPeak || day(stats.MaxDrawdownTrough) != tt.wantTrough {
+				t.Errorf("peak day %d, trough day %d; want %d, %d", day(stats.MaxDrawdownPeak), day(stats.MaxDrawdownTrough), tt.wantPeak, tt.wantTrough)
+			}
+			recovery := -1
+			if stats.RecoveryDays != nil {
+				recovery = *stats.RecoveryDays
+			}
+			if day(stats.RecoveredAt) != tt.wantRecovered || recovery != tt.wantRecovery {
+				t.Errorf("recovered on day %d after %d days; want %d, %d", day(stats.RecoveredAt), recovery, tt.wantRecovered, tt.wantRecovery)
+			}
+			if stats.DaysUnderwater != tt.wantUnderwater {
+				t.Errorf("%d days underwater, want %d", stats.DaysUnderwater, tt.wantUnderwater)
+			}
+			if last := tt.points[len(tt.points)-1]; last.Drawdown != stats.CurrentDrawdown {
+				t.Errorf("last point drawdown %v, want %v", last.Drawdown, stats.CurrentDrawdown)
+			}
+		})
+	}
+
+	if stats := MeasureDrawdown(nil); stats.Observations != 0 || stats.MaxDrawdownPeak != nil {
+		t.Errorf("empty series: %+v", stats)
+	}
+}
@@ -1,31 +1,30 @@
 package services
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"os"
 	"time"
 
+	"github.com/artpro/assessapp/pkg/config"
 	"github.com/artpro/assessapp/pkg/models"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
 )
 
-// ExchangeRateService handles exchange rate operations
+// ExchangeRateService handles exchange rate operations. It is the single source of
+// FX rates; stored rates are refreshed through the FXChain.
 type ExchangeRateService struct {
-	db     *gorm.DB
-	logger zerolog.Logger
-	apiKey string
+	db        *gorm.DB
+	logger    zerolog.Logger
+	providers FXChain
 }
 
 // NewExchangeRateService creates a new exchange rate service
-func NewExchangeRateService(db *gorm.DB, logger zerolog.Logger) *ExchangeRateService {
+func NewExchangeRateService(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *ExchangeRateService {
 	return &ExchangeRateService{
-		db:     db,
-		logger: logger,
-		apiKey: os.Getenv("EXCHANGE_RATE_API_KEY"),
+		db:        db,
+		logger:    logger,
+		providers: NewFXChain(db, cfg.ExchangeRatesAPIKey, NewHTTPClient(cfg, 30*time.Second)),
 	}
 }
 
@@ -41,63 +40,111 @@ type ExchangeRateAPIResponse struct {
 	ErrorType       string             `json:"error-type,omitempty"`
 }
 
-// FetchLatestRates fetches the latest exchange rates from the API
+// FetchLatestRates refreshes all tracked, non-manual rates through the provider chain.
+// A rate is only replaced by a fallback provider if that provider's quote is newer.
 func (s *ExchangeRateService) FetchLatestRates() error {
-	// If no API key, skip fetching
-	if s.apiKey == "" {
-		s.logger.Warn().Msg("No exchange rate API key configured, using default rates")
+	var rates []models.ExchangeRate
+	if err := s.db.Where("is_active = ? AND is_manual = ? AND currency_code <> ?", true, false, "EUR").Find(&rates).Error; err != nil {
+		return err
+	}
+	if len(rates) == 0 {
 		return nil
 	}
 
-	// Construct API URL
-	url := fmt.Sprintf("https://v6.exchangerate-api.com/v6/%s/latest/EUR", s.apiKey)
+	currencies := make([]string, len(rates))
+	for i, rate := range rates {
+		currencies[i] = rate.CurrencyCode
+	}
 
-	// Make HTTP request
-	resp, err := http.Get(url)
+	quotes, err := s.providers.Quotes(currencies)
 	if err != nil {
-		return fmt.Errorf("failed to fetch exchange rates: %w", err)
+		s.logger.Warn().Err(err).Msg("Some FX providers failed, using fallbacks")
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// Parse JSON response
-	var apiResp ExchangeRateAPIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	// Check for API errors
-	if apiResp.Result != "success" {
-		return fmt.Errorf("API error: %s", apiResp.ErrorType)
-	}
-
-	// Update rates in database
-	for code, rate := range apiResp.ConversionRates {
-		// Check if we track this currency
-		var exchangeRate models.ExchangeRate
-		result := s.db.Where("currency_code = ?", code).First(&exchangeRate)
-		
-		if result.Error == nil {
-			// Update existing rate if not manually set
-			if !exchangeRate.IsManual {
-				exchangeRate.Rate = rate
-				exchangeRate.LastUpdated = time.Now()
-				if err := s.db.Save(&exchangeRate).Error; err != nil {
-					s.logger.Error().Err(err).Str("currency", code).Msg("Failed to update exchange rate")
-				}
-			}
+	updated := 0
+	for i := range rates {
+		quote, ok := quotes[rates[i].CurrencyCode]
+		if !ok {
+			s.logger.Warn().Str("currency", rates[i].CurrencyCode).Msg("No FX provider has a rate, keeping the stored one")
+			continue
+		}
+		if !s.providers.Supersedes(quote, rates[i]) {
+			continue
+		}
+		if err := s.applyQuote(s.db, &rates[i], quote); err != nil {
+			s.logger.Error().Err(err).Str("currency", rates[i].CurrencyCode).Msg("Failed to update exchange rate")
+			continue
 		}
+		updated++
 	}
 
-	s.logger.Info().Msg("Exchange rates updated successfully")
+	s.logger.Info().Int("updated", updated).Msg("Exchange rates updated successfully")
 	return nil
 }
 
+// applyQuote stores a provider's quote on the rate and appends it to the history if it changed
+func (s *ExchangeRateService) applyQuote(db *gorm.DB, rate *models.ExchangeRate, quote FXQuote) error {
+	changed := rate.Rate != quote.Rate || rate.Source != quote.Provider || !rate.RateDate.Equal(quote.AsOf)
+
+	rate.Rate = quote.Rate
+	rate.Source = quote.Provider
+	rate.RateDate = quote.AsOf
+	rate.LastUpdated = time.Now()
+	if err := db.Save(rate).Error; err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	recordedAt := quote.AsOf
+	if recordedAt.IsZero() {
+		recordedAt = rate.LastUpdated
+	}
+
+	// ECB and manual quotes are served from the history already
+	var count int64
+	if err := db.Model(&models.ExchangeRateHistory{}).
+		Where("currency_code = ? AND source = ? AND recorded_at = ?", rate.CurrencyCode, quote.Provider, recordedAt).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.recordHistory(db, rate.CurrencyCode, quote.Rate, recordedAt, quote.Provider)
+}
+
+// ImportECBRates stores the rates of an ECB reference rate file in the history
+// (skipping days already imported) and returns how many were added
+func (s *ExchangeRateService) ImportECBRates(r io.Reader) (int, error) {
+	quotes, err := ParseECBRates(r)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, quote := range quotes {
+			var count int64
+			if err := tx.Model(&models.ExchangeRateHistory{}).
+				Where("currency_code = ? AND source = ? AND recorded_at = ?", quote.Currency, RateSourceECB, quote.AsOf).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if err := s.recordHistory(tx, quote.Currency, quote.Rate, quote.AsOf, RateSourceECB); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	return added, err
+}
+
 // GetAllRates returns all exchange rates
 func (s *ExchangeRateService) GetAllRates() ([]models.ExchangeRate, error) {
 	var rates []models.ExchangeRate
@@ -140,12 +187,19 @@ func (s *ExchangeRateService) AddCurrency(currencyCode string, rate float64, isM
 	exchangeRate := models.ExchangeRate{
 		CurrencyCode: currencyCode,
 		Rate:         rate,
+		Source:       RateSourceManual,
+		RateDate:     time.Now(),
 		LastUpdated:  time.Now(),
 		IsActive:     true,
 		IsManual:     isManual,
 	}
-	
-	return s.db.Create(&exchangeRate).Error
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&exchangeRate).Error; err != nil {
+			return err
+		}
+		return s.recordHistory(tx, currencyCode, rate, exchangeRate.LastUpdated, RateSourceManual)
+	})
 }
 
 // UpdateRate updates an exchange rate
@@ -157,9 +211,16 @@ func (s *ExchangeRateService) UpdateRate(currencyCode string, rate float64, isMa
 	
 	exchangeRate.Rate = rate
 	exchangeRate.IsManual = isManual
-	exchangeRate.LastUpdated = time.Now()
-	
-	return s.db.Save(&exchangeRate).Error
+	exchangeRate.Source = RateSourceManual
+	exchangeRate.RateDate = time.Now()
+	exchangeRate.LastUpdated = exchangeRate.RateDate
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&exchangeRate).Error; err != nil {
+			return err
+		}
+		return s.recordHistory(tx, currencyCode, rate, exchangeRate.LastUpdated, RateSourceManual)
+	})
 }
 
 // DeleteCurrency soft deletes a currency (sets is_active to false)
@@ -182,6 +243,75 @@ func (s *ExchangeRateService) DeleteCurrency(currencyCode string) error {
 		Update("is_active", false).Error
 }
 
+// ReportingCurrency returns the currency values are reported in (PortfolioSettings.ReportingCurrency, default EUR)
+func (s *ExchangeRateService) ReportingCurrency() string {
+	var settings models.PortfolioSettings
+	if err := s.db.First(&settings).Error; err != nil || settings.ReportingCurrency == "" {
+		return "EUR"
+	}
+	return settings.ReportingCurrency
+}
+
+// UpdatePositionValues fills the stock's value_base and pnl_base in the reporting currency
+func (s *ExchangeRateService) UpdatePositionValues(stock *models.Stock) error {
+	rates, err := s.GetRatesMap()
+	if err != nil {
+		return err
+	}
+	PositionValues(stock, rates, s.ReportingCurrency())
+	return nil
+}
+
+// ConvertToReporting converts an amount to the reporting currency and returns that currency
+func (s *ExchangeRateService) ConvertToReporting(amount float64, fromCurrency string) (float64, string, error) {
+	currency := s.ReportingCurrency()
+	rates, err := s.GetRatesMap()
+	if err != nil {
+		return 0, currency, err
+	}
+	return amount * CrossRate(fromCurrency, currency, rates), currency, nil
+}
+
+// RefreshBaseValues recomputes the reporting-currency values of all positions and cash holdings
+func (s *ExchangeRateService) RefreshBaseValues() error {
+	rates, err := s.GetRatesMap()
+	if err != nil {
+		return err
+	}
+	currency := s.ReportingCurrency()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var stocks []models.Stock
+		if err := tx.Find(&stocks).Error; err != nil {
+			return err
+		}
+		for i := range stocks {
+			PositionValues(&stocks[i], rates, currency)
+			if err := tx.Model(&stocks[i]).UpdateColumns(map[string]interface{}{
+				"value_base":    stocks[i].ValueBase,
+				"pnl_base":      stocks[i].PnLBase,
+				"base_currency": stocks[i].BaseCurrency,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		var holdings []models.CashHolding
+		if err := tx.Find(&holdings).Error; err != nil {
+			return err
+		}
+		for _, holding := range holdings {
+			if err := tx.Model(&holding).UpdateColumns(map[string]interface{}{
+				"value_base":    holding.Amount * CrossRate(holding.CurrencyCode, currency, rates),
+				"base_currency": currency,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // ConvertToEUR converts an amount from a given currency to EUR
 func (s *ExchangeRateService) ConvertToEUR(amount float64, fromCurrency string) (float64, error) {
 	if fromCurrency == "EUR" {
@@ -212,4 +342,87 @@ func (s *ExchangeRateService) ConvertFromEUR(amount float64, toCurrency string)
 	}
 	
 	return amount * rate, nil
-}
\ No newline at end of file
+}
+
+// recordHistory appends a rate to the currency's history
+func (s *ExchangeRateService) recordHistory(db *gorm.DB, currencyCode string, rate float64, recordedAt time.Time, source string) error {
+	return db.Create(&models.ExchangeRateHistory{
+		CurrencyCode: currencyCode,
+		Rate:         rate,
+		RecordedAt:   recordedAt,
+		Source:       source,
+	}).Error
+}
+
+// GetRateAt returns the rate of a currency on a date: the latest rate recorded on or
+// before that day. Dates before the first recorded rate fall back to the current rate.
+func (s *ExchangeRateService) GetRateAt(currencyCode string, date time.Time) (float64, error) {
+	if currencyCode == "EUR" {
+		return 1.0, nil
+	}
+
+	var entry models.ExchangeRateHistory
+	err := s.db.Where("currency_code = ? AND recorded_at < ?", currencyCode, SnapshotDay(date).AddDate(0, 0, 1)).
+		Order("recorded_at DESC").
+		First(&entry).Error
+	if err == nil && entry.Rate > 0 {
+		return entry.Rate, nil
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+	return s.GetRate(currencyCode)
+}
+
+// GetRateHistory returns the recorded rates of the given currencies (all when empty),
+// oldest first. Zero times leave the range open.
+func (s *ExchangeRateService) GetRateHistory(currencyCodes []string, from, to time.Time) ([]models.ExchangeRateHistory, error) {
+	query := s.db.Order("currency_code ASC, recorded_at ASC")
+	if len(currencyCodes) > 0 {
+		query = query.Where("currency_code IN ?", currencyCodes)
+	}
+	if !from.IsZero() {
+		query = query.Where("recorded_at >= ?", SnapshotDay(from))
+	}
+	if !to.IsZero() {
+		query = query.Where("recorded_at < ?", SnapshotDay(to).AddDate(0, 0, 1))
+	}
+
+	var history []models.ExchangeRateHistory
+	if err := query.Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// ConvertToEURAt converts an amount to EUR at the rate in effect on a date
+func (s *ExchangeRateService) ConvertToEURAt(amount float64, fromCurrency string, date time.Time) (float64, error) {
+	if fromCurrency == "EUR" {
+		return amount, nil
+	}
+
+	rate, err := s.GetRateAt(fromCurrency, date)
+	if err != nil {
+		return 0, err
+	}
+
+	if rate == 0 {
+		return 0, fmt.Errorf("invalid exchange rate for %s", fromCurrency)
+	}
+
+	return amount / rate, nil
+}
+
+// ConvertFromEURAt converts an amount from EUR at the rate in effect on a date
+func (s *ExchangeRateService) ConvertFromEURAt(amount float64, toCurrency string, date time.Time) (float64, error) {
+	if toCurrency == "EUR" {
+		return amount, nil
+	}
+
+	rate, err := s.GetRateAt(toCurrency, date)
+	if err != nil {
+		return 0, err
+	}
+
+	return amount * rate, nil
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// ErrUnknownExportColumn is returned when a requested holdings column does not exist
+var ErrUnknownExportColumn = errors.New("unknown export column")
+
+// ExportColumn is a selectable column of the holdings export
+type ExportColumn struct {
+	Key    string                               `json:"key"`
+	Header string                               `json:"header"`
+	Value  func(stock models.Stock) interface{} `json:"-"`
+}
+
+// ExportTable is one sheet (XLSX) or file (CSV) of exported data
+type ExportTable struct {
+	Name    string
+	Headers []string
+	Rows    [][]interface{}
+}
+
+// HoldingColumns lists every exportable holdings column in default order
+var HoldingColumns = []ExportColumn{
+	{"ticker", "Ticker", func(s models.Stock) interface{} { return s.Ticker }},
+	{"company_name", "Company Name", func(s models.Stock) interface{} { return s.CompanyName }},
+	{"isin", "ISIN", func(s models.Stock) interface{} { return s.ISIN }},
+	{"sector", "Sector", func(s models.Stock) interface{} { return s.Sector }},
+	{"currency", "Currency", func(s models.Stock) interface{} { return s.Currency }},
+	{"current_price", "Current Price", func(s models.Stock) interface{} { return s.CurrentPrice }},
+	{"fair_value", "Fair Value", func(s models.Stock) interface{} { return s.FairValue }},
+	{"fair_value_source", "Fair Value Source", func(s models.Stock) interface{} { return s.FairValueSource }},
+	{"upside_potential", "Upside Potential %", func(s models.Stock) interface{} { return s.UpsidePotential }},
+	{"downside_risk", "Downside Risk %", func(s models.Stock) interface{} { return s.DownsideRisk }},
+	{"probability_positive", "Probability Positive", func(s models.Stock) interface{} { return s.ProbabilityPositive }},
+	{"expected_value", "Expected Value %", func(s models.Stock) interface{} { return s.ExpectedValue }},
+	{"beta", "Beta", func(s models.Stock) interface{} { return s.Beta }},
+	{"volatility", "Volatility %", func(s models.Stock) interface{} { return s.Volatility }},
+	{"pe_ratio", "P/E Ratio", func(s models.Stock) interface{} { return s.PERatio }},
+	{"eps_growth_rate", "EPS Growth %", func(s models.Stock) interface{} { return s.EPSGrowthRate }},
+	{"debt_to_ebitda", "Debt/EBITDA", func(s models.Stock) interface{} { return s.DebtToEBITDA }},
+	{"dividend_yield", "Dividend Yield %", func(s models.Stock) interface{} { return s.DividendYield }},
+	{"b_ratio", "b Ratio", func(s models.Stock) interface{} { return s.BRatio }},
+	{"kelly_fraction", "Kelly f* %", func(s models.Stock) interface{} { return s.KellyFraction }},
+	{"half_kelly_suggested", "Half-Kelly %", func(s models.Stock) interface{} { return s.HalfKellySuggested }},
+	{"shares_owned", "Shares Owned", func(s models.Stock) interface{} { return s.SharesOwned }},
+	{"avg_price_local", "Avg Price (Local)", func(s models.Stock) interface{} { return s.AvgPriceLocal }},
+	{"value_base", "Value (Base)", func(s models.Stock) interface{} { return s.ValueBase }},
+	{"weight", "Weight %", func(s models.Stock) interface{} { return s.Weight }},
+	{"pnl_base", "Unrealized P&L (Base)", func(s models.Stock) interface{} { return s.PnLBase }},
+	{"base_currency", "Base Currency", func(s models.Stock) interface{} { return s.BaseCurrency }},
+	{"buy_zone_min", "Buy Zone Min", func(s models.Stock) interface{} { return s.BuyZoneMin }},
+	{"buy_zone_max", "Buy Zone Max", func(s models.Stock) interface{} { return s.BuyZoneMax }},
+	{"assessment", "Assessment", func(s models.Stock) interface{} { return s.Assessment }},
+	{"update_frequency", "Update Frequency", func(s models.Stock) interface{} { return s.UpdateFrequency }},
+	{"data_source", "Data Source", func(s models.Stock) interface{} { return s.DataSource }},
+	{"comment", "Comment", func(s models.Stock) interface{} { return s.Comment }},
+	{"last_updated", "Last Updated", func(s models.Stock) interface{} { return s.LastUpdated }},
+}
+
+// SelectHoldingColumns returns the columns for a comma separated list of keys,
+// or all columns when the list is empty
+func SelectHoldingColumns(keys string) ([]ExportColumn, error) {
+	if strings.TrimSpace(keys) == "" {
+		return HoldingColumns, nil
+	}
+
+	byKey := make(map[string]ExportColumn, len(HoldingColumns))
+	for _, col := range HoldingColumns {
+		byKey[col.Key] = col
+	}
+
+	var selected []ExportColumn
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		col, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownExportColumn, key)
+		}
+		selected = append(selected, col)
+	}
+	return selected, nil
+}
+
+// HoldingsTable builds the holdings sheet from the selected columns
+func HoldingsTable(stocks []models.Stock, columns []ExportColumn) ExportTable {
+	table := ExportTable{Name: "Holdings"}
+	for _, col := range columns {
+		table.Headers = append(table.Headers, col.Header)
+	}
+	for _, stock := range stocks {
+		row := make([]interface{}, len(columns))
+		for i, col := range columns {
+			row[i] = col.Value(stock)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// CashTable builds the cash holdings sheet
+func CashTable(holdings []models.CashHolding) ExportTable {
+	table := ExportTable{
+		Name:    "Cash",
+		Headers: []string{"Currency", "Amount", "Value (Base)", "Base Currency", "Description", "Last Updated"},
+	}
+	for _, h := range holdings {
+		table.Rows = append(table.Rows, []interface{}{h.CurrencyCode, h.Amount, h.ValueBase, h.BaseCurrency, h.Description, h.LastUpdated})
+	}
+	return table
+}
+
+// ExchangeRatesTable builds the exchange rates sheet (rates are units per EUR)
+func ExchangeRatesTable(rates []models.ExchangeRate) ExportTable {
+	table := ExportTable{
+		Name:    "Exchange Rates",
+		Headers: []string{"Currency", "Rate per EUR", "Active", "Manual", "Last Updated"},
+	}
+	for _, r := range rates {
+		table.Rows = append(table.Rows, []interface{}{r.CurrencyCode, r.Rate, r.IsActive, r.IsManual, r.LastUpdated})
+	}
+	return table
+}
+
+// HistoryTable builds the stock history sheet
+func HistoryTable(history []models.StockHistory) ExportTable {
+	table := ExportTable{
+		Name: "History",
+		Headers: []string{"Recorded At", "Ticker", "Current Price", "Fair Value", "Upside Potential %",
+			"Downside Risk %", "Probability Positive", "Expected Value %", "Kelly f* %", "Weight %", "Assessment"},
+	}
+	for _, h := range history {
+		table.Rows = append(table.Rows, []interface{}{h.RecordedAt, h.Ticker, h.CurrentPrice, h.FairValue, h.UpsidePotential,
+			h.DownsideRisk, h.ProbabilityPositive, h.ExpectedValue, h.KellyFraction, h.Weight, h.Assessment})
+	}
+	return table
+}
+
+// AlertsTable builds the alerts sheet
+func AlertsTable(alerts []models.Alert) ExportTable {
+	table := ExportTable{
+		Name:    "Alerts",
+		Headers: []string{"Created At", "Ticker", "Type", "Message", "Email Sent"},
+	}
+	for _, a := range alerts {
+		table.Rows = append(table.Rows, []interface{}{a.CreatedAt, a.Ticker, a.AlertType, a.Message, a.EmailSent})
+	}
+	return table
+}
+
+// FormatExportValue renders a cell as CSV text without losing precision
+func FormatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
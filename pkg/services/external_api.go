@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,7 +18,6 @@ import (
 type ExternalAPIService struct {
 	cfg                   *config.Config
 	client                *http.Client
-	exchangeRateCache     map[string]float64 // Cache for exchange rates from Grok
 	lastAlphaVantageCall  time.Time         // Track last API call for rate limiting
 	alphaVantageCallMutex sync.Mutex        // Mutex for thread-safe rate limiting
 }
@@ -75,10 +75,7 @@ type AlphaVantageOverview struct {
 func NewExternalAPIService(cfg *config.Config) *ExternalAPIService {
 	return &ExternalAPIService{
 		cfg: cfg,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		exchangeRateCache:    make(map[string]float64),
+		client:               NewHTTPClient(cfg, 30*time.Second),
 		lastAlphaVantageCall: time.Time{},
 	}
 }
@@ -86,6 +83,11 @@ func NewExternalAPIService(cfg *config.Config) *ExternalAPIService {
 // enforceAlphaVantageRateLimit ensures we don't exceed 5 calls per minute for free tier
 // Premium tiers: 30 calls/min (75 calls/min for higher tiers)
 func (s *ExternalAPIService) enforceAlphaVantageRateLimit() {
+	// Replayed responses cost no quota
+	if s.cfg.HTTPFixturesMode == FixturesReplay {
+		return
+	}
+
 	s.alphaVantageCallMutex.Lock()
 	defer s.alphaVantageCallMutex.Unlock()
 
@@ -105,19 +107,6 @@ func (s *ExternalAPIService) enforceAlphaVantageRateLimit() {
 	s.lastAlphaVantageCall = time.Now()
 }
 
-// cacheExchangeRate stores an exchange rate from Grok
-func (s *ExternalAPIService) cacheExchangeRate(currency string, rate float64) {
-	if rate > 0 {
-		s.exchangeRateCache[currency] = rate
-	}
-}
-
-// getCachedExchangeRate retrieves a cached exchange rate
-func (s *ExternalAPIService) getCachedExchangeRate(currency string) (float64, bool) {
-	rate, ok := s.exchangeRateCache[currency]
-	return rate, ok
-}
-
 // GrokStockRequest represents the request to Grok API for complete stock analysis
 type GrokStockRequest struct {
 	Model    string    `json:"model"`
@@ -272,562 +261,162 @@ func (s *ExternalAPIService) FetchAlphaVantageOverview(ticker string) (*AlphaVan
 	return &overview, nil
 }
 
-// parseFloat safely parses a string to float64, returning 0 on error
-func parseFloat(s string) float64 {
-	if s == "" || s == "None" || s == "-" {
-		return 0
-	}
-	var f float64
-	fmt.Sscanf(s, "%f", &f)
-	return f
+// DailyClose is one trading day of a daily price series. Open, high, low and
+// volume are zero when the source only reports closes.
+type DailyClose struct {
+	Date   time.Time `json:"date"`
+	Open   float64   `json:"open,omitempty"`
+	High   float64   `json:"high,omitempty"`
+	Low    float64   `json:"low,omitempty"`
+	Close  float64   `json:"close"`
+	Volume float64   `json:"volume,omitempty"`
 }
 
-// StockAnalysis represents the parsed stock data from Grok
-type StockAnalysis struct {
-	Ticker              string  `json:"ticker"`
-	CompanyName         string  `json:"company_name"`
-	CurrentPrice        float64 `json:"current_price"`
-	Currency            string  `json:"currency"`
-	ExchangeRateToUSD   float64 `json:"exchange_rate_to_usd"`
-	FairValue           float64 `json:"fair_value"`
-	Beta                float64 `json:"beta"`
-	Volatility          float64 `json:"volatility"`
-	PERatio             float64 `json:"pe_ratio"`
-	EPSGrowthRate       float64 `json:"eps_growth_rate"`
-	DebtToEBITDA        float64 `json:"debt_to_ebitda"`
-	DividendYield       float64 `json:"dividend_yield"`
-	ProbabilityPositive float64 `json:"probability_positive"`
-	DownsideRisk        float64 `json:"downside_risk"`
-	BRatio              float64 `json:"b_ratio"`
-	UpsidePotential     float64 `json:"upside_potential"`
-	ExpectedValue       float64 `json:"expected_value"`
-	KellyFraction       float64 `json:"kelly_fraction"`
-	HalfKellySuggested  float64 `json:"half_kelly_suggested"`
-	BuyZoneMin          float64 `json:"buy_zone_min"`
-	BuyZoneMax          float64 `json:"buy_zone_max"`
-	Assessment          string  `json:"assessment"`
-}
-
-// GrokStockData extends StockAnalysis with additional metadata fields
-type GrokStockData struct {
-	CurrentPrice        float64 `json:"current_price"`
-	FairValue           float64 `json:"fair_value"`
-	Beta                float64 `json:"beta"`
-	Volatility          float64 `json:"volatility"`
-	PERatio             float64 `json:"pe_ratio"`
-	EPSGrowthRate       float64 `json:"eps_growth_rate"`
-	DebtToEBITDA        float64 `json:"debt_to_ebitda"`
-	DividendYield       float64 `json:"dividend_yield"`
-	ProbabilityPositive float64 `json:"probability_positive"`
-	DownsideRisk        float64 `json:"downside_risk"`
-	BRatio              float64 `json:"b_ratio"`
-	UpsidePotential     float64 `json:"upside_potential"`
-	ExpectedValue       float64 `json:"expected_value"`
-	KellyFraction       float64 `json:"kelly_fraction"`
-	HalfKellySuggested  float64 `json:"half_kelly_suggested"`
-	BuyZoneMin          float64 `json:"buy_zone_min"`
-	BuyZoneMax          float64 `json:"buy_zone_max"`
-	Assessment          string  `json:"assessment"`
-	Sector              string  `json:"sector"`
-	FairValueSource     string  `json:"fair_value_source"`
-	DataSource          string  `json:"data_source"`
-}
-
-// FetchAllStockData fetches all stock data using Alpha Vantage (primary) and Grok (analysis)
-func (s *ExternalAPIService) FetchAllStockData(stock *models.Stock) error {
-	var dataSource string
-	var fairValueSource string
-	
-	// Step 1: Try to fetch real-time data from Alpha Vantage (most accurate)
-	if s.cfg.AlphaVantageAPIKey != "" {
-		fmt.Printf("Fetching Alpha Vantage data for %s...\n", stock.Ticker)
-		
-		// Fetch current price
-		quote, err := s.FetchAlphaVantageQuote(stock.Ticker)
-		if err == nil && quote.GlobalQuote.Price != "" {
-			stock.CurrentPrice = parseFloat(quote.GlobalQuote.Price)
-			dataSource = "Alpha Vantage"
-			fmt.Printf("✓ Current price from Alpha Vantage: %.2f\n", stock.CurrentPrice)
-		} else {
-			fmt.Printf("⚠ Alpha Vantage quote error: %v\n", err)
-		}
-		
-		// Fetch fundamentals (beta, fair value, etc.)
-		overview, err := s.FetchAlphaVantageOverview(stock.Ticker)
-		if err == nil && overview.Symbol != "" {
-			// Update beta
-			if overview.Beta != "" && overview.Beta != "None" {
-				stock.Beta = parseFloat(overview.Beta)
-				fmt.Printf("✓ Beta from Alpha Vantage: %.2f\n", stock.Beta)
-			}
-			
-			// Update fair value from analyst target price
-			if overview.AnalystTargetPrice != "" && overview.AnalystTargetPrice != "None" {
-				stock.FairValue = parseFloat(overview.AnalystTargetPrice)
-				fairValueSource = fmt.Sprintf("Alpha Vantage Consensus, %s", time.Now().Format("Jan 2, 2006"))
-				fmt.Printf("✓ Fair value from Alpha Vantage: %.2f\n", stock.FairValue)
-			}
-			
-			// Update other fundamentals
-			stock.PERatio = parseFloat(overview.PERatio)
-			stock.DividendYield = parseFloat(overview.DividendYield)
-			
-			// Calculate EPS growth from quarterly data
-			if overview.QuarterlyEarningsGrowthYOY != "" {
-				stock.EPSGrowthRate = parseFloat(overview.QuarterlyEarningsGrowthYOY) * 100
-			}
-			
-			// Update sector if provided
-			if overview.Sector != "" {
-				stock.Sector = overview.Sector
-			}
-		} else {
-			fmt.Printf("⚠ Alpha Vantage overview error: %v\n", err)
-		}
-		
-		// If we got basic data from Alpha Vantage, use it
-		if stock.CurrentPrice > 0 {
-			stock.DataSource = dataSource
-			if fairValueSource != "" {
-				stock.FairValueSource = fairValueSource
-			}
-			
-			// Use CalculateMetrics to compute derived values
-			CalculateMetrics(stock)
-			stock.LastUpdated = time.Now()
-			return nil
-		}
-	}
-	
-	// Step 2: If Alpha Vantage not available or failed, try Grok
-	if s.cfg.XAIAPIKey == "" {
-		// No APIs configured - return error
-		return s.mockStockData(stock)
-	}
-
-	// Create comprehensive prompt based on the probabilistic investment strategy
-	prompt := fmt.Sprintf(`You are a financial analyst following a strict probabilistic investment strategy. The core philosophy is built on probabilistic thinking, expected value (EV) optimization, and ½-Kelly sizing to maximize long-term growth while minimizing ruin probability.
-
-Key principles:
-
-1. Probabilistic Thinking: Assign probabilities to scenarios (growth, stagnation, decline) rather than binary outcomes.
-
-2. Expected Value (EV): Calculate EV = (p × upside %%) + ((1 - p) × downside %%). Only hold if EV > 0%%, add if >7%%, trim if <3%%, sell if <0%%.
-
-3. Kelly Criterion: f* = [(b × p) - q] / b, where b = upside %% / |downside %%|, q = 1 - p. Use ½-Kelly for sizing, capped at 15%% for high-conviction/low-vol assets (typical 3–6%%).
-
-4. Sector targets: Healthcare 30–35%%, Technology 15%%, Energy 8–10%%, Financials 5–7%%, Industrials 3–4%%, Consumer Staples 8–10%%, REITs 5–7%%, Cash 8–12%%.
-
-Analyze the following stock (use ISIN and ticker together to ensure correct security identification):
-- Ticker: %s
-- ISIN: %s
-- Company Name: %s
-- Sector: %s
-- Currency: %s
-
-CRITICAL REQUIREMENTS:
-- "current_price" = ACTUAL REAL-TIME TRADING PRICE on the stock exchange (what you can buy TODAY)
-- "fair_value" = MEDIAN ANALYST CONSENSUS TARGET PRICE (12-month target from TipRanks/Yahoo Finance/Bloomberg)
-- These are DIFFERENT values. Current price is TODAY's market price. Fair value is FUTURE analyst target.
-- Use p=0.65 as default probability (adjust based on analyst ratings: 0.7 for Strong Buy, 0.65 for Buy, 0.5 for Hold)
-- Calibrate downside by beta: <0.5 = -15%%, 0.5-1 = -20%%, 1-1.5 = -25%%, >1.5 = -30%%
-- Buy zone: typically 85-95%% of current price or where EV >15%%
-
-Provide response in valid JSON format with these EXACT fields (no additional text):
-
-{
-  "ticker": "%s",
-  "company_name": "Full company name",
-  "sector": "%s",
-  "current_price": ACTUAL TRADING PRICE RIGHT NOW (number only),
-  "currency": "%s",
-  "exchange_rate_to_usd": 1 %s = X USD (e.g., 1 DKK = 0.1538 USD),
-  "fair_value": MEDIAN analyst consensus target price (future 12-month target),
-  "beta": beta coefficient (market sensitivity),
-  "volatility": annualized volatility percentage,
-  "pe_ratio": price to earnings ratio,
-  "eps_growth_rate": EPS growth rate percentage,
-  "debt_to_ebitda": debt to EBITDA ratio,
-  "dividend_yield": dividend yield percentage,
-  "probability_positive": probability p (0.65 default, 0.7 for Strong Buy, 0.5 for Hold),
-  "downside_risk": downside %% (negative number, calibrated by beta),
-  "upside_potential": ((fair_value - current_price) / current_price) × 100,
-  "b_ratio": upside_potential / |downside_risk|,
-  "expected_value": (p × upside_potential) + ((1-p) × downside_risk),
-  "kelly_fraction": [(b × p) - q] / b × 100,
-  "half_kelly_suggested": kelly_fraction / 2 (capped at 15%%),
-  "buy_zone_min": minimum attractive entry price,
-  "buy_zone_max": maximum attractive entry price,
-  "assessment": "Add" if EV>7, "Hold" if EV>0, "Trim" if EV>-3, else "Sell"
+// AlphaVantageDailySeries represents the Alpha Vantage TIME_SERIES_DAILY response
+type AlphaVantageDailySeries struct {
+	TimeSeries map[string]struct {
+		Open   string `json:"1. open"`
+		High   string `json:"2. high"`
+		Low    string `json:"3. low"`
+		Close  string `json:"4. close"`
+		Volume string `json:"5. volume"`
+	} `json:"Time Series (Daily)"`
+	// Error handling fields
+	Note         string `json:"Note,omitempty"`
+	ErrorMessage string `json:"Error Message,omitempty"`
+	Information  string `json:"Information,omitempty"`
 }
 
-VERIFY: Current price must be LOWER than fair value if upside is positive. Use real market data. Calculate all metrics using the formulas provided. Return ONLY the JSON object with NO additional text.`,
-		stock.Ticker, stock.ISIN, stock.CompanyName, stock.Sector, stock.Currency,
-		stock.Ticker, stock.Sector, stock.Currency, stock.Currency)
-
-	// Build Grok API request
-	reqBody := GrokStockRequest{
-		Model: "grok-4-fast-reasoning",
-		Messages: []Message{
-			{
-				Role:    "system",
-				Content: "You are a financial analyst AI. Respond only with valid JSON data, no additional text.",
-			},
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		Stream: false,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return s.mockStockData(stock)
-	}
-
-	// xAI API endpoint
-	url := "https://api.x.ai/v1/chat/completions"
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return s.mockStockData(stock)
+// FetchAlphaVantageDailySeries fetches daily bars from Alpha Vantage, oldest first.
+// Compact returns the latest 100 days, full the complete history.
+func (s *ExternalAPIService) FetchAlphaVantageDailySeries(ticker string, full bool) ([]DailyClose, error) {
+	if s.cfg.AlphaVantageAPIKey == "" {
+		return nil, fmt.Errorf("Alpha Vantage API key not configured")
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.cfg.XAIAPIKey)
-
-	// Implement exponential backoff for retries
-	var resp *http.Response
-	for i := 0; i < 3; i++ {
-		resp, err = s.client.Do(req)
-		if err == nil && resp != nil && resp.StatusCode == http.StatusOK {
-			break
-		}
+	// Enforce rate limiting
+	s.enforceAlphaVantageRateLimit()
 
-		if i < 2 {
-			time.Sleep(time.Duration(1<<uint(i)) * time.Second)
-		}
+	outputSize := "compact"
+	if full {
+		outputSize = "full"
 	}
+	url := fmt.Sprintf("https://www.alphavantage.co/query?function=TIME_SERIES_DAILY&symbol=%s&outputsize=%s&apikey=%s&datatype=json",
+		ticker, outputSize, s.cfg.AlphaVantageAPIKey)
 
+	resp, err := s.client.Get(url)
 	if err != nil {
-		fmt.Printf("Grok API request error: %v\n", err)
-		return s.mockStockData(stock)
-	}
-	if resp == nil {
-		fmt.Printf("Grok API: no response received\n")
-		return s.mockStockData(stock)
+		return nil, fmt.Errorf("failed to fetch daily series: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Grok API returned status: %d\n", resp.StatusCode)
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Grok API error response: %s\n", string(bodyBytes))
-		return s.mockStockData(stock)
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Failed to read Grok response body: %v\n", err)
-		return s.mockStockData(stock)
-	}
-
-	fmt.Printf("Grok raw response: %s\n", string(body))
-
-	// Parse Grok response
-	var grokResp GrokStockResponse
-	if err := json.Unmarshal(body, &grokResp); err != nil {
-		fmt.Printf("Failed to parse Grok response JSON: %v\n", err)
-		return s.mockStockData(stock)
-	}
-
-	// Extract the JSON content from Grok's response
-	if len(grokResp.Choices) == 0 {
-		fmt.Printf("Grok response has no choices\n")
-		return s.mockStockData(stock)
-	}
-
-	content := grokResp.Choices[0].Message.Content
-	fmt.Printf("Grok content: %s\n", content)
-
-	// Parse the stock analysis JSON
-	var analysis StockAnalysis
-	if err := json.Unmarshal([]byte(content), &analysis); err != nil {
-		fmt.Printf("Failed to parse Grok stock analysis: %v\n", err)
-		return s.mockStockData(stock)
-	}
-
-	// Update stock with all data from Grok (raw data + calculations)
-	stock.CurrentPrice = analysis.CurrentPrice
-	stock.FairValue = analysis.FairValue
-	stock.Beta = analysis.Beta
-	stock.Volatility = analysis.Volatility
-	stock.PERatio = analysis.PERatio
-	stock.EPSGrowthRate = analysis.EPSGrowthRate
-	stock.DebtToEBITDA = analysis.DebtToEBITDA
-	stock.DividendYield = analysis.DividendYield
-	stock.ProbabilityPositive = analysis.ProbabilityPositive
-	stock.DownsideRisk = analysis.DownsideRisk
-	
-	// Set data source
-	stock.DataSource = "Grok AI"
-	stock.FairValueSource = fmt.Sprintf("Grok AI Analysis, %s", time.Now().Format("Jan 2, 2006"))
-	stock.LastUpdated = time.Now()
-
-	// Validate fair value (warn if it seems inflated)
-	if stock.FairValue > 0 && stock.CurrentPrice > 0 {
-		upsidePercent := ((stock.FairValue - stock.CurrentPrice) / stock.CurrentPrice) * 100
-		if upsidePercent > 100 {
-			fmt.Printf("⚠️ WARNING: Fair value %.2f for %s seems inflated (%.1f%% upside). Please verify consensus target.\n",
-				stock.FairValue, stock.Ticker, upsidePercent)
-		}
-	}
-
-	// Recalculate metrics using our corrected formulas
-	CalculateMetrics(stock)
-
-	// Store exchange rate for later use (will be retrieved by FetchExchangeRate)
-	s.cacheExchangeRate(stock.Currency, analysis.ExchangeRateToUSD)
-
-	return nil
-}
-
-// mockStockData provides N/A values when no API is available
-func (s *ExternalAPIService) mockStockData(stock *models.Stock) error {
-	// Set all values to 0 or empty to indicate data is not available (N/A)
-	// These will be displayed as "N/A" in the frontend
-	stock.CurrentPrice = 0
-	stock.FairValue = 0
-	stock.Beta = 0
-	stock.Volatility = 0
-	stock.PERatio = 0
-	stock.EPSGrowthRate = 0
-	stock.DebtToEBITDA = 0
-	stock.DividendYield = 0
-	stock.ProbabilityPositive = 0
-	stock.DownsideRisk = 0
-	stock.UpsidePotential = 0
-	stock.BRatio = 0
-	stock.ExpectedValue = 0
-	stock.KellyFraction = 0
-	stock.HalfKellySuggested = 0
-	stock.BuyZoneMin = 0
-	stock.BuyZoneMax = 0
-	stock.Assessment = "N/A"
-	stock.DataSource = "None"
-	stock.FairValueSource = "Not available"
-	stock.LastUpdated = time.Now()
-
-	// Cache mock exchange rate
-	mockExchangeRate := s.getMockExchangeRate(stock.Currency)
-	s.cacheExchangeRate(stock.Currency, mockExchangeRate)
-
-	return fmt.Errorf("no API configured - stock data unavailable")
-}
-
-// getMockExchangeRate returns a mock exchange rate for a currency
-func (s *ExternalAPIService) getMockExchangeRate(currency string) float64 {
-	mockRates := map[string]float64{
-		"USD": 1.0,
-		"EUR": 1.10,
-		"GBP": 1.27,
-		"DKK": 0.1538,
-		"SEK": 0.096,
-		"NOK": 0.094,
-	}
-	if rate, ok := mockRates[currency]; ok {
-		return rate
-	}
-	return 0.15 // Default fallback
-}
-
-// Legacy functions for backward compatibility
-// These now call the unified FetchAllStockData function
-
-// FetchStockPrice fetches current stock price (now from Grok)
-func (s *ExternalAPIService) FetchStockPrice(ticker string) (float64, error) {
-	// Create temporary stock for fetching
-	tempStock := &models.Stock{
-		Ticker:      ticker,
-		CompanyName: ticker,
-		Sector:      "Unknown",
-		Currency:    "USD",
-	}
-
-	err := s.FetchAllStockData(tempStock)
-	if err != nil {
-		return 0, err
-	}
-
-	return tempStock.CurrentPrice, nil
-}
-
-// FetchGrokCalculations fetches stock calculations (now part of FetchAllStockData)
-func (s *ExternalAPIService) FetchGrokCalculations(stock *models.Stock) error {
-	// This now does nothing as FetchAllStockData handles everything
-	return nil
-}
-
-// FetchExchangeRate fetches currency exchange rate to USD
-// Prefers cached rate from Grok if available
-func (s *ExternalAPIService) FetchExchangeRate(fromCurrency string) (float64, error) {
-	if fromCurrency == "USD" {
-		return 1.0, nil
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Check if we have a cached rate from Grok (most recent and accurate)
-	if cachedRate, ok := s.getCachedExchangeRate(fromCurrency); ok {
-		return cachedRate, nil
+	// Check for rate limit error in response
+	if bytes.Contains(body, []byte("API call frequency")) || bytes.Contains(body, []byte("Thank you for using Alpha Vantage")) {
+		return nil, fmt.Errorf("Alpha Vantage rate limit reached (5 calls/minute for free tier)")
 	}
 
-	// If no Grok API key, use mock rates
-	if s.cfg.ExchangeRatesAPIKey == "" && s.cfg.XAIAPIKey == "" {
-		return s.getMockExchangeRate(fromCurrency), nil
+	var series AlphaVantageDailySeries
+	if err := json.Unmarshal(body, &series); err != nil {
+		return nil, fmt.Errorf("failed to decode daily series: %w", err)
 	}
 
-	url := fmt.Sprintf("https://api.exchangeratesapi.io/v1/latest?access_key=%s&base=%s&symbols=USD",
-		s.cfg.ExchangeRatesAPIKey, fromCurrency)
-
-	resp, err := s.client.Get(url)
-	if err != nil {
-		return 1.0, fmt.Errorf("failed to fetch exchange rate: %w", err)
+	// Check for structured error responses
+	if series.Note != "" {
+		return nil, fmt.Errorf("Alpha Vantage rate limit: %s", series.Note)
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 1.0, fmt.Errorf("failed to decode response: %w", err)
+	if series.ErrorMessage != "" {
+		return nil, fmt.Errorf("Alpha Vantage error: %s", series.ErrorMessage)
 	}
-
-	rates, ok := result["rates"].(map[string]interface{})
-	if !ok {
-		return 1.0, fmt.Errorf("invalid response format")
-	}
-
-	rate, ok := rates["USD"].(float64)
-	if !ok {
-		return 1.0, fmt.Errorf("USD rate not found")
+	if len(series.TimeSeries) == 0 {
+		if series.Information != "" {
+			return nil, fmt.Errorf("Alpha Vantage info: %s", series.Information)
+		}
+		return nil, fmt.Errorf("no daily series returned for ticker %s", ticker)
 	}
 
-	return rate, nil
-}
-
-// FetchAllExchangeRates fetches all needed exchange rates
-func (s *ExternalAPIService) FetchAllExchangeRates(currencies []string) (map[string]float64, error) {
-	rates := make(map[string]float64)
-	rates["USD"] = 1.0 // USD to USD is always 1
-
-	for _, currency := range currencies {
-		if currency == "USD" {
+	closes := make([]DailyClose, 0, len(series.TimeSeries))
+	for day, bar := range series.TimeSeries {
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
 			continue
 		}
-		rate, err := s.FetchExchangeRate(currency)
-		if err != nil {
-			// Use fallback rate on error
-			rates[currency] = 1.0
-		} else {
-			rates[currency] = rate
+		if price := parseFloat(bar.Close); price > 0 {
+			closes = append(closes, DailyClose{
+				Date:   date,
+				Open:   parseFloat(bar.Open),
+				High:   parseFloat(bar.High),
+				Low:    parseFloat(bar.Low),
+				Close:  price,
+				Volume: parseFloat(bar.Volume),
+			})
 		}
 	}
+	sort.Slice(closes, func(i, j int) bool { return closes[i].Date.Before(closes[j].Date) })
 
-	return rates, nil
+	return closes, nil
 }
 
-// FetchFromAlphaVantage fetches ONLY raw financial data from Alpha Vantage
-// Best for: current_price, beta, volatility, P/E, EPS growth, debt/EBITDA, dividend yield
-func (s *ExternalAPIService) FetchFromAlphaVantage(stock *models.Stock) error {
-	if s.cfg.AlphaVantageAPIKey == "" {
-		return fmt.Errorf("Alpha Vantage API key not configured")
-	}
-
-	fmt.Printf("📊 Fetching RAW financial data from Alpha Vantage for %s...\n", stock.Ticker)
-
-	// Fetch current price
-	quote, err := s.FetchAlphaVantageQuote(stock.Ticker)
-	if err != nil {
-		return fmt.Errorf("failed to fetch quote: %w", err)
-	}
-
-	if quote.GlobalQuote.Price == "" {
-		return fmt.Errorf("no price data returned for %s", stock.Ticker)
-	}
-
-	stock.CurrentPrice = parseFloat(quote.GlobalQuote.Price)
-	fmt.Printf("✓ Current price: %.2f\n", stock.CurrentPrice)
-
-	// Fetch fundamentals
-	overview, err := s.FetchAlphaVantageOverview(stock.Ticker)
-	if err != nil {
-		return fmt.Errorf("failed to fetch overview: %w", err)
-	}
-
-	// Store raw JSON responses
-	combinedResponse := map[string]interface{}{
-		"quote":    quote,
-		"overview": overview,
-	}
-	rawJSON, _ := json.MarshalIndent(combinedResponse, "", "  ")
-	stock.AlphaVantageRawJSON = string(rawJSON)
-
-	// Update all available raw data
-	if overview.Beta != "" && overview.Beta != "None" {
-		stock.Beta = parseFloat(overview.Beta)
-		fmt.Printf("✓ Beta: %.2f\n", stock.Beta)
-	}
-
-	if overview.AnalystTargetPrice != "" && overview.AnalystTargetPrice != "None" {
-		stock.FairValue = parseFloat(overview.AnalystTargetPrice)
-		stock.FairValueSource = fmt.Sprintf("Alpha Vantage Consensus, %s", time.Now().Format("Jan 2, 2006"))
-		fmt.Printf("✓ Fair value (analyst target): %.2f\n", stock.FairValue)
-	}
-
-	stock.PERatio = parseFloat(overview.PERatio)
-	stock.DividendYield = parseFloat(overview.DividendYield)
-
-	// Calculate EPS growth from quarterly data
-	if overview.QuarterlyEarningsGrowthYOY != "" {
-		stock.EPSGrowthRate = parseFloat(overview.QuarterlyEarningsGrowthYOY) * 100
-	}
-
-	// Update sector if provided
-	if overview.Sector != "" {
-		stock.Sector = overview.Sector
+// parseFloat safely parses a string to float64, returning 0 on error
+func parseFloat(s string) float64 {
+	if s == "" || s == "None" || s == "-" {
+		return 0
 	}
-
-	// Set data source
-	stock.DataSource = "Alpha Vantage (Raw Data)"
-
-	// Calculate all derived metrics (EV, Kelly, assessment, etc.)
-	CalculateMetrics(stock)
-	stock.LastUpdated = time.Now()
-
-	fmt.Printf("✅ Alpha Vantage fetch complete for %s\n", stock.Ticker)
-	return nil
+	var f float64
+	fmt.Sscanf(s, "%f", &f)
+	return f
 }
 
-// FetchFromGrok fetches ONLY analytical/interpretive data from Grok
-// Best for: fair_value (consensus), probability (p), EV, Kelly, downside risk, assessment, recommendations
-func (s *ExternalAPIService) FetchFromGrok(stock *models.Stock) error {
-	if s.cfg.XAIAPIKey == "" {
-		return fmt.Errorf("Grok API key not configured")
-	}
-
-	fmt.Printf("🤖 Fetching ANALYTICAL data from Grok for %s...\n", stock.Ticker)
+// GrokStockData is the stock analysis returned by Grok
+type GrokStockData struct {
+	CurrentPrice        float64 `json:"current_price"`
+	FairValue           float64 `json:"fair_value"`
+	Beta                float64 `json:"beta"`
+	Volatility          float64 `json:"volatility"`
+	PERatio             float64 `json:"pe_ratio"`
+	EPSGrowthRate       float64 `json:"eps_growth_rate"`
+	DebtToEBITDA        float64 `json:"debt_to_ebitda"`
+	DividendYield       float64 `json:"dividend_yield"`
+	ProbabilityPositive float64 `json:"probability_positive"`
+	DownsideRisk        float64 `json:"downside_risk"`
+	BRatio              float64 `json:"b_ratio"`
+	UpsidePotential     float64 `json:"upside_potential"`
+	ExpectedValue       float64 `json:"expected_value"`
+	KellyFraction       float64 `json:"kelly_fraction"`
+	HalfKellySuggested  float64 `json:"half_kelly_suggested"`
+	BuyZoneMin          float64 `json:"buy_zone_min"`
+	BuyZoneMax          float64 `json:"buy_zone_max"`
+	Assessment          string  `json:"assessment"`
+	Sector              string  `json:"sector"`
+	FairValueSource     string  `json:"fair_value_source"`
+	DataSource          string  `json:"data_source"`
+}
 
-	// Create comprehensive prompt for Grok focusing on analytical data
-	prompt := fmt.Sprintf(`You are a financial analyst following a strict probabilistic investment strategy. The core philosophy is built on probabilistic thinking, expected value (EV) optimization, and ½-Kelly sizing to maximize long-term growth while minimizing ruin probability.
+// grokAnalysisPrompt builds the stock analysis prompt for Grok from the strategy profile
+func grokAnalysisPrompt(stock *models.Stock, strategy *models.StrategyProfile) string {
+	rules := fmt.Sprintf(`You are a financial analyst following a strict probabilistic investment strategy. The core philosophy is built on probabilistic thinking, expected value (EV) optimization, and ½-Kelly sizing to maximize long-term growth while minimizing ruin probability.
 
 Key principles:
 
 1. Probabilistic Thinking: Assign probabilities to scenarios (growth, stagnation, decline) rather than binary outcomes.
 
-2. Expected Value (EV): Calculate EV = (p × upside %%) + ((1 - p) × downside %%). Only hold if EV > 0%%, add if >7%%, trim if <3%%, sell if <0%%.
+2. Expected Value (EV): Calculate EV = (p × upside %%) + ((1 - p) × downside %%). Add if EV > %g%%, hold if EV > %g%%, trim if EV > %g%%, sell otherwise.
 
-3. Kelly Criterion: f* = [(b × p) - q] / b, where b = upside %% / |downside %%|, q = 1 - p. Use ½-Kelly for sizing, capped at 15%% for high-conviction/low-vol assets (typical 3–6%%).
+3. Kelly Criterion: f* = [(b × p) - q] / b, where b = upside %% / |downside %%|, q = 1 - p. Use ½-Kelly for sizing, capped at %g%% for high-conviction/low-vol assets (typical 3–6%%).
 
-4. Sector targets: Healthcare 30–35%%, Technology 15%%, Energy 8–10%%, Financials 5–7%%, Industrials 3–4%%, Consumer Staples 8–10%%, REITs 5–7%%, Cash 8–12%%.
+4. Sector targets: %s, Cash %g–%g%%.
+`, strategy.AddThresholdEV, strategy.HoldThresholdEV, strategy.TrimThresholdEV, strategy.HalfKellyCap,
+		FormatSectorTargets(strategy), strategy.CashMin, strategy.CashMax)
 
+	return rules + fmt.Sprintf(`
 Analyze the following stock (use ISIN and ticker together to ensure correct security identification):
 - Ticker: %s
 - ISIN: %s
@@ -839,16 +428,16 @@ CRITICAL REQUIREMENTS:
 - "current_price" = ACTUAL REAL-TIME TRADING PRICE on the stock exchange (what you can buy TODAY)
 - "fair_value" = MEDIAN ANALYST CONSENSUS TARGET PRICE (12-month target from TipRanks/Yahoo Finance/Bloomberg)
 - These are DIFFERENT values. Current price is TODAY's market price. Fair value is FUTURE analyst target.
-- Use p=0.65 as default probability (adjust based on analyst ratings: 0.7 for Strong Buy, 0.65 for Buy, 0.5 for Hold)
-- Calibrate downside by beta: <0.5 = -15%%, 0.5-1 = -20%%, 1-1.5 = -25%%, >1.5 = -30%%
-- Buy zone: typically 85-95%% of current price or where EV >15%%
+- Use p=%g as default probability (adjust based on analyst ratings: 0.7 for Strong Buy, 0.65 for Buy, 0.5 for Hold)
+- Calibrate downside by beta: <%g = %g%%, %g-%g = %g%%, %g-%g = %g%%, >%g = %g%%
+- Buy zone: typically 85-95%% of current price or where EV >%g%%
 
 IMPORTANT FORMULAS:
 1. upside_potential = ((fair_value - current_price) / current_price) × 100
 2. b = upside_potential / |downside_risk|
 3. expected_value = (probability_positive × upside_potential) + ((1 - probability_positive) × downside_risk)
 4. kelly_fraction = ((b × probability_positive) - (1 - probability_positive)) / b
-5. half_kelly_suggested = (kelly_fraction / 2), capped at 15%%
+5. half_kelly_suggested = (kelly_fraction / 2), capped at %g%%
 
 Return ONLY valid JSON (no markdown, no extra text):
 {
@@ -862,7 +451,7 @@ Return ONLY valid JSON (no markdown, no extra text):
   "expected_value": <EV %%>,
   "b_ratio": <upside/|downside|>,
   "kelly_fraction": <optimal %% uncapped>,
-  "half_kelly_suggested": <conservative %% capped at 15>,
+  "half_kelly_suggested": <conservative %% capped at %g>,
   "buy_zone_min": <lower price bound>,
   "buy_zone_max": <upper price bound>,
   "assessment": "<Add/Hold/Trim/Sell>",
@@ -875,59 +464,86 @@ Return ONLY valid JSON (no markdown, no extra text):
   "exchange_rate_to_usd": <rate if non-USD>,
   "fair_value_source": "<source, date>",
   "data_source": "Grok AI"
-}`, stock.Ticker, stock.ISIN, stock.CompanyName, stock.Sector, stock.Currency, stock.Sector, stock.Currency)
+}`, stock.Ticker, stock.ISIN, stock.CompanyName, stock.Sector, stock.Currency,
+		strategy.DefaultProbability,
+		strategy.BetaLow, strategy.DownsideLow, strategy.BetaLow, strategy.BetaMid, strategy.DownsideMid,
+		strategy.BetaMid, strategy.BetaHigh, strategy.DownsideHigh, strategy.BetaHigh, strategy.DownsideMax,
+		strategy.BuyZoneTargetEV, strategy.HalfKellyCap, strategy.HalfKellyCap,
+		stock.Sector, stock.Currency)
+}
 
-	req := GrokStockRequest{
+// FetchGrokAnalysis asks Grok for a complete analysis of the stock and returns it
+// together with the raw API response
+func (s *ExternalAPIService) FetchGrokAnalysis(stock *models.Stock, strategy *models.StrategyProfile) (*GrokStockData, string, error) {
+	if s.cfg.XAIAPIKey == "" {
+		return nil, "", fmt.Errorf("Grok API key not configured")
+	}
+
+	fmt.Printf("🤖 Fetching analysis from Grok for %s...\n", stock.Ticker)
+
+	reqBody := GrokStockRequest{
 		Model: "grok-4-fast-reasoning",
 		Messages: []Message{
+			{
+				Role:    "system",
+				Content: "You are a financial analyst AI. Respond only with valid JSON data, no additional text.",
+			},
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: grokAnalysisPrompt(stock, strategy),
 			},
 		},
 		Stream: false,
 	}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	httpReq, err := http.NewRequest("POST", "https://api.x.ai/v1/chat/completions", bytes.NewBuffer(jsonData))
+	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+s.cfg.XAIAPIKey)
+	// Implement exponential backoff for retries
+	var resp *http.Response
+	for i := 0; i < 3; i++ {
+		httpReq, err := http.NewRequest("POST", "https://api.x.ai/v1/chat/completions", bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+s.cfg.XAIAPIKey)
 
-	resp, err := s.client.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("failed to call Grok API: %w", err)
+		resp, err = s.client.Do(httpReq)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			break
+		}
+		if i == 2 {
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to call Grok API: %w", err)
+			}
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		time.Sleep(time.Duration(1<<uint(i)) * time.Second)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("Grok API returned status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, "", fmt.Errorf("Grok API returned status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read Grok response: %w", err)
+		return nil, "", fmt.Errorf("failed to read Grok response: %w", err)
 	}
 
 	var grokResp GrokStockResponse
 	if err := json.Unmarshal(body, &grokResp); err != nil {
-		return fmt.Errorf("failed to decode Grok response: %w", err)
+		return nil, "", fmt.Errorf("failed to decode Grok response: %w", err)
 	}
-
-	// Store the raw Grok response
-	stock.GrokRawJSON = string(body)
-
 	if len(grokResp.Choices) == 0 {
-		return fmt.Errorf("no choices in Grok response")
+		return nil, "", fmt.Errorf("no choices in Grok response")
 	}
 
 	content := grokResp.Choices[0].Message.Content
@@ -943,43 +559,18 @@ Return ONLY valid JSON (no markdown, no extra text):
 
 	var data GrokStockData
 	if err := json.Unmarshal([]byte(content), &data); err != nil {
-		return fmt.Errorf("failed to parse Grok JSON: %w, content: %s", err, content)
-	}
-
-	// Update stock with Grok data
-	stock.CurrentPrice = data.CurrentPrice
-	stock.FairValue = data.FairValue
-	stock.Beta = data.Beta
-	stock.Volatility = data.Volatility
-	stock.ProbabilityPositive = data.ProbabilityPositive
-	stock.DownsideRisk = data.DownsideRisk
-	stock.UpsidePotential = data.UpsidePotential
-	stock.ExpectedValue = data.ExpectedValue
-	stock.BRatio = data.BRatio
-	stock.KellyFraction = data.KellyFraction
-	stock.HalfKellySuggested = data.HalfKellySuggested
-	stock.BuyZoneMin = data.BuyZoneMin
-	stock.BuyZoneMax = data.BuyZoneMax
-	stock.Assessment = data.Assessment
-	stock.PERatio = data.PERatio
-	stock.EPSGrowthRate = data.EPSGrowthRate
-	stock.DebtToEBITDA = data.DebtToEBITDA
-	stock.DividendYield = data.DividendYield
-	
-	if data.Sector != "" {
-		stock.Sector = data.Sector
-	}
-	
-	stock.DataSource = "Grok AI (Analytical)"
-	if data.FairValueSource != "" {
-		stock.FairValueSource = data.FairValueSource
+		return nil, "", fmt.Errorf("failed to parse Grok JSON: %w, content: %s", err, content)
 	}
 
-	stock.LastUpdated = time.Now()
+	// Validate fair value (warn if it seems inflated)
+	if data.FairValue > 0 && data.CurrentPrice > 0 {
+		upsidePercent := ((data.FairValue - data.CurrentPrice) / data.CurrentPrice) * 100
+		if upsidePercent > 100 {
+			fmt.Printf("⚠️ WARNING: Fair value %.2f for %s seems inflated (%.1f%% upside). Please verify consensus target.\n",
+				data.FairValue, stock.Ticker, upsidePercent)
+		}
+	}
 
 	fmt.Printf("✅ Grok fetch complete for %s\n", stock.Ticker)
-	fmt.Printf("   Current Price: %.2f, Fair Value: %.2f, EV: %.1f%%, Assessment: %s\n",
-		stock.CurrentPrice, stock.FairValue, stock.ExpectedValue, stock.Assessment)
-
-	return nil
+	return &data, string(body), nil
 }
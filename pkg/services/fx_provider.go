@@ -0,0 +1,271 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// FX rate providers, in their default priority order
+const (
+	RateSourceAPI    = "api"    // exchangerate-api.com
+	RateSourceECB    = "ecb"    // Imported ECB reference rate files
+	RateSourceManual = "manual" // Rates entered by the user
+	RateSourceStatic = "static" // Built-in defaults
+)
+
+// ErrFXProviderNotConfigured is returned by providers that lack credentials; the chain skips them silently
+var ErrFXProviderNotConfigured = errors.New("fx provider not configured")
+
+// StaticFXRates are the built-in rates (units per EUR), the last resort of the chain
+var StaticFXRates = map[string]float64{
+	"EUR": 1.0,
+	"USD": 1.154,
+	"DKK": 7.4604,
+	"GBP": 0.8796,
+	"RUB": 93.7594,
+}
+
+// FXQuote is a rate supplied by a provider
+type FXQuote struct {
+	Currency string    `json:"currency"`
+	Rate     float64   `json:"rate"` // Units per EUR
+	Provider string    `json:"provider"`
+	AsOf     time.Time `json:"as_of"` // When the provider quoted the rate; zero for static defaults
+}
+
+// FXProvider supplies exchange rates relative to EUR
+type FXProvider interface {
+	Name() string
+	Quotes(currencies []string) (map[string]FXQuote, error)
+}
+
+// FXChain asks its providers in order; each currency takes the first quote found
+type FXChain []FXProvider
+
+// NewFXChain returns the default chain: remote API, ECB imports, manual rates, static defaults
+func NewFXChain(db *gorm.DB, apiKey string, client *http.Client) FXChain {
+	return FXChain{
+		&exchangeRateAPIProvider{apiKey: apiKey, client: client},
+		&storedFXProvider{db: db, source: RateSourceECB},
+		&storedFXProvider{db: db, source: RateSourceManual},
+		staticFXProvider{},
+	}
+}
+
+// Quotes resolves the currencies through the chain. Errors of individual providers
+// are joined and returned next to the quotes the other providers supplied.
+func (c FXChain) Quotes(currencies []string) (map[string]FXQuote, error) {
+	quotes := make(map[string]FXQuote, len(currencies))
+	missing := currencies
+	var errs []error
+
+	for _, provider := range c {
+		if len(missing) == 0 {
+			break
+		}
+		found, err := provider.Quotes(missing)
+		if err != nil && !errors.Is(err, ErrFXProviderNotConfigured) {
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+		}
+
+		var rest []string
+		for _, code := range missing {
+			if quote, ok := found[code]; ok && quote.Rate > 0 {
+				quotes[code] = quote
+			} else {
+				rest = append(rest, code)
+			}
+		}
+		missing = rest
+	}
+
+	return quotes, errors.Join(errs...)
+}
+
+// Supersedes reports whether a quote should replace a stored rate. Quotes from an
+// equally or more preferred provider always do; others only when they are more recent.
+func (c FXChain) Supersedes(quote FXQuote, stored models.ExchangeRate) bool {
+	if stored.Source != "" && c.priority(quote.Provider) <= c.priority(stored.Source) {
+		return true
+	}
+	return quote.AsOf.After(stored.RateDate)
+}
+
+func (c FXChain) priority(name string) int {
+	for i, provider := range c {
+		if provider.Name() == name {
+			return i
+		}
+	}
+	return len(c)
+}
+
+// exchangeRateAPIProvider fetches the latest EUR-based rates from exchangerate-api.com
+type exchangeRateAPIProvider struct {
+	apiKey string
+	client *http.Client
+}
+
+func (p *exchangeRateAPIProvider) Name() string { return RateSourceAPI }
+
+func (p *exchangeRateAPIProvider) Quotes(currencies []string) (map[string]FXQuote, error) {
+	if p.apiKey == "" {
+		return nil, ErrFXProviderNotConfigured
+	}
+
+	resp, err := p.client.Get(fmt.Sprintf("https://v6.exchangerate-api.com/v6/%s/latest/EUR", p.apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("API status %d", resp.StatusCode)
+	}
+
+	var apiResp ExchangeRateAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if apiResp.Result != "success" {
+		return nil, fmt.Errorf("API error: %s", apiResp.ErrorType)
+	}
+
+	asOf := time.Unix(apiResp.TimeLastUpdate, 0).UTC()
+	quotes := make(map[string]FXQuote, len(currencies))
+	for _, code := range currencies {
+		if rate, ok := apiResp.ConversionRates[code]; ok && rate > 0 {
+			quotes[code] = FXQuote{Currency: code, Rate: rate, Provider: RateSourceAPI, AsOf: asOf}
+		}
+	}
+	return quotes, nil
+}
+
+// storedFXProvider serves the latest rate history entry of one source (ECB imports or manual rates)
+type storedFXProvider struct {
+	db     *gorm.DB
+	source string
+}
+
+func (p *storedFXProvider) Name() string { return p.source }
+
+func (p *storedFXProvider) Quotes(currencies []string) (map[string]FXQuote, error) {
+	quotes := make(map[string]FXQuote, len(currencies))
+	for _, code := range currencies {
+		var entry models.ExchangeRateHistory
+		err := p.db.Where("currency_code = ? AND source = ?", code, p.source).
+			Order("recorded_at DESC").
+			First(&entry).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return quotes, err
+		}
+		quotes[code] = FXQuote{Currency: code, Rate: entry.Rate, Provider: p.source, AsOf: entry.RecordedAt}
+	}
+	return quotes, nil
+}
+
+// staticFXProvider serves StaticFXRates
+type staticFXProvider struct{}
+
+func (staticFXProvider) Name() string { return RateSourceStatic }
+
+func (staticFXProvider) Quotes(currencies []string) (map[string]FXQuote, error) {
+	quotes := make(map[string]FXQuote, len(currencies))
+	for _, code := range currencies {
+		if rate, ok := StaticFXRates[code]; ok {
+			quotes[code] = FXQuote{Currency: code, Rate: rate, Provider: RateSourceStatic}
+		}
+	}
+	return quotes, nil
+}
+
+// ecbEnvelope is the layout of the ECB eurofxref XML files
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ParseECBRates reads an ECB euro reference rate file, either the XML feed
+// (eurofxref-daily.xml, eurofxref-hist.xml) or the CSV download (eurofxref.csv, eurofxref-hist.csv)
+func ParseECBRates(r io.Reader) ([]FXQuote, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var quotes []FXQuote
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("<")) {
+		var envelope ecbEnvelope
+		if err := xml.Unmarshal(raw, &envelope); err != nil {
+			return nil, fmt.Errorf("%w: invalid ECB XML: %v", ErrInvalidImport, err)
+		}
+		for _, day := range envelope.Days {
+			date, err := parseDate(day.Time, "")
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+			}
+			for _, rate := range day.Rates {
+				if rate.Rate > 0 {
+					quotes = append(quotes, FXQuote{Currency: rate.Currency, Rate: rate.Rate, Provider: RateSourceECB, AsOf: date})
+				}
+			}
+		}
+	} else {
+		records, err := readRecords(bytes.NewReader(raw), ',')
+		if err != nil {
+			return nil, err
+		}
+		if len(records) < 2 {
+			return nil, fmt.Errorf("%w: file has no data rows", ErrInvalidImport)
+		}
+		header := records[0]
+		if len(header) == 0 || !strings.EqualFold(strings.TrimSpace(header[0]), "date") {
+			return nil, fmt.Errorf("%w: missing Date column", ErrInvalidImport)
+		}
+		for i, record := range records[1:] {
+			if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+				continue
+			}
+			date, err := parseDate(record[0], "")
+			if err != nil {
+				return nil, rowError(i+1, "Date", err)
+			}
+			for col := 1; col < len(record) && col < len(header); col++ {
+				code := strings.ToUpper(strings.TrimSpace(header[col]))
+				value := strings.TrimSpace(record[col])
+				if code == "" || value == "" || value == "N/A" {
+					continue
+				}
+				rate, err := parseNumber(value, false)
+				if err != nil {
+					return nil, rowError(i+1, code, err)
+				}
+				if rate > 0 {
+					quotes = append(quotes, FXQuote{Currency: code, Rate: rate, Provider: RateSourceECB, AsOf: date})
+				}
+			}
+		}
+	}
+
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("%w: no rates found", ErrInvalidImport)
+	}
+	return quotes, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc answers requests without a server
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestExchangeRateAPIProviderStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"success", http.StatusOK, `{"result":"success","time_last_update_unix":1760000000,"conversion_rates":{"USD":1.08}}`, ""},
+		{"rejected key", http.StatusForbidden, `{"result":"error","error-type":"invalid-key"}`, "API status 403"},
+		{"gateway error page", http.StatusBadGateway, "<html>Bad Gateway</html>", "API status 502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body)), Header: http.Header{}, Request: r}, nil
+			})}
+			provider := &exchangeRateAPIProvider{apiKey: "key", client: client}
+			quotes, err := provider.Quotes([]string{"USD"})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || quotes["USD"].Rate != 1.08 {
+				t.Errorf("quotes %v, error %v", quotes, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens an empty SQLite database with the tables the services use
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(
+		&models.Stock{},
+		&models.StockScenario{},
+		&models.StockHistory{},
+		&models.StrategyProfile{},
+		&models.PortfolioSettings{},
+		&models.Transaction{},
+		&models.Dividend{},
+		&models.CorporateAction{},
+		&models.PriceBar{},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
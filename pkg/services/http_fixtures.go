@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+)
+
+// HTTP fixture modes (HTTP_FIXTURES_MODE)
+const (
+	FixturesLive   = "live"   // Call the real APIs
+	FixturesRecord = "record" // Call the real APIs and store every response
+	FixturesReplay = "replay" // Serve stored responses only, never touching the network
+)
+
+// ErrFixtureNotFound is returned in replay mode for requests that were never recorded
+var ErrFixtureNotFound = errors.New("no recorded HTTP fixture")
+
+// HTTPFixture is one recorded request/response pair. Secrets are redacted from the
+// request before it is stored and before it is matched.
+type HTTPFixture struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   string `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int                 `json:"status_code"`
+		Header     map[string][]string `json:"header"`
+		Body       string              `json:"body"`
+	} `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// NewHTTPClient returns the client used for external APIs. In record and replay mode
+// its transport writes or serves fixtures under HTTP_FIXTURES_DIR.
+func NewHTTPClient(cfg *config.Config, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if cfg.HTTPFixturesMode != FixturesRecord && cfg.HTTPFixturesMode != FixturesReplay {
+		return client
+	}
+	client.Transport = &FixtureTransport{
+		Mode:    cfg.HTTPFixturesMode,
+		Dir:     cfg.HTTPFixturesDir,
+		Secrets: apiSecrets(cfg),
+		Next:    http.DefaultTransport,
+	}
+	return client
+}
+
+// apiSecrets lists the configured API keys that must not end up in fixtures
+func apiSecrets(cfg *config.Config) []string {
+	var secrets []string
+	for _, secret := range []string{
+		cfg.AlphaVantageAPIKey,
+		cfg.XAIAPIKey,
+		cfg.DeepseekAPIKey,
+		cfg.ExchangeRatesAPIKey,
+		cfg.SendGridAPIKey,
+	} {
+		if secret != "" && secret != config.FixtureSecret {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// FixtureTransport records responses to, or replays them from, a fixture directory.
+// Fixtures are matched on method, URL and body; in replay mode a request whose body
+// changed (e.g. prompts containing today's date) falls back to the latest fixture
+// recorded for the same method and URL.
+type FixtureTransport struct {
+	Mode    string
+	Dir     string
+	Secrets []string
+	Next    http.RoundTripper
+
+	mu sync.Mutex
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	url := t.redact(req.URL.String())
+	redactedBody := t.redact(string(body))
+
+	if t.Mode == FixturesReplay {
+		fixture, err := t.find(req.URL.Host, method, url, redactedBody)
+		if err != nil {
+			return nil, err
+		}
+		return fixture.response(req), nil
+	}
+
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	fixture := HTTPFixture{RecordedAt: time.Now().UTC()}
+	fixture.Request.Method = method
+	fixture.Request.URL = url
+	fixture.Request.Body = redactedBody
+	fixture.Response.StatusCode = resp.StatusCode
+	fixture.Response.Header = resp.Header
+	fixture.Response.Body = string(respBody)
+	if err := t.save(req.URL.Host, fixture); err != nil {
+		fmt.Printf("⚠ Failed to record HTTP fixture for %s: %v\n", url, err)
+	}
+
+	return resp, nil
+}
+
+// redact replaces every configured secret with config.FixtureSecret
+func (t *FixtureTransport) redact(text string) string {
+	for _, secret := range t.Secrets {
+		text = strings.ReplaceAll(text, secret, config.FixtureSecret)
+	}
+	return text
+}
+
+var fixtureSlugPattern = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// fixturePath names a fixture file after its method, URL path and a hash of the full request
+func (t *FixtureTransport) fixturePath(host, method, url, body string) string {
+	sum := sha256.Sum256([]byte(method + " " + url + "\n" + body))
+	return filepath.Join(t.Dir, fixtureHostDir(host), fmt.Sprintf("%s_%s.json", fixturePrefix(method, url), hex.EncodeToString(sum[:8])))
+}
+
+func fixtureHostDir(host string) string {
+	return fixtureSlugPattern.ReplaceAllString(host, "_")
+}
+
+// fixturePrefix is the part of a fixture's file name shared by all bodies sent to one URL path
+func fixturePrefix(method, url string) string {
+	path := url
+	if idx := strings.Index(path, "://"); idx >= 0 {
+		path = path[idx+3:]
+	}
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		path = path[idx:]
+	} else {
+		path = ""
+	}
+	path, _, _ = strings.Cut(path, "?")
+	slug := strings.Trim(fixtureSlugPattern.ReplaceAllString(path, "_"), "_")
+	if slug == "" {
+		slug = "root"
+	}
+	return strings.ToLower(method) + "_" + slug
+}
+
+func (t *FixtureTransport) save(host string, fixture HTTPFixture) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	path := t.fixturePath(host, fixture.Request.Method, fixture.Request.URL, fixture.Request.Body)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0644)
+}
+
+func (t *FixtureTransport) find(host, method, url, body string) (*HTTPFixture, error) {
+	if fixture, err := readFixture(t.fixturePath(host, method, url, body)); err == nil {
+		return fixture, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Same endpoint, different body: use the latest recording of that URL
+	matches, err := filepath.Glob(filepath.Join(t.Dir, fixtureHostDir(host), fixturePrefix(method, url)+"_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var latest *HTTPFixture
+	for _, path := range matches {
+		fixture, err := readFixture(path)
+		if err != nil || fixture.Request.Method != method || fixture.Request.URL != url {
+			continue
+		}
+		if latest == nil || fixture.RecordedAt.After(latest.RecordedAt) {
+			latest = fixture
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%w for %s %s", ErrFixtureNotFound, method, url)
+	}
+	return latest, nil
+}
+
+func readFixture(path string) (*HTTPFixture, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture HTTPFixture
+	if err := json.Unmarshal(raw, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid HTTP fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// response rebuilds the recorded response for a request
+func (f *HTTPFixture) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	for key, values := range f.Response.Header {
+		header[key] = values
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Response.StatusCode, http.StatusText(f.Response.StatusCode)),
+		StatusCode:    f.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(f.Response.Body)),
+		ContentLength: int64(len(f.Response.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func fixtureDo(t *testing.T, client *http.Client, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(raw)
+}
+
+func TestFixtureTransportRecordReplay(t *testing.T) {
+	const secret = "live-api-key"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		io.WriteString(w, `{"path":"`+r.URL.Path+`","symbol":"`+r.URL.Query().Get("symbol")+`","body_length":`+strconv.Itoa(len(body))+`}`)
+	}))
+
+	dir := t.TempDir()
+	quoteURL := server.URL + "/quote?symbol=ABC&apikey=" + secret
+	recorder := &http.Client{Transport: &FixtureTransport{Mode: FixturesRecord, Dir: dir, Secrets: []string{secret}, Next: http.DefaultTransport}}
+	recorded := map[string][2]interface{}{}
+	for _, call := range []struct{ method, url, body string }{
+		{http.MethodGet, quoteURL, ""},
+		{http.MethodPost, server.URL + "/analysis", "prompt " + secret},
+		{http.MethodGet, server.URL + "/missing", ""},
+	} {
+		status, body := fixtureDo(t, recorder, call.method, call.url, call.body)
+		recorded[call.method+call.url] = [2]interface{}{status, body}
+	}
+	server.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("recorded %d fixtures, want 3", len(files))
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("%s contains the API key", filepath.Base(file))
+		}
+	}
+
+	// The server is gone: everything below is served from the fixtures
+	replayer := &http.Client{Transport: &FixtureTransport{Mode: FixturesReplay, Dir: dir, Secrets: []string{secret}}}
+	for _, call := range []struct{ method, url, body string }{
+		{http.MethodGet, quoteURL, ""},
+		{http.MethodPost, server.URL + "/analysis", "prompt " + secret},
+		{http.MethodGet, server.URL + "/missing", ""},
+	} {
+		status, body := fixtureDo(t, replayer, call.method, call.url, call.body)
+		want := recorded[call.method+call.url]
+		if status != want[0] || body != want[1] {
+			t.Errorf("replayed %s %s = %d %s, want %d %s", call.method, call.url, status, body, want[0], want[1])
+		}
+	}
+
+	// A changed body falls back to the latest recording of the same URL
+	if _, body := fixtureDo(t, replayer, http.MethodPost, server.URL+"/analysis", "another prompt"); body != recorded[http.MethodPost+server.URL+"/analysis"][1] {
+		t.Errorf("changed body replayed %s", body)
+	}
+
+	_, err = replayer.Get(server.URL + "/quote?symbol=XYZ&apikey=" + secret)
+	if !errors.Is(err, ErrFixtureNotFound) {
+		t.Errorf("unrecorded request: got %v, want ErrFixtureNotFound", err)
+	}
+}
+
+func TestMarketDataUpdateReplaysAlphaVantage(t *testing.T) {
+	db := openTestDB(t)
+
+	// Replay mode stands in the fixture placeholder for the missing API key
+	t.Setenv("ALPHA_VANTAGE_API_KEY", "")
+	t.Setenv("HTTP_FIXTURES_MODE", FixturesReplay)
+	t.Setenv("HTTP_FIXTURES_DIR", filepath.Join("testdata", "http"))
+	cfg := config.Load()
+	if cfg.AlphaVantageAPIKey != config.FixtureSecret {
+		t.Fatalf("AlphaVantageAPIKey = %q, want the fixture placeholder", cfg.AlphaVantageAPIKey)
+	}
+
+	marketData := NewMarketDataService(db, cfg, NewExternalAPIService(cfg))
+	stock := models.Stock{Ticker: "MSFT", Currency: "USD"}
+	update, err := marketData.Update(&stock, []string{MarketDataAlphaVantage})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if len(update.Errors) > 0 {
+		t.Errorf("provider errors: %v", update.Errors)
+	}
+	if update.Quote != MarketDataAlphaVantage || update.Fundamentals != MarketDataAlphaVantage || update.AnalystTarget != MarketDataAlphaVantage {
+		t.Errorf("update = %+v, want every part from alphavantage", update)
+	}
+	if stock.CurrentPrice != 416.42 {
+		t.Errorf("CurrentPrice = %v, want 416.42", stock.CurrentPrice)
+	}
+	if stock.FairValue != 495.5 {
+		t.Errorf("FairValue = %v, want 495.5", stock.FairValue)
+	}
+	if stock.Sector == "" || stock.PERatio != 35.2 {
+		t.Errorf("fundamentals not applied: sector %q, P/E %v", stock.Sector, stock.PERatio)
+	}
+	if update.QuoteBar == nil || update.QuoteBar.Date.Format("2006-01-02") != "2026-10-15" {
+		t.Errorf("QuoteBar = %+v, want the 2026-10-15 bar", update.QuoteBar)
+	}
+	if stock.ExpectedValue == 0 {
+		t.Error("metrics were not recalculated")
+	}
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Position holds the holding derived from a stock's transaction ledger
+type Position struct {
+	Quantity      float64 `json:"quantity"`
+	AvgPriceLocal float64 `json:"avg_price_local"` // Average cost per share incl. buy fees
+	CostBasis     float64 `json:"cost_basis"`      // Remaining cost in local currency
+	TotalFees     float64 `json:"total_fees"`      // All fees paid, incl. standalone fee entries
+}
+
+// ErrInvalidLedger is returned when transactions cannot be replayed into a valid position
+var ErrInvalidLedger = errors.New("invalid ledger")
+
+// quantityEpsilon absorbs float noise when a position is fully closed
+const quantityEpsilon = 1e-9
+
+// CalculatePosition replays transactions in trade order using the average-cost method.
+// Buys add quantity and cost (including fees), sells remove quantity at the running
+// average cost, cost adjustments scale the remaining cost, and standalone fee entries
+// only count towards TotalFees.
+func CalculatePosition(transactions []models.Transaction) (Position, error) {
+	sorted := make([]models.Transaction, len(transactions))
+	copy(sorted, transactions)
+	sortTransactions(sorted)
+
+	var pos Position
+	for _, tx := range sorted {
+		switch tx.Type {
+		case models.TransactionTypeBuy:
+			pos.Quantity += tx.Quantity
+			pos.CostBasis += tx.Quantity*tx.Price + tx.Fees
+			pos.TotalFees += tx.Fees
+		case models.TransactionTypeSell:
+			if tx.Quantity > pos.Quantity+quantityEpsilon {
+				return Position{}, fmt.Errorf("%w: sell of %.4f on %s exceeds position of %.4f",
+					ErrInvalidLedger, tx.Quantity, tx.TradeDate.Format("2006-01-02"), pos.Quantity)
+			}
+			if pos.Quantity > 0 {
+				pos.CostBasis -= pos.CostBasis * (tx.Quantity / pos.Quantity)
+			}
+			pos.Quantity -= tx.Quantity
+			pos.TotalFees += tx.Fees
+		case models.TransactionTypeFee:
+			pos.TotalFees += tx.Fees
+		case models.TransactionTypeCostAdjustment:
+			pos.CostBasis *= tx.CostFactor
+		default:
+			return Position{}, fmt.Errorf("%w: unknown transaction type %q", ErrInvalidLedger, tx.Type)
+		}
+
+		if math.Abs(pos.Quantity) < quantityEpsilon {
+			pos.Quantity = 0
+			pos.CostBasis = 0
+		}
+	}
+
+	if pos.Quantity > 0 {
+		pos.AvgPriceLocal = pos.CostBasis / pos.Quantity
+	}
+
+	return pos, nil
+}
+
+// sortTransactions orders transactions by trade date, then by ID for same-day entries.
+// Cost adjustments take effect at the start of their day, before that day's trades.
+func sortTransactions(transactions []models.Transaction) {
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if transactions[i].TradeDate.Equal(transactions[j].TradeDate) {
+			adjustI := transactions[i].Type == models.TransactionTypeCostAdjustment
+			adjustJ := transactions[j].Type == models.TransactionTypeCostAdjustment
+			if adjustI != adjustJ {
+				return adjustI
+			}
+			return transactions[i].ID < transactions[j].ID
+		}
+		return transactions[i].TradeDate.Before(transactions[j].TradeDate)
+	})
+}
+
+// LedgerService handles transaction ledger operations
+type LedgerService struct {
+	db *gorm.DB
+}
+
+// NewLedgerService creates a new ledger service
+func NewLedgerService(db *gorm.DB) *LedgerService {
+	return &LedgerService{db: db}
+}
+
+// GetTransactions returns all transactions for a stock in trade order
+func (s *LedgerService) GetTransactions(stockID uint) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	if err := s.db.Where("stock_id = ?", stockID).Order("trade_date ASC, id ASC").Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// SyncPosition recalculates the stock's position from its ledger and stores
+// the derived quantity and average cost on the stock (without saving it)
+func (s *LedgerService) SyncPosition(stock *models.Stock) (Position, error) {
+	transactions, err := s.GetTransactions(stock.ID)
+	if err != nil {
+		return Position{}, err
+	}
+
+	pos, err := CalculatePosition(transactions)
+	if err != nil {
+		return Position{}, err
+	}
+
+	stock.SharesOwned = pos.Quantity
+	stock.AvgPriceLocal = pos.AvgPriceLocal
+	return pos, nil
+}
+
+// OpeningBalance builds the opening-balance transaction for a stock's current holding
+func OpeningBalance(stock *models.Stock, fxRate float64) models.Transaction {
+	tradeDate := stock.CreatedAt
+	if tradeDate.IsZero() {
+		tradeDate = stock.LastUpdated
+	}
+
+	return models.Transaction{
+		StockID:   stock.ID,
+		Type:      models.TransactionTypeBuy,
+		TradeDate: tradeDate,
+		Quantity:  stock.SharesOwned,
+		Price:     stock.AvgPriceLocal,
+		Currency:  stock.Currency,
+		FXRate:    fxRate,
+		Source:    "opening_balance",
+		Note:      "Opening balance",
+	}
+}
@@ -0,0 +1,574 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Market data providers
+const (
+	MarketDataAlphaVantage = "alphavantage" // Alpha Vantage quote, overview and daily series
+	MarketDataGrok         = "grok"         // xAI Grok analysis
+	MarketDataFile         = "file"         // CSV/JSON files in MARKET_DATA_DIR
+)
+
+// marketDataLabels are the display names written to Stock.DataSource
+var marketDataLabels = map[string]string{
+	MarketDataAlphaVantage: "Alpha Vantage",
+	MarketDataGrok:         "Grok AI",
+	MarketDataFile:         "Local files",
+}
+
+var (
+	// ErrMarketDataNotConfigured is returned by providers that lack credentials or files; they are skipped silently
+	ErrMarketDataNotConfigured = errors.New("market data provider not configured")
+	// ErrMarketDataUnsupported is returned by providers that do not offer the requested kind of data
+	ErrMarketDataUnsupported = errors.New("not supported by market data provider")
+	// ErrNoMarketData is returned when no provider in the priority list supplied a quote
+	ErrNoMarketData = errors.New("no market data provider returned a quote")
+	// ErrUnknownMarketDataProvider is returned for priority lists naming unregistered providers
+	ErrUnknownMarketDataProvider = errors.New("unknown market data provider")
+)
+
+// MarketQuote is the latest price of a stock in its local currency
+type MarketQuote struct {
+	Price         float64   `json:"price"`
+	Open          float64   `json:"open,omitempty"`
+	High          float64   `json:"high,omitempty"`
+	Low           float64   `json:"low,omitempty"`
+	PreviousClose float64   `json:"previous_close,omitempty"`
+	Volume        float64   `json:"volume,omitempty"`
+	ChangePercent float64   `json:"change_percent,omitempty"`
+	AsOf          time.Time `json:"as_of"`
+	Provider      string    `json:"provider"`
+	Raw           string    `json:"-"` // Provider response, kept on the stock for Alpha Vantage and Grok
+}
+
+// Bar returns the quote as the daily bar of its trading day. Quotes without a
+// trading day or without open, high and low (e.g. Grok estimates) are no bar.
+func (q *MarketQuote) Bar() (DailyClose, bool) {
+	if q.AsOf.IsZero() || q.Open <= 0 || q.High <= 0 || q.Low <= 0 {
+		return DailyClose{}, false
+	}
+	return DailyClose{
+		Date:   SnapshotDay(q.AsOf),
+		Open:   q.Open,
+		High:   q.High,
+		Low:    q.Low,
+		Close:  q.Price,
+		Volume: q.Volume,
+	}, true
+}
+
+// Fundamentals are the company figures a provider reports. Zero values are not reported.
+type Fundamentals struct {
+	Sector        string  `json:"sector,omitempty"`
+	Beta          float64 `json:"beta,omitempty"`
+	Volatility    float64 `json:"volatility,omitempty"`
+	PERatio       float64 `json:"pe_ratio,omitempty"`
+	EPSGrowthRate float64 `json:"eps_growth_rate,omitempty"`
+	DebtToEBITDA  float64 `json:"debt_to_ebitda,omitempty"`
+	DividendYield float64 `json:"dividend_yield,omitempty"`
+	Provider      string  `json:"provider"`
+	Raw           string  `json:"-"`
+}
+
+// AnalystTarget is a consensus fair value. Providers that assess the stock themselves
+// may also supply p and the downside; zero values leave the stock's own inputs in place.
+type AnalystTarget struct {
+	FairValue           float64 `json:"fair_value"`
+	Source              string  `json:"source"` // e.g. "Alpha Vantage Consensus, Nov 5, 2025"
+	ProbabilityPositive float64 `json:"probability_positive,omitempty"`
+	DownsideRisk        float64 `json:"downside_risk,omitempty"`
+	Provider            string  `json:"provider"`
+	Raw                 string  `json:"-"`
+}
+
+// MarketDataProvider supplies quotes, fundamentals, analyst targets and price history
+type MarketDataProvider interface {
+	Name() string
+	Quote(stock *models.Stock) (*MarketQuote, error)
+	Fundamentals(stock *models.Stock) (*Fundamentals, error)
+	AnalystTarget(stock *models.Stock) (*AnalystTarget, error)
+	// History returns daily bars, oldest first; full asks for the complete history
+	History(stock *models.Stock, full bool) ([]DailyClose, error)
+}
+
+// MarketDataUpdate records which provider supplied each part of a stock update
+type MarketDataUpdate struct {
+	Quote         string      `json:"quote"`
+	Fundamentals  string      `json:"fundamentals,omitempty"`
+	AnalystTarget string      `json:"analyst_target,omitempty"`
+	Errors        []string    `json:"errors,omitempty"` // Failures of providers that were tried
+	QuoteBar      *DailyClose `json:"-"`                // The quote's daily bar, stored with PriceService.RecordQuote
+}
+
+// MarketDataService holds the registered providers and resolves each stock's priority list
+type MarketDataService struct {
+	db           *gorm.DB
+	providers    map[string]MarketDataProvider
+	defaultOrder []string
+}
+
+// NewMarketDataService registers the Alpha Vantage, Grok and file providers.
+// The default order comes from MARKET_DATA_PROVIDERS.
+func NewMarketDataService(db *gorm.DB, cfg *config.Config, apiService *ExternalAPIService) *MarketDataService {
+	s := &MarketDataService{
+		db:        db,
+		providers: make(map[string]MarketDataProvider),
+	}
+	s.Register(&alphaVantageProvider{api: apiService})
+	s.Register(&grokProvider{api: apiService, strategy: s.Strategy})
+	s.Register(&fileMarketDataProvider{dir: cfg.MarketDataDir})
+	s.defaultOrder = ParseProviderList(cfg.MarketDataProviders)
+	return s
+}
+
+// Strategy returns the active strategy profile
+func (s *MarketDataService) Strategy() (*models.StrategyProfile, error) {
+	return NewStrategyService(s.db).Active()
+}
+
+// Register adds a provider, replacing one with the same name
+func (s *MarketDataService) Register(provider MarketDataProvider) {
+	s.providers[provider.Name()] = provider
+}
+
+// Provider returns a registered provider by name
+func (s *MarketDataService) Provider(name string) (MarketDataProvider, bool) {
+	provider, ok := s.providers[name]
+	return provider, ok
+}
+
+// DefaultOrder returns the provider priority used when neither stock nor portfolio set one
+func (s *MarketDataService) DefaultOrder() []string {
+	return s.defaultOrder
+}
+
+// ParseProviderList splits a comma-separated priority list, dropping blanks and duplicates
+func ParseProviderList(list string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(list, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// ValidateOrder normalizes a priority list and checks that every provider is registered
+func (s *MarketDataService) ValidateOrder(list string) (string, error) {
+	names := ParseProviderList(list)
+	for _, name := range names {
+		if _, ok := s.providers[name]; !ok {
+			return "", fmt.Errorf("%w: %s", ErrUnknownMarketDataProvider, name)
+		}
+	}
+	return strings.Join(names, ","), nil
+}
+
+// Order returns the stock's provider priority: its own list, else the portfolio's, else the default
+func (s *MarketDataService) Order(stock *models.Stock) []string {
+	if order := ParseProviderList(stock.DataProviders); len(order) > 0 {
+		return order
+	}
+	return s.PortfolioOrder()
+}
+
+// PortfolioOrder returns the portfolio's provider priority, else the default
+func (s *MarketDataService) PortfolioOrder() []string {
+	var settings models.PortfolioSettings
+	if err := s.db.First(&settings).Error; err == nil {
+		if order := ParseProviderList(settings.DataProviders); len(order) > 0 {
+			return order
+		}
+	}
+	return s.defaultOrder
+}
+
+// Update fetches the stock's quote, fundamentals and analyst target. Each part comes from
+// the first provider in order that supplies it; derived metrics are recalculated afterwards.
+// The stock is left unchanged if no provider returns a quote.
+func (s *MarketDataService) Update(stock *models.Stock, order []string) (MarketDataUpdate, error) {
+	var update MarketDataUpdate
+	var errs []error
+	providers := s.resolve(order)
+
+	strategy, err := s.Strategy()
+	if err != nil {
+		return update, err
+	}
+	if err := NewScenarioService(s.db).Load(stock); err != nil {
+		return update, err
+	}
+
+	record := func(name string, err error) {
+		if err != nil && !errors.Is(err, ErrMarketDataNotConfigured) && !errors.Is(err, ErrMarketDataUnsupported) {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			update.Errors = append(update.Errors, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	var quote *MarketQuote
+	for _, provider := range providers {
+		q, err := provider.Quote(stock)
+		if err == nil && q.Price > 0 {
+			quote = q
+			break
+		}
+		record(provider.Name(), err)
+	}
+	if quote == nil {
+		return update, errors.Join(append([]error{ErrNoMarketData}, errs...)...)
+	}
+
+	now := time.Now()
+	stock.CurrentPrice = quote.Price
+	stock.PriceUpdatedAt = &now
+	update.Quote = quote.Provider
+	if bar, ok := quote.Bar(); ok {
+		update.QuoteBar = &bar
+	}
+	recordRawResponse(stock, quote.Provider, quote.Raw, now)
+
+	for _, provider := range providers {
+		f, err := provider.Fundamentals(stock)
+		if err != nil {
+			record(provider.Name(), err)
+			continue
+		}
+		applyFundamentals(stock, f)
+		update.Fundamentals = f.Provider
+		recordRawResponse(stock, f.Provider, f.Raw, now)
+		break
+	}
+
+	for _, provider := range providers {
+		t, err := provider.AnalystTarget(stock)
+		if err == nil && t.FairValue > 0 {
+			stock.FairValue = t.FairValue
+			stock.FairValueSource = t.Source
+			if t.ProbabilityPositive > 0 {
+				stock.ProbabilityPositive = t.ProbabilityPositive
+				stock.ProbabilitySource = InputSourceProvider
+			}
+			if t.DownsideRisk < 0 {
+				stock.DownsideRisk = t.DownsideRisk
+				stock.DownsideSource = InputSourceProvider
+			}
+			update.AnalystTarget = t.Provider
+			recordRawResponse(stock, t.Provider, t.Raw, now)
+			break
+		}
+		record(provider.Name(), err)
+	}
+
+	stock.PriceProvider = update.Quote
+	stock.FundamentalsProvider = update.Fundamentals
+	stock.FairValueProvider = update.AnalystTarget
+	stock.DataSource = update.Label()
+
+	CalculateMetrics(stock, strategy)
+	stock.LastUpdated = now
+
+	return update, nil
+}
+
+// History returns the daily bars of the first provider in order that has them
+func (s *MarketDataService) History(stock *models.Stock, order []string, full bool) ([]DailyClose, string, error) {
+	var errs []error
+	for _, provider := range s.resolve(order) {
+		closes, err := provider.History(stock, full)
+		if err == nil && len(closes) > 0 {
+			return closes, provider.Name(), nil
+		}
+		if err != nil && !errors.Is(err, ErrMarketDataNotConfigured) && !errors.Is(err, ErrMarketDataUnsupported) {
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("no market data provider has a price history for %s", stock.Ticker)
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// resolve maps provider names to registered providers, skipping unknown names
+func (s *MarketDataService) resolve(order []string) []MarketDataProvider {
+	providers := make([]MarketDataProvider, 0, len(order))
+	for _, name := range order {
+		if provider, ok := s.providers[name]; ok {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// Label returns the display names of the providers used, for Stock.DataSource
+func (u MarketDataUpdate) Label() string {
+	var labels []string
+	seen := make(map[string]bool)
+	for _, name := range []string{u.Quote, u.Fundamentals, u.AnalystTarget} {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		label := marketDataLabels[name]
+		if label == "" {
+			label = name
+		}
+		labels = append(labels, label)
+	}
+	return strings.Join(labels, " + ")
+}
+
+// applyFundamentals copies the reported (non-zero) fundamentals onto the stock
+func applyFundamentals(stock *models.Stock, f *Fundamentals) {
+	if f.Sector != "" {
+		stock.Sector = f.Sector
+	}
+	applyProviderRisk(stock, f)
+	if f.PERatio != 0 {
+		stock.PERatio = f.PERatio
+	}
+	if f.EPSGrowthRate != 0 {
+		stock.EPSGrowthRate = f.EPSGrowthRate
+	}
+	if f.DebtToEBITDA != 0 {
+		stock.DebtToEBITDA = f.DebtToEBITDA
+	}
+	if f.DividendYield != 0 {
+		stock.DividendYield = f.DividendYield
+	}
+}
+
+// recordRawResponse keeps the Alpha Vantage and Grok responses and fetch times on the stock
+func recordRawResponse(stock *models.Stock, provider, raw string, at time.Time) {
+	switch provider {
+	case MarketDataAlphaVantage:
+		stock.AlphaVantageFetchedAt = &at
+		if raw != "" {
+			stock.AlphaVantageRawJSON = raw
+		}
+	case MarketDataGrok:
+		stock.GrokFetchedAt = &at
+		if raw != "" {
+			stock.GrokRawJSON = raw
+		}
+	}
+}
+
+// responseCacheTTL is how long a provider reuses one API response for the different
+// parts of an update (Alpha Vantage's overview, Grok's analysis)
+const responseCacheTTL = 5 * time.Minute
+
+// responseCache remembers the last response per ticker
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	value     interface{}
+	fetchedAt time.Time
+}
+
+func (c *responseCache) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && time.Since(entry.fetchedAt) < responseCacheTTL {
+		return entry.value, nil
+	}
+	value, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]cachedResponse)
+	}
+	c.entries[key] = cachedResponse{value: value, fetchedAt: time.Now()}
+	return value, nil
+}
+
+// alphaVantageProvider serves GLOBAL_QUOTE, OVERVIEW and TIME_SERIES_DAILY
+type alphaVantageProvider struct {
+	api       *ExternalAPIService
+	overviews responseCache
+}
+
+func (p *alphaVantageProvider) Name() string { return MarketDataAlphaVantage }
+
+func (p *alphaVantageProvider) configured() bool { return p.api.cfg.AlphaVantageAPIKey != "" }
+
+func (p *alphaVantageProvider) Quote(stock *models.Stock) (*MarketQuote, error) {
+	if !p.configured() {
+		return nil, ErrMarketDataNotConfigured
+	}
+	quote, err := p.api.FetchAlphaVantageQuote(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := json.MarshalIndent(map[string]interface{}{"quote": quote}, "", "  ")
+	q := quote.GlobalQuote
+	asOf, _ := time.Parse("2006-01-02", q.LatestTradingDay)
+	return &MarketQuote{
+		Price:         parseFloat(q.Price),
+		Open:          parseFloat(q.Open),
+		High:          parseFloat(q.High),
+		Low:           parseFloat(q.Low),
+		PreviousClose: parseFloat(q.PreviousClose),
+		Volume:        parseFloat(q.Volume),
+		ChangePercent: parseFloat(strings.TrimSuffix(q.ChangePercent, "%")),
+		AsOf:          asOf,
+		Provider:      MarketDataAlphaVantage,
+		Raw:           string(raw),
+	}, nil
+}
+
+func (p *alphaVantageProvider) overview(ticker string) (*AlphaVantageOverview, error) {
+	if !p.configured() {
+		return nil, ErrMarketDataNotConfigured
+	}
+	value, err := p.overviews.get(ticker, func() (interface{}, error) {
+		return p.api.FetchAlphaVantageOverview(ticker)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*AlphaVantageOverview), nil
+}
+
+func (p *alphaVantageProvider) Fundamentals(stock *models.Stock) (*Fundamentals, error) {
+	overview, err := p.overview(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := json.MarshalIndent(map[string]interface{}{"overview": overview}, "", "  ")
+	return &Fundamentals{
+		Sector:        overview.Sector,
+		Beta:          parseFloat(overview.Beta),
+		PERatio:       parseFloat(overview.PERatio),
+		EPSGrowthRate: parseFloat(overview.QuarterlyEarningsGrowthYOY) * 100,
+		DividendYield: parseFloat(overview.DividendYield),
+		Provider:      MarketDataAlphaVantage,
+		Raw:           string(raw),
+	}, nil
+}
+
+func (p *alphaVantageProvider) AnalystTarget(stock *models.Stock) (*AnalystTarget, error) {
+	overview, err := p.overview(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	fairValue := parseFloat(overview.AnalystTargetPrice)
+	if fairValue <= 0 {
+		return nil, fmt.Errorf("no analyst target price for %s", stock.Ticker)
+	}
+	return &AnalystTarget{
+		FairValue: fairValue,
+		Source:    fmt.Sprintf("Alpha Vantage Consensus, %s", time.Now().Format("Jan 2, 2006")),
+		Provider:  MarketDataAlphaVantage,
+	}, nil
+}
+
+func (p *alphaVantageProvider) History(stock *models.Stock, full bool) ([]DailyClose, error) {
+	if !p.configured() {
+		return nil, ErrMarketDataNotConfigured
+	}
+	return p.api.FetchAlphaVantageDailySeries(stock.Ticker, full)
+}
+
+// grokProvider serves all parts of an update from one Grok analysis
+type grokProvider struct {
+	api      *ExternalAPIService
+	strategy func() (*models.StrategyProfile, error)
+	analyses responseCache
+}
+
+type grokAnalysis struct {
+	data *GrokStockData
+	raw  string
+}
+
+func (p *grokProvider) Name() string { return MarketDataGrok }
+
+func (p *grokProvider) analysis(stock *models.Stock) (grokAnalysis, error) {
+	if p.api.cfg.XAIAPIKey == "" {
+		return grokAnalysis{}, ErrMarketDataNotConfigured
+	}
+	value, err := p.analyses.get(stock.Ticker+"|"+stock.ISIN, func() (interface{}, error) {
+		strategy, err := p.strategy()
+		if err != nil {
+			return nil, err
+		}
+		data, raw, err := p.api.FetchGrokAnalysis(stock, strategy)
+		if err != nil {
+			return nil, err
+		}
+		return grokAnalysis{data: data, raw: raw}, nil
+	})
+	if err != nil {
+		return grokAnalysis{}, err
+	}
+	return value.(grokAnalysis), nil
+}
+
+func (p *grokProvider) Quote(stock *models.Stock) (*MarketQuote, error) {
+	a, err := p.analysis(stock)
+	if err != nil {
+		return nil, err
+	}
+	return &MarketQuote{Price: a.data.CurrentPrice, AsOf: time.Now(), Provider: MarketDataGrok, Raw: a.raw}, nil
+}
+
+func (p *grokProvider) Fundamentals(stock *models.Stock) (*Fundamentals, error) {
+	a, err := p.analysis(stock)
+	if err != nil {
+		return nil, err
+	}
+	return &Fundamentals{
+		Sector:        a.data.Sector,
+		Beta:          a.data.Beta,
+		Volatility:    a.data.Volatility,
+		PERatio:       a.data.PERatio,
+		EPSGrowthRate: a.data.EPSGrowthRate,
+		DebtToEBITDA:  a.data.DebtToEBITDA,
+		DividendYield: a.data.DividendYield,
+		Provider:      MarketDataGrok,
+		Raw:           a.raw,
+	}, nil
+}
+
+func (p *grokProvider) AnalystTarget(stock *models.Stock) (*AnalystTarget, error) {
+	a, err := p.analysis(stock)
+	if err != nil {
+		return nil, err
+	}
+	source := a.data.FairValueSource
+	if source == "" {
+		source = fmt.Sprintf("Grok AI Analysis, %s", time.Now().Format("Jan 2, 2006"))
+	}
+	return &AnalystTarget{
+		FairValue:           a.data.FairValue,
+		Source:              source,
+		ProbabilityPositive: a.data.ProbabilityPositive,
+		DownsideRisk:        a.data.DownsideRisk,
+		Provider:            MarketDataGrok,
+		Raw:                 a.raw,
+	}, nil
+}
+
+func (p *grokProvider) History(stock *models.Stock, full bool) ([]DailyClose, error) {
+	return nil, ErrMarketDataUnsupported
+}
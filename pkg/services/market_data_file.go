@@ -0,0 +1,246 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// fileMarketDataProvider reads market data from a local directory for offline use:
+//
+//	<dir>/<TICKER>.json          one stock: quote, fundamentals, target and optional "history"
+//	<dir>/quotes.csv             one row per stock, same column names as the JSON fields
+//	<dir>/history/<TICKER>.csv   daily bars (date, close, optional open/high/low/volume), as accepted for price imports
+//
+// A ticker's JSON file takes precedence over its quotes.csv row.
+type fileMarketDataProvider struct {
+	dir string
+}
+
+// marketDataFileRecord is the layout of a ticker's JSON file and of a quotes.csv row
+type marketDataFileRecord struct {
+	Ticker              string  `json:"ticker"`
+	Price               float64 `json:"price"`
+	Open                float64 `json:"open"`
+	High                float64 `json:"high"`
+	Low                 float64 `json:"low"`
+	PreviousClose       float64 `json:"previous_close"`
+	Volume              float64 `json:"volume"`
+	Date                string  `json:"date"` // Quote date, YYYY-MM-DD
+	FairValue           float64 `json:"fair_value"`
+	FairValueSource     string  `json:"fair_value_source"`
+	ProbabilityPositive float64 `json:"probability_positive"`
+	DownsideRisk        float64 `json:"downside_risk"`
+	Sector              string  `json:"sector"`
+	Beta                float64 `json:"beta"`
+	Volatility          float64 `json:"volatility"`
+	PERatio             float64 `json:"pe_ratio"`
+	EPSGrowthRate       float64 `json:"eps_growth_rate"`
+	DebtToEBITDA        float64 `json:"debt_to_ebitda"`
+	DividendYield       float64 `json:"dividend_yield"`
+	History             []struct {
+		Date   string  `json:"date"`
+		Open   float64 `json:"open"`
+		High   float64 `json:"high"`
+		Low    float64 `json:"low"`
+		Close  float64 `json:"close"`
+		Volume float64 `json:"volume"`
+	} `json:"history"`
+}
+
+func (p *fileMarketDataProvider) Name() string { return MarketDataFile }
+
+func (p *fileMarketDataProvider) tickerFile(parts ...string) string {
+	return filepath.Join(append([]string{p.dir}, parts...)...)
+}
+
+// marketDataFileName is the file name of a ticker; path separators in tickers are replaced
+func marketDataFileName(ticker string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(strings.ToUpper(ticker))
+}
+
+// record finds the stock in <TICKER>.json or quotes.csv
+func (p *fileMarketDataProvider) record(ticker string) (*marketDataFileRecord, error) {
+	if p.dir == "" {
+		return nil, ErrMarketDataNotConfigured
+	}
+
+	raw, err := os.ReadFile(p.tickerFile(marketDataFileName(ticker) + ".json"))
+	if err == nil {
+		var record marketDataFileRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, fmt.Errorf("invalid market data file for %s: %w", ticker, err)
+		}
+		return &record, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.Open(p.tickerFile("quotes.csv"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no market data file for %s", ticker)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := readRecords(file, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("no market data for %s in quotes.csv", ticker)
+	}
+	cols := headerIndex(records[0])
+	if _, ok := cols["ticker"]; !ok {
+		return nil, fmt.Errorf("%w: quotes.csv has no ticker column", ErrInvalidImport)
+	}
+	for i, row := range records[1:] {
+		if !strings.EqualFold(field(row, cols, "ticker"), ticker) {
+			continue
+		}
+		record := marketDataFileRecord{
+			Ticker:          ticker,
+			Date:            field(row, cols, "date"),
+			FairValueSource: field(row, cols, "fair_value_source"),
+			Sector:          field(row, cols, "sector"),
+		}
+		for name, target := range map[string]*float64{
+			"price":                &record.Price,
+			"open":                 &record.Open,
+			"high":                 &record.High,
+			"low":                  &record.Low,
+			"previous_close":       &record.PreviousClose,
+			"volume":               &record.Volume,
+			"fair_value":           &record.FairValue,
+			"probability_positive": &record.ProbabilityPositive,
+			"downside_risk":        &record.DownsideRisk,
+			"beta":                 &record.Beta,
+			"volatility":           &record.Volatility,
+			"pe_ratio":             &record.PERatio,
+			"eps_growth_rate":      &record.EPSGrowthRate,
+			"debt_to_ebitda":       &record.DebtToEBITDA,
+			"dividend_yield":       &record.DividendYield,
+		} {
+			value := field(row, cols, name)
+			if value == "" {
+				continue
+			}
+			number, err := parseNumber(value, false)
+			if err != nil {
+				return nil, rowError(i+1, name, err)
+			}
+			*target = number
+		}
+		return &record, nil
+	}
+	return nil, fmt.Errorf("no market data for %s in quotes.csv", ticker)
+}
+
+func (p *fileMarketDataProvider) Quote(stock *models.Stock) (*MarketQuote, error) {
+	record, err := p.record(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	if record.Price <= 0 {
+		return nil, fmt.Errorf("no price for %s in market data files", stock.Ticker)
+	}
+	asOf := time.Now()
+	if record.Date != "" {
+		if asOf, err = parseDate(record.Date, ""); err != nil {
+			return nil, fmt.Errorf("invalid quote date for %s: %w", stock.Ticker, err)
+		}
+	}
+	return &MarketQuote{
+		Price:         record.Price,
+		Open:          record.Open,
+		High:          record.High,
+		Low:           record.Low,
+		PreviousClose: record.PreviousClose,
+		Volume:        record.Volume,
+		AsOf:          asOf,
+		Provider:      MarketDataFile,
+	}, nil
+}
+
+func (p *fileMarketDataProvider) Fundamentals(stock *models.Stock) (*Fundamentals, error) {
+	record, err := p.record(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	return &Fundamentals{
+		Sector:        record.Sector,
+		Beta:          record.Beta,
+		Volatility:    record.Volatility,
+		PERatio:       record.PERatio,
+		EPSGrowthRate: record.EPSGrowthRate,
+		DebtToEBITDA:  record.DebtToEBITDA,
+		DividendYield: record.DividendYield,
+		Provider:      MarketDataFile,
+	}, nil
+}
+
+func (p *fileMarketDataProvider) AnalystTarget(stock *models.Stock) (*AnalystTarget, error) {
+	record, err := p.record(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	if record.FairValue <= 0 {
+		return nil, fmt.Errorf("no fair value for %s in market data files", stock.Ticker)
+	}
+	source := record.FairValueSource
+	if source == "" {
+		source = fmt.Sprintf("Local file, %s", time.Now().Format("Jan 2, 2006"))
+	}
+	return &AnalystTarget{
+		FairValue:           record.FairValue,
+		Source:              source,
+		ProbabilityPositive: record.ProbabilityPositive,
+		DownsideRisk:        record.DownsideRisk,
+		Provider:            MarketDataFile,
+	}, nil
+}
+
+func (p *fileMarketDataProvider) History(stock *models.Stock, full bool) ([]DailyClose, error) {
+	if p.dir == "" {
+		return nil, ErrMarketDataNotConfigured
+	}
+
+	file, err := os.Open(p.tickerFile("history", marketDataFileName(stock.Ticker)+".csv"))
+	if err == nil {
+		defer file.Close()
+		return ParsePriceCSV(file)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	record, err := p.record(stock.Ticker)
+	if err != nil {
+		return nil, err
+	}
+	if len(record.History) == 0 {
+		return nil, fmt.Errorf("no price history for %s in market data files", stock.Ticker)
+	}
+	closes := make([]DailyClose, 0, len(record.History))
+	for _, bar := range record.History {
+		date, err := parseDate(bar.Date, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid history date for %s: %w", stock.Ticker, err)
+		}
+		if bar.Close > 0 {
+			closes = append(closes, DailyClose{Date: date, Open: bar.Open, High: bar.High, Low: bar.Low, Close: bar.Close, Volume: bar.Volume})
+		}
+	}
+	sort.Slice(closes, func(i, j int) bool { return closes[i].Date.Before(closes[j].Date) })
+	return closes, nil
+}
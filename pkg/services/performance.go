@@ -0,0 +1,449 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// Performance periods
+const (
+	PeriodMTD       = "mtd"
+	PeriodYTD       = "ytd"
+	PeriodOneYear   = "1y"
+	PeriodInception = "inception"
+	PeriodCustom    = "custom"
+)
+
+// ErrNoXIRRSolution is returned when the cash flows have no internal rate of return
+var ErrNoXIRRSolution = errors.New("no XIRR solution")
+
+// DatedFlow is a cash flow from the investor's point of view: negative when money
+// goes into the portfolio, positive when it comes out
+type DatedFlow struct {
+	Date   time.Time
+	Amount float64
+}
+
+// PeriodReturn holds time- and money-weighted returns for one period. Returns are percentages.
+type PeriodReturn struct {
+	Period           string    `json:"period"`
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	StartValue       float64   `json:"start_value"`
+	EndValue         float64   `json:"end_value"`
+	NetContributions float64   `json:"net_contributions"` // Purchases and fees minus sale proceeds
+	Income           float64   `json:"income"`            // Net dividends
+	Gain             float64   `json:"gain"`              // End - start - contributions + income
+	TWR              float64   `json:"twr"`
+	TWRAnnualized    *float64  `json:"twr_annualized,omitempty"` // Only for periods of a year or more
+	XIRR             *float64  `json:"xirr"`                     // Annualized money-weighted return
+	MWR              *float64  `json:"mwr"`                      // XIRR de-annualized to the period
+}
+
+// PositionReturn is the performance of a single position
+type PositionReturn struct {
+	StockID  uint         `json:"stock_id"`
+	Ticker   string       `json:"ticker"`
+	Currency string       `json:"currency"`
+	EUR      PeriodReturn `json:"eur"`   // In EUR
+	Local    PeriodReturn `json:"local"` // In the stock's currency
+}
+
+// PerformanceInput is everything the performance calculation reads
+type PerformanceInput struct {
+	Stocks       []models.Stock
+	Transactions map[uint][]models.Transaction
+	Dividends    map[uint][]models.Dividend
+	History      map[uint][]models.StockHistory
+	CurrentRates map[string]float64 // Units per EUR, used for today's valuation
+	RateHistory  []models.ExchangeRateHistory
+	Now          time.Time
+}
+
+// RateFunc returns the rate (units per EUR) of a currency on a date
+type RateFunc func(currency string, date time.Time) float64
+
+// PerformanceCalculator computes TWR and XIRR from the ledger, dividends and price history
+type PerformanceCalculator struct {
+	input  PerformanceInput
+	today  time.Time
+	prices map[uint][]pricePoint
+	rates  map[string][]ratePoint
+}
+
+type pricePoint struct {
+	date    time.Time
+	price   float64
+	history bool // Recorded close rather than a trade price
+}
+
+type ratePoint struct {
+	date time.Time
+	rate float64
+}
+
+// NewPerformanceCalculator indexes prices and FX rates for fast lookups
+func NewPerformanceCalculator(input PerformanceInput) *PerformanceCalculator {
+	if input.Now.IsZero() {
+		input.Now = time.Now()
+	}
+	calc := &PerformanceCalculator{
+		input:  input,
+		today:  SnapshotDay(input.Now),
+		prices: make(map[uint][]pricePoint),
+		rates:  make(map[string][]ratePoint),
+	}
+
+	// Recorded rates go first so that a rate booked on a trade wins on the same day
+	for _, entry := range input.RateHistory {
+		if entry.Rate > 0 {
+			calc.rates[entry.CurrencyCode] = append(calc.rates[entry.CurrencyCode], ratePoint{date: SnapshotDay(entry.RecordedAt), rate: entry.Rate})
+		}
+	}
+
+	for _, stock := range input.Stocks {
+		var points []pricePoint
+		for _, h := range input.History[stock.ID] {
+			if h.CurrentPrice > 0 {
+				points = append(points, pricePoint{date: SnapshotDay(h.RecordedAt), price: h.CurrentPrice, history: true})
+			}
+		}
+		for _, tx := range input.Transactions[stock.ID] {
+			if tx.Type != models.TransactionTypeFee && tx.Price > 0 {
+				points = append(points, pricePoint{date: SnapshotDay(tx.TradeDate), price: tx.Price})
+			}
+			if tx.FXRate > 0 {
+				calc.rates[tx.Currency] = append(calc.rates[tx.Currency], ratePoint{date: SnapshotDay(tx.TradeDate), rate: tx.FXRate})
+			}
+		}
+		for _, d := range input.Dividends[stock.ID] {
+			if d.FXRate > 0 {
+				calc.rates[d.Currency] = append(calc.rates[d.Currency], ratePoint{date: SnapshotDay(d.PayDate), rate: d.FXRate})
+			}
+		}
+		// On the same day a recorded close wins over a trade price
+		sort.SliceStable(points, func(i, j int) bool {
+			if !points[i].date.Equal(points[j].date) {
+				return points[i].date.Before(points[j].date)
+			}
+			return !points[i].history && points[j].history
+		})
+		calc.prices[stock.ID] = points
+	}
+	for currency := range calc.rates {
+		points := calc.rates[currency]
+		sort.SliceStable(points, func(i, j int) bool { return points[i].date.Before(points[j].date) })
+	}
+
+	return calc
+}
+
+// PeriodRange returns the date range of a named period ending today
+func (p *PerformanceCalculator) PeriodRange(period string) (time.Time, time.Time) {
+	to := p.today
+	switch period {
+	case PeriodMTD:
+		return time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC), to
+	case PeriodYTD:
+		return time.Date(to.Year(), 1, 1, 0, 0, 0, 0, time.UTC), to
+	case PeriodOneYear:
+		return to.AddDate(-1, 0, 0), to
+	}
+	return p.Inception(), to
+}
+
+// Inception returns the date of the first transaction, or today if there is none
+func (p *PerformanceCalculator) Inception() time.Time {
+	first := p.today
+	for _, transactions := range p.input.Transactions {
+		for _, tx := range transactions {
+			if day := SnapshotDay(tx.TradeDate); day.Before(first) {
+				first = day
+			}
+		}
+	}
+	return first
+}
+
+// Portfolio returns the performance of all positions together in EUR
+func (p *PerformanceCalculator) Portfolio(period string, from, to time.Time) PeriodReturn {
+	return p.compute(period, p.input.Stocks, from, to, true)
+}
+
+// Positions returns the performance of every position that was held during the period
+func (p *PerformanceCalculator) Positions(period string, from, to time.Time) []PositionReturn {
+	positions := []PositionReturn{}
+	for _, stock := range p.input.Stocks {
+		if len(p.input.Transactions[stock.ID]) == 0 {
+			continue
+		}
+		base := p.compute(period, []models.Stock{stock}, from, to, true)
+		if base.StartValue == 0 && base.EndValue == 0 && base.NetContributions == 0 && base.Income == 0 {
+			continue
+		}
+		positions = append(positions, PositionReturn{
+			StockID:  stock.ID,
+			Ticker:   stock.Ticker,
+			Currency: stock.Currency,
+			EUR:      base,
+			Local:    p.compute(period, []models.Stock{stock}, from, to, false),
+		})
+	}
+	return positions
+}
+
+// RateAt returns the FX rate of a currency on a date: today's rate for today,
+// otherwise the latest recorded or booked rate on or before the date
+func (p *PerformanceCalculator) RateAt(currency string, date time.Time) float64 {
+	if currency == "EUR" {
+		return 1.0
+	}
+	current := p.input.CurrentRates[currency]
+	if !SnapshotDay(date).Before(p.today) && current > 0 {
+		return current
+	}
+
+	points := p.rates[currency]
+	idx := sort.Search(len(points), func(i int) bool { return points[i].date.After(SnapshotDay(date)) })
+	if idx > 0 {
+		return points[idx-1].rate
+	}
+	if len(points) > 0 {
+		return points[0].rate
+	}
+	if current > 0 {
+		return current
+	}
+	return 1.0
+}
+
+// priceAt returns the stock's price on a date: the current price for today,
+// otherwise the latest recorded close or trade price on or before the date
+func (p *PerformanceCalculator) priceAt(stock models.Stock, date time.Time) float64 {
+	day := SnapshotDay(date)
+	if !day.Before(p.today) && stock.CurrentPrice > 0 {
+		return stock.CurrentPrice
+	}
+
+	points := p.prices[stock.ID]
+	idx := sort.Search(len(points), func(i int) bool { return points[i].date.After(day) })
+	if idx > 0 {
+		return points[idx-1].price
+	}
+	return stock.CurrentPrice
+}
+
+// valueAt values the stocks on a date with CalculatePortfolioMetrics. Trades on the
+// date itself are included only when includeDay is set.
+func (p *PerformanceCalculator) valueAt(stocks []models.Stock, date time.Time, includeDay bool, rate RateFunc) float64 {
+	day := SnapshotDay(date)
+	valued := make([]models.Stock, 0, len(stocks))
+	fxRates := make(map[string]float64)
+
+	for _, stock := range stocks {
+		var eligible []models.Transaction
+		for _, tx := range p.input.Transactions[stock.ID] {
+			txDay := SnapshotDay(tx.TradeDate)
+			if txDay.Before(day) || (includeDay && txDay.Equal(day)) {
+				eligible = append(eligible, tx)
+			}
+		}
+		pos, err := CalculatePosition(eligible)
+		if err != nil || pos.Quantity <= 0 {
+			continue
+		}
+
+		stock.SharesOwned = pos.Quantity
+		stock.CurrentPrice = p.priceAt(stock, day)
+		valued = append(valued, stock)
+		fxRates[stock.Currency] = rate(stock.Currency, day)
+	}
+
+	return CalculatePortfolioMetrics(valued, fxRates, nil).TotalValue
+}
+
+// compute runs the TWR chain and XIRR for the stocks over [from, to], in EUR when
+// base is set and otherwise in the stocks' own currency
+func (p *PerformanceCalculator) compute(period string, stocks []models.Stock, from, to time.Time, base bool) PeriodReturn {
+	rate := RateFunc(func(string, time.Time) float64 { return 1.0 })
+	if base {
+		rate = p.RateAt
+	}
+	// Flows are converted at the rate they were actually booked at
+	booked := func(currency string, day time.Time, fxRate float64) float64 {
+		if base && fxRate > 0 {
+			return fxRate
+		}
+		return rate(currency, day)
+	}
+
+	from, to = SnapshotDay(from), SnapshotDay(to)
+	result := PeriodReturn{Period: period, From: from, To: to}
+
+	// Contributions and income per day, in the valuation currency
+	contributions := make(map[time.Time]float64)
+	income := make(map[time.Time]float64)
+	for _, stock := range stocks {
+		for _, tx := range p.input.Transactions[stock.ID] {
+			day := SnapshotDay(tx.TradeDate)
+			if day.Before(from) || day.After(to) {
+				continue
+			}
+			fx := booked(tx.Currency, day, tx.FXRate)
+			switch tx.Type {
+			case models.TransactionTypeBuy:
+				contributions[day] += (tx.Quantity*tx.Price + tx.Fees) / fx
+			case models.TransactionTypeSell:
+				contributions[day] -= (tx.Quantity*tx.Price - tx.Fees) / fx
+			case models.TransactionTypeFee:
+				contributions[day] += tx.Fees / fx
+			}
+		}
+		for _, d := range p.input.Dividends[stock.ID] {
+			day := SnapshotDay(d.PayDate)
+			if day.Before(from) || day.After(to) {
+				continue
+			}
+			fx := booked(d.Currency, day, d.FXRate)
+			income[day] += d.NetAmount / fx
+		}
+	}
+
+	days := make([]time.Time, 0, len(contributions)+len(income))
+	seen := make(map[time.Time]bool)
+	for _, set := range []map[time.Time]float64{contributions, income} {
+		for day := range set {
+			if !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	result.StartValue = p.valueAt(stocks, from, false, rate)
+	flows := []DatedFlow{}
+	if result.StartValue > 0 {
+		flows = append(flows, DatedFlow{Date: from, Amount: -result.StartValue})
+	}
+
+	// Chain sub-period returns between flow dates; flows happen at the start of their day
+	growth := 1.0
+	previous := result.StartValue
+	for _, day := range days {
+		before := p.valueAt(stocks, day, false, rate)
+		if previous > 0 {
+			growth *= (before + income[day]) / previous
+		}
+		previous = p.valueAt(stocks, day, true, rate)
+
+		result.NetContributions += contributions[day]
+		result.Income += income[day]
+		if net := income[day] - contributions[day]; net != 0 {
+			flows = append(flows, DatedFlow{Date: day, Amount: net})
+		}
+	}
+
+	result.EndValue = p.valueAt(stocks, to, true, rate)
+	if previous > 0 {
+		growth *= result.EndValue / previous
+	}
+	if result.EndValue > 0 {
+		flows = append(flows, DatedFlow{Date: to, Amount: result.EndValue})
+	}
+
+	result.Gain = result.EndValue - result.StartValue - result.NetContributions + result.Income
+	result.TWR = (growth - 1) * 100
+
+	years := to.Sub(from).Hours() / 24 / 365
+	if years >= 1 {
+		annualized := (math.Pow(growth, 1/years) - 1) * 100
+		result.TWRAnnualized = &annualized
+	}
+
+	if xirr, err := XIRR(flows); err == nil {
+		annual := xirr * 100
+		result.XIRR = &annual
+		periodReturn := (math.Pow(1+xirr, years) - 1) * 100
+		result.MWR = &periodReturn
+	}
+
+	return result
+}
+
+// XIRR returns the annualized internal rate of return of irregular cash flows
+func XIRR(flows []DatedFlow) (float64, error) {
+	if len(flows) < 2 {
+		return 0, ErrNoXIRRSolution
+	}
+	hasIn, hasOut := false, false
+	first := flows[0].Date
+	for _, f := range flows {
+		if f.Amount < 0 {
+			hasIn = true
+		} else if f.Amount > 0 {
+			hasOut = true
+		}
+		if f.Date.Before(first) {
+			first = f.Date
+		}
+	}
+	if !hasIn || !hasOut {
+		return 0, ErrNoXIRRSolution
+	}
+
+	npv := func(rate float64) (float64, float64) {
+		var value, derivative float64
+		for _, f := range flows {
+			t := f.Date.Sub(first).Hours() / 24 / 365
+			discount := math.Pow(1+rate, t)
+			value += f.Amount / discount
+			derivative -= t * f.Amount / (discount * (1 + rate))
+		}
+		return value, derivative
+	}
+
+	// Newton's method first, bisection if it does not converge
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		value, derivative := npv(rate)
+		if math.Abs(value) < 1e-7 {
+			return rate, nil
+		}
+		if derivative == 0 {
+			break
+		}
+		next := rate - value/derivative
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < 1e-10 {
+			return next, nil
+		}
+		rate = next
+	}
+
+	low, high := -0.9999, 10.0
+	lowValue, _ := npv(low)
+	highValue, _ := npv(high)
+	if lowValue*highValue > 0 {
+		return 0, ErrNoXIRRSolution
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		midValue, _ := npv(mid)
+		if math.Abs(midValue) < 1e-7 || high-low < 1e-10 {
+			return mid, nil
+		}
+		if lowValue*midValue < 0 {
+			high = mid
+		} else {
+			low, lowValue = mid, midValue
+		}
+	}
+	return (low + high) / 2, nil
+}
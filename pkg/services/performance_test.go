@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestXIRR(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		flows   []DatedFlow
+		want    float64
+		wantErr error
+	}{
+		{"ten percent over a year", []DatedFlow{{date(2025, 1, 1), -1000}, {date(2026, 1, 1), 1100}}, 0.10, nil},
+		{"half lost over a year", []DatedFlow{{date(2025, 1, 1), -1000}, {date(2026, 1, 1), 500}}, -0.50, nil},
+		{"spreadsheet reference vector", []DatedFlow{
+			{date(2008, 1, 1), -10000},
+			{date(2008, 3, 1), 2750},
+			{date(2008, 10, 30), 4250},
+			{date(2009, 2, 15), 3250},
+			{date(2009, 4, 1), 2750},
+		}, 0.373362535, nil},
+		{"unsorted flows", []DatedFlow{{date(2026, 1, 1), 1100}, {date(2025, 1, 1), -1000}}, 0.10, nil},
+		{"single flow", []DatedFlow{{date(2025, 1, 1), -1000}}, 0, ErrNoXIRRSolution},
+		{"only contributions", []DatedFlow{{date(2025, 1, 1), -1000}, {date(2026, 1, 1), -100}}, 0, ErrNoXIRRSolution},
+		// NPV stays negative for every rate, so neither Newton nor bisection converge
+		{"no rate solves the flows", []DatedFlow{{date(2024, 1, 1), -100}, {date(2025, 1, 1), 50}, {date(2026, 1, 1), -100}}, 0, ErrNoXIRRSolution},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := XIRR(tt.flows)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("XIRR = %v, %v; want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("XIRR = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
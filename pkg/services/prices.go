@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Resampling intervals of the stored daily bars
+const (
+	PriceIntervalDaily   = "daily"
+	PriceIntervalWeekly  = "weekly"  // ISO weeks
+	PriceIntervalMonthly = "monthly" // Calendar months
+)
+
+// PriceSourceCSV marks bars imported from a file; downloaded bars record the market data provider
+const PriceSourceCSV = "csv"
+
+// ErrInvalidInterval is returned for unknown resampling intervals
+var ErrInvalidInterval = errors.New("interval must be daily, weekly or monthly")
+
+// PriceService stores and serves the daily price bars of stocks
+type PriceService struct {
+	db         *gorm.DB
+	marketData *MarketDataService
+}
+
+// NewPriceService creates a new price service
+func NewPriceService(db *gorm.DB, marketData *MarketDataService) *PriceService {
+	return &PriceService{db: db, marketData: marketData}
+}
+
+// StoreBars inserts or updates daily bars of a stock; a trading day holds one bar
+func (s *PriceService) StoreBars(stockID uint, source string, bars []DailyClose) (int, error) {
+	if len(bars) == 0 {
+		return 0, nil
+	}
+
+	// Keep the last bar of each day so a batch never conflicts with itself
+	byDay := make(map[time.Time]models.PriceBar, len(bars))
+	for _, b := range bars {
+		day := SnapshotDay(b.Date)
+		byDay[day] = models.PriceBar{
+			StockID: stockID,
+			Date:    day,
+			Open:    b.Open,
+			High:    b.High,
+			Low:     b.Low,
+			Close:   b.Close,
+			Volume:  b.Volume,
+			Source:  source,
+		}
+	}
+	rows := make([]models.PriceBar, 0, len(byDay))
+	for _, bar := range byDay {
+		rows = append(rows, bar)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Date.Before(rows[j].Date) })
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "stock_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume", "source", "updated_at"}),
+	}).CreateInBatches(&rows, 500).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// Backfill downloads the stock's daily series through its market data providers and
+// stores it split-adjusted. It returns the number of bars and the provider used.
+func (s *PriceService) Backfill(stock *models.Stock, full bool) (int, string, error) {
+	bars, provider, err := s.marketData.History(stock, s.marketData.Order(stock), full)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := s.adjustForSplits(stock.ID, provider, bars); err != nil {
+		return 0, "", err
+	}
+	stored, err := s.StoreBars(stock.ID, provider, bars)
+	return stored, provider, err
+}
+
+// RecordQuote stores the bar of the quote that updated the stock, if the provider reported one
+func (s *PriceService) RecordQuote(stock *models.Stock, update MarketDataUpdate) error {
+	if update.QuoteBar == nil || stock.ID == 0 {
+		return nil
+	}
+	bars := []DailyClose{*update.QuoteBar}
+	if err := s.adjustForSplits(stock.ID, update.Quote, bars); err != nil {
+		return err
+	}
+	_, err := s.StoreBars(stock.ID, update.Quote, bars)
+	return err
+}
+
+// adjustForSplits scales provider bars dated before an applied split the way
+// CorporateActionService adjusts the stored bars. Providers report raw prices;
+// imported files are expected to be split-adjusted already, so the file provider's
+// bars are left as they are.
+func (s *PriceService) adjustForSplits(stockID uint, provider string, bars []DailyClose) error {
+	if provider == MarketDataFile {
+		return nil
+	}
+	var actions []models.CorporateAction
+	err := s.db.Where("stock_id = ? AND status = ? AND type IN ?", stockID, "applied",
+		[]string{models.CorporateActionSplit, models.CorporateActionReverseSplit}).Find(&actions).Error
+	if err != nil {
+		return err
+	}
+	for i := range actions {
+		factor, err := splitFactor(&actions[i])
+		if err != nil {
+			continue
+		}
+		for j := range bars {
+			if bars[j].Date.Before(actions[i].EffectiveDate) {
+				scaleBar(&bars[j], factor)
+			}
+		}
+	}
+	return nil
+}
+
+// scaleBar divides the prices of a bar by a split factor and multiplies its volume
+func scaleBar(bar *DailyClose, factor float64) {
+	bar.Open /= factor
+	bar.High /= factor
+	bar.Low /= factor
+	bar.Close /= factor
+	bar.Volume *= factor
+}
+
+// GetBars returns the stored bars of a stock, oldest first. Zero times leave the range open.
+func (s *PriceService) GetBars(stockID uint, from, to time.Time) ([]models.PriceBar, error) {
+	query := s.db.Where("stock_id = ?", stockID).Order("date ASC")
+	if !from.IsZero() {
+		query = query.Where("date >= ?", SnapshotDay(from))
+	}
+	if !to.IsZero() {
+		query = query.Where("date <= ?", SnapshotDay(to))
+	}
+
+	var bars []models.PriceBar
+	if err := query.Find(&bars).Error; err != nil {
+		return nil, err
+	}
+	return bars, nil
+}
+
+// ResampleBars aggregates daily bars (oldest first) into weekly or monthly bars:
+// first open, highest high, lowest low, last close and summed volume. Each bar is
+// dated on the last trading day of its period.
+func ResampleBars(bars []models.PriceBar, interval string) ([]models.PriceBar, error) {
+	var samePeriod func(a, b time.Time) bool
+	switch interval {
+	case "", PriceIntervalDaily:
+		return bars, nil
+	case PriceIntervalWeekly:
+		samePeriod = sameWeek
+	case PriceIntervalMonthly:
+		samePeriod = func(a, b time.Time) bool { return a.Year() == b.Year() && a.Month() == b.Month() }
+	default:
+		return nil, ErrInvalidInterval
+	}
+
+	resampled := make([]models.PriceBar, 0, len(bars))
+	for _, bar := range bars {
+		n := len(resampled)
+		if n == 0 || !samePeriod(resampled[n-1].Date, bar.Date) {
+			bar.ID = 0
+			resampled = append(resampled, bar)
+			continue
+		}
+		period := &resampled[n-1]
+		if period.Open <= 0 {
+			period.Open = bar.Open
+		}
+		if bar.High > period.High {
+			period.High = bar.High
+		}
+		if bar.Low > 0 && (period.Low <= 0 || bar.Low < period.Low) {
+			period.Low = bar.Low
+		}
+		period.Close = bar.Close
+		period.Volume += bar.Volume
+		period.Date = bar.Date
+		period.Source = bar.Source
+		period.UpdatedAt = bar.UpdatedAt
+	}
+	return resampled, nil
+}
+
+// ParsePriceCSV reads a daily price file with a date and a close column and optional
+// open, high, low and volume columns. The close column may be named close, adj close,
+// price or value; when adj close is used, open, high and low are scaled by the same
+// adjustment. Dates use the common import formats.
+func ParsePriceCSV(r io.Reader) ([]DailyClose, error) {
+	records, err := readRecords(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%w: file has no data rows", ErrInvalidImport)
+	}
+
+	header := headerIndex(records[0])
+	dateCol, ok := header["date"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing date column", ErrInvalidImport)
+	}
+	closeCol := -1
+	closeName := ""
+	for _, name := range []string{"adj close", "adj. close", "close", "price", "value"} {
+		if idx, ok := header[name]; ok {
+			closeCol = idx
+			closeName = name
+			break
+		}
+	}
+	if closeCol < 0 {
+		return nil, fmt.Errorf("%w: missing close column", ErrInvalidImport)
+	}
+	adjusted := strings.HasPrefix(closeName, "adj")
+
+	// European exports write closes as 1234,56
+	decimalComma := len(records[1]) > closeCol && strings.Contains(records[1][closeCol], ",") && !strings.Contains(records[1][closeCol], ".")
+
+	closes := make([]DailyClose, 0, len(records)-1)
+	for i, record := range records[1:] {
+		if len(record) <= dateCol || len(record) <= closeCol || strings.TrimSpace(record[dateCol]) == "" {
+			continue
+		}
+		date, err := parseDate(record[dateCol], "")
+		if err != nil {
+			return nil, rowError(i+1, "date", err)
+		}
+		price, err := parseNumber(record[closeCol], decimalComma)
+		if err != nil {
+			return nil, rowError(i+1, "close", err)
+		}
+		if price <= 0 {
+			continue
+		}
+
+		bar := DailyClose{Date: date, Close: price}
+		for name, target := range map[string]*float64{
+			"open":   &bar.Open,
+			"high":   &bar.High,
+			"low":    &bar.Low,
+			"volume": &bar.Volume,
+		} {
+			value := field(record, header, name)
+			if value == "" {
+				continue
+			}
+			if *target, err = parseNumber(value, decimalComma); err != nil {
+				return nil, rowError(i+1, name, err)
+			}
+		}
+		if adjusted {
+			raw, err := parseNumber(field(record, header, "close"), decimalComma)
+			if err == nil && raw > 0 {
+				ratio := price / raw
+				bar.Open *= ratio
+				bar.High *= ratio
+				bar.Low *= ratio
+			}
+		}
+		closes = append(closes, bar)
+	}
+
+	if len(closes) == 0 {
+		return nil, fmt.Errorf("%w: no prices found", ErrInvalidImport)
+	}
+	sort.Slice(closes, func(i, j int) bool { return closes[i].Date.Before(closes[j].Date) })
+	return closes, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// rawHistoryProvider reports unadjusted closes of 400 before and 100 after 2026-03-02
+type rawHistoryProvider struct{}
+
+func (rawHistoryProvider) Name() string { return "raw" }
+func (rawHistoryProvider) Quote(*models.Stock) (*MarketQuote, error) {
+	return nil, ErrMarketDataUnsupported
+}
+func (rawHistoryProvider) Fundamentals(*models.Stock) (*Fundamentals, error) {
+	return nil, ErrMarketDataUnsupported
+}
+func (rawHistoryProvider) AnalystTarget(*models.Stock) (*AnalystTarget, error) {
+	return nil, ErrMarketDataUnsupported
+}
+func (rawHistoryProvider) History(*models.Stock, bool) ([]DailyClose, error) {
+	return []DailyClose{
+		{Date: time.Date(2026, 2, 27, 0, 0, 0, 0, time.UTC), Close: 400},
+		{Date: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Close: 100},
+	}, nil
+}
+
+func TestBackfillAdjustsOnlyRawProviderBars(t *testing.T) {
+	db := openTestDB(t)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "history"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// An adjusted export: the pre-split close is already divided by 4
+	csv := "date,adj close\n2026-02-27,100\n2026-03-02,100\n"
+	if err := os.WriteFile(filepath.Join(dir, "history", "SPLT.csv"), []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{MarketDataDir: dir}
+	marketData := NewMarketDataService(db, cfg, NewExternalAPIService(cfg))
+	marketData.Register(rawHistoryProvider{})
+	prices := NewPriceService(db, marketData)
+
+	for _, provider := range []string{MarketDataFile, "raw"} {
+		t.Run(provider, func(t *testing.T) {
+			stock := models.Stock{Ticker: "SPLT", CompanyName: "Split Co", Sector: "Technology", DataProviders: provider}
+			if err := db.Create(&stock).Error; err != nil {
+				t.Fatal(err)
+			}
+			split := models.CorporateAction{StockID: stock.ID, Type: models.CorporateActionSplit, Status: "applied",
+				EffectiveDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), RatioFrom: 1, RatioTo: 4}
+			if err := db.Create(&split).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if _, used, err := prices.Backfill(&stock, true); err != nil || used != provider {
+				t.Fatalf("Backfill: provider %q, err %v", used, err)
+			}
+			bars, err := prices.GetBars(stock.ID, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bars) != 2 || bars[0].Close != 100 || bars[1].Close != 100 {
+				t.Errorf("stored bars %+v, want two closes of 100", bars)
+			}
+		})
+	}
+}
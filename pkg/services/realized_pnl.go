@@ -0,0 +1,214 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// Lot matching methods for realized P&L
+const (
+	CostBasisFIFO    = "fifo"
+	CostBasisLIFO    = "lifo"
+	CostBasisAverage = "average"
+)
+
+// ValidCostBasisMethod reports whether method is a supported lot matching method
+func ValidCostBasisMethod(method string) bool {
+	switch method {
+	case CostBasisFIFO, CostBasisLIFO, CostBasisAverage:
+		return true
+	}
+	return false
+}
+
+// Lot is an open (or partially sold) purchase lot
+type Lot struct {
+	TransactionID uint      `json:"transaction_id"`
+	TradeDate     time.Time `json:"trade_date"`
+	Quantity      float64   `json:"quantity"`   // Remaining quantity
+	CostLocal     float64   `json:"cost_local"` // Remaining cost incl. fees in local currency
+	CostEUR       float64   `json:"cost_eur"`   // Remaining cost converted at the buy-date FX rate
+}
+
+// LotMatch records how much of a lot was consumed by a sale
+type LotMatch struct {
+	BuyTransactionID uint      `json:"buy_transaction_id"`
+	BuyDate          time.Time `json:"buy_date"`
+	Quantity         float64   `json:"quantity"`
+	CostLocal        float64   `json:"cost_local"`
+	CostEUR          float64   `json:"cost_eur"`
+}
+
+// RealizedSale is the realized gain of a single sell transaction
+type RealizedSale struct {
+	TransactionID uint       `json:"transaction_id"`
+	StockID       uint       `json:"stock_id"`
+	Ticker        string     `json:"ticker,omitempty"`
+	SaleDate      time.Time  `json:"sale_date"`
+	Quantity      float64    `json:"quantity"`
+	Currency      string     `json:"currency"`
+	ProceedsLocal float64    `json:"proceeds_local"` // Net of sale fees
+	CostLocal     float64    `json:"cost_local"`
+	GainLocal     float64    `json:"gain_local"`
+	ProceedsEUR   float64    `json:"proceeds_eur"` // Converted at the sale-date FX rate
+	CostEUR       float64    `json:"cost_eur"`     // Converted at each lot's buy-date FX rate
+	GainEUR       float64    `json:"gain_eur"`
+	Matches       []LotMatch `json:"matches"`
+}
+
+// RealizedYear aggregates realized gains for one calendar year
+type RealizedYear struct {
+	Year           int                `json:"year"`
+	Sales          int                `json:"sales"`
+	GainEUR        float64            `json:"gain_eur"`
+	GainByCurrency map[string]float64 `json:"gain_by_currency"` // Local-currency gains
+}
+
+// toEUR converts a local amount to EUR using a rate in units per EUR
+func toEUR(amount, fxRate float64) float64 {
+	if fxRate <= 0 {
+		return amount
+	}
+	return amount / fxRate
+}
+
+// MatchLots replays a stock's transactions and matches every sale against purchase
+// lots using the given method. It returns the realized sales and the remaining open lots.
+func MatchLots(transactions []models.Transaction, method string) ([]RealizedSale, []Lot, error) {
+	if !ValidCostBasisMethod(method) {
+		return nil, nil, fmt.Errorf("%w: unknown cost basis method %q", ErrInvalidLedger, method)
+	}
+
+	sorted := make([]models.Transaction, len(transactions))
+	copy(sorted, transactions)
+	sortTransactions(sorted)
+
+	lots := []Lot{}
+	sales := []RealizedSale{}
+
+	for _, tx := range sorted {
+		switch tx.Type {
+		case models.TransactionTypeBuy:
+			costLocal := tx.Quantity*tx.Price + tx.Fees
+			lot := Lot{
+				TransactionID: tx.ID,
+				TradeDate:     tx.TradeDate,
+				Quantity:      tx.Quantity,
+				CostLocal:     costLocal,
+				CostEUR:       toEUR(costLocal, tx.FXRate),
+			}
+			if method == CostBasisAverage && len(lots) > 0 {
+				// Average cost keeps a single pooled lot
+				lots[0].Quantity += lot.Quantity
+				lots[0].CostLocal += lot.CostLocal
+				lots[0].CostEUR += lot.CostEUR
+			} else {
+				lots = append(lots, lot)
+			}
+
+		case models.TransactionTypeSell:
+			sale, remaining, err := consumeLots(lots, tx, method)
+			if err != nil {
+				return nil, nil, err
+			}
+			lots = remaining
+			sales = append(sales, sale)
+
+		case models.TransactionTypeCostAdjustment:
+			// Only the lots still open carry the adjustment; sales before it keep their cost
+			for i := range lots {
+				lots[i].CostLocal *= tx.CostFactor
+				lots[i].CostEUR *= tx.CostFactor
+			}
+		}
+	}
+
+	return sales, lots, nil
+}
+
+// consumeLots matches a sell transaction against open lots in method order
+func consumeLots(lots []Lot, tx models.Transaction, method string) (RealizedSale, []Lot, error) {
+	var available float64
+	for _, lot := range lots {
+		available += lot.Quantity
+	}
+	if tx.Quantity > available+quantityEpsilon {
+		return RealizedSale{}, nil, fmt.Errorf("%w: sell of %.4f on %s exceeds open lots of %.4f",
+			ErrInvalidLedger, tx.Quantity, tx.TradeDate.Format("2006-01-02"), available)
+	}
+
+	proceedsLocal := tx.Quantity*tx.Price - tx.Fees
+	sale := RealizedSale{
+		TransactionID: tx.ID,
+		StockID:       tx.StockID,
+		SaleDate:      tx.TradeDate,
+		Quantity:      tx.Quantity,
+		Currency:      tx.Currency,
+		ProceedsLocal: proceedsLocal,
+		ProceedsEUR:   toEUR(proceedsLocal, tx.FXRate),
+	}
+
+	remaining := tx.Quantity
+	for remaining > quantityEpsilon && len(lots) > 0 {
+		idx := 0
+		if method == CostBasisLIFO {
+			idx = len(lots) - 1
+		}
+		lot := &lots[idx]
+
+		take := remaining
+		if lot.Quantity < take {
+			take = lot.Quantity
+		}
+		fraction := take / lot.Quantity
+		match := LotMatch{
+			BuyTransactionID: lot.TransactionID,
+			BuyDate:          lot.TradeDate,
+			Quantity:         take,
+			CostLocal:        lot.CostLocal * fraction,
+			CostEUR:          lot.CostEUR * fraction,
+		}
+		sale.Matches = append(sale.Matches, match)
+		sale.CostLocal += match.CostLocal
+		sale.CostEUR += match.CostEUR
+
+		lot.Quantity -= take
+		lot.CostLocal -= match.CostLocal
+		lot.CostEUR -= match.CostEUR
+		remaining -= take
+
+		if lot.Quantity <= quantityEpsilon {
+			lots = append(lots[:idx], lots[idx+1:]...)
+		}
+	}
+
+	sale.GainLocal = sale.ProceedsLocal - sale.CostLocal
+	sale.GainEUR = sale.ProceedsEUR - sale.CostEUR
+	return sale, lots, nil
+}
+
+// SummarizeRealizedByYear groups realized sales by calendar year of the sale
+func SummarizeRealizedByYear(sales []RealizedSale) []RealizedYear {
+	byYear := make(map[int]*RealizedYear)
+	for _, sale := range sales {
+		year := sale.SaleDate.Year()
+		summary, ok := byYear[year]
+		if !ok {
+			summary = &RealizedYear{Year: year, GainByCurrency: make(map[string]float64)}
+			byYear[year] = summary
+		}
+		summary.Sales++
+		summary.GainEUR += sale.GainEUR
+		summary.GainByCurrency[sale.Currency] += sale.GainLocal
+	}
+
+	years := make([]RealizedYear, 0, len(byYear))
+	for _, summary := range byYear {
+		years = append(years, *summary)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].Year < years[j].Year })
+	return years
+}
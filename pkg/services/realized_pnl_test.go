@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func TestMatchLots(t *testing.T) {
+	day := func(month time.Month) time.Time { return time.Date(2025, month, 1, 0, 0, 0, 0, time.UTC) }
+	buys := []models.Transaction{
+		{ID: 1, Type: models.TransactionTypeBuy, TradeDate: day(time.January), Quantity: 10, Price: 100, FXRate: 1},
+		{ID: 2, Type: models.TransactionTypeBuy, TradeDate: day(time.February), Quantity: 10, Price: 200, FXRate: 1},
+	}
+	sell := func(quantity float64) []models.Transaction {
+		return append(buys[:2:2], models.Transaction{ID: 3, Type: models.TransactionTypeSell, TradeDate: day(time.March), Quantity: quantity, Price: 300, Fees: 10, FXRate: 1})
+	}
+
+	tests := []struct {
+		name         string
+		transactions []models.Transaction
+		method       string
+		wantCost     float64 // Cost of the sale
+		wantOpen     float64 // Cost of the remaining 5 shares
+		wantMatches  int
+		wantErr      error
+	}{
+		{"fifo sells the oldest lot first", sell(15), CostBasisFIFO, 1000 + 5*200, 5 * 200, 2, nil},
+		{"lifo sells the newest lot first", sell(15), CostBasisLIFO, 2000 + 5*100, 5 * 100, 2, nil},
+		{"average pools the lots", sell(15), CostBasisAverage, 15 * 150, 5 * 150, 1, nil},
+		{"oversell is rejected", sell(25), CostBasisFIFO, 0, 0, 0, ErrInvalidLedger},
+		{"unknown method is rejected", sell(15), "hifo", 0, 0, 0, ErrInvalidLedger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sales, lots, err := MatchLots(tt.transactions, tt.method)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MatchLots: %v", err)
+			}
+			if len(sales) != 1 {
+				t.Fatalf("%d sales, want 1", len(sales))
+			}
+			sale := sales[0]
+			if math.Abs(sale.CostLocal-tt.wantCost) > 1e-9 || math.Abs(sale.GainLocal-(15*300-10-tt.wantCost)) > 1e-9 {
+				t.Errorf("cost %v, gain %v; want cost %v", sale.CostLocal, sale.GainLocal, tt.wantCost)
+			}
+			if len(sale.Matches) != tt.wantMatches {
+				t.Errorf("%d lot matches, want %d", len(sale.Matches), tt.wantMatches)
+			}
+			var open, cost float64
+			for _, lot := range lots {
+				open += lot.Quantity
+				cost += lot.CostLocal
+			}
+			if math.Abs(open-5) > 1e-9 || math.Abs(cost-tt.wantOpen) > 1e-9 {
+				t.Errorf("open lots %v shares at %v, want 5 at %v", open, cost, tt.wantOpen)
+			}
+		})
+	}
+}
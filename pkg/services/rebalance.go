@@ -0,0 +1,292 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// Sources of the target weights of a rebalance
+const (
+	RebalanceMethodHalfKelly = "half_kelly" // Configured target weights, else the stand-alone half-Kelly
+	RebalanceMethodAllocator = "allocator"  // Joint fractional-Kelly weights from AllocateKelly
+)
+
+var (
+	// ErrInvalidRebalanceMethod is returned for target methods other than half_kelly or allocator
+	ErrInvalidRebalanceMethod = errors.New("method must be half_kelly or allocator")
+	// ErrRebalanceOrderClosed is returned when an executed or skipped order is marked again
+	ErrRebalanceOrderClosed = errors.New("order is already executed or skipped")
+)
+
+// RebalanceOptions configures BuildRebalance
+type RebalanceOptions struct {
+	Method        string
+	MinTradeValue float64 // EUR; smaller orders are dropped
+	Constraints   AllocationConstraints
+}
+
+// ParseRebalanceMethod normalizes a rebalance method name; empty means half_kelly
+func ParseRebalanceMethod(method string) (string, error) {
+	switch method = strings.ToLower(strings.TrimSpace(method)); method {
+	case "":
+		return RebalanceMethodHalfKelly, nil
+	case RebalanceMethodHalfKelly, RebalanceMethodAllocator:
+		return method, nil
+	}
+	return "", ErrInvalidRebalanceMethod
+}
+
+// HalfKellyTargets returns the target weight of each stock in percent of the whole
+// portfolio: its configured TargetWeight, else its half-Kelly suggestion. Targets are
+// capped per position, scaled down to the sector maximums and then to the investable
+// share left by the cash buffer.
+func HalfKellyTargets(stocks []models.Stock, constraints AllocationConstraints) (map[uint]float64, []string) {
+	targets := make(map[uint]float64, len(stocks))
+	warnings := []string{}
+	bySector := make(map[string][]uint)
+	for _, stock := range stocks {
+		target := stock.TargetWeight
+		if target <= 0 && stock.ExpectedValue > 0 {
+			target = stock.HalfKellySuggested
+		}
+		if constraints.MaxPosition > 0 && target > constraints.MaxPosition {
+			warnings = append(warnings, fmt.Sprintf("%s target capped at %.0f%%", stock.Ticker, constraints.MaxPosition))
+			target = constraints.MaxPosition
+		}
+		if target <= 0 {
+			continue
+		}
+		targets[stock.ID] = target
+		if name, _, ok := constraints.sectorRange(stock.Sector); ok {
+			bySector[name] = append(bySector[name], stock.ID)
+		}
+	}
+
+	names := make([]string, 0, len(constraints.SectorRanges))
+	for name := range constraints.SectorRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := constraints.SectorRanges[name]
+		var sum float64
+		for _, id := range bySector[name] {
+			sum += targets[id]
+		}
+		switch {
+		case sum > r.Max:
+			for _, id := range bySector[name] {
+				targets[id] *= r.Max / sum
+			}
+			warnings = append(warnings, fmt.Sprintf("%s targets scaled from %.1f%% to the %.0f%% sector maximum", name, sum, r.Max))
+		case sum < r.Min:
+			warnings = append(warnings, fmt.Sprintf("%s targets total %.1f%%, below the %.0f%% sector minimum", name, sum, r.Min))
+		}
+	}
+
+	var total float64
+	for _, target := range targets {
+		total += target
+	}
+	if budget := 100 - constraints.CashMin; total > budget {
+		for id := range targets {
+			targets[id] *= budget / total
+		}
+		warnings = append(warnings, fmt.Sprintf("targets scaled from %.1f%% to %.0f%% to keep the cash buffer", total, budget))
+	}
+	return targets, warnings
+}
+
+// rebalanceLine is a stock's move from its current to its target value
+type rebalanceLine struct {
+	stock   models.Stock
+	fxRate  float64
+	value   float64 // EUR
+	current float64 // Weight percentages
+	target  float64
+}
+
+// BuildRebalance compares each position's share of the whole portfolio (positions plus
+// cash) with its target and returns the orders that close the gaps. Shares are rounded
+// down to the stock's lot size, except that positions without a target are sold in full;
+// orders below the minimum trade value are dropped. Buys are scaled down when sells and
+// cash above the buffer cannot fund them. fxRates are units per EUR.
+func BuildRebalance(stocks []models.Stock, cash []models.CashHolding, fxRates map[string]float64, targets map[uint]float64, opts RebalanceOptions) models.RebalanceProposal {
+	proposal := models.RebalanceProposal{
+		Method:        opts.Method,
+		Status:        models.RebalanceStatusOpen,
+		Currency:      "EUR",
+		MinTradeValue: opts.MinTradeValue,
+		Warnings:      []string{},
+		Orders:        []models.RebalanceOrder{},
+	}
+	rate := func(currency string) float64 {
+		if r := fxRates[currency]; r > 0 {
+			return r
+		}
+		return 1.0
+	}
+
+	cashByCurrency := make(map[string]float64)
+	for _, holding := range cash {
+		cashByCurrency[holding.CurrencyCode] += holding.Amount
+		proposal.CashBefore += holding.Amount / rate(holding.CurrencyCode)
+	}
+
+	var lines []rebalanceLine
+	total := proposal.CashBefore
+	for _, stock := range stocks {
+		if stock.CurrentPrice <= 0 || (stock.SharesOwned <= 0 && targets[stock.ID] <= 0) {
+			continue
+		}
+		line := rebalanceLine{stock: stock, fxRate: rate(stock.Currency), target: targets[stock.ID]}
+		if stock.SharesOwned > 0 {
+			line.value = stock.SharesOwned * stock.CurrentPrice / line.fxRate
+		}
+		total += line.value
+		lines = append(lines, line)
+	}
+	proposal.TotalValue = total
+	if total <= 0 {
+		return proposal
+	}
+	sort.Slice(lines, func(a, b int) bool { return lines[a].stock.Ticker < lines[b].stock.Ticker })
+
+	var sells, buys []models.RebalanceOrder
+	dropped := 0
+	for i := range lines {
+		line := &lines[i]
+		line.current = line.value / total * 100
+		delta := line.target/100*total - line.value // EUR
+		shares := roundToLot(math.Abs(delta)*line.fxRate/line.stock.CurrentPrice, line.stock.LotSize)
+
+		side := models.TransactionTypeBuy
+		if delta < 0 {
+			side = models.TransactionTypeSell
+			if line.target <= 0 || shares > line.stock.SharesOwned {
+				shares = line.stock.SharesOwned
+			}
+		}
+		order := newRebalanceOrder(line, side, shares)
+		if shares <= 0 || order.Value < opts.MinTradeValue {
+			if math.Abs(delta) > 0 && shares > 0 {
+				dropped++
+			}
+			continue
+		}
+		if side == models.TransactionTypeSell {
+			sells = append(sells, order)
+		} else {
+			buys = append(buys, order)
+		}
+	}
+
+	// Buys are funded by sells and the cash above the buffer
+	for _, order := range sells {
+		proposal.SellValue += order.Value
+	}
+	var wanted float64
+	for _, order := range buys {
+		wanted += order.Value
+	}
+	reserve := opts.Constraints.CashMin / 100 * total
+	available := math.Max(0, proposal.CashBefore+proposal.SellValue-reserve)
+	if wanted > available {
+		scale := available / wanted
+		proposal.Warnings = append(proposal.Warnings, fmt.Sprintf("buys scaled to %.0f%% to keep the %.0f%% cash buffer", scale*100, opts.Constraints.CashMin))
+		scaled := buys[:0]
+		for _, order := range buys {
+			line := lineFor(lines, order.StockID)
+			order = newRebalanceOrder(line, models.TransactionTypeBuy, roundToLot(order.Shares*scale, line.stock.LotSize))
+			if order.Shares <= 0 || order.Value < opts.MinTradeValue {
+				dropped++
+				continue
+			}
+			scaled = append(scaled, order)
+		}
+		buys = scaled
+	}
+	for _, order := range buys {
+		proposal.BuyValue += order.Value
+	}
+	if dropped > 0 {
+		proposal.Warnings = append(proposal.Warnings, fmt.Sprintf("%d orders below the minimum trade value were dropped", dropped))
+	}
+
+	// Sells first so their proceeds fund the buys; largest first within each side
+	for _, orders := range [][]models.RebalanceOrder{sells, buys} {
+		sort.SliceStable(orders, func(a, b int) bool { return orders[a].Value > orders[b].Value })
+		proposal.Orders = append(proposal.Orders, orders...)
+	}
+	proposal.CashAfter = proposal.CashBefore + proposal.SellValue - proposal.BuyValue
+
+	// Buys in a currency without enough cash need a conversion first
+	for _, order := range proposal.Orders {
+		if order.Side == models.TransactionTypeSell {
+			cashByCurrency[order.Currency] += order.ValueLocal
+		} else {
+			cashByCurrency[order.Currency] -= order.ValueLocal
+		}
+	}
+	currencies := make([]string, 0, len(cashByCurrency))
+	for currency := range cashByCurrency {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		if amount := cashByCurrency[currency]; amount < -0.005 {
+			proposal.Warnings = append(proposal.Warnings, fmt.Sprintf("convert %.2f %s from other cash before buying", -amount, currency))
+		}
+	}
+	return proposal
+}
+
+func newRebalanceOrder(line *rebalanceLine, side string, shares float64) models.RebalanceOrder {
+	valueLocal := shares * line.stock.CurrentPrice
+	return models.RebalanceOrder{
+		StockID:       line.stock.ID,
+		Ticker:        line.stock.Ticker,
+		Side:          side,
+		Shares:        shares,
+		Price:         line.stock.CurrentPrice,
+		Currency:      line.stock.Currency,
+		ValueLocal:    valueLocal,
+		Value:         valueLocal / line.fxRate,
+		CurrentWeight: line.current,
+		TargetWeight:  line.target,
+		Status:        models.RebalanceOrderPending,
+	}
+}
+
+func lineFor(lines []rebalanceLine, stockID uint) *rebalanceLine {
+	for i := range lines {
+		if lines[i].stock.ID == stockID {
+			return &lines[i]
+		}
+	}
+	return nil
+}
+
+// roundToLot rounds a quantity down to whole lots; lot sizes of 0 trade whole shares
+func roundToLot(shares, lot float64) float64 {
+	if lot <= 0 {
+		lot = 1
+	}
+	lots := math.Floor(shares/lot + 1e-9)
+	return math.Round(lots*lot*1e8) / 1e8
+}
+
+// CompleteRebalanceStatus returns the proposal status implied by its orders
+func CompleteRebalanceStatus(orders []models.RebalanceOrder) string {
+	for _, order := range orders {
+		if order.Status == models.RebalanceOrderPending {
+			return models.RebalanceStatusOpen
+		}
+	}
+	return models.RebalanceStatusCompleted
+}
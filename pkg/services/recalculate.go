@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// DataSourceRecalculation tags history entries written by an offline recalculation
+const DataSourceRecalculation = "recalculation"
+
+// RecalculationChange is one stock's assessment and EV before and after a recalculation
+type RecalculationChange struct {
+	StockID          uint    `json:"stock_id"`
+	Ticker           string  `json:"ticker"`
+	AssessmentBefore string  `json:"assessment_before"`
+	AssessmentAfter  string  `json:"assessment_after"`
+	EVBefore         float64 `json:"ev_before"`
+	EVAfter          float64 `json:"ev_after"`
+	EVChange         float64 `json:"ev_change"`
+	WeightBefore     float64 `json:"weight_before"`
+	WeightAfter      float64 `json:"weight_after"`
+	Changed          bool    `json:"changed"` // Assessment changed or EV moved by at least 0.01 points
+}
+
+// RecalculationResult summarizes an offline recalculation
+type RecalculationResult struct {
+	Strategy        string                `json:"strategy"`
+	StrategyVersion int                   `json:"strategy_version"`
+	Recalculated    int                   `json:"recalculated"`
+	Changed         int                   `json:"changed"`
+	Changes         []RecalculationChange `json:"changes"`
+}
+
+// Recalculate reruns CalculateMetrics, the reporting-currency values and the portfolio
+// weights from the stored inputs only; no market data provider is called. Without
+// stock IDs every stock is recalculated; weights always use the whole portfolio's value.
+// Each recalculated stock gets a StockHistory entry tagged DataSourceRecalculation.
+func (s *StrategyService) Recalculate(profile *models.StrategyProfile, stockIDs []uint, fxRates map[string]float64, reportingCurrency string) (RecalculationResult, error) {
+	result := RecalculationResult{
+		Strategy:        profile.Name,
+		StrategyVersion: profile.Version,
+		Changes:         []RecalculationChange{},
+	}
+
+	var stocks []models.Stock
+	if err := s.db.Preload("Scenarios").Order("ticker ASC").Find(&stocks).Error; err != nil {
+		return result, err
+	}
+	selected := make(map[uint]bool, len(stockIDs))
+	for _, id := range stockIDs {
+		selected[id] = true
+	}
+
+	// Weights are shares of the positions' EUR value, as in the portfolio summary
+	values := make([]float64, len(stocks))
+	var total float64
+	for i, stock := range stocks {
+		if stock.SharesOwned <= 0 {
+			continue
+		}
+		fxRate := fxRates[stock.Currency]
+		if fxRate == 0 {
+			fxRate = 1.0
+		}
+		values[i] = stock.SharesOwned * stock.CurrentPrice / fxRate
+		total += values[i]
+	}
+
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range stocks {
+			stock := &stocks[i]
+			if len(selected) > 0 && !selected[stock.ID] {
+				continue
+			}
+			change := RecalculationChange{
+				StockID:          stock.ID,
+				Ticker:           stock.Ticker,
+				AssessmentBefore: stock.Assessment,
+				EVBefore:         stock.ExpectedValue,
+				WeightBefore:     stock.Weight,
+			}
+
+			CalculateMetrics(stock, profile)
+			PositionValues(stock, fxRates, reportingCurrency)
+			stock.Weight = 0
+			if total > 0 {
+				stock.Weight = values[i] / total * 100
+			}
+			if err := tx.Save(stock).Error; err != nil {
+				return fmt.Errorf("%s: %w", stock.Ticker, err)
+			}
+
+			history := models.StockHistory{
+				StockID:             stock.ID,
+				Ticker:              stock.Ticker,
+				CurrentPrice:        stock.CurrentPrice,
+				FairValue:           stock.FairValue,
+				UpsidePotential:     stock.UpsidePotential,
+				DownsideRisk:        stock.DownsideRisk,
+				ProbabilityPositive: stock.ProbabilityPositive,
+				ExpectedValue:       stock.ExpectedValue,
+				KellyFraction:       stock.KellyFraction,
+				Weight:              stock.Weight,
+				Assessment:          stock.Assessment,
+				DataSource:          DataSourceRecalculation,
+				RecordedAt:          now,
+			}
+			if err := tx.Create(&history).Error; err != nil {
+				return fmt.Errorf("%s: %w", stock.Ticker, err)
+			}
+
+			change.AssessmentAfter = stock.Assessment
+			change.EVAfter = stock.ExpectedValue
+			change.EVChange = change.EVAfter - change.EVBefore
+			change.WeightAfter = stock.Weight
+			change.Changed = change.AssessmentAfter != change.AssessmentBefore || change.EVChange >= 0.01 || change.EVChange <= -0.01
+			if change.Changed {
+				result.Changed++
+			}
+			result.Changes = append(result.Changes, change)
+			result.Recalculated++
+		}
+		return nil
+	})
+	if err != nil {
+		return RecalculationResult{Strategy: profile.Name, StrategyVersion: profile.Version, Changes: []RecalculationChange{}}, err
+	}
+	return result, nil
+}
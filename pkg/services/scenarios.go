@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// scenarioProbabilityTolerance is how far a stock's scenario probabilities may sum from 1
+const scenarioProbabilityTolerance = 0.001
+
+// ErrInvalidScenarios is returned when a stock's scenarios do not form a distribution
+var ErrInvalidScenarios = errors.New("invalid scenarios")
+
+// ScenarioMetrics summarizes a stock's scenario distribution at its current price.
+// Returns are percentages.
+type ScenarioMetrics struct {
+	ExpectedValue   float64 `json:"expected_value"`   // Probability-weighted return
+	ExpectedPrice   float64 `json:"expected_price"`   // Probability-weighted target price
+	StdDev          float64 `json:"std_dev"`          // Spread of the returns around the EV
+	ProbabilityGain float64 `json:"probability_gain"` // Probability of the scenarios above the current price
+	AverageGain     float64 `json:"average_gain"`     // Return of the gaining scenarios, probability-weighted
+	AverageLoss     float64 `json:"average_loss"`     // Return of the losing scenarios (negative), probability-weighted
+	BRatio          float64 `json:"b_ratio"`          // AverageGain / |AverageLoss|; 0 without losing scenarios
+	KellyFraction   float64 `json:"kelly_fraction"`   // f* = p - q/b with p, q the gain and loss probabilities
+}
+
+// ValidateScenarios checks that scenarios are named uniquely, have probabilities in (0, 1]
+// summing to 1 and non-negative target prices
+func ValidateScenarios(scenarios []models.StockScenario) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidScenarios, fmt.Sprintf(format, args...))
+	}
+	names := make(map[string]bool, len(scenarios))
+	var total float64
+	for _, scenario := range scenarios {
+		name := strings.ToLower(strings.TrimSpace(scenario.Name))
+		switch {
+		case name == "":
+			return invalid("every scenario needs a name")
+		case names[name]:
+			return invalid("scenario %s is defined twice", scenario.Name)
+		case scenario.Probability <= 0 || scenario.Probability > 1:
+			return invalid("probability of %s must be greater than 0 and at most 1", scenario.Name)
+		case scenario.TargetPrice < 0:
+			return invalid("target price of %s must not be negative", scenario.Name)
+		}
+		names[name] = true
+		total += scenario.Probability
+	}
+	if len(scenarios) > 0 && math.Abs(total-1) > scenarioProbabilityTolerance {
+		return invalid("probabilities sum to %.4f instead of 1", total)
+	}
+	return nil
+}
+
+// EvaluateScenarios computes the EV, b ratio and Kelly fraction of a scenario distribution.
+// With two scenarios, fair value with probability p and the downside otherwise, the
+// results equal those of the binary model.
+func EvaluateScenarios(price float64, scenarios []models.StockScenario) ScenarioMetrics {
+	var metrics ScenarioMetrics
+	if price <= 0 {
+		return metrics
+	}
+
+	var total, gain, loss, probabilityLoss, secondMoment float64
+	for _, scenario := range scenarios {
+		total += scenario.Probability
+	}
+	if total <= 0 {
+		return metrics
+	}
+	for _, scenario := range scenarios {
+		p := scenario.Probability / total // Absorbs rounding within the tolerance
+		r := (scenario.TargetPrice - price) / price * 100
+		metrics.ExpectedValue += p * r
+		metrics.ExpectedPrice += p * scenario.TargetPrice
+		secondMoment += p * r * r
+		switch {
+		case r > 0:
+			metrics.ProbabilityGain += p
+			gain += p * r
+		case r < 0:
+			probabilityLoss += p
+			loss += p * r
+		}
+	}
+	metrics.StdDev = math.Sqrt(math.Max(secondMoment-metrics.ExpectedValue*metrics.ExpectedValue, 0))
+	if metrics.ProbabilityGain > 0 {
+		metrics.AverageGain = gain / metrics.ProbabilityGain
+	}
+	if probabilityLoss > 0 {
+		metrics.AverageLoss = loss / probabilityLoss
+	}
+
+	switch {
+	case metrics.AverageGain <= 0:
+		metrics.KellyFraction = 0
+	case metrics.AverageLoss == 0:
+		// Nothing to lose: the bet is sized by the chance of gaining alone
+		metrics.KellyFraction = metrics.ProbabilityGain * 100
+	default:
+		metrics.BRatio = metrics.AverageGain / math.Abs(metrics.AverageLoss)
+		metrics.KellyFraction = (metrics.ProbabilityGain - probabilityLoss/metrics.BRatio) * 100
+	}
+	if metrics.KellyFraction < 0 {
+		metrics.KellyFraction = 0
+	}
+	return metrics
+}
+
+// ScenarioService stores the scenarios of stocks
+type ScenarioService struct {
+	db *gorm.DB
+}
+
+// NewScenarioService creates a new scenario service
+func NewScenarioService(db *gorm.DB) *ScenarioService {
+	return &ScenarioService{db: db}
+}
+
+// Load reads the stock's scenarios into stock.Scenarios
+func (s *ScenarioService) Load(stock *models.Stock) error {
+	stock.Scenarios = nil
+	return s.db.Where("stock_id = ?", stock.ID).Order("probability DESC, id ASC").Find(&stock.Scenarios).Error
+}
+
+// Replace validates the scenarios and stores them in place of the stock's current ones.
+// An empty list removes them, which returns the stock to the binary model.
+func (s *ScenarioService) Replace(stock *models.Stock, scenarios []models.StockScenario) error {
+	if err := ValidateScenarios(scenarios); err != nil {
+		return err
+	}
+	for i := range scenarios {
+		scenarios[i].ID = 0
+		scenarios[i].StockID = stock.ID
+		scenarios[i].Name = strings.TrimSpace(scenarios[i].Name)
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("stock_id = ?", stock.ID).Delete(&models.StockScenario{}).Error; err != nil {
+			return err
+		}
+		if len(scenarios) == 0 {
+			return nil
+		}
+		return tx.Create(&scenarios).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.Load(stock)
+}
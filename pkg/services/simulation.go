@@ -0,0 +1,538 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+// Monte Carlo limits and defaults
+const (
+	DefaultSimulationPaths   = 2000
+	MaxSimulationPaths       = 10000
+	DefaultSimulationHorizon = 252  // Trading days
+	MaxSimulationHorizon     = 1260 // Five years of trading days
+	simulationYear           = 252  // Trading days over which a stock's outcome plays out
+	simulationStep           = 5    // Trading days between simulated points
+
+	// DefaultDrawdownLimit applies when neither the request nor the portfolio settings'
+	// drawdown alert level set a limit; it matches the alert level's column default
+	DefaultDrawdownLimit = 15
+)
+
+// ErrInvalidSimulation is returned for out-of-range simulation options
+var ErrInvalidSimulation = errors.New("invalid simulation options")
+
+// SimulationOptions configures SimulatePortfolio; zero values take the defaults
+type SimulationOptions struct {
+	Paths         int     `json:"paths"`
+	HorizonDays   int     `json:"horizon_days"` // Trading days
+	Seed          int64   `json:"seed"`
+	RuinLevel     float64 `json:"ruin_level"`     // Loss percentage from the start counted as ruin, default 50
+	DrawdownLimit float64 `json:"drawdown_limit"` // Maximum drawdown percentage the strategy tolerates, default DefaultDrawdownLimit
+}
+
+// Normalize fills in the defaults and checks the ranges
+func (o *SimulationOptions) Normalize() error {
+	if o.Paths == 0 {
+		o.Paths = DefaultSimulationPaths
+	}
+	if o.HorizonDays == 0 {
+		o.HorizonDays = DefaultSimulationHorizon
+	}
+	if o.Seed == 0 {
+		o.Seed = 1
+	}
+	if o.RuinLevel == 0 {
+		o.RuinLevel = 50
+	}
+	if o.DrawdownLimit == 0 {
+		o.DrawdownLimit = DefaultDrawdownLimit
+	}
+	switch {
+	case o.Paths < 100 || o.Paths > MaxSimulationPaths:
+		return fmt.Errorf("%w: paths must be between 100 and %d", ErrInvalidSimulation, MaxSimulationPaths)
+	case o.HorizonDays < simulationStep || o.HorizonDays > MaxSimulationHorizon:
+		return fmt.Errorf("%w: horizon_days must be between %d and %d", ErrInvalidSimulation, simulationStep, MaxSimulationHorizon)
+	case o.RuinLevel <= 0 || o.RuinLevel >= 100:
+		return fmt.Errorf("%w: ruin_level must be between 0 and 100", ErrInvalidSimulation)
+	case o.DrawdownLimit <= 0 || o.DrawdownLimit >= 100:
+		return fmt.Errorf("%w: drawdown_limit must be between 0 and 100", ErrInvalidSimulation)
+	}
+	return nil
+}
+
+// SimulationWeight is a position's starting share of the simulated portfolio
+type SimulationWeight struct {
+	StockID uint    `json:"stock_id"`
+	Ticker  string  `json:"ticker"`
+	Weight  float64 `json:"weight"` // Percentage of positions plus cash
+}
+
+// SimulationPercentiles are percentiles of a simulated return, in percent
+type SimulationPercentiles struct {
+	P5  float64 `json:"p5"`
+	P25 float64 `json:"p25"`
+	P50 float64 `json:"p50"`
+	P75 float64 `json:"p75"`
+	P95 float64 `json:"p95"`
+}
+
+// SimulationPathPoint is the distribution of the portfolio's return on one day of the horizon
+type SimulationPathPoint struct {
+	Day int `json:"day"` // Trading days from the start
+	SimulationPercentiles
+}
+
+// DrawdownDistribution describes the maximum drawdowns of the simulated paths, in percent
+type DrawdownDistribution struct {
+	Expected              float64 `json:"expected"`
+	P50                   float64 `json:"p50"`
+	P75                   float64 `json:"p75"`
+	P95                   float64 `json:"p95"`
+	P99                   float64 `json:"p99"`
+	ProbabilityAboveLimit float64 `json:"probability_above_limit"` // Share of paths beyond the drawdown limit, percentage
+}
+
+// SimulationOutcome is the simulated distribution of one set of starting weights
+type SimulationOutcome struct {
+	Weights           []SimulationWeight    `json:"weights"`
+	CashWeight        float64               `json:"cash_weight"`
+	ExpectedReturn    float64               `json:"expected_return"` // Mean return over the horizon, percentage
+	Returns           SimulationPercentiles `json:"returns"`         // Return over the horizon
+	ProbabilityOfLoss float64               `json:"probability_of_loss"`
+	ProbabilityOfRuin float64               `json:"probability_of_ruin"` // Share of paths touching the ruin level, percentage
+	Drawdown          DrawdownDistribution  `json:"drawdown"`
+	Paths             []SimulationPathPoint `json:"paths"`
+}
+
+// Simulation compares the simulated outcomes of the current and the half-Kelly weights
+type Simulation struct {
+	Options             SimulationOptions `json:"options"`
+	CorrelationCoverage float64           `json:"correlation_coverage"` // Share of pairs with a computed correlation, percentage
+	Current             SimulationOutcome `json:"current"`
+	HalfKelly           SimulationOutcome `json:"half_kelly"`
+	Warnings            []string          `json:"warnings"`
+}
+
+// simulatedAsset is one stock's outcome model over a year of the horizon
+type simulatedAsset struct {
+	stock      models.Stock
+	returns    []float64 // Outcome returns as fractions, ascending
+	cumulative []float64 // Cumulative probabilities of the returns
+	volatility float64   // Annualized sigma as a fraction
+}
+
+// SimulatePortfolio runs a seeded Monte Carlo of buy-and-hold portfolios. Each year of
+// the horizon, a stock's return is drawn from its scenarios, else from its binary
+// p/upside/downside outcome, with a Gaussian copula over the return correlations
+// (the allocator's default correlation where none was computed). Between draws, prices
+// follow a Brownian bridge with the stock's volatility, which sets the drawdowns along
+// the way. Both weight sets see the same random draws. Targets are percentages of
+// positions plus cash, and cash earns nothing; fxRates are units per EUR.
+func SimulatePortfolio(stocks []models.Stock, cash []models.CashHolding, fxRates map[string]float64, correlations *CorrelationMatrix, halfKelly map[uint]float64, opts SimulationOptions) (Simulation, error) {
+	if err := opts.Normalize(); err != nil {
+		return Simulation{}, err
+	}
+	simulation := Simulation{Options: opts, Warnings: []string{}}
+	rate := func(currency string) float64 {
+		if r := fxRates[currency]; r > 0 {
+			return r
+		}
+		return 1.0
+	}
+
+	// Current weights over positions plus cash
+	var total float64
+	values := make(map[uint]float64)
+	for _, holding := range cash {
+		total += holding.Amount / rate(holding.CurrencyCode)
+	}
+	for _, stock := range stocks {
+		if stock.SharesOwned > 0 && stock.CurrentPrice > 0 {
+			values[stock.ID] = stock.SharesOwned * stock.CurrentPrice / rate(stock.Currency)
+			total += values[stock.ID]
+		}
+	}
+
+	var assets []simulatedAsset
+	for _, stock := range stocks {
+		if values[stock.ID] <= 0 && halfKelly[stock.ID] <= 0 {
+			continue
+		}
+		asset, warning := newSimulatedAsset(stock)
+		if warning != "" {
+			simulation.Warnings = append(simulation.Warnings, warning)
+		}
+		assets = append(assets, asset)
+	}
+	n := len(assets)
+
+	current := make([]float64, n)
+	target := make([]float64, n)
+	for i, asset := range assets {
+		if total > 0 {
+			current[i] = values[asset.stock.ID] / total
+		}
+		target[i] = halfKelly[asset.stock.ID] / 100
+	}
+
+	// Correlations of the copula and of the bridge noise
+	corr := make([][]float64, n)
+	pairs, covered := 0, 0
+	for i := range assets {
+		corr[i] = make([]float64, n)
+		for j := range assets {
+			rho, ok := correlations.Correlation(assets[i].stock.ID, assets[j].stock.ID)
+			if i < j {
+				pairs++
+				if ok {
+					covered++
+				}
+			}
+			if !ok {
+				rho = defaultAllocationCorrelation
+			}
+			corr[i][j] = rho
+		}
+	}
+	if pairs > 0 {
+		simulation.CorrelationCoverage = float64(covered) / float64(pairs) * 100
+	} else {
+		simulation.CorrelationCoverage = 100
+	}
+	chol, shrink := choleskyShrunk(corr)
+	if shrink < 1 {
+		simulation.Warnings = append(simulation.Warnings, fmt.Sprintf("correlations shrunk to %.0f%% to form a valid correlation matrix", shrink*100))
+	}
+
+	// Simulated days: every step, the end of each outcome year and the horizon
+	var days []int
+	for day := simulationStep; day < opts.HorizonDays; day += simulationStep {
+		days = append(days, day)
+	}
+	for year := simulationYear; year < opts.HorizonDays; year += simulationYear {
+		days = append(days, year)
+	}
+	days = append(days, opts.HorizonDays)
+	sort.Ints(days)
+	days = uniqueDays(days)
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	outcomes := []*simulationAccumulator{
+		newSimulationAccumulator(current, opts, len(days)),
+		newSimulationAccumulator(target, opts, len(days)),
+	}
+	logPrice := make([]float64, n)
+	goal := make([]float64, n)
+	z := make([]float64, n)
+	prices := make([]float64, n)
+	for path := 0; path < opts.Paths; path++ {
+		for i := range logPrice {
+			logPrice[i] = 0
+		}
+		for _, acc := range outcomes {
+			acc.startPath()
+		}
+		t, yearEnd := 0, 0
+		for step, day := range days {
+			if t == yearEnd {
+				// Draw each stock's outcome for the coming year
+				yearEnd += simulationYear
+				correlatedNormals(rng, chol, z)
+				for i := range assets {
+					goal[i] = logPrice[i] + assets[i].drawLogReturn(z[i])
+				}
+			}
+			dt := float64(day-t) / simulationYear
+			remaining := float64(yearEnd-t) / simulationYear
+			correlatedNormals(rng, chol, z)
+			for i := range assets {
+				// Brownian bridge towards the year's outcome
+				drift := (goal[i] - logPrice[i]) * dt / remaining
+				spread := assets[i].volatility * math.Sqrt(dt*(remaining-dt)/remaining)
+				logPrice[i] += drift + spread*z[i]
+				prices[i] = math.Exp(logPrice[i])
+			}
+			for _, acc := range outcomes {
+				acc.record(step, prices)
+			}
+			t = day
+		}
+		for _, acc := range outcomes {
+			acc.endPath()
+		}
+	}
+
+	simulation.Current = outcomes[0].outcome(assets, days)
+	simulation.HalfKelly = outcomes[1].outcome(assets, days)
+	return simulation, nil
+}
+
+// newSimulatedAsset builds the outcome model of a stock: its scenarios, else its binary
+// outcome, else a driftless lognormal year with its volatility
+func newSimulatedAsset(stock models.Stock) (simulatedAsset, string) {
+	asset := simulatedAsset{stock: stock, volatility: stock.Volatility / 100}
+	var outcomes []struct{ r, p float64 }
+	var spread float64
+	switch {
+	case len(stock.Scenarios) > 0 && stock.CurrentPrice > 0:
+		metrics := EvaluateScenarios(stock.CurrentPrice, stock.Scenarios)
+		var total float64
+		for _, scenario := range stock.Scenarios {
+			total += scenario.Probability
+		}
+		for _, scenario := range stock.Scenarios {
+			outcomes = append(outcomes, struct{ r, p float64 }{(scenario.TargetPrice - stock.CurrentPrice) / stock.CurrentPrice, scenario.Probability / total})
+		}
+		spread = metrics.StdDev / 100
+	case stock.ProbabilityPositive > 0 && stock.ProbabilityPositive < 1 && stock.DownsideRisk < 0:
+		p := stock.ProbabilityPositive
+		up, down := stock.UpsidePotential/100, stock.DownsideRisk/100
+		outcomes = append(outcomes, struct{ r, p float64 }{up, p}, struct{ r, p float64 }{down, 1 - p})
+		spread = math.Sqrt(p*(1-p)) * math.Abs(up-down)
+	}
+
+	var warning string
+	if asset.volatility <= 0 {
+		asset.volatility = spread
+		warning = fmt.Sprintf("%s has no volatility; its path uses the spread of its outcomes", stock.Ticker)
+	}
+	if len(outcomes) == 0 {
+		return asset, fmt.Sprintf("%s has no outcome model; simulated without drift", stock.Ticker)
+	}
+
+	sort.Slice(outcomes, func(a, b int) bool { return outcomes[a].r < outcomes[b].r })
+	var cumulative float64
+	for _, outcome := range outcomes {
+		cumulative += outcome.p
+		asset.returns = append(asset.returns, math.Max(outcome.r, -0.99))
+		asset.cumulative = append(asset.cumulative, cumulative)
+	}
+	return asset, warning
+}
+
+// drawLogReturn maps a standard normal draw to the log return of the asset's year
+func (a *simulatedAsset) drawLogReturn(z float64) float64 {
+	if len(a.returns) == 0 {
+		return -a.volatility*a.volatility/2 + a.volatility*z
+	}
+	u := 0.5 * math.Erfc(-z/math.Sqrt2)
+	for i, cumulative := range a.cumulative {
+		if u <= cumulative {
+			return math.Log1p(a.returns[i])
+		}
+	}
+	return math.Log1p(a.returns[len(a.returns)-1])
+}
+
+// simulationAccumulator collects the portfolio values of one weight set across paths
+type simulationAccumulator struct {
+	weights   []float64
+	cash      float64
+	ruinLevel float64
+	limit     float64
+	values    [][]float64 // Per day, per path
+	finals    []float64
+	drawdowns []float64
+	ruined    int
+	peak      float64
+	maxDD     float64
+	lowest    float64
+}
+
+func newSimulationAccumulator(weights []float64, opts SimulationOptions, days int) *simulationAccumulator {
+	acc := &simulationAccumulator{
+		weights:   weights,
+		cash:      1 - sumWeights(weights),
+		ruinLevel: 1 - opts.RuinLevel/100,
+		limit:     opts.DrawdownLimit / 100,
+		values:    make([][]float64, days),
+	}
+	if acc.cash < 0 {
+		acc.cash = 0
+	}
+	for i := range acc.values {
+		acc.values[i] = make([]float64, 0, opts.Paths)
+	}
+	return acc
+}
+
+func (acc *simulationAccumulator) startPath() {
+	acc.peak, acc.maxDD, acc.lowest = 1, 0, 1
+}
+
+func (acc *simulationAccumulator) record(step int, prices []float64) {
+	value := acc.cash
+	for i, w := range acc.weights {
+		value += w * prices[i]
+	}
+	acc.values[step] = append(acc.values[step], value)
+	if value > acc.peak {
+		acc.peak = value
+	}
+	if dd := (acc.peak - value) / acc.peak; dd > acc.maxDD {
+		acc.maxDD = dd
+	}
+	if value < acc.lowest {
+		acc.lowest = value
+	}
+}
+
+func (acc *simulationAccumulator) endPath() {
+	last := acc.values[len(acc.values)-1]
+	acc.finals = append(acc.finals, last[len(last)-1])
+	acc.drawdowns = append(acc.drawdowns, acc.maxDD)
+	if acc.lowest <= acc.ruinLevel {
+		acc.ruined++
+	}
+}
+
+func (acc *simulationAccumulator) outcome(assets []simulatedAsset, days []int) SimulationOutcome {
+	paths := float64(len(acc.finals))
+	outcome := SimulationOutcome{
+		Weights:    []SimulationWeight{},
+		CashWeight: acc.cash * 100,
+		Paths:      make([]SimulationPathPoint, 0, len(days)+1),
+	}
+	for i, asset := range assets {
+		if acc.weights[i] > 0 {
+			outcome.Weights = append(outcome.Weights, SimulationWeight{StockID: asset.stock.ID, Ticker: asset.stock.Ticker, Weight: acc.weights[i] * 100})
+		}
+	}
+	if paths == 0 {
+		return outcome
+	}
+
+	var sum float64
+	losses := 0
+	for _, final := range acc.finals {
+		sum += final
+		if final < 1 {
+			losses++
+		}
+	}
+	outcome.ExpectedReturn = (sum/paths - 1) * 100
+	outcome.Returns = returnPercentiles(acc.finals)
+	outcome.ProbabilityOfLoss = float64(losses) / paths * 100
+	outcome.ProbabilityOfRuin = float64(acc.ruined) / paths * 100
+
+	outcome.Paths = append(outcome.Paths, SimulationPathPoint{Day: 0})
+	for step, day := range days {
+		outcome.Paths = append(outcome.Paths, SimulationPathPoint{Day: day, SimulationPercentiles: returnPercentiles(acc.values[step])})
+	}
+
+	sorted := append([]float64(nil), acc.drawdowns...)
+	sort.Float64s(sorted)
+	var ddSum float64
+	above := 0
+	for _, dd := range sorted {
+		ddSum += dd
+		if dd > acc.limit {
+			above++
+		}
+	}
+	outcome.Drawdown = DrawdownDistribution{
+		Expected:              ddSum / paths * 100,
+		P50:                   sortedPercentile(sorted, 0.50) * 100,
+		P75:                   sortedPercentile(sorted, 0.75) * 100,
+		P95:                   sortedPercentile(sorted, 0.95) * 100,
+		P99:                   sortedPercentile(sorted, 0.99) * 100,
+		ProbabilityAboveLimit: float64(above) / paths * 100,
+	}
+	return outcome
+}
+
+// returnPercentiles returns the percentiles of portfolio values (1 = start) as returns
+func returnPercentiles(values []float64) SimulationPercentiles {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	r := func(q float64) float64 { return (sortedPercentile(sorted, q) - 1) * 100 }
+	return SimulationPercentiles{P5: r(0.05), P25: r(0.25), P50: r(0.50), P75: r(0.75), P95: r(0.95)}
+}
+
+// sortedPercentile interpolates the q-quantile of ascending values
+func sortedPercentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// correlatedNormals fills z with standard normals correlated by the Cholesky factor
+func correlatedNormals(rng *rand.Rand, chol [][]float64, z []float64) {
+	independent := make([]float64, len(z))
+	for i := range independent {
+		independent[i] = rng.NormFloat64()
+	}
+	for i := range z {
+		z[i] = 0
+		for j := 0; j <= i; j++ {
+			z[i] += chol[i][j] * independent[j]
+		}
+	}
+}
+
+// choleskyShrunk factors a correlation matrix, shrinking the off-diagonal correlations
+// towards zero until it is positive semidefinite; pairwise estimates need not be. It
+// returns the factor and the shrink factor applied.
+func choleskyShrunk(corr [][]float64) ([][]float64, float64) {
+	for step := 10; step > 0; step-- {
+		shrink := float64(step) / 10
+		if chol, ok := cholesky(corr, shrink); ok {
+			return chol, shrink
+		}
+	}
+	chol, _ := cholesky(corr, 0)
+	return chol, 0
+}
+
+// cholesky factors the shrunk matrix, allowing the zero pivots of a semidefinite one
+// (e.g. perfectly correlated positions); it fails if the matrix is not semidefinite
+func cholesky(corr [][]float64, shrink float64) ([][]float64, bool) {
+	n := len(corr)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			value := 1.0
+			if i != j {
+				value = corr[i][j] * shrink
+			}
+			for k := 0; k < j; k++ {
+				value -= l[i][k] * l[j][k]
+			}
+			switch {
+			case i == j && value < -1e-10:
+				return nil, false
+			case i == j:
+				l[i][i] = math.Sqrt(math.Max(value, 0))
+			case l[j][j] > 1e-6:
+				l[i][j] = value / l[j][j]
+			case math.Abs(value) > 1e-6:
+				// A zero pivot leaves nothing to explain the rest of the column
+				return nil, false
+			}
+		}
+	}
+	return l, true
+}
+
+func uniqueDays(days []int) []int {
+	unique := days[:0]
+	for i, day := range days {
+		if i == 0 || day != days[i-1] {
+			unique = append(unique, day)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/artpro/assessapp/pkg/models"
+)
+
+func TestSimulatePortfolioIsSeeded(t *testing.T) {
+	stocks := []models.Stock{
+		{ID: 1, Ticker: "AAA", Currency: "USD", SharesOwned: 10, CurrentPrice: 100, Volatility: 30,
+			ProbabilityPositive: 0.6, UpsidePotential: 40, DownsideRisk: -25},
+		{ID: 2, Ticker: "BBB", Currency: "EUR", SharesOwned: 20, CurrentPrice: 50, Volatility: 20,
+			ProbabilityPositive: 0.55, UpsidePotential: 25, DownsideRisk: -15},
+	}
+	cash := []models.CashHolding{{CurrencyCode: "EUR", Amount: 500}}
+	fxRates := map[string]float64{"USD": 1.08}
+	halfKelly := map[uint]float64{1: 30, 2: 40}
+
+	simulate := func(seed int64) Simulation {
+		t.Helper()
+		simulation, err := SimulatePortfolio(stocks, cash, fxRates, nil, halfKelly, SimulationOptions{Paths: 200, HorizonDays: 504, Seed: seed})
+		if err != nil {
+			t.Fatalf("SimulatePortfolio: %v", err)
+		}
+		return simulation
+	}
+
+	tests := []struct {
+		name     string
+		seeds    [2]int64
+		wantSame bool
+	}{
+		{"same seed", [2]int64{7, 7}, true},
+		{"default seed", [2]int64{0, 1}, true},
+		{"different seeds", [2]int64{7, 8}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := simulate(tt.seeds[0]), simulate(tt.seeds[1])
+			if same := reflect.DeepEqual(first, second); same != tt.wantSame {
+				t.Errorf("outputs equal = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/config"
+	"github.com/artpro/assessapp/pkg/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Snapshot sources
+const (
+	SnapshotSourceScheduler = "scheduler" // Daily scheduler job
+	SnapshotSourceManual    = "manual"    // Requested through the API
+	SnapshotSourceAuto      = "auto"      // Written lazily when the day had none (serverless mode)
+	SnapshotSourceCron      = "cron"      // External cron call (e.g. Vercel Cron)
+)
+
+// SnapshotDay returns UTC midnight of the given time, the key of a daily snapshot
+func SnapshotDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// BuildSnapshot computes a portfolio snapshot from positions, cash, EUR-based FX rates
+// and the positions' correlations
+func BuildSnapshot(day time.Time, stocks []models.Stock, cash []models.CashHolding, fxRates map[string]float64, correlations *CorrelationMatrix) models.PortfolioSnapshot {
+	metrics := CalculatePortfolioMetrics(stocks, fxRates, correlations)
+
+	snapshot := models.PortfolioSnapshot{
+		SnapshotDate:       SnapshotDay(day),
+		PositionsValue:     metrics.TotalValue,
+		OverallEV:          metrics.OverallEV,
+		WeightedVolatility: metrics.WeightedVolatility,
+		SharpeRatio:        metrics.SharpeRatio,
+		KellyUtilization:   metrics.KellyUtilization,
+		SectorWeights:      metrics.SectorWeights,
+		Positions:          []models.SnapshotPosition{},
+		Cash:               make(map[string]float64),
+	}
+
+	for _, stock := range stocks {
+		if stock.SharesOwned <= 0 {
+			continue
+		}
+		fxRate := fxRates[stock.Currency]
+		if fxRate == 0 {
+			fxRate = 1.0
+		}
+
+		position := models.SnapshotPosition{
+			StockID:       stock.ID,
+			Ticker:        stock.Ticker,
+			Sector:        stock.Sector,
+			Currency:      stock.Currency,
+			Shares:        stock.SharesOwned,
+			Price:         stock.CurrentPrice,
+			Value:         stock.SharesOwned * stock.CurrentPrice / fxRate,
+			CostBasis:     stock.SharesOwned * stock.AvgPriceLocal / fxRate,
+			ExpectedValue: stock.ExpectedValue,
+		}
+		if metrics.TotalValue > 0 {
+			position.Weight = position.Value / metrics.TotalValue * 100
+		}
+
+		snapshot.CostBasis += position.CostBasis
+		snapshot.Positions = append(snapshot.Positions, position)
+	}
+	snapshot.PositionCount = len(snapshot.Positions)
+	snapshot.UnrealizedPnL = snapshot.PositionsValue - snapshot.CostBasis
+
+	for _, holding := range cash {
+		snapshot.Cash[holding.CurrencyCode] += holding.Amount
+		fxRate := fxRates[holding.CurrencyCode]
+		if fxRate == 0 {
+			fxRate = 1.0
+		}
+		snapshot.CashValue += holding.Amount / fxRate
+	}
+	snapshot.TotalValue = snapshot.PositionsValue + snapshot.CashValue
+
+	return snapshot
+}
+
+// SnapshotService writes and reads daily portfolio snapshots
+type SnapshotService struct {
+	db                  *gorm.DB
+	cfg                 *config.Config
+	logger              zerolog.Logger
+	exchangeRateService *ExchangeRateService
+}
+
+// NewSnapshotService creates a new snapshot service
+func NewSnapshotService(db *gorm.DB, cfg *config.Config, logger zerolog.Logger) *SnapshotService {
+	return &SnapshotService{
+		db:                  db,
+		cfg:                 cfg,
+		logger:              logger,
+		exchangeRateService: NewExchangeRateService(db, cfg, logger),
+	}
+}
+
+// TakeSnapshot computes today's snapshot from the current data, replacing any
+// snapshot already stored for today
+func (s *SnapshotService) TakeSnapshot(source string) (*models.PortfolioSnapshot, error) {
+	var stocks []models.Stock
+	if err := s.db.Find(&stocks).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch stocks: %w", err)
+	}
+
+	var cash []models.CashHolding
+	if err := s.db.Find(&cash).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch cash holdings: %w", err)
+	}
+
+	fxRates, err := s.exchangeRateService.GetRatesMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+
+	correlations, err := NewStatisticsService(s.db).Correlations(stocks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute correlations: %w", err)
+	}
+
+	snapshot := BuildSnapshot(time.Now(), stocks, cash, fxRates, correlations)
+	snapshot.Source = source
+
+	var existing models.PortfolioSnapshot
+	err = s.db.Where("snapshot_date = ?", snapshot.SnapshotDate).First(&existing).Error
+	if err == nil {
+		snapshot.ID = existing.ID
+		snapshot.CreatedAt = existing.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := s.db.Save(&snapshot).Error; err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	s.logger.Info().Time("date", snapshot.SnapshotDate).Float64("total_value", snapshot.TotalValue).Str("source", source).Msg("Portfolio snapshot saved")
+
+	// Every new point of the series may cross the drawdown alert level
+	if _, err := NewDrawdownService(s.db, s.cfg, s.logger).CheckAlerts(); err != nil {
+		s.logger.Warn().Err(err).Msg("Failed to check drawdown alerts")
+	}
+	return &snapshot, nil
+}
+
+// EnsureDailySnapshot writes today's snapshot if none exists yet. It lets
+// deployments without the scheduler (serverless) still build a daily series.
+func (s *SnapshotService) EnsureDailySnapshot() error {
+	var count int64
+	if err := s.db.Model(&models.PortfolioSnapshot{}).Where("snapshot_date = ?", SnapshotDay(time.Now())).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := s.TakeSnapshot(SnapshotSourceAuto)
+	return err
+}
+
+// GetSnapshots returns the snapshots between from and to (inclusive days), oldest first.
+// Zero times leave that side of the range open.
+func (s *SnapshotService) GetSnapshots(from, to time.Time) ([]models.PortfolioSnapshot, error) {
+	query := s.db.Order("snapshot_date ASC")
+	if !from.IsZero() {
+		query = query.Where("snapshot_date >= ?", SnapshotDay(from))
+	}
+	if !to.IsZero() {
+		query = query.Where("snapshot_date <= ?", SnapshotDay(to))
+	}
+
+	var snapshots []models.PortfolioSnapshot
+	if err := query.Find(&snapshots).Error; err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Sources of a stock's beta and volatility (Stock.RiskSource)
+const (
+	RiskSourceProvider = "provider" // As reported by the market data provider
+	RiskSourceComputed = "computed" // Computed from the stored price bars
+)
+
+// RiskMethodManual marks beta and volatility values entered by the user
+const RiskMethodManual = "manual"
+
+const (
+	tradingDaysPerYear = 252
+	defaultRiskWindow  = 252
+)
+
+// MinRiskObservations is the fewest daily returns that give a meaningful estimate
+const MinRiskObservations = 20
+
+var (
+	// ErrInvalidRiskSource is returned for risk sources other than provider or computed
+	ErrInvalidRiskSource = errors.New("risk_source must be provider or computed")
+	// ErrInsufficientPriceHistory is returned when too few price bars are stored to compute volatility
+	ErrInsufficientPriceHistory = errors.New("not enough price history")
+)
+
+// closePoint is one close of a stock or benchmark series
+type closePoint struct {
+	Date  time.Time
+	Close float64
+}
+
+// StatisticsService computes beta and volatility from stored daily prices
+type StatisticsService struct {
+	db *gorm.DB
+}
+
+// NewStatisticsService creates a new statistics service
+func NewStatisticsService(db *gorm.DB) *StatisticsService {
+	return &StatisticsService{db: db}
+}
+
+// riskSettings returns the windows and beta benchmark from the portfolio settings
+func (s *StatisticsService) riskSettings() (volatilityWindow, betaWindow int, benchmark string, err error) {
+	var settings models.PortfolioSettings
+	if err := s.db.First(&settings).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, "", err
+	}
+	volatilityWindow, betaWindow = settings.VolatilityWindow, settings.BetaWindow
+	if volatilityWindow <= 0 {
+		volatilityWindow = defaultRiskWindow
+	}
+	if betaWindow <= 0 {
+		betaWindow = defaultRiskWindow
+	}
+	benchmark = settings.RiskBenchmarkTicker
+	if benchmark == "" {
+		benchmark = settings.BenchmarkTicker
+	}
+	return volatilityWindow, betaWindow, benchmark, nil
+}
+
+// Compute estimates the stock's annualized volatility over the volatility window and its
+// beta against the risk benchmark over the beta window, both from daily simple returns.
+// Beta is nil when no benchmark is configured or its series does not overlap the stock's.
+func (s *StatisticsService) Compute(stock *models.Stock) (models.RiskStatistic, error) {
+	stat := models.RiskStatistic{StockID: stock.ID, ComputedAt: time.Now()}
+
+	volatilityWindow, betaWindow, benchmark, err := s.riskSettings()
+	if err != nil {
+		return stat, err
+	}
+	stat.VolatilityWindow, stat.BetaWindow, stat.BenchmarkTicker = volatilityWindow, betaWindow, benchmark
+
+	var bars []models.PriceBar
+	if err := s.db.Where("stock_id = ?", stock.ID).Order("date ASC").Find(&bars).Error; err != nil {
+		return stat, err
+	}
+	series := barSeries(bars)
+
+	window := lastPoints(series, volatilityWindow+1)
+	returns := simpleReturns(window)
+	if len(returns) < MinRiskObservations {
+		return stat, fmt.Errorf("%w for %s: %d daily returns, need %d", ErrInsufficientPriceHistory, stock.Ticker, len(returns), MinRiskObservations)
+	}
+	volatility := math.Sqrt(sampleCovariance(returns, returns)*tradingDaysPerYear) * 100
+	stat.Volatility = &volatility
+	stat.VolatilityObservations = len(returns)
+	stat.From, stat.To = window[0].Date, window[len(window)-1].Date
+
+	if benchmark == "" {
+		return stat, nil
+	}
+	var prices []models.BenchmarkPrice
+	if err := s.db.Where("ticker = ?", benchmark).Order("date ASC").Find(&prices).Error; err != nil {
+		return stat, err
+	}
+	aligned := alignSeries(series, benchmarkSeries(prices))
+	stockPoints := lastPoints(aligned[0], betaWindow+1)
+	benchPoints := lastPoints(aligned[1], betaWindow+1)
+	stockReturns, benchReturns := simpleReturns(stockPoints), simpleReturns(benchPoints)
+	if len(benchReturns) < MinRiskObservations {
+		return stat, nil
+	}
+	if variance := sampleCovariance(benchReturns, benchReturns); variance > 0 {
+		beta := sampleCovariance(stockReturns, benchReturns) / variance
+		stat.Beta = &beta
+		stat.BetaObservations = len(benchReturns)
+		if stockPoints[0].Date.Before(stat.From) {
+			stat.From = stockPoints[0].Date
+		}
+	}
+	return stat, nil
+}
+
+// Refresh computes and stores the stock's statistics. Stocks using computed risk
+// figures take them over and have their metrics recalculated.
+func (s *StatisticsService) Refresh(stock *models.Stock) (models.RiskStatistic, error) {
+	stat, err := s.Compute(stock)
+	if err != nil {
+		return stat, err
+	}
+	if err := s.db.Create(&stat).Error; err != nil {
+		return stat, err
+	}
+
+	if stock.RiskSource == RiskSourceComputed {
+		strategy, err := NewStrategyService(s.db).Active()
+		if err != nil {
+			return stat, err
+		}
+		if err := NewScenarioService(s.db).Load(stock); err != nil {
+			return stat, err
+		}
+		ApplyRiskSource(stock, &stat)
+		CalculateMetrics(stock, strategy)
+		if err := s.db.Save(stock).Error; err != nil {
+			return stat, err
+		}
+	}
+	return stat, nil
+}
+
+// RefreshAll refreshes the statistics of every stock with stored price bars
+func (s *StatisticsService) RefreshAll() (int, error) {
+	var stocks []models.Stock
+	if err := s.db.Where("id IN (?)", s.db.Model(&models.PriceBar{}).Distinct("stock_id")).Find(&stocks).Error; err != nil {
+		return 0, err
+	}
+
+	refreshed := 0
+	var errs []error
+	for i := range stocks {
+		if _, err := s.Refresh(&stocks[i]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", stocks[i].Ticker, err))
+			continue
+		}
+		refreshed++
+	}
+	return refreshed, errors.Join(errs...)
+}
+
+// Latest returns the stock's most recent statistics, or nil if none were computed
+func (s *StatisticsService) Latest(stockID uint) (*models.RiskStatistic, error) {
+	var stat models.RiskStatistic
+	err := s.db.Where("stock_id = ?", stockID).Order("computed_at DESC").First(&stat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
+// History returns the stock's stored statistics, newest first
+func (s *StatisticsService) History(stockID uint, limit int) ([]models.RiskStatistic, error) {
+	var stats []models.RiskStatistic
+	if err := s.db.Where("stock_id = ?", stockID).Order("computed_at DESC").Limit(limit).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ParseRiskSource normalizes a risk source name
+func ParseRiskSource(source string) (string, error) {
+	switch source = strings.ToLower(strings.TrimSpace(source)); source {
+	case RiskSourceProvider, RiskSourceComputed:
+		return source, nil
+	}
+	return "", ErrInvalidRiskSource
+}
+
+// ApplyRiskSource sets the stock's beta and volatility from its chosen source: the latest
+// computed statistics, or the values last reported by a provider. Figures the source
+// cannot supply are left unchanged.
+func ApplyRiskSource(stock *models.Stock, latest *models.RiskStatistic) {
+	if stock.RiskSource == RiskSourceComputed {
+		if latest == nil {
+			return
+		}
+		if latest.Volatility != nil {
+			stock.Volatility = *latest.Volatility
+			stock.VolatilityMethod = fmt.Sprintf("%s (%dd daily)", RiskSourceComputed, latest.VolatilityObservations)
+		}
+		if latest.Beta != nil {
+			stock.Beta = *latest.Beta
+			stock.BetaMethod = fmt.Sprintf("%s (%dd daily vs %s)", RiskSourceComputed, latest.BetaObservations, latest.BenchmarkTicker)
+		}
+		return
+	}
+
+	provider := stock.FundamentalsProvider
+	if provider == "" {
+		provider = RiskSourceProvider
+	}
+	if stock.ProviderBeta != 0 {
+		stock.Beta = stock.ProviderBeta
+		stock.BetaMethod = provider
+	}
+	if stock.ProviderVolatility != 0 {
+		stock.Volatility = stock.ProviderVolatility
+		stock.VolatilityMethod = provider
+	}
+}
+
+// applyProviderRisk records a provider's beta and volatility. They become the stock's
+// figures unless it uses computed statistics and those are available.
+func applyProviderRisk(stock *models.Stock, f *Fundamentals) {
+	keepComputed := func(method string) bool {
+		return stock.RiskSource == RiskSourceComputed && strings.HasPrefix(method, RiskSourceComputed)
+	}
+	if f.Beta != 0 {
+		stock.ProviderBeta = f.Beta
+		if !keepComputed(stock.BetaMethod) {
+			stock.Beta = f.Beta
+			stock.BetaMethod = f.Provider
+		}
+	}
+	if f.Volatility != 0 {
+		stock.ProviderVolatility = f.Volatility
+		if !keepComputed(stock.VolatilityMethod) {
+			stock.Volatility = f.Volatility
+			stock.VolatilityMethod = f.Provider
+		}
+	}
+}
+
+func barSeries(bars []models.PriceBar) []closePoint {
+	points := make([]closePoint, 0, len(bars))
+	for _, bar := range bars {
+		if bar.Close > 0 {
+			points = append(points, closePoint{Date: SnapshotDay(bar.Date), Close: bar.Close})
+		}
+	}
+	return points
+}
+
+func benchmarkSeries(prices []models.BenchmarkPrice) []closePoint {
+	points := make([]closePoint, 0, len(prices))
+	for _, price := range prices {
+		if price.Close > 0 {
+			points = append(points, closePoint{Date: SnapshotDay(price.Date), Close: price.Close})
+		}
+	}
+	return points
+}
+
+// alignSeries restricts each series (oldest first) to the dates present in all of them,
+// so returns are measured over the same days
+func alignSeries(series ...[]closePoint) [][]closePoint {
+	counts := make(map[time.Time]int)
+	for _, points := range series {
+		for _, p := range points {
+			counts[p.Date]++
+		}
+	}
+
+	aligned := make([][]closePoint, len(series))
+	for i, points := range series {
+		for _, p := range points {
+			if counts[p.Date] == len(series) {
+				aligned[i] = append(aligned[i], p)
+			}
+		}
+		sort.Slice(aligned[i], func(a, b int) bool { return aligned[i][a].Date.Before(aligned[i][b].Date) })
+	}
+	return aligned
+}
+
+// lastPoints returns at most the last n points
+func lastPoints(points []closePoint, n int) []closePoint {
+	if len(points) > n {
+		return points[len(points)-n:]
+	}
+	return points
+}
+
+// simpleReturns returns the returns between consecutive closes
+func simpleReturns(points []closePoint) []float64 {
+	if len(points) < 2 {
+		return nil
+	}
+	returns := make([]float64, 0, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		returns = append(returns, points[i].Close/points[i-1].Close-1)
+	}
+	return returns
+}
+
+// sampleCovariance returns the sample covariance of two equally long series
+func sampleCovariance(x, y []float64) float64 {
+	n := len(x)
+	if n < 2 || len(y) != n {
+		return 0
+	}
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+	var sum float64
+	for i := range x {
+		sum += (x[i] - meanX) * (y[i] - meanY)
+	}
+	return sum / float64(n-1)
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/artpro/assessapp/pkg/models"
+	"gorm.io/gorm"
+)
+
+// DefaultStrategyName is the name of the profile created on first start
+const DefaultStrategyName = "default"
+
+// Sources of a stock's downside risk and probability (Stock.DownsideSource, Stock.ProbabilitySource)
+const (
+	InputSourceDerived  = "derived"  // From the active strategy profile; re-derived on every calculation
+	InputSourceManual   = "manual"   // Entered by the user
+	InputSourceProvider = "provider" // Reported by a market data provider
+)
+
+var (
+	// ErrInvalidStrategy is returned when a strategy profile's parameters are inconsistent
+	ErrInvalidStrategy = errors.New("invalid strategy profile")
+	// ErrStrategyExists is returned when a new profile reuses the name of an existing one
+	ErrStrategyExists = errors.New("a strategy profile with this name already exists")
+)
+
+// DefaultStrategyProfile returns the strategy's original parameters: Add above 7% EV,
+// Hold above 0%, Trim above -3%; downside -15/-20/-25/-30% by beta; p = 0.65; half-Kelly
+// and positions capped at 15%; buy zone up to 15% EV; 8–12% cash; 0.75–0.85 Kelly usage.
+func DefaultStrategyProfile() *models.StrategyProfile {
+	return &models.StrategyProfile{
+		Name:               DefaultStrategyName,
+		Version:            1,
+		Active:             true,
+		Description:        "Probabilistic EV strategy with ½-Kelly sizing",
+		AddThresholdEV:     7,
+		HoldThresholdEV:    0,
+		TrimThresholdEV:    -3,
+		BetaLow:            0.5,
+		BetaMid:            1.0,
+		BetaHigh:           1.5,
+		DownsideLow:        -15,
+		DownsideMid:        -20,
+		DownsideHigh:       -25,
+		DownsideMax:        -30,
+		DefaultProbability: 0.65,
+		HalfKellyCap:       15,
+		BuyZoneTargetEV:    15,
+		CashMin:            8,
+		CashMax:            12,
+		KellyMin:           0.75,
+		KellyMax:           0.85,
+		SectorRanges: map[string]models.SectorRange{
+			"Healthcare":       {Min: 30, Max: 35},
+			"Technology":       {Min: 0, Max: 15},
+			"Energy":           {Min: 8, Max: 10},
+			"Financials":       {Min: 5, Max: 7},
+			"Industrials":      {Min: 3, Max: 4},
+			"Consumer Staples": {Min: 8, Max: 10},
+			"REITs":            {Min: 5, Max: 7},
+		},
+	}
+}
+
+// ValidateStrategy checks that the thresholds and bands of a profile are ordered and in range
+func ValidateStrategy(p *models.StrategyProfile) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidStrategy, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case strings.TrimSpace(p.Name) == "":
+		return invalid("name is required")
+	case !(p.AddThresholdEV > p.HoldThresholdEV && p.HoldThresholdEV > p.TrimThresholdEV):
+		return invalid("EV thresholds must satisfy add > hold > trim")
+	case !(p.BetaLow > 0 && p.BetaLow < p.BetaMid && p.BetaMid < p.BetaHigh):
+		return invalid("beta bands must satisfy 0 < low < mid < high")
+	case !(p.DownsideLow < 0 && p.DownsideMid < 0 && p.DownsideHigh < 0 && p.DownsideMax < 0):
+		return invalid("downside bands must be negative")
+	case p.DefaultProbability <= 0 || p.DefaultProbability >= 1:
+		return invalid("default_probability must be between 0 and 1")
+	case p.HalfKellyCap <= 0 || p.HalfKellyCap > 100:
+		return invalid("half_kelly_cap must be between 0 and 100")
+	case p.BuyZoneTargetEV <= 0:
+		return invalid("buy_zone_target_ev must be positive")
+	case p.CashMin < 0 || p.CashMin > p.CashMax || p.CashMax > 100:
+		return invalid("cash range must satisfy 0 <= cash_min <= cash_max <= 100")
+	case p.KellyMin <= 0 || p.KellyMin > p.KellyMax || p.KellyMax > 1:
+		return invalid("Kelly range must satisfy 0 < kelly_min <= kelly_max <= 1")
+	}
+	for sector, r := range p.SectorRanges {
+		if r.Min < 0 || r.Min > r.Max || r.Max > 100 {
+			return invalid("sector range of %s must satisfy 0 <= min <= max <= 100", sector)
+		}
+	}
+	return nil
+}
+
+// DownsideForBeta returns the downside risk band of a beta
+func DownsideForBeta(p *models.StrategyProfile, beta float64) float64 {
+	switch {
+	case beta < p.BetaLow:
+		return p.DownsideLow
+	case beta < p.BetaMid:
+		return p.DownsideMid
+	case beta < p.BetaHigh:
+		return p.DownsideHigh
+	default:
+		return p.DownsideMax
+	}
+}
+
+// AssessmentForEV returns Add, Hold, Trim or Sell for an expected value
+func AssessmentForEV(p *models.StrategyProfile, ev float64) string {
+	switch {
+	case ev > p.AddThresholdEV:
+		return "Add"
+	case ev > p.HoldThresholdEV:
+		return "Hold"
+	case ev > p.TrimThresholdEV:
+		return "Trim"
+	default:
+		return "Sell"
+	}
+}
+
+// AllocationConstraintsFor returns the allocator limits of a profile
+func AllocationConstraintsFor(p *models.StrategyProfile) AllocationConstraints {
+	ranges := make(map[string]models.SectorRange, len(p.SectorRanges))
+	for sector, r := range p.SectorRanges {
+		ranges[sector] = r
+	}
+	return AllocationConstraints{
+		MaxPosition:  p.HalfKellyCap,
+		SectorRanges: ranges,
+		CashMin:      p.CashMin,
+		CashMax:      p.CashMax,
+		KellyMin:     p.KellyMin,
+		KellyMax:     p.KellyMax,
+	}
+}
+
+// FormatSectorTargets describes a profile's sector ranges for prompts, e.g.
+// "Healthcare 30–35%, Technology up to 15%"
+func FormatSectorTargets(p *models.StrategyProfile) string {
+	sectors := make([]string, 0, len(p.SectorRanges))
+	for sector := range p.SectorRanges {
+		sectors = append(sectors, sector)
+	}
+	// Largest allocations first, as the strategy lists them
+	sort.Slice(sectors, func(i, j int) bool {
+		a, b := p.SectorRanges[sectors[i]], p.SectorRanges[sectors[j]]
+		if a.Max != b.Max {
+			return a.Max > b.Max
+		}
+		return sectors[i] < sectors[j]
+	})
+
+	parts := make([]string, 0, len(sectors))
+	for _, sector := range sectors {
+		r := p.SectorRanges[sector]
+		switch {
+		case r.Min == r.Max:
+			parts = append(parts, fmt.Sprintf("%s %g%%", sector, r.Max))
+		case r.Min == 0:
+			parts = append(parts, fmt.Sprintf("%s up to %g%%", sector, r.Max))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %g–%g%%", sector, r.Min, r.Max))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// StrategyService stores and activates strategy profiles
+type StrategyService struct {
+	db *gorm.DB
+}
+
+// NewStrategyService creates a new strategy service
+func NewStrategyService(db *gorm.DB) *StrategyService {
+	return &StrategyService{db: db}
+}
+
+// Active returns the active profile, or the default parameters if none is stored
+func (s *StrategyService) Active() (*models.StrategyProfile, error) {
+	var profile models.StrategyProfile
+	err := s.db.Where("active = ?", true).Order("id DESC").First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultStrategyProfile(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// List returns every version of every profile, newest version first within a name
+func (s *StrategyService) List() ([]models.StrategyProfile, error) {
+	var profiles []models.StrategyProfile
+	if err := s.db.Order("name ASC, version DESC").Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// Get returns one profile version
+func (s *StrategyService) Get(id uint) (*models.StrategyProfile, error) {
+	var profile models.StrategyProfile
+	if err := s.db.First(&profile, id).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// Create stores the first version of a new, inactive profile
+func (s *StrategyService) Create(profile *models.StrategyProfile, createdBy string) error {
+	profile.Name = strings.TrimSpace(profile.Name)
+	if err := ValidateStrategy(profile); err != nil {
+		return err
+	}
+	var count int64
+	if err := s.db.Model(&models.StrategyProfile{}).Where("name = ?", profile.Name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrStrategyExists
+	}
+
+	profile.ID = 0
+	profile.Version = 1
+	profile.Active = false
+	profile.CreatedBy = createdBy
+	return s.db.Create(profile).Error
+}
+
+// Update stores the changes to a profile version as the next version of its profile.
+// Changes use the JSON field names; editing the active version activates the new one.
+func (s *StrategyService) Update(id uint, changes map[string]interface{}, createdBy string) (*models.StrategyProfile, error) {
+	base, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overlay the changes on the JSON form of the base version
+	fields := make(map[string]interface{})
+	raw, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range changes {
+		switch key {
+		case "id", "name", "version", "active", "created_by", "created_at", "updated_at":
+			continue
+		}
+		fields[key] = value
+	}
+	raw, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	next := &models.StrategyProfile{}
+	if err := json.Unmarshal(raw, next); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStrategy, err)
+	}
+	if err := ValidateStrategy(next); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&models.StrategyProfile{}).Where("name = ?", base.Name).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		next.ID = 0
+		next.Version = latest + 1
+		next.Active = base.Active
+		next.CreatedBy = createdBy
+		if base.Active {
+			if err := tx.Model(&models.StrategyProfile{}).Where("active = ?", true).Update("active", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// Activate makes a profile version the active one
+func (s *StrategyService) Activate(id uint) (*models.StrategyProfile, error) {
+	profile, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.StrategyProfile{}).Where("active = ?", true).Update("active", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(profile).Update("active", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	profile.Active = true
+	return profile, nil
+}